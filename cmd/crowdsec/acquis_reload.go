@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// the signals to reload the datasources without restarting crowdsec
+var acquisReloadSignals = []os.Signal{syscall.SIGUSR1}
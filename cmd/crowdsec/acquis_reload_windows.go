@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// the datasources can only be reloaded with a full restart on windows
+var acquisReloadSignals = []os.Signal{}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"slices"
 	"syscall"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/crowdsecurity/go-cs-lib/csdaemon"
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition"
 	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
 	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
 	"github.com/crowdsecurity/crowdsec/pkg/cticlient/ctiexpr"
@@ -224,6 +226,22 @@ func drainChan(c chan types.Event) {
 	}
 }
 
+// reloadAcquisition applies the changes of the acquisition files to the running datasources.
+// Nothing is restarted when it fails, a full reload is required then.
+func reloadAcquisition(cConfig *csconfig.Config) {
+	if cConfig.DisableAgent {
+		log.Warning("the agent is disabled, no acquisition to reload")
+		return
+	}
+
+	if err := acquisition.ReloadAcquisition(cConfig.Crowdsec, cConfig.Prometheus); err != nil {
+		log.Errorf("could not reload acquisition, send SIGHUP to reload crowdsec: %s", err)
+		return
+	}
+
+	log.Info("acquisition reloaded")
+}
+
 func HandleSignals(cConfig *csconfig.Config) error {
 	var (
 		newConfig *csconfig.Config
@@ -234,10 +252,11 @@ func HandleSignals(cConfig *csconfig.Config) error {
 
 	// We add os.Interrupt mostly to ease windows development,
 	// it allows to simulate a clean shutdown when running in the console
-	signal.Notify(signalChan,
+	signal.Notify(signalChan, append([]os.Signal{
 		syscall.SIGHUP,
 		syscall.SIGTERM,
-		os.Interrupt)
+		os.Interrupt,
+	}, acquisReloadSignals...)...)
 
 	exitChan := make(chan error)
 
@@ -250,6 +269,15 @@ func HandleSignals(cConfig *csconfig.Config) error {
 	Loop:
 		for {
 			s := <-signalChan
+
+			// kill -SIGUSR1 XXXX
+			if slices.Contains(acquisReloadSignals, s) {
+				log.Warningf("%s received, reloading acquisition", s)
+				reloadAcquisition(cConfig)
+
+				continue
+			}
+
 			switch s {
 			// kill -SIGHUP XXXX
 			case syscall.SIGHUP:
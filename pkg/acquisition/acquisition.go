@@ -60,6 +60,12 @@ type DataSource interface {
 	Dump() any
 }
 
+// ReloadableDataSource is implemented by the datasources that can apply a new configuration while running,
+// without losing the output channel.
+type ReloadableDataSource interface {
+	Reload(newConfig []byte, metricsLevel metrics.AcquisitionMetricsLevel) error // Apply a new YAML configuration, restarting the acquisition only if needed. Fails without changing anything if a setting can't be changed while running
+}
+
 var (
 	// We declare everything here so we can tell if they are unsupported, or excluded from the build
 	AcquisitionSources = map[string]func() DataSource{}
@@ -99,7 +105,7 @@ func registerDataSource(dataSourceType string, dsGetter func() DataSource) {
 }
 
 // setupLogger creates a logger for the datasource to use at runtime.
-func setupLogger(source, name string, level *log.Level) (*log.Entry, error) {
+func setupLogger(source, name string, level *log.Level, format string) (*log.Entry, error) {
 	clog := log.New()
 	if err := types.ConfigureLogger(clog, level); err != nil {
 		return nil, fmt.Errorf("while configuring datasource logger: %w", err)
@@ -109,6 +115,14 @@ func setupLogger(source, name string, level *log.Level) (*log.Entry, error) {
 		"type": source,
 	}
 
+	switch format {
+	case "", "text":
+	case "json":
+		clog.SetFormatter(&log.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log_format '%s' (must be 'text' or 'json')", format)
+	}
+
 	if name != "" {
 		fields["name"] = name
 	}
@@ -128,7 +142,7 @@ func DataSourceConfigure(commonConfig configuration.DataSourceCommonCfg, yamlCon
 		return nil, err
 	}
 
-	subLogger, err := setupLogger(commonConfig.Source, commonConfig.Name, commonConfig.LogLevel)
+	subLogger, err := setupLogger(commonConfig.Source, commonConfig.Name, commonConfig.LogLevel, commonConfig.LogFormat)
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +170,7 @@ func LoadAcquisitionFromDSN(dsn string, labels map[string]string, transformExpr
 		return nil, fmt.Errorf("no acquisition for protocol %s:// - %w", frags[0], err)
 	}
 
-	subLogger, err := setupLogger(dsn, "", nil)
+	subLogger, err := setupLogger(dsn, "", nil, "")
 	if err != nil {
 		return nil, err
 	}
@@ -231,10 +245,32 @@ func detectType(r io.Reader) (string, error) {
 }
 
 // sourcesFromFile reads and parses one acquisition file into DataSources.
-func sourcesFromFile(acquisFile string, metricsLevel metrics.AcquisitionMetricsLevel) ([]DataSource, error) {
-	var sources []DataSource
+// documentKey identifies a document of the acquisition files.
+type documentKey struct {
+	file string
+	idx  int // position in the file
+}
 
-	log.Infof("loading acquisition file : %s", acquisFile)
+// acquisDocument is the configuration of a datasource, from an acquisition file.
+type acquisDocument struct {
+	key    documentKey
+	common configuration.DataSourceCommonCfg
+	yaml   []byte
+}
+
+// loadedDocument is an acquisition document with the datasource created from it,
+// nil if the datasource is not available.
+type loadedDocument struct {
+	acquisDocument
+	source DataSource
+}
+
+// the documents the datasources were created from by LoadAcquisitionFromFiles, for ReloadAcquisition
+var loadedDocuments = map[documentKey]loadedDocument{}
+
+// readAcquisFile returns the datasource configurations of an acquisition file, without the empty documents.
+func readAcquisFile(acquisFile string) ([]acquisDocument, error) {
+	var docs []acquisDocument
 
 	yamlFile, err := os.Open(acquisFile)
 	if err != nil {
@@ -312,14 +348,37 @@ func sourcesFromFile(acquisFile string, metricsLevel metrics.AcquisitionMetricsL
 			return nil, fmt.Errorf("in file %s (position %d) - %w", acquisFile, idx, err)
 		}
 
+		docs = append(docs, acquisDocument{key: documentKey{file: acquisFile, idx: idx}, common: sub, yaml: yamlDoc})
+	}
+
+	return docs, nil
+}
+
+func sourcesFromFile(acquisFile string, metricsLevel metrics.AcquisitionMetricsLevel) ([]DataSource, error) {
+	var sources []DataSource
+
+	log.Infof("loading acquisition file : %s", acquisFile)
+
+	docs, err := readAcquisFile(acquisFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs {
+		sub := doc.common
+		idx := doc.key.idx
+
 		uniqueID := uuid.NewString()
 		sub.UniqueId = uniqueID
 
-		src, err := DataSourceConfigure(sub, yamlDoc, metricsLevel)
+		src, err := DataSourceConfigure(sub, doc.yaml, metricsLevel)
 		if err != nil {
 			var dserr *DataSourceUnavailableError
 			if errors.As(err, &dserr) {
 				log.Error(err)
+
+				loadedDocuments[doc.key] = loadedDocument{acquisDocument: doc}
+
 				continue
 			}
 
@@ -335,6 +394,8 @@ func sourcesFromFile(acquisFile string, metricsLevel metrics.AcquisitionMetricsL
 			transformRuntimes[uniqueID] = vm
 		}
 
+		loadedDocuments[doc.key] = loadedDocument{acquisDocument: doc, source: src}
+
 		sources = append(sources, src)
 	}
 
@@ -347,6 +408,8 @@ func LoadAcquisitionFromFiles(config *csconfig.CrowdsecServiceCfg, prom *csconfi
 
 	metricsLevel := GetMetricsLevelFromPromCfg(prom)
 
+	loadedDocuments = map[documentKey]loadedDocument{}
+
 	for _, acquisFile := range config.AcquisitionFiles {
 		sources, err := sourcesFromFile(acquisFile, metricsLevel)
 		if err != nil {
@@ -359,6 +422,90 @@ func LoadAcquisitionFromFiles(config *csconfig.CrowdsecServiceCfg, prom *csconfi
 	return allSources, nil
 }
 
+// commonSettingsChanged returns true if the settings used to create a datasource, that can't be
+// changed while it's running, differ.
+func commonSettingsChanged(a, b configuration.DataSourceCommonCfg) bool {
+	levelA, levelB := "", ""
+
+	if a.LogLevel != nil {
+		levelA = a.LogLevel.String()
+	}
+
+	if b.LogLevel != nil {
+		levelB = b.LogLevel.String()
+	}
+
+	return a.Source != b.Source ||
+		a.Name != b.Name ||
+		levelA != levelB ||
+		a.LogFormat != b.LogFormat ||
+		a.TransformExpr != b.TransformExpr
+}
+
+// ReloadAcquisition applies the changes of the acquisition files to the datasources created by
+// LoadAcquisitionFromFiles, while they are running. It fails if datasources were added or removed, or if
+// a change requires a restart: the datasource can't be reloaded, the source, name, logging or transform
+// changed, or the datasource refuses the new configuration. The changes are checked before reloading
+// any datasource, but the ones reloaded before a datasource refuses its configuration are kept.
+func ReloadAcquisition(config *csconfig.CrowdsecServiceCfg, prom *csconfig.PrometheusCfg) error {
+	metricsLevel := GetMetricsLevelFromPromCfg(prom)
+
+	var changed []acquisDocument
+
+	found := 0
+
+	for _, acquisFile := range config.AcquisitionFiles {
+		docs, err := readAcquisFile(acquisFile)
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			loaded, ok := loadedDocuments[doc.key]
+			if !ok {
+				return fmt.Errorf("new datasource in %s (position %d), a restart is required", acquisFile, doc.key.idx)
+			}
+
+			found++
+
+			if !bytes.Equal(doc.yaml, loaded.yaml) {
+				changed = append(changed, doc)
+			}
+		}
+	}
+
+	if found != len(loadedDocuments) {
+		return errors.New("datasources were removed, a restart is required")
+	}
+
+	for _, doc := range changed {
+		loaded := loadedDocuments[doc.key]
+
+		if _, ok := loaded.source.(ReloadableDataSource); !ok {
+			return fmt.Errorf("datasource %s in %s (position %d) can't be reloaded, a restart is required", loaded.common.Source, doc.key.file, doc.key.idx)
+		}
+
+		if commonSettingsChanged(loaded.common, doc.common) {
+			return fmt.Errorf("source, name, log_level, log_format or transform changed in %s (position %d), a restart is required", doc.key.file, doc.key.idx)
+		}
+	}
+
+	for _, doc := range changed {
+		loaded := loadedDocuments[doc.key]
+
+		if err := loaded.source.(ReloadableDataSource).Reload(doc.yaml, metricsLevel); err != nil {
+			return fmt.Errorf("while reloading datasource %s from %s (position %d): %w", loaded.common.Source, doc.key.file, doc.key.idx, err)
+		}
+
+		log.Infof("reloaded datasource %s from %s (position %d)", loaded.common.Source, doc.key.file, doc.key.idx)
+
+		loaded.acquisDocument = doc
+		loadedDocuments[doc.key] = loaded
+	}
+
+	return nil
+}
+
 func GetMetrics(sources []DataSource, aggregated bool) error {
 	var metrics []prometheus.Collector
 
@@ -1,9 +1,13 @@
 package acquisition
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -178,6 +182,26 @@ wowo: ajsajasjas
 `,
 			ExpectedError: "datasource 'mock_cant_run' is not available: can't run bro",
 		},
+		{
+			TestName: "json_log_format",
+			String: `
+mode: cat
+log_level: info
+log_format: json
+source: mock
+toto: test_value1
+`,
+		},
+		{
+			TestName: "bad_log_format",
+			String: `
+mode: cat
+log_format: xml
+source: mock
+toto: test_value1
+`,
+			ExpectedError: "unknown log_format 'xml' (must be 'text' or 'json')",
+		},
 		{
 			TestName: "empty common section -- bypassing source autodetect",
 			String: `
@@ -218,6 +242,20 @@ filename: foo.log
 				assert.Equal(t, "tail", mock.Mode)
 				assert.Equal(t, log.DebugLevel, mock.logger.Logger.Level)
 				assert.Equal(t, map[string]string{"test": "foobar"}, mock.Labels)
+			case "json_log_format":
+				mock := ds.Dump().(*MockSource)
+				buf := bytes.Buffer{}
+				mock.logger.Logger.SetOutput(&buf)
+				mock.logger.WithField("src", "foo.log").Warning("hello")
+
+				entry := map[string]string{}
+				err := json.Unmarshal(buf.Bytes(), &entry)
+				require.NoError(t, err)
+				assert.Equal(t, "hello", entry["msg"])
+				assert.Equal(t, "warning", entry["level"])
+				assert.Equal(t, "mock", entry["type"])
+				assert.NotContains(t, entry, "module")
+				assert.Equal(t, "foo.log", entry["src"])
 			}
 		})
 	}
@@ -321,6 +359,86 @@ func TestLoadAcquisitionFromFiles(t *testing.T) {
 	}
 }
 
+// MockSourceReload can be reloaded, unless toto is "refused"
+type MockSourceReload struct {
+	MockSource
+	reloads int
+}
+
+func (f *MockSourceReload) Reload(cfg []byte, metricsLevel metrics.AcquisitionMetricsLevel) error {
+	reloaded := MockSource{}
+	if err := reloaded.UnmarshalConfig(cfg); err != nil {
+		return err
+	}
+
+	if reloaded.Toto == "refused" {
+		return errors.New("refused")
+	}
+
+	f.Toto = reloaded.Toto
+	f.reloads++
+
+	return nil
+}
+
+func (f *MockSourceReload) Dump() any { return f }
+
+func TestReloadAcquisition(t *testing.T) {
+	appendMockSource()
+	AcquisitionSources["mock_reload"] = func() DataSource { return &MockSourceReload{} }
+
+	acquisFile := filepath.Join(t.TempDir(), "acquis.yaml")
+
+	reloadable := func(name, toto string) string {
+		return "source: mock_reload\nname: " + name + "\ntoto: " + toto + "\nlabels:\n  test: foobar\n"
+	}
+
+	other := "source: mock\ntoto: bar\nlabels:\n  test: foobar\n"
+
+	writeAcquis := func(docs ...string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(acquisFile, []byte(strings.Join(docs, "---\n")), 0o600))
+	}
+
+	config := csconfig.CrowdsecServiceCfg{AcquisitionFiles: []string{acquisFile}}
+
+	writeAcquis(reloadable("first", "foo"), other)
+
+	dss, err := LoadAcquisitionFromFiles(&config, nil)
+	require.NoError(t, err)
+	require.Len(t, dss, 2)
+
+	mock := dss[0].Dump().(*MockSourceReload)
+
+	// nothing changed
+	require.NoError(t, ReloadAcquisition(&config, nil))
+	assert.Equal(t, 0, mock.reloads)
+
+	writeAcquis(reloadable("first", "baz"), other)
+	require.NoError(t, ReloadAcquisition(&config, nil))
+	assert.Equal(t, 1, mock.reloads)
+	assert.Equal(t, "baz", mock.Toto)
+
+	// the changes that need a restart are not applied
+	writeAcquis(reloadable("first", "qux"), "source: mock\ntoto: other\nlabels:\n  test: foobar\n")
+	cstest.RequireErrorContains(t, ReloadAcquisition(&config, nil), "datasource mock in "+acquisFile+" (position 1) can't be reloaded, a restart is required")
+	assert.Equal(t, "baz", mock.Toto)
+
+	writeAcquis(reloadable("renamed", "baz"), other)
+	cstest.RequireErrorContains(t, ReloadAcquisition(&config, nil), "source, name, log_level, log_format or transform changed in "+acquisFile+" (position 0), a restart is required")
+
+	writeAcquis(reloadable("first", "baz"), other, other)
+	cstest.RequireErrorContains(t, ReloadAcquisition(&config, nil), "new datasource in "+acquisFile+" (position 2), a restart is required")
+
+	writeAcquis(reloadable("first", "baz"))
+	cstest.RequireErrorContains(t, ReloadAcquisition(&config, nil), "datasources were removed, a restart is required")
+
+	// the datasource refuses the new configuration
+	writeAcquis(reloadable("first", "refused"), other)
+	cstest.RequireErrorContains(t, ReloadAcquisition(&config, nil), "while reloading datasource mock_reload from "+acquisFile+" (position 0): refused")
+	assert.Equal(t, 1, mock.reloads)
+}
+
 /*
  test start acquisition :
   - create mock parser in cat mode : start acquisition, check it returns, count items in chan
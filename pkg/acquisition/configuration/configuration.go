@@ -1,13 +1,21 @@
 package configuration
 
 import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
 	log "github.com/sirupsen/logrus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 )
 
 type DataSourceCommonCfg struct {
 	Mode           string            `yaml:"mode,omitempty"`
 	Labels         map[string]string `yaml:"labels,omitempty"`
 	LogLevel       *log.Level        `yaml:"log_level,omitempty"`
+	LogFormat      string            `yaml:"log_format,omitempty"` // "text" (default) or "json"
 	Source         string            `yaml:"source,omitempty"`
 	Name           string            `yaml:"name,omitempty"`
 	UseTimeMachine bool              `yaml:"use_time_machine,omitempty"`
@@ -20,3 +28,46 @@ const (
 	CAT_MODE    = "cat"
 	SERVER_MODE = "server" // No difference with tail, just a bit more verbose
 )
+
+// GetMetricsLevel returns the metrics level of a datasource that can override it
+// with metrics_level: the configured one if set, defaultLevel otherwise.
+func GetMetricsLevel(level metrics.MetricsLevelConfig, defaultLevel metrics.AcquisitionMetricsLevel) (metrics.AcquisitionMetricsLevel, error) {
+	switch level {
+	case "":
+		return defaultLevel, nil
+	case metrics.MetricsLevelNone:
+		return metrics.AcquisitionMetricsLevelNone, nil
+	case metrics.MetricsLevelAggregated:
+		return metrics.AcquisitionMetricsLevelAggregated, nil
+	case metrics.MetricsLevelFull:
+		return metrics.AcquisitionMetricsLevelFull, nil
+	default:
+		return defaultLevel, fmt.Errorf("%w: %s", metrics.ErrInvalidMetricsLevel, level)
+	}
+}
+
+// ExpandEnv replaces ${VAR} and $VAR references in a datasource configuration
+// with the value of the environment variables. "$$" is replaced by a literal "$".
+// An error is returned if any of the referenced variables is not defined.
+func ExpandEnv(yamlConfig []byte) ([]byte, error) {
+	missing := []string{}
+
+	expanded := os.Expand(string(yamlConfig), func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		val, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+
+		return val
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variable(s) in configuration: %s", strings.Join(missing, ", "))
+	}
+
+	return []byte(expanded), nil
+}
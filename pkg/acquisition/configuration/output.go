@@ -0,0 +1,97 @@
+package configuration
+
+import (
+	"fmt"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// values of output_buffer.backpressure
+const (
+	BackpressureBlock      = "block"
+	BackpressureDropOldest = "drop_oldest"
+	BackpressureDropNewest = "drop_newest"
+)
+
+// OutputBuffer holds the events of a datasource when they are not read fast enough.
+// When the buffer is full, the datasource waits (block, the default), or the oldest or
+// the newest event is dropped so it can keep reading its input.
+type OutputBuffer struct {
+	Size         int    `yaml:"size,omitempty"`
+	Backpressure string `yaml:"backpressure,omitempty"`
+}
+
+// Validate checks the buffer size and the backpressure policy.
+func (o OutputBuffer) Validate() error {
+	if o.Size < 0 {
+		return fmt.Errorf("invalid output_buffer.size %d: must be positive", o.Size)
+	}
+
+	switch o.Backpressure {
+	case "", BackpressureBlock:
+	case BackpressureDropOldest, BackpressureDropNewest:
+		if o.Size == 0 {
+			return fmt.Errorf("output_buffer.size is required with backpressure %s", o.Backpressure)
+		}
+	default:
+		return fmt.Errorf("invalid output_buffer.backpressure %q: must be block, drop_oldest or drop_newest", o.Backpressure)
+	}
+
+	return nil
+}
+
+// Start returns the channel the datasource must send its events to. They are forwarded to out
+// through the buffer until dead is closed, and onDrop is called with each dropped event.
+// out is returned as is if there is no buffer.
+func (o OutputBuffer) Start(out chan types.Event, dead <-chan struct{}, onDrop func(types.Event)) chan types.Event {
+	if o.Size == 0 {
+		return out
+	}
+
+	in := make(chan types.Event)
+
+	go o.forward(in, out, dead, onDrop)
+
+	return in
+}
+
+func (o OutputBuffer) forward(in chan types.Event, out chan types.Event, dead <-chan struct{}, onDrop func(types.Event)) {
+	queue := make([]types.Event, 0, o.Size)
+
+	for {
+		// nil channels are never ready: nothing to send, or no room for a new event
+		var (
+			send chan types.Event
+			next types.Event
+		)
+
+		if len(queue) > 0 {
+			send = out
+			next = queue[0]
+		}
+
+		recv := in
+		if len(queue) >= o.Size && o.Backpressure != BackpressureDropOldest && o.Backpressure != BackpressureDropNewest {
+			recv = nil
+		}
+
+		select {
+		case <-dead:
+			return
+		case send <- next:
+			queue = queue[1:]
+		case evt := <-recv:
+			if len(queue) >= o.Size {
+				if o.Backpressure == BackpressureDropNewest {
+					onDrop(evt)
+					continue
+				}
+
+				onDrop(queue[0])
+				queue = queue[1:]
+			}
+
+			queue = append(queue, evt)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package configuration
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// PreProcessRule replaces the matches of a regular expression in a raw line,
+// before the event is sent. Replace can reference the groups of the expression, written
+// $$1 or $${name} in the configuration files since they are expanded like environment variables.
+type PreProcessRule struct {
+	Regexp  string `yaml:"regexp"`
+	Replace string `yaml:"replace"`
+	re      *regexp.Regexp
+}
+
+// PreProcess is the list of rewrite rules of a datasource, applied in order.
+type PreProcess []PreProcessRule
+
+// Compile checks the regular expressions, it must be called before Apply.
+func (p PreProcess) Compile() error {
+	for i := range p {
+		re, err := regexp.Compile(p[i].Regexp)
+		if err != nil {
+			return fmt.Errorf("invalid pre_process regexp %q: %w", p[i].Regexp, err)
+		}
+
+		p[i].re = re
+	}
+
+	return nil
+}
+
+// Apply returns the line rewritten by all the rules.
+func (p PreProcess) Apply(line string) string {
+	for _, rule := range p {
+		line = rule.re.ReplaceAllString(line, rule.Replace)
+	}
+
+	return line
+}
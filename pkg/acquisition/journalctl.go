@@ -6,6 +6,8 @@ import (
 	journalctlacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/journalctl"
 )
 
+var _ ReloadableDataSource = (*journalctlacquisition.JournalCtlSource)(nil)
+
 //nolint:gochecknoinits
 func init() {
 	registerDataSource("journalctl", func() DataSource { return &journalctlacquisition.JournalCtlSource{} })
@@ -32,6 +32,12 @@ func TestBadConfiguration(t *testing.T) {
 			config:      "foobar: asd.log",
 			expectedErr: `cannot parse FileAcquisition configuration: [1:1] unknown field "foobar"`,
 		},
+		{
+			name: "metrics level override",
+			config: `filenames: ["asd.log"]
+metrics_level: none`,
+			expectedErr: `cannot parse FileAcquisition configuration: [2:1] unknown field "metrics_level"`,
+		},
 		{
 			name:        "missing filenames",
 			config:      "mode: tail",
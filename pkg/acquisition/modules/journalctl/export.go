@@ -0,0 +1,229 @@
+package journalctlacquisition
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// openExport opens a file written by "journalctl -o export", decompressing it if needed.
+// The compression is detected from the content, not the file name.
+func openExport(path string) (io.ReadCloser, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(fd)
+
+	// shorter files are not compressed, or truncated: the parser will tell
+	header, _ := br.Peek(len(xzMagic))
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			fd.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return readCloser{gz, fd}, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			fd.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return readCloser{zr.IOReadCloser(), fd}, nil
+	case bytes.HasPrefix(header, xzMagic):
+		fd.Close()
+		return nil, fmt.Errorf("%s: xz compression is not supported, decompress the file or compress it with gzip or zstd", path)
+	}
+
+	return readCloser{io.NopCloser(br), fd}, nil
+}
+
+// readCloser closes the decompressor, then the file.
+type readCloser struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r readCloser) Close() error {
+	return errors.Join(r.ReadCloser.Close(), r.file.Close())
+}
+
+// readExportEntry returns the fields of the next entry of a journal export stream, or io.EOF.
+// Text fields are "NAME=value" lines, binary fields are the name, a little-endian 64 bits size
+// and the data. The fields longer than maxLen are discarded, and tooLong is set.
+func readExportEntry(r *bufio.Reader, maxLen int) (fields map[string]string, tooLong bool, err error) {
+	fields = make(map[string]string)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			if len(fields) == 0 {
+				return nil, false, io.EOF
+			}
+
+			// no blank line after the last entry
+			return fields, tooLong, nil
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, false, err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		if len(line) == 0 {
+			if len(fields) == 0 {
+				// extra blank lines between entries
+				continue
+			}
+
+			return fields, tooLong, nil
+		}
+
+		if name, value, ok := bytes.Cut(line, []byte("=")); ok {
+			if len(value) > maxLen {
+				tooLong = true
+				continue
+			}
+
+			fields[string(name)] = string(value)
+
+			continue
+		}
+
+		var size uint64
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, false, fmt.Errorf("reading the size of field %s: %w", line, err)
+		}
+
+		if size > uint64(maxLen) {
+			if _, err := io.CopyN(io.Discard, r, int64(size)+1); err != nil {
+				return nil, false, fmt.Errorf("reading field %s: %w", line, err)
+			}
+
+			tooLong = true
+
+			continue
+		}
+
+		// the data, followed by a newline
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, false, fmt.Errorf("reading field %s: %w", line, err)
+		}
+
+		fields[string(line)] = string(value[:size])
+	}
+}
+
+// entryTime returns the time an entry was received by journald, and false if it's missing.
+func entryTime(fields map[string]string) (time.Time, bool) {
+	usec, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.UnixMicro(usec).UTC(), true
+}
+
+// shortLine formats an entry like the default output of journalctl.
+func shortLine(fields map[string]string) string {
+	timestamp := ""
+
+	if ts, ok := entryTime(fields); ok {
+		timestamp = ts.Local().Format("Jan 02 15:04:05") + " "
+	}
+
+	identifier := cmp.Or(fields["SYSLOG_IDENTIFIER"], fields["_COMM"], "unknown")
+
+	if pid := cmp.Or(fields["SYSLOG_PID"], fields["_PID"]); pid != "" {
+		identifier += "[" + pid + "]"
+	}
+
+	return timestamp + fields["_HOSTNAME"] + " " + identifier + ": " + fields["MESSAGE"]
+}
+
+// readExportFile sends the entries of export_file, formatted like the output of journalctl.
+func (j *JournalCtlSource) readExportFile(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	logger := j.logger
+
+	rc, err := openExport(j.config.ExportFile)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	logger.Infof("Reading journal export file %s", j.config.ExportFile)
+
+	r := bufio.NewReader(rc)
+
+	for {
+		fields, tooLong, err := readExportEntry(r, j.config.MaxLineLength)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", j.config.ExportFile, err)
+		}
+
+		if tooLong {
+			logger.Warningf("skipping an entry with a field longer than max_line_length (%d)", j.config.MaxLineLength)
+			continue
+		}
+
+		var line string
+
+		if len(j.config.FieldLabels) > 0 {
+			// like --output=json
+			raw, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+
+			line = string(raw)
+		} else {
+			line = shortLine(fields)
+		}
+
+		ts, ok := entryTime(fields)
+		if !ok {
+			ts = time.Now().UTC()
+		}
+
+		select {
+		case out <- j.newEvent(line, ts):
+		case <-t.Dying():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
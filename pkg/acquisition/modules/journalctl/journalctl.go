@@ -2,12 +2,18 @@ package journalctlacquisition
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/url"
 	"os/exec"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	yaml "github.com/goccy/go-yaml"
@@ -25,7 +31,30 @@ import (
 type JournalCtlConfiguration struct {
 	configuration.DataSourceCommonCfg `yaml:",inline"`
 
-	Filters []string `yaml:"journalctl_filter"`
+	Filters    []string `yaml:"journalctl_filter"`
+	Directory  string   `yaml:"directory,omitempty"`
+	Command    string   `yaml:"command,omitempty"`     // run this instead of journalctl, ie. a wrapper to read journals from a remote host
+	ArgsPrefix []string `yaml:"args_prefix,omitempty"` // arguments passed to the command before the journalctl ones
+	Boot       string   `yaml:"boot,omitempty"`        // "true" for the current boot, or a boot id and/or offset as accepted by journalctl -b
+	Priority   string   `yaml:"priority,omitempty"`    // syslog priority (name or number) or range of priorities, as accepted by journalctl -p
+	Container  string   `yaml:"container,omitempty"`   // logs of a container using the journald log driver, in json, with a container_name label
+	Since      string   `yaml:"since,omitempty"`       // read the logs since this date, as accepted by journalctl --since
+	Merge      bool     `yaml:"merge,omitempty"`       // interleave the entries of all the journals, ie. of several hosts in directory, with journalctl --merge
+	ExportFile string   `yaml:"export_file,omitempty"` // read the entries of a "journalctl -o export" file, plain or compressed with gzip or zstd, instead of running journalctl
+
+	PreProcess   configuration.PreProcess   `yaml:"pre_process,omitempty"`   // rewrite the lines before sending them
+	OutputBuffer configuration.OutputBuffer `yaml:"output_buffer,omitempty"` // hold the events when they are not read fast enough, in tail and catchup mode
+
+	// longer lines are skipped with a warning, defaults to 64KiB
+	MaxLineLength int `yaml:"max_line_length,omitempty"`
+
+	// overrides the prometheus level for this source
+	MetricsLevel metrics.MetricsLevelConfig `yaml:"metrics_level,omitempty"`
+
+	// journal fields to copy to the labels of the events, by field name. The lines are then in json, like with container.
+	FieldLabels map[string]string `yaml:"field_labels,omitempty"`
+	// static labels added to each event, they take precedence over the labels from the journal fields and the container
+	ExtraLabels map[string]string `yaml:"extra_labels,omitempty"`
 }
 
 type JournalCtlSource struct {
@@ -33,20 +62,127 @@ type JournalCtlSource struct {
 	config       JournalCtlConfiguration
 	logger       *log.Entry
 	src          string
+	acquisType   string // value of the acquis_type label of the metrics
 	args         []string
+	mu           sync.Mutex             // protects the configuration while it's being reloaded
+	reload       chan *JournalCtlSource // new configurations to apply, when streaming
 }
 
 const journalctlCmd string = "journalctl"
 
+// label added to the events read with the container option
+const containerLabel = "container_name"
+
+const (
+	// used in the metrics when the datasource has no type label
+	defaultAcquisType = "unknown"
+	// longer type labels are probably a mistake, and would bloat the metrics
+	maxAcquisTypeLength = 128
+)
+
+// catchupMode reads the logs since the boot or since option, then streams the new ones like tail mode.
+// The logs are read again from the same point if a reload restarts the command.
+const catchupMode = "catchup"
+
 var (
 	journalctlArgsOneShot  = []string{}
 	journalctlArgstreaming = []string{"--follow", "-n", "0"}
+	journalctlArgsCatchup  = []string{"--follow", "--no-tail"}
 )
 
-func readLine(scanner *bufio.Scanner, out chan string, errChan chan error) error {
+// a boot id (32 hex digits, possibly as an UUID) and/or an offset
+var bootRegexp = regexp.MustCompile(`^(?:[0-9a-fA-F]{32}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})?(?:[+-]?[0-9]+)?$`)
+
+// bootArgs returns the journalctl arguments to select the logs of a boot.
+func bootArgs(boot string) ([]string, error) {
+	switch boot {
+	case "", "false":
+		return nil, nil
+	case "true":
+		return []string{"-b"}, nil
+	}
+
+	if !bootRegexp.MatchString(boot) {
+		return nil, fmt.Errorf("invalid boot %q: must be true, a boot id or an offset", boot)
+	}
+
+	return []string{"-b", boot}, nil
+}
+
+// syslog priorities known by journalctl, from emerg (0) to debug (7)
+var priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func validPriority(priority string) bool {
+	if slices.Contains(priorityNames, priority) {
+		return true
+	}
+
+	return len(priority) == 1 && priority[0] >= '0' && priority[0] <= '7'
+}
+
+// priorityArgs returns the journalctl arguments to select the logs of a priority or a range of priorities.
+func priorityArgs(priority string) ([]string, error) {
+	if priority == "" {
+		return nil, nil
+	}
+
+	from, to, isRange := strings.Cut(priority, "..")
+	if !validPriority(from) || (isRange && !validPriority(to)) {
+		return nil, fmt.Errorf("invalid priority %q: must be one of %s, a number from 0 to 7, or a range like err..emerg", priority, strings.Join(priorityNames, ", "))
+	}
+
+	return []string{"-p", priority}, nil
+}
+
+// skipLongLines is a bufio.SplitFunc like bufio.ScanLines, except that the lines that don't fit
+// in maxLen bytes are discarded instead of stopping the scanner with bufio.ErrTooLong.
+// skipped is called for each of them.
+func skipLongLines(maxLen int, skipped func()) bufio.SplitFunc {
+	discarding := false
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		i := bytes.IndexByte(data, '\n')
+
+		if discarding {
+			if i < 0 && !atEOF {
+				return len(data), nil, nil
+			}
+
+			// end of the long line
+			discarding = false
+
+			skipped()
+
+			if i < 0 {
+				return len(data), nil, nil
+			}
+
+			// the scanner reads more data after an empty token, return the next line if it's already there
+			advance, token, err := bufio.ScanLines(data[i+1:], atEOF)
+
+			return i + 1 + advance, token, err
+		}
+
+		if i < 0 && len(data) >= maxLen {
+			// the buffer is full, drop its content until the end of the line
+			discarding = true
+
+			return len(data), nil, nil
+		}
+
+		return bufio.ScanLines(data, atEOF)
+	}
+}
+
+func readLine(scanner *bufio.Scanner, out chan string, errChan chan error, dying <-chan struct{}) error {
 	for scanner.Scan() {
 		txt := scanner.Text()
-		out <- txt
+		select {
+		case out <- txt:
+		case <-dying:
+			// nobody is reading anymore
+			return nil
+		}
 	}
 
 	if errChan != nil && scanner.Err() != nil {
@@ -66,7 +202,16 @@ func readLine(scanner *bufio.Scanner, out chan string, errChan chan error) error
 func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	ctx, cancel := context.WithCancel(ctx)
 
-	cmd := exec.CommandContext(ctx, journalctlCmd, j.args...)
+	// the configuration can be reloaded while the command runs
+	j.mu.Lock()
+	args := append(append([]string{}, j.config.ArgsPrefix...), j.args...)
+	command := j.command()
+	src := j.src
+	maxLineLength := j.config.MaxLineLength
+	logger := j.logger
+	j.mu.Unlock()
+
+	cmd := exec.CommandContext(ctx, command, args...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -84,8 +229,6 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	stdoutChan := make(chan string)
 	errChan := make(chan error, 1)
 
-	logger := j.logger.WithField("src", j.src)
-
 	logger.Infof("Running journalctl command: %s %s", cmd.Path, cmd.Args)
 
 	err = cmd.Start()
@@ -103,6 +246,11 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 		return errors.New("failed to create stdout scanner")
 	}
 
+	stdoutscanner.Buffer(make([]byte, 0, min(maxLineLength, bufio.MaxScanTokenSize)), maxLineLength)
+	stdoutscanner.Split(skipLongLines(maxLineLength, func() {
+		logger.Warningf("skipping a line longer than max_line_length (%d)", maxLineLength)
+	}))
+
 	stderrScanner := bufio.NewScanner(stderr)
 
 	if stderrScanner == nil {
@@ -112,39 +260,24 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	}
 
 	t.Go(func() error {
-		return readLine(stdoutscanner, stdoutChan, errChan)
+		return readLine(stdoutscanner, stdoutChan, errChan, t.Dying())
 	})
 
 	t.Go(func() error {
 		// looks like journalctl closes stderr quite early, so ignore its status (but not its output)
-		return readLine(stderrScanner, stderrChan, nil)
+		return readLine(stderrScanner, stderrChan, nil, t.Dying())
 	})
 
 	for {
 		select {
 		case <-t.Dying():
-			logger.Infof("journalctl datasource %s stopping", j.src)
+			logger.Infof("journalctl datasource %s stopping", src)
 			cancel()
 			cmd.Wait() // avoid zombie process
 
 			return nil
 		case stdoutLine := <-stdoutChan:
-			l := types.Line{}
-			l.Raw = stdoutLine
-			logger.Debugf("getting one line : %s", l.Raw)
-			l.Labels = j.config.Labels
-			l.Time = time.Now().UTC()
-			l.Src = j.src
-			l.Process = true
-			l.Module = j.GetName()
-
-			if j.metricsLevel != metrics.AcquisitionMetricsLevelNone {
-				metrics.JournalCtlDataSourceLinesRead.With(prometheus.Labels{"source": j.src, "datasource_type": "journalctl", "acquis_type": l.Labels["type"]}).Inc()
-			}
-
-			evt := types.MakeEvent(j.config.UseTimeMachine, types.LOG, true)
-			evt.Line = l
-			out <- evt
+			out <- j.newEvent(stdoutLine, time.Now().UTC())
 		case stderrLine := <-stderrChan:
 			logger.Warnf("Got stderr message : %s", stderrLine)
 			err := fmt.Errorf("journalctl error : %s", stderrLine)
@@ -162,22 +295,93 @@ func (j *JournalCtlSource) runJournalCtl(ctx context.Context, out chan types.Eve
 	}
 }
 
+// newEvent returns the event of a line read from journalctl, and counts it.
+func (j *JournalCtlSource) newEvent(line string, ts time.Time) types.Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	l := types.Line{}
+	l.Raw = j.config.PreProcess.Apply(line)
+	j.logger.Debugf("getting one line : %s", l.Raw)
+	l.Labels = j.lineLabels(line)
+	l.Time = ts
+	l.Src = j.src
+	l.Process = true
+	l.Module = j.GetName()
+
+	if j.metricsLevel != metrics.AcquisitionMetricsLevelNone {
+		metrics.JournalCtlDataSourceLinesRead.With(prometheus.Labels{"source": j.src, "datasource_type": "journalctl", "acquis_type": j.acquisType}).Inc()
+	}
+
+	evt := types.MakeEvent(j.config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+
+	return evt
+}
+
+// lineLabels returns the labels of an event, with the journal fields of field_labels
+// when they are present in the entry, and extra_labels.
+func (j *JournalCtlSource) lineLabels(line string) map[string]string {
+	if len(j.config.FieldLabels) == 0 && len(j.config.ExtraLabels) == 0 {
+		return j.config.Labels
+	}
+
+	// the labels are shared by all the events, don't modify them
+	labels := make(map[string]string, len(j.config.Labels)+len(j.config.FieldLabels)+len(j.config.ExtraLabels))
+	maps.Copy(labels, j.config.Labels)
+
+	if len(j.config.FieldLabels) > 0 {
+		// values that are not strings are binary data or repeated fields, they are ignored
+		fields := map[string]any{}
+
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			j.logger.Warnf("could not parse journal entry: %s", err)
+		}
+
+		for field, label := range j.config.FieldLabels {
+			if value, ok := fields[field].(string); ok {
+				labels[label] = value
+			}
+		}
+	}
+
+	maps.Copy(labels, j.config.ExtraLabels)
+
+	return labels
+}
+
 func (j *JournalCtlSource) GetUuid() string {
 	return j.config.UniqueId
 }
 
+func (j *JournalCtlSource) incDropped(evt types.Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.metricsLevel == metrics.AcquisitionMetricsLevelNone {
+		return
+	}
+
+	metrics.AcquisitionDroppedEvents.With(prometheus.Labels{"source": evt.Line.Src, "datasource_type": "journalctl", "acquis_type": j.acquisType}).Inc()
+}
+
 func (*JournalCtlSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{metrics.JournalCtlDataSourceLinesRead}
+	return []prometheus.Collector{metrics.JournalCtlDataSourceLinesRead, metrics.AcquisitionDroppedEvents}
 }
 
 func (*JournalCtlSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{metrics.JournalCtlDataSourceLinesRead}
+	return []prometheus.Collector{metrics.JournalCtlDataSourceLinesRead, metrics.AcquisitionDroppedEvents}
 }
 
 func (j *JournalCtlSource) UnmarshalConfig(yamlConfig []byte) error {
 	j.config = JournalCtlConfiguration{}
 
-	err := yaml.UnmarshalWithOptions(yamlConfig, &j.config, yaml.Strict())
+	yamlConfig, err := configuration.ExpandEnv(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.UnmarshalWithOptions(yamlConfig, &j.config, yaml.Strict())
 	if err != nil {
 		return fmt.Errorf("cannot parse JournalCtlSource configuration: %s", yaml.FormatError(err, false, false))
 	}
@@ -187,24 +391,142 @@ func (j *JournalCtlSource) UnmarshalConfig(yamlConfig []byte) error {
 	}
 
 	var args []string
-	if j.config.Mode == configuration.TAIL_MODE {
+
+	switch j.config.Mode {
+	case configuration.TAIL_MODE:
 		args = journalctlArgstreaming
-	} else {
+	case catchupMode:
+		// without a starting point, the whole journal would be read again at each restart
+		if j.config.Boot == "" && j.config.Since == "" {
+			return errors.New("catchup mode requires boot or since")
+		}
+
+		args = journalctlArgsCatchup
+	default:
 		args = journalctlArgsOneShot
 	}
 
-	if len(j.config.Filters) == 0 {
+	if j.config.Container != "" {
+		j.config.Filters = append(j.config.Filters, "CONTAINER_NAME="+j.config.Container)
+
+		if j.config.Labels == nil {
+			j.config.Labels = make(map[string]string)
+		}
+
+		j.config.Labels[containerLabel] = j.config.Container
+	}
+
+	if j.config.ExportFile != "" {
+		if j.config.Mode != configuration.CAT_MODE {
+			return errors.New("export_file is only supported in cat mode")
+		}
+
+		// they are options of journalctl, which is not run
+		if len(j.config.Filters) > 0 || j.config.Container != "" || j.config.Directory != "" || j.config.Command != "" ||
+			j.config.Boot != "" || j.config.Priority != "" || j.config.Since != "" || j.config.Merge {
+			return errors.New("export_file can't be used with journalctl_filter, container, directory, command, boot, priority, since or merge")
+		}
+	} else if len(j.config.Filters) == 0 {
 		return errors.New("journalctl_filter is required")
 	}
 
+	if err := j.config.PreProcess.Compile(); err != nil {
+		return err
+	}
+
+	if err := j.config.OutputBuffer.Validate(); err != nil {
+		return err
+	}
+
+	if j.config.MaxLineLength < 0 {
+		return fmt.Errorf("invalid max_line_length %d", j.config.MaxLineLength)
+	}
+
+	if j.config.MaxLineLength == 0 {
+		j.config.MaxLineLength = bufio.MaxScanTokenSize
+	}
+
+	if j.config.Directory != "" {
+		if j.config.Mode == configuration.TAIL_MODE || j.config.Mode == catchupMode {
+			return errors.New("directory is only supported in cat mode")
+		}
+
+		args = append(args, "--directory="+j.config.Directory)
+	}
+
+	if j.config.Merge {
+		if j.config.Mode == configuration.TAIL_MODE || j.config.Mode == catchupMode {
+			return errors.New("merge is only supported in cat mode")
+		}
+
+		// journalctl refuses it
+		if j.config.Boot != "" {
+			return errors.New("merge can't be used with boot")
+		}
+
+		args = append(args, "--merge")
+	}
+
+	boot, err := bootArgs(j.config.Boot)
+	if err != nil {
+		return err
+	}
+
+	args = append(args, boot...)
+
+	if j.config.Since != "" {
+		args = append(args, "--since="+j.config.Since)
+	}
+
+	priority, err := priorityArgs(j.config.Priority)
+	if err != nil {
+		return err
+	}
+
+	args = append(args, priority...)
+
+	if j.config.Container != "" || len(j.config.FieldLabels) > 0 {
+		args = append(args, "--output=json")
+	}
+
+	if j.config.Command != "" {
+		if _, err := exec.LookPath(j.config.Command); err != nil {
+			return fmt.Errorf("invalid command: %w", err)
+		}
+	}
+
 	args = append(args, j.config.Filters...)
 
 	j.args = args
 	j.src = "journalctl-" + strings.Join(j.config.Filters, ".")
 
+	if j.config.ExportFile != "" {
+		j.src = "journalctl-export-" + j.config.ExportFile
+	}
+
+	j.acquisType, err = acquisTypeLabel(j.config.Labels)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// acquisTypeLabel returns the value to use for the acquis_type label of the metrics.
+func acquisTypeLabel(labels map[string]string) (string, error) {
+	acquisType := strings.TrimSpace(labels["type"])
+
+	if acquisType == "" {
+		return defaultAcquisType, nil
+	}
+
+	if len(acquisType) > maxAcquisTypeLength {
+		return "", fmt.Errorf("type label is too long (%d characters, max %d)", len(acquisType), maxAcquisTypeLength)
+	}
+
+	return acquisType, nil
+}
+
 func (j *JournalCtlSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLevel metrics.AcquisitionMetricsLevel) error {
 	j.logger = logger
 	j.metricsLevel = metricsLevel
@@ -214,6 +536,13 @@ func (j *JournalCtlSource) Configure(yamlConfig []byte, logger *log.Entry, metri
 		return err
 	}
 
+	j.logger = logger.WithField("src", j.src)
+
+	j.metricsLevel, err = configuration.GetMetricsLevel(j.config.MetricsLevel, metricsLevel)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -256,6 +585,43 @@ func (j *JournalCtlSource) ConfigureByDSN(dsn string, labels map[string]string,
 			j.logger.Logger.SetLevel(lvl)
 		case "since":
 			j.args = append(j.args, "--since", value[0])
+		case "directory":
+			if len(value) != 1 {
+				return errors.New("expected zero or one value for 'directory'")
+			}
+
+			j.config.Directory = value[0]
+			j.args = append(j.args, "--directory="+value[0])
+		case "boot":
+			if len(value) != 1 {
+				return errors.New("expected zero or one value for 'boot'")
+			}
+
+			// journalctl://boot&filters=... selects the current boot
+			boot := value[0]
+			if boot == "" {
+				boot = "true"
+			}
+
+			args, err := bootArgs(boot)
+			if err != nil {
+				return err
+			}
+
+			j.config.Boot = boot
+			j.args = append(j.args, args...)
+		case "priority":
+			if len(value) != 1 {
+				return errors.New("expected zero or one value for 'priority'")
+			}
+
+			args, err := priorityArgs(value[0])
+			if err != nil {
+				return err
+			}
+
+			j.config.Priority = value[0]
+			j.args = append(j.args, args...)
 		default:
 			return fmt.Errorf("unsupported key %s in journalctl DSN", key)
 		}
@@ -263,10 +629,20 @@ func (j *JournalCtlSource) ConfigureByDSN(dsn string, labels map[string]string,
 
 	j.args = append(j.args, j.config.Filters...)
 
+	j.acquisType, err = acquisTypeLabel(j.config.Labels)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (j *JournalCtlSource) GetMode() string {
+	// catchup mode is streamed
+	if j.config.Mode == catchupMode {
+		return configuration.TAIL_MODE
+	}
+
 	return j.config.Mode
 }
 
@@ -277,24 +653,139 @@ func (*JournalCtlSource) GetName() string {
 func (j *JournalCtlSource) OneShotAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
 	defer trace.CatchPanic("crowdsec/acquis/journalctl/oneshot")
 
-	err := j.runJournalCtl(ctx, out, t)
+	var err error
+
+	if j.config.ExportFile != "" {
+		err = j.readExportFile(ctx, out, t)
+	} else {
+		err = j.runJournalCtl(ctx, out, t)
+	}
+
 	j.logger.Debug("Oneshot journalctl acquisition is done")
 
 	return err
 }
 
 func (j *JournalCtlSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
+	j.mu.Lock()
+	j.reload = make(chan *JournalCtlSource, 1)
+	j.mu.Unlock()
+
+	// the buffer is kept when the command is restarted
+	out = j.config.OutputBuffer.Start(out, t.Dead(), j.incDropped)
+
 	t.Go(func() error {
 		defer trace.CatchPanic("crowdsec/acquis/journalctl/streaming")
-		return j.runJournalCtl(ctx, out, t)
+
+		for {
+			// each run of the command has its own tomb, so it can be restarted on reload
+			run := &tomb.Tomb{}
+			run.Go(func() error {
+				return j.runJournalCtl(ctx, out, run)
+			})
+
+			select {
+			case <-t.Dying():
+				run.Kill(nil)
+				return run.Wait()
+			case <-run.Dead():
+				return run.Err()
+			case reloaded := <-j.reload:
+				run.Kill(nil)
+				if err := run.Wait(); err != nil {
+					return err
+				}
+
+				j.mu.Lock()
+				j.apply(reloaded)
+				j.logger.Info("journalctl configuration changed, restarting command")
+				j.mu.Unlock()
+			}
+		}
 	})
 
 	return nil
 }
 
-func (*JournalCtlSource) CanRun() error {
+// Reload applies a new configuration. If the command, its arguments or max_line_length changed, the
+// running command is restarted, writing to the same channel. The other settings apply to the next lines.
+// The mode and the output buffer can't be changed.
+func (j *JournalCtlSource) Reload(newConfig []byte, metricsLevel metrics.AcquisitionMetricsLevel) error {
+	reloaded := &JournalCtlSource{logger: j.logger}
+	if err := reloaded.UnmarshalConfig(newConfig); err != nil {
+		return err
+	}
+
+	var err error
+
+	reloaded.metricsLevel, err = configuration.GetMetricsLevel(reloaded.config.MetricsLevel, metricsLevel)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if reloaded.config.Mode != j.config.Mode {
+		return fmt.Errorf("cannot change mode from %s to %s on reload", j.config.Mode, reloaded.config.Mode)
+	}
+
+	if reloaded.config.OutputBuffer != j.config.OutputBuffer {
+		return errors.New("cannot change output_buffer on reload")
+	}
+
+	// set by the acquisition, not in the yaml
+	reloaded.config.UniqueId = j.config.UniqueId
+
+	if j.reload != nil {
+		// replace a reload that has not been applied yet
+		select {
+		case <-j.reload:
+		default:
+		}
+	}
+
+	if j.reload == nil || !j.restartNeeded(reloaded) {
+		// not running, or the command is unchanged
+		j.apply(reloaded)
+		return nil
+	}
+
+	j.reload <- reloaded
+
+	return nil
+}
+
+// restartNeeded returns true if the command must be restarted to use the configuration of other.
+func (j *JournalCtlSource) restartNeeded(other *JournalCtlSource) bool {
+	return other.command() != j.command() ||
+		!slices.Equal(other.config.ArgsPrefix, j.config.ArgsPrefix) ||
+		!slices.Equal(other.args, j.args) ||
+		other.config.MaxLineLength != j.config.MaxLineLength
+}
+
+// apply replaces the configuration, and the state derived from it, with the one of other.
+func (j *JournalCtlSource) apply(other *JournalCtlSource) {
+	j.config = other.config
+	j.args = other.args
+	j.src = other.src
+	j.acquisType = other.acquisType
+	j.metricsLevel = other.metricsLevel
+	j.logger = j.logger.WithField("src", other.src)
+}
+
+// command returns the program to run, journalctl unless a wrapper is configured.
+func (j *JournalCtlSource) command() string {
+	if j.config.Command != "" {
+		return j.config.Command
+	}
+
+	return journalctlCmd
+}
+
+func (j *JournalCtlSource) CanRun() error {
 	// TODO: add a more precise check on version or something ?
-	_, err := exec.LookPath(journalctlCmd)
+	_, err := exec.LookPath(j.command())
 	return err
 }
 
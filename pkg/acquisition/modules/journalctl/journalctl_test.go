@@ -1,12 +1,16 @@
 package journalctlacquisition
 
 import (
+	"bufio"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
@@ -15,6 +19,7 @@ import (
 
 	"github.com/crowdsecurity/go-cs-lib/cstest"
 
+	"github.com/crowdsecurity/crowdsec/pkg/acquisition/configuration"
 	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
@@ -44,6 +49,146 @@ journalctl_filter:
  - _UID=42`,
 			expectedErr: "",
 		},
+		{
+			config: `
+mode: tail
+source: journalctl
+directory: /var/log/journal
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: "directory is only supported in cat mode",
+		},
+		{
+			config: `
+mode: tail
+source: journalctl
+merge: true
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: "merge is only supported in cat mode",
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+merge: true
+boot: "true"
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: "merge can't be used with boot",
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+command: /does/not/exist
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: "invalid command: exec: \"/does/not/exist\": stat /does/not/exist: no such file or directory",
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+labels:
+  type: ` + strings.Repeat("a", 129) + `
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: "type label is too long (129 characters, max 128)",
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+boot: yesterday
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: `invalid boot "yesterday": must be true, a boot id or an offset`,
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+priority: fatal
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: `invalid priority "fatal": must be one of emerg, alert, crit, err, warning, notice, info, debug, a number from 0 to 7, or a range like err..emerg`,
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+priority: err..8
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: `invalid priority "err..8"`,
+		},
+		{
+			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+pre_process:
+ - regexp: "(foo"`,
+			expectedErr: `invalid pre_process regexp "(foo": error parsing regexp: missing closing ): ` + "`(foo`",
+		},
+		{
+			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+max_line_length: -1`,
+			expectedErr: "invalid max_line_length -1",
+		},
+		{
+			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+output_buffer:
+  backpressure: drop_oldest`,
+			expectedErr: "output_buffer.size is required with backpressure drop_oldest",
+		},
+		{
+			config: `
+source: journalctl
+journalctl_filter:
+ - _UID=42
+output_buffer:
+  size: 10
+  backpressure: drop`,
+			expectedErr: `invalid output_buffer.backpressure "drop": must be block, drop_oldest or drop_newest`,
+		},
+		{
+			config: `
+source: journalctl
+export_file: testdata/ssh.export.zst`,
+			expectedErr: "export_file is only supported in cat mode",
+		},
+		{
+			config: `
+source: journalctl
+mode: cat
+export_file: testdata/ssh.export.zst
+journalctl_filter:
+ - _UID=42`,
+			expectedErr: "export_file can't be used with journalctl_filter, container, directory, command, boot, priority, since or merge",
+		},
+		{
+			config: `
+source: journalctl
+mode: cat
+export_file: testdata/ssh.export.zst
+container: web`,
+			expectedErr: "export_file can't be used with journalctl_filter, container, directory, command, boot, priority, since or merge",
+		},
+		{
+			config: `
+source: journalctl
+mode: cat
+export_file: testdata/ssh.export.zst`,
+			expectedErr: "",
+		},
 	}
 
 	subLogger := log.WithField("type", "journalctl")
@@ -92,6 +237,22 @@ func TestConfigureDSN(t *testing.T) {
 			dsn:         "journalctl://filters=_UID=1000&log_level=warn&since=yesterday",
 			expectedErr: "",
 		},
+		{
+			dsn:         "journalctl://filters=_UID=1000&directory=/var/log/journal",
+			expectedErr: "",
+		},
+		{
+			dsn:         "journalctl://filters=_UID=1000&directory=/a&directory=/b",
+			expectedErr: "expected zero or one value for 'directory'",
+		},
+		{
+			dsn:         "journalctl://filters=_UID=1000&priority=fatal",
+			expectedErr: `invalid priority "fatal"`,
+		},
+		{
+			dsn:         "journalctl://filters=_UID=1000&boot=foo",
+			expectedErr: `invalid boot "foo": must be true, a boot id or an offset`,
+		},
 	}
 
 	subLogger := log.WithField("type", "journalctl")
@@ -103,6 +264,164 @@ func TestConfigureDSN(t *testing.T) {
 	}
 }
 
+func TestDirectoryArgs(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	j := JournalCtlSource{}
+	err := j.Configure([]byte(`
+mode: cat
+source: journalctl
+directory: /var/log/journal
+journalctl_filter:
+ - _UID=42`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--directory=/var/log/journal", "_UID=42"}, j.args)
+
+	j = JournalCtlSource{}
+	err = j.ConfigureByDSN("journalctl://filters=_UID=42&directory=/var/log/journal", map[string]string{"type": "testtype"}, subLogger, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--directory=/var/log/journal", "_UID=42"}, j.args)
+}
+
+func TestMergeArgs(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	j := JournalCtlSource{}
+	err := j.Configure([]byte(`
+mode: cat
+source: journalctl
+directory: /var/log/journal/remote
+merge: true
+journalctl_filter:
+ - _UID=42`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--directory=/var/log/journal/remote", "--merge", "_UID=42"}, j.args)
+}
+
+func TestBootArgs(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	tests := []struct {
+		config   string
+		expected []string
+	}{
+		{
+			config: `
+mode: cat
+source: journalctl
+boot: true
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"-b", "_UID=42"},
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+boot: 8a6c6f1d2b0d4e3c9b5e3e2a1f0c9d8e
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"-b", "8a6c6f1d2b0d4e3c9b5e3e2a1f0c9d8e", "_UID=42"},
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+boot: -1
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"-b", "-1", "_UID=42"},
+		},
+		{
+			config: `
+mode: tail
+source: journalctl
+boot: true
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"--follow", "-n", "0", "-b", "_UID=42"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			j := JournalCtlSource{}
+			err := j.Configure([]byte(tc.config), subLogger, metrics.AcquisitionMetricsLevelNone)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, j.args)
+		})
+	}
+
+	j := JournalCtlSource{}
+	err := j.ConfigureByDSN("journalctl://filters=_UID=42&boot", map[string]string{"type": "testtype"}, subLogger, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-b", "_UID=42"}, j.args)
+
+	j = JournalCtlSource{}
+	err = j.ConfigureByDSN("journalctl://filters=_UID=42&boot=8a6c6f1d2b0d4e3c9b5e3e2a1f0c9d8e", map[string]string{"type": "testtype"}, subLogger, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-b", "8a6c6f1d2b0d4e3c9b5e3e2a1f0c9d8e", "_UID=42"}, j.args)
+}
+
+func TestPriorityArgs(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	tests := []struct {
+		config   string
+		expected []string
+	}{
+		{
+			config: `
+mode: cat
+source: journalctl
+priority: err
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"-p", "err", "_UID=42"},
+		},
+		{
+			config: `
+mode: tail
+source: journalctl
+priority: err..emerg
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"--follow", "-n", "0", "-p", "err..emerg", "_UID=42"},
+		},
+		{
+			config: `
+mode: cat
+source: journalctl
+priority: 3
+journalctl_filter:
+ - _UID=42`,
+			expected: []string{"-p", "3", "_UID=42"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			j := JournalCtlSource{}
+			err := j.Configure([]byte(tc.config), subLogger, metrics.AcquisitionMetricsLevelNone)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, j.args)
+		})
+	}
+
+	j := JournalCtlSource{}
+	err := j.ConfigureByDSN("journalctl://filters=_UID=42&priority=err", map[string]string{"type": "testtype"}, subLogger, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-p", "err", "_UID=42"}, j.args)
+}
+
 func TestOneShot(t *testing.T) {
 	cstest.SkipOnWindows(t)
 
@@ -184,6 +503,337 @@ journalctl_filter:
 	}
 }
 
+func TestWrapperCommand(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	wrapper, err := filepath.Abs("testdata/ssh-wrapper")
+	require.NoError(t, err)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: cat
+command: `+wrapper+`
+args_prefix:
+ - jumphost
+ - journalctl
+journalctl_filter:
+ - _UID=42`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	require.NoError(t, j.CanRun())
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.OneShotAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+
+	evt := <-out
+	assert.Equal(t, "jumphost: journalctl _UID=42", evt.Line.Raw)
+
+	evt = <-out
+	assert.Equal(t, "Nov 22 11:22:19 remote sshd[1480]: Invalid user wqeqwe from 127.0.0.1 port 55818", evt.Line.Raw)
+}
+
+func TestContainer(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	command, err := filepath.Abs("testdata/echo-args")
+	require.NoError(t, err)
+
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: tail
+command: `+command+`
+labels:
+  type: nginx
+container: web`), log.WithField("type", "journalctl"), metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--follow", "-n", "0", "--output=json", "CONTAINER_NAME=web"}, j.args)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	select {
+	case evt := <-out:
+		assert.Equal(t, "args: --follow -n 0 --output=json CONTAINER_NAME=web", evt.Line.Raw)
+		assert.Equal(t, map[string]string{"type": "nginx", "container_name": "web"}, evt.Line.Labels)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	tomb.Kill(nil)
+	require.NoError(t, tomb.Wait())
+}
+
+func TestFieldLabels(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	command, err := filepath.Abs("testdata/json-entries")
+	require.NoError(t, err)
+
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: tail
+command: `+command+`
+labels:
+  type: syslog
+journalctl_filter:
+  - _SYSTEMD_UNIT=ssh.service
+field_labels:
+  SYSLOG_IDENTIFIER: program
+  _HOSTNAME: host
+  _MISSING: missing
+extra_labels:
+  env: prod
+  host: override`), log.WithField("type", "journalctl"), metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--follow", "-n", "0", "--output=json", "_SYSTEMD_UNIT=ssh.service"}, j.args)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	select {
+	case evt := <-out:
+		// extra_labels take precedence over the journal fields
+		assert.Equal(t, map[string]string{"type": "syslog", "program": "sshd", "host": "override", "env": "prod"}, evt.Line.Labels)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	// the configured labels are not modified
+	assert.Equal(t, map[string]string{"type": "syslog"}, j.config.Labels)
+
+	tomb.Kill(nil)
+	require.NoError(t, tomb.Wait())
+}
+
+func TestCatchup(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	command, err := filepath.Abs("testdata/catchup")
+	require.NoError(t, err)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: catchup
+journalctl_filter:
+  - _SYSTEMD_UNIT=ssh.service`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	cstest.RequireErrorContains(t, err, "catchup mode requires boot or since")
+
+	j = JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: catchup
+command: `+command+`
+boot: "true"
+since: "-1h"
+journalctl_filter:
+  - _SYSTEMD_UNIT=ssh.service`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--follow", "--no-tail", "-b", "--since=-1h", "_SYSTEMD_UNIT=ssh.service"}, j.args)
+	assert.Equal(t, configuration.TAIL_MODE, j.GetMode())
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	// the past entries, then the new ones
+	for _, expected := range []string{"historical 1", "historical 2", "live 1"} {
+		select {
+		case evt := <-out:
+			assert.Contains(t, evt.Line.Raw, expected)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", expected)
+		}
+	}
+
+	tomb.Kill(nil)
+	require.NoError(t, tomb.Wait())
+}
+
+func TestMetricsDefaultType(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	metrics.JournalCtlDataSourceLinesRead.Reset()
+
+	wrapper, err := filepath.Abs("testdata/ssh-wrapper")
+	require.NoError(t, err)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	// no type label
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: cat
+command: `+wrapper+`
+journalctl_filter:
+ - _UID=42`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.OneShotAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+
+	counter := metrics.JournalCtlDataSourceLinesRead.With(prometheus.Labels{"source": "journalctl-_UID=42", "datasource_type": "journalctl", "acquis_type": "unknown"})
+	assert.InDelta(t, 3, testutil.ToFloat64(counter), 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.JournalCtlDataSourceLinesRead))
+}
+
+func TestMetricsLevelOverride(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	metrics.JournalCtlDataSourceLinesRead.Reset()
+
+	wrapper, err := filepath.Abs("testdata/ssh-wrapper")
+	require.NoError(t, err)
+
+	subLogger := log.WithField("type", "journalctl")
+
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+mode: cat
+command: `+wrapper+`
+metrics_level: none
+journalctl_filter:
+ - _UID=42`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.OneShotAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.JournalCtlDataSourceLinesRead))
+
+	err = j.Configure([]byte(`
+source: journalctl
+metrics_level: verbose
+journalctl_filter:
+ - _UID=42`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.ErrorIs(t, err, metrics.ErrInvalidMetricsLevel)
+}
+
+func TestReload(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	command, err := filepath.Abs("testdata/echo-args")
+	require.NoError(t, err)
+
+	config := func(filter string, typ string) []byte {
+		return []byte(`
+source: journalctl
+mode: tail
+command: ` + command + `
+labels:
+  type: ` + typ + `
+journalctl_filter:
+ - ` + filter)
+	}
+
+	j := JournalCtlSource{}
+	err = j.Configure(config("_UID=42", "syslog"), log.WithField("type", "journalctl"), metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	expectLine := func(expected string) {
+		t.Helper()
+		select {
+		case evt := <-out:
+			assert.Equal(t, expected, evt.Line.Raw)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", expected)
+		}
+	}
+
+	expectLine("args: --follow -n 0 _UID=42")
+	assert.Equal(t, "journalctl-_UID=42", j.logger.Data["src"])
+
+	// other settings: the command keeps running, they apply to the next lines
+	require.NoError(t, j.Reload(config("_UID=42", "other"), metrics.AcquisitionMetricsLevelFull))
+
+	select {
+	case evt := <-out:
+		t.Fatalf("unexpected event after no-op reload: %q", evt.Line.Raw)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	evt := j.newEvent("line", time.Now().UTC())
+	assert.Equal(t, "other", evt.Line.Labels["type"])
+
+	j.mu.Lock()
+	assert.Equal(t, metrics.AcquisitionMetricsLevelFull, j.metricsLevel)
+	assert.Equal(t, "other", j.acquisType)
+	j.mu.Unlock()
+
+	// new filter: the command is restarted with the new arguments
+	require.NoError(t, j.Reload(config("_UID=43", "syslog"), metrics.AcquisitionMetricsLevelNone))
+	expectLine("args: --follow -n 0 _UID=43")
+
+	j.mu.Lock()
+	assert.Equal(t, "journalctl-_UID=43", j.logger.Data["src"])
+	j.mu.Unlock()
+
+	// the mode can't change
+	err = j.Reload([]byte(`
+source: journalctl
+mode: cat
+journalctl_filter:
+ - _UID=43`), metrics.AcquisitionMetricsLevelNone)
+	cstest.RequireErrorContains(t, err, "cannot change mode from tail to cat on reload")
+
+	// neither can the output buffer
+	err = j.Reload(append(config("_UID=43", "syslog"), []byte(`
+output_buffer:
+  size: 10`)...), metrics.AcquisitionMetricsLevelNone)
+	cstest.RequireErrorContains(t, err, "cannot change output_buffer on reload")
+
+	tomb.Kill(nil)
+	require.NoError(t, tomb.Wait())
+}
+
 func TestStreaming(t *testing.T) {
 	cstest.SkipOnWindows(t)
 
@@ -280,6 +930,164 @@ journalctl_filter:
 	}
 }
 
+func TestOutputBuffer(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	tests := []struct {
+		backpressure string
+		expected     []string
+		dropped      int
+	}{
+		{
+			backpressure: "block",
+			expected:     []string{"1", "2", "3", "4", "5"},
+		},
+		{
+			backpressure: "drop_newest",
+			expected:     []string{"1", "2"},
+			dropped:      3,
+		},
+		{
+			backpressure: "drop_oldest",
+			expected:     []string{"4", "5"},
+			dropped:      3,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.backpressure, func(t *testing.T) {
+			// the command writes its lines at once, then waits like journalctl --follow
+			config := `
+source: journalctl
+mode: tail
+command: sh
+args_prefix:
+ - -c
+ - printf '1\n2\n3\n4\n5\n'; exec sleep 10
+ - sh
+journalctl_filter:
+ - _UID=42
+labels:
+  type: ` + tc.backpressure + `
+output_buffer:
+  size: 2
+  backpressure: ` + tc.backpressure
+
+			j := JournalCtlSource{}
+			err := j.Configure([]byte(config), log.WithField("type", "journalctl"), metrics.AcquisitionMetricsLevelFull)
+			require.NoError(t, err)
+
+			counter := metrics.AcquisitionDroppedEvents.With(prometheus.Labels{"source": j.src, "datasource_type": "journalctl", "acquis_type": tc.backpressure})
+			before := testutil.ToFloat64(counter)
+			dropped := func() int {
+				return int(testutil.ToFloat64(counter) - before)
+			}
+
+			tomb := tomb.Tomb{}
+			out := make(chan types.Event)
+
+			err = j.StreamingAcquisition(ctx, out, &tomb)
+			require.NoError(t, err)
+
+			// the consumer only starts reading once the lines that don't fit are dropped
+			if tc.dropped > 0 {
+				require.Eventually(t, func() bool {
+					return dropped() == tc.dropped
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+
+			for _, line := range tc.expected {
+				select {
+				case evt := <-out:
+					assert.Equal(t, line, evt.Line.Raw)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timeout waiting for line %s", line)
+				}
+			}
+
+			select {
+			case evt := <-out:
+				t.Fatalf("unexpected line %s", evt.Line.Raw)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			assert.Equal(t, tc.dropped, dropped())
+
+			tomb.Kill(nil)
+			err = tomb.Wait()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	// the magic number is enough
+	xzFile := filepath.Join(t.TempDir(), "ssh.export.xz")
+	require.NoError(t, os.WriteFile(xzFile, []byte("\xfd7zXZ\x00\x00"), 0o644))
+
+	tests := []struct {
+		file        string
+		expectedErr string
+	}{
+		{
+			file: "testdata/ssh.export.zst",
+		},
+		{
+			file: "testdata/ssh.export.gz",
+		},
+		{
+			file:        xzFile,
+			expectedErr: "xz compression is not supported",
+		},
+		{
+			file:        "testdata/missing.export",
+			expectedErr: "no such file or directory",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(filepath.Base(tc.file), func(t *testing.T) {
+			j := JournalCtlSource{}
+			err := j.Configure([]byte(`
+source: journalctl
+mode: cat
+export_file: `+tc.file+`
+labels:
+  type: syslog`), log.WithField("type", "journalctl"), metrics.AcquisitionMetricsLevelNone)
+			require.NoError(t, err)
+
+			tomb := tomb.Tomb{}
+			out := make(chan types.Event, 100)
+
+			err = j.OneShotAcquisition(ctx, out, &tomb)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			require.Len(t, out, 3)
+
+			// the events are dated by journald, not by the time the file is read
+			evt := <-out
+			assert.Equal(t, time.UnixMicro(1606040539000000).UTC(), evt.Line.Time)
+
+			// the second message is a binary field
+			evt = <-out
+			assert.True(t, strings.HasSuffix(evt.Line.Raw, " zeroed sshd[1480]: Failed password for invalid user wqeqwe from 127.0.0.1 port 55818 ssh2"), evt.Line.Raw)
+			assert.Equal(t, "journalctl-export-"+tc.file, evt.Line.Src)
+			assert.Equal(t, "syslog", evt.Line.Labels["type"])
+			assert.Equal(t, time.UnixMicro(1606040543000000).UTC(), evt.Line.Time)
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	if os.Getenv("USE_SYSTEM_JOURNALCTL") == "" {
 		fullPath, _ := filepath.Abs("./testdata")
@@ -288,3 +1096,60 @@ func TestMain(m *testing.M) {
 
 	os.Exit(m.Run())
 }
+
+func TestMaxLineLength(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	command, err := filepath.Abs("testdata/long-line")
+	require.NoError(t, err)
+
+	logger, hook := test.NewNullLogger()
+
+	j := JournalCtlSource{}
+	err = j.Configure([]byte(`
+source: journalctl
+command: `+command+`
+journalctl_filter:
+  - _SYSTEMD_UNIT=ssh.service`), logger.WithField("type", "journalctl"), metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event, 100)
+
+	err = j.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	// the long line is skipped, the acquisition goes on
+	for _, expected := range []string{"before", "after"} {
+		select {
+		case evt := <-out:
+			assert.Contains(t, evt.Line.Raw, expected)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", expected)
+		}
+	}
+
+	tomb.Kill(nil)
+	require.NoError(t, tomb.Wait())
+
+	cstest.RequireLogContains(t, hook, "skipping a line longer than max_line_length (65536)")
+}
+
+func TestSkipLongLines(t *testing.T) {
+	skipped := 0
+
+	scanner := bufio.NewScanner(strings.NewReader("short\n" + strings.Repeat("a", 100) + "\nshort again\n" + strings.Repeat("b", 100)))
+	scanner.Buffer(make([]byte, 0, 16), 16)
+	scanner.Split(skipLongLines(16, func() { skipped++ }))
+
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"short", "short again"}, lines)
+	assert.Equal(t, 2, skipped)
+}
@@ -0,0 +1,214 @@
+package syslogacquisition
+
+import (
+	"strconv"
+	"strings"
+)
+
+// values of message_format
+const (
+	messageFormatCEF  = "cef"
+	messageFormatLEEF = "leef"
+)
+
+// names of the labels of the CEF header fields, in order, with the cef_ prefix.
+// The extension keys are prefixed with cef_ext_, so that they can't overwrite them.
+var cefHeaderLabels = []string{"version", "device_vendor", "device_product", "device_version", "signature_id", "name", "severity"}
+
+// names of the labels of the LEEF header fields, in order, with the leef_ prefix.
+// The attributes are prefixed with leef_attr_.
+var leefHeaderLabels = []string{"version", "vendor", "product", "product_version", "event_id"}
+
+// splitHeader returns the first n fields of s separated by pipes, unescaped, and what follows them.
+// It returns false if there are less than n fields.
+func splitHeader(s string, n int) ([]string, string, bool) {
+	fields := make([]string, 0, n)
+
+	var field strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			field.WriteByte(s[i])
+		case s[i] == '|':
+			fields = append(fields, field.String())
+			field.Reset()
+
+			if len(fields) == n {
+				return fields, s[i+1:], true
+			}
+		default:
+			field.WriteByte(s[i])
+		}
+	}
+
+	return nil, "", false
+}
+
+// unescapeCEFValue unescapes the value of a CEF extension.
+func unescapeCEFValue(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	return strings.NewReplacer(`\=`, `=`, `\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(s)
+}
+
+// parseCEFExtension returns the key=value pairs of a CEF extension. The values can contain spaces,
+// they end where the next key begins.
+func parseCEFExtension(ext string) map[string]string {
+	type pair struct {
+		keyStart int
+		eq       int
+	}
+
+	pairs := []pair{}
+
+	for i := range len(ext) {
+		if ext[i] != '=' || (i > 0 && ext[i-1] == '\\') {
+			continue
+		}
+
+		keyStart := strings.LastIndexByte(ext[:i], ' ') + 1
+		if keyStart == i {
+			continue
+		}
+
+		pairs = append(pairs, pair{keyStart: keyStart, eq: i})
+	}
+
+	ret := make(map[string]string, len(pairs))
+
+	for i, p := range pairs {
+		end := len(ext)
+		if i+1 < len(pairs) {
+			end = pairs[i+1].keyStart
+		}
+
+		ret[ext[p.keyStart:p.eq]] = unescapeCEFValue(strings.TrimRight(ext[p.eq+1:end], " "))
+	}
+
+	return ret
+}
+
+// parseCEF returns the fields of the CEF message found in the line, or false if there is none.
+func parseCEF(line string) (map[string]string, bool) {
+	idx := strings.Index(line, "CEF:")
+	if idx < 0 {
+		return nil, false
+	}
+
+	// the syslog parser sees CEF as the tag, and adds a space after it
+	header, ext, ok := splitHeader(strings.TrimLeft(line[idx+len("CEF:"):], " "), len(cefHeaderLabels))
+	if !ok {
+		return nil, false
+	}
+
+	extension := parseCEFExtension(ext)
+	ret := make(map[string]string, len(cefHeaderLabels)+len(extension))
+
+	for i, name := range cefHeaderLabels {
+		ret[name] = header[i]
+	}
+
+	for k, v := range extension {
+		ret["ext_"+k] = v
+	}
+
+	return ret, true
+}
+
+// leefDelimiter returns the attribute delimiter of a LEEF 2.0 header: a character,
+// or its code in hexadecimal. It defaults to a tab, like in LEEF 1.0.
+func leefDelimiter(s string) (string, bool) {
+	switch {
+	case s == "":
+		return "\t", true
+	case len(s) == 1:
+		return s, true
+	}
+
+	code, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0"), "x"), 16, 8)
+	if err != nil {
+		return "", false
+	}
+
+	return string(rune(code)), true
+}
+
+// parseLEEF returns the fields of the LEEF message found in the line, or false if there is none.
+func parseLEEF(line string) (map[string]string, bool) {
+	idx := strings.Index(line, "LEEF:")
+	if idx < 0 {
+		return nil, false
+	}
+
+	header, attrs, ok := splitHeader(strings.TrimLeft(line[idx+len("LEEF:"):], " "), len(leefHeaderLabels))
+	if !ok {
+		return nil, false
+	}
+
+	delimiter := "\t"
+
+	if strings.HasPrefix(header[0], "2.") {
+		var fields []string
+
+		fields, attrs, ok = splitHeader(attrs, 1)
+		if !ok {
+			return nil, false
+		}
+
+		delimiter, ok = leefDelimiter(fields[0])
+		if !ok {
+			return nil, false
+		}
+	}
+
+	ret := make(map[string]string)
+
+	for attr := range strings.SplitSeq(attrs, delimiter) {
+		key, value, found := strings.Cut(attr, "=")
+		if !found || key == "" {
+			continue
+		}
+
+		ret["attr_"+key] = value
+	}
+
+	for i, name := range leefHeaderLabels {
+		ret[name] = header[i]
+	}
+
+	return ret, true
+}
+
+// messageLabels returns the labels of the fields of the message, as configured with message_format.
+// It returns nil if the message can't be parsed, the line is then sent as is.
+func (c *SyslogConfiguration) messageLabels(line string) map[string]string {
+	var (
+		fields map[string]string
+		ok     bool
+	)
+
+	switch c.MessageFormat {
+	case messageFormatCEF:
+		fields, ok = parseCEF(line)
+	case messageFormatLEEF:
+		fields, ok = parseLEEF(line)
+	default:
+		return nil
+	}
+
+	if !ok {
+		return nil
+	}
+
+	labels := make(map[string]string, len(fields))
+
+	for k, v := range fields {
+		labels[c.MessageFormat+"_"+k] = v
+	}
+
+	return labels
+}
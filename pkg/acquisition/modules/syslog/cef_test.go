@@ -0,0 +1,227 @@
+package syslogacquisition
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	syslogserver "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/server"
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestParseCEF(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected map[string]string
+	}{
+		{
+			name: "header and extension",
+			line: `fw1 CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232`,
+			expected: map[string]string{
+				"version":        "0",
+				"device_vendor":  "Security",
+				"device_product": "threatmanager",
+				"device_version": "1.0",
+				"signature_id":   "100",
+				"name":           "worm successfully stopped",
+				"severity":       "10",
+				"ext_src":        "10.0.0.1",
+				"ext_dst":        "2.1.2.2",
+				"ext_spt":        "1232",
+			},
+		},
+		{
+			name: "escaped characters",
+			line: `CEF:0|Vendor\|Inc|Product|1.0|100|detected a \\ in message|5|msg=a value with spaces and \= sign act=blocked`,
+			expected: map[string]string{
+				"version":        "0",
+				"device_vendor":  "Vendor|Inc",
+				"device_product": "Product",
+				"device_version": "1.0",
+				"signature_id":   "100",
+				"name":           `detected a \ in message`,
+				"severity":       "5",
+				"ext_msg":        "a value with spaces and = sign",
+				"ext_act":        "blocked",
+			},
+		},
+		{
+			name: "no extension",
+			line: `CEF:1|Vendor|Product|1.0|100|name|5|`,
+			expected: map[string]string{
+				"version":        "1",
+				"device_vendor":  "Vendor",
+				"device_product": "Product",
+				"device_version": "1.0",
+				"signature_id":   "100",
+				"name":           "name",
+				"severity":       "5",
+			},
+		},
+		{
+			name: "extension keys named like the header fields",
+			line: `CEF:0|Vendor|Product|1.0|100|name|5|name=other severity=1`,
+			expected: map[string]string{
+				"version":        "0",
+				"device_vendor":  "Vendor",
+				"device_product": "Product",
+				"device_version": "1.0",
+				"signature_id":   "100",
+				"name":           "name",
+				"severity":       "5",
+				"ext_name":       "other",
+				"ext_severity":   "1",
+			},
+		},
+		{
+			name: "truncated header",
+			line: `CEF:0|Vendor|Product|1.0`,
+		},
+		{
+			name: "not CEF",
+			line: `sshd[49340]: Failed password for root`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fields, ok := parseCEF(tc.line)
+			assert.Equal(t, tc.expected != nil, ok)
+			assert.Equal(t, tc.expected, fields)
+		})
+	}
+}
+
+func TestParseLEEF(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected map[string]string
+	}{
+		{
+			name: "LEEF 1.0",
+			line: "LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdst=172.50.123.1\tsev=5",
+			expected: map[string]string{
+				"version":         "1.0",
+				"vendor":          "Microsoft",
+				"product":         "MSExchange",
+				"product_version": "4.0 SP1",
+				"event_id":        "15345",
+				"attr_src":        "192.0.2.0",
+				"attr_dst":        "172.50.123.1",
+				"attr_sev":        "5",
+			},
+		},
+		{
+			name: "LEEF 2.0 with a delimiter",
+			line: "LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^usrName=joe user",
+			expected: map[string]string{
+				"version":         "2.0",
+				"vendor":          "Lancope",
+				"product":         "StealthWatch",
+				"product_version": "1.0",
+				"event_id":        "41",
+				"attr_src":        "10.0.1.8",
+				"attr_dst":        "10.0.0.5",
+				"attr_usrName":    "joe user",
+			},
+		},
+		{
+			name: "LEEF 2.0 with an hex delimiter",
+			line: "LEEF:2.0|Vendor|Product|1.0|41|x7C|src=10.0.1.8|dst=10.0.0.5",
+			expected: map[string]string{
+				"version":         "2.0",
+				"vendor":          "Vendor",
+				"product":         "Product",
+				"product_version": "1.0",
+				"event_id":        "41",
+				"attr_src":        "10.0.1.8",
+				"attr_dst":        "10.0.0.5",
+			},
+		},
+		{
+			name: "invalid delimiter",
+			line: "LEEF:2.0|Vendor|Product|1.0|41|xZZ|src=10.0.1.8",
+		},
+		{
+			name: "not LEEF",
+			line: "CEF:0|Vendor|Product|1.0|100|name|5|",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fields, ok := parseLEEF(tc.line)
+			assert.Equal(t, tc.expected != nil, ok)
+			assert.Equal(t, tc.expected, fields)
+		})
+	}
+}
+
+func TestMessageFormat(t *testing.T) {
+	subLogger := log.WithField("type", "syslog")
+
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+message_format: json`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	cstest.RequireErrorContains(t, err, `invalid message_format "json": must be cef or leef`)
+
+	s = SyslogSource{}
+	err = s.Configure([]byte(`source: syslog
+message_format: cef
+labels:
+  type: cef`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	c := make(chan syslogserver.SyslogMessage)
+	out := make(chan types.Event, 10)
+
+	serverTomb := tomb.Tomb{}
+	serverTomb.Go(func() error {
+		<-serverTomb.Dying()
+		return nil
+	})
+
+	tomb := tomb.Tomb{}
+	tomb.Go(func() error {
+		return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+	})
+
+	for _, msg := range []string{
+		`<134>May 18 12:37:56 fw1 CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2`,
+		`<134>May 18 12:37:57 fw1 CEF:0|truncated`,
+	} {
+		c <- syslogserver.SyslogMessage{Message: []byte(msg), Client: "10.0.0.1"}
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+	close(out)
+
+	require.Len(t, out, 2)
+
+	evt := <-out
+	// the syslog parser sees CEF as the tag
+	assert.Equal(t, "May 18 12:37:56 fw1 CEF: 0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2", evt.Line.Raw)
+	assert.Equal(t, "cef", evt.Line.Labels["type"])
+	assert.Equal(t, "fw1", evt.Line.Labels[sourceHostnameLabel])
+	assert.Equal(t, "0", evt.Line.Labels["cef_version"])
+	assert.Equal(t, "Security", evt.Line.Labels["cef_device_vendor"])
+	assert.Equal(t, "worm successfully stopped", evt.Line.Labels["cef_name"])
+	assert.Equal(t, "10", evt.Line.Labels["cef_severity"])
+	assert.Equal(t, "10.0.0.1", evt.Line.Labels["cef_ext_src"])
+	assert.Equal(t, "2.1.2.2", evt.Line.Labels["cef_ext_dst"])
+
+	// sent as is
+	evt = <-out
+	assert.Equal(t, "May 18 12:37:57 fw1 CEF: 0|truncated", evt.Line.Raw)
+	assert.Equal(t, map[string]string{"type": "cef", sourceHostnameLabel: "fw1"}, evt.Line.Labels)
+}
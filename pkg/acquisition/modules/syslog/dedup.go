@@ -0,0 +1,112 @@
+package syslogacquisition
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// repeatCountLabel is set on the events coalescing the repeats of a message, to the number of repeats.
+const repeatCountLabel = "repeat_count"
+
+type pendingRepeat struct {
+	key   string
+	evt   types.Event // the last repeat
+	count int
+	first time.Time
+}
+
+// flush returns the event coalescing the repeats, if any.
+func (p *pendingRepeat) flush() []types.Event {
+	if p.count == 0 {
+		return nil
+	}
+
+	p.evt.Line.Labels[repeatCountLabel] = strconv.Itoa(p.count)
+
+	return []types.Event{p.evt}
+}
+
+// repeatCoalescer remembers the last message of each client, to merge the identical messages that
+// follow it within the window into a single event. The first occurrence is sent right away, the
+// repeats when the window is over or the client sends another message.
+// A nil *repeatCoalescer lets everything through.
+type repeatCoalescer struct {
+	window  time.Duration
+	pending map[string]*pendingRepeat
+}
+
+func newRepeatCoalescer(window time.Duration) *repeatCoalescer {
+	if window <= 0 {
+		return nil
+	}
+
+	return &repeatCoalescer{
+		window:  window,
+		pending: make(map[string]*pendingRepeat),
+	}
+}
+
+// repeatKey returns the part of a line that identifies a message: the repeats are sent at different
+// times, the timestamp of the syslog header is left out.
+func repeatKey(line string, hasHeader bool) string {
+	if !hasHeader {
+		return line
+	}
+
+	// the line starts with a syslogTimestampLayout timestamp, see buildLogFromSyslog
+	n := strings.Count(syslogTimestampLayout, " ") + 1
+
+	fields := strings.SplitN(line, " ", n+1)
+	if len(fields) <= n {
+		return line
+	}
+
+	return fields[n]
+}
+
+// add returns the events that can be sent after receiving a message from client: none if it repeats
+// the last one, else the repeats of the last one if any, and the new message.
+func (r *repeatCoalescer) add(client string, key string, evt types.Event, now time.Time) []types.Event {
+	if r == nil {
+		return []types.Event{evt}
+	}
+
+	var ret []types.Event
+
+	p, ok := r.pending[client]
+	if ok {
+		if p.key == key && now.Sub(p.first) < r.window {
+			p.evt = evt
+			p.count++
+
+			return nil
+		}
+
+		ret = p.flush()
+	}
+
+	r.pending[client] = &pendingRepeat{key: key, first: now}
+
+	return append(ret, evt)
+}
+
+// expire returns the repeats whose window is over, or all of them if all is true.
+func (r *repeatCoalescer) expire(now time.Time, all bool) []types.Event {
+	if r == nil {
+		return nil
+	}
+
+	var ret []types.Event
+
+	for client, p := range r.pending {
+		if all || now.Sub(p.first) >= r.window {
+			ret = append(ret, p.flush()...)
+			delete(r.pending, client)
+		}
+	}
+
+	return ret
+}
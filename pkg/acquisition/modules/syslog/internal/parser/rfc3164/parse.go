@@ -21,7 +21,9 @@ type RFC3164 struct {
 	position       int
 	buf            []byte
 	useCurrentYear bool //If no year is specified in the timestamp, use the current year
+	inferYear      bool //If no year is specified in the timestamp, use the year giving the most recent date that is not in the future
 	strictHostname bool //If the hostname contains invalid characters or is not an IP, return an error
+	now            func() time.Time
 }
 
 const PRI_MAX_LEN = 3
@@ -41,6 +43,40 @@ func WithCurrentYear() RFC3164Option {
 	}
 }
 
+// WithInferredYear completes the timestamps without a year with the current year, the previous one if
+// the date would be in the future (ie. a message from December received in January), or the next one
+// if it's the new year for the sender but not yet for us.
+func WithInferredYear() RFC3164Option {
+	return func(r *RFC3164) {
+		r.inferYear = true
+	}
+}
+
+// maxFutureSkew is how far in the future an inferred date can be, for the senders in another timezone
+// or with a clock ahead of ours.
+const maxFutureSkew = 24 * time.Hour
+
+// inferYear returns ts in the year that gives the most recent date not later than now+maxFutureSkew.
+// February 29 is only looked for in leap years, the previous one can be up to 8 years ago.
+func inferYear(ts time.Time, now time.Time) time.Time {
+	limit := now.Add(maxFutureSkew)
+
+	for year := now.Year() + 1; year >= now.Year()-8; year-- {
+		candidate := time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), ts.Location())
+
+		// normalized to March 1st, not a leap year
+		if candidate.Month() != ts.Month() {
+			continue
+		}
+
+		if !candidate.After(limit) {
+			return candidate
+		}
+	}
+
+	return ts
+}
+
 func WithStrictHostname() RFC3164Option {
 	return func(r *RFC3164) {
 		r.strictHostname = true
@@ -104,6 +140,9 @@ func (r *RFC3164) parseTimestamp() error {
 			r.Timestamp = time.Date(time.Now().Year(), r.Timestamp.Month(), r.Timestamp.Day(), r.Timestamp.Hour(), r.Timestamp.Minute(), r.Timestamp.Second(), r.Timestamp.Nanosecond(), r.Timestamp.Location())
 		}
 	}
+	if r.inferYear && r.Timestamp.Year() == 0 {
+		r.Timestamp = inferYear(r.Timestamp, r.now())
+	}
 	r.position++
 	return nil
 }
@@ -246,7 +285,7 @@ func (r *RFC3164) Parse(message []byte) error {
 }
 
 func NewRFC3164Parser(opts ...RFC3164Option) *RFC3164 {
-	r := &RFC3164{}
+	r := &RFC3164{now: time.Now}
 	for _, opt := range opts {
 		opt(r)
 	}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/crowdsecurity/go-cs-lib/cstest"
 )
@@ -81,6 +82,43 @@ func TestTimestamp(t *testing.T) {
 	}
 }
 
+func TestInferredYear(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		now      string
+		expected string
+	}{
+		{"same day", "May 20 09:33:54", "2024-05-20T10:00:00Z", "2024-05-20T09:33:54Z"},
+		{"december message parsed in january", "Dec 31 23:59:58", "2025-01-01T00:00:05Z", "2024-12-31T23:59:58Z"},
+		{"earlier in the year", "Jan  2 09:33:54", "2025-10-16T10:00:00Z", "2025-01-02T09:33:54Z"},
+		{"later in the year", "Oct 30 09:33:54", "2025-10-16T10:00:00Z", "2024-10-30T09:33:54Z"},
+		{"sender clock slightly ahead", "Oct 16 20:00:00", "2025-10-16T10:00:00Z", "2025-10-16T20:00:00Z"},
+		{"january message parsed in december", "Jan  1 00:00:01", "2024-12-31T23:59:58Z", "2025-01-01T00:00:01Z"},
+		{"year is given", "Dec 31 23:59:58 2025", "2025-01-01T00:00:05Z", "2025-12-31T23:59:58Z"},
+		{"february 29 in a leap year", "Feb 29 12:00:00", "2024-03-01T10:00:00Z", "2024-02-29T12:00:00Z"},
+		{"february 29 after a leap year", "Feb 29 12:00:00", "2026-10-16T10:00:00Z", "2024-02-29T12:00:00Z"},
+		{"february 29 parsed in january", "Feb 29 12:00:00", "2029-01-01T00:00:00Z", "2028-02-29T12:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, test.now)
+			require.NoError(t, err)
+
+			r := NewRFC3164Parser(WithInferredYear())
+			r.now = func() time.Time { return now }
+			r.buf = []byte(test.input)
+			r.len = len(r.buf)
+
+			err = r.parseTimestamp()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expected, r.Timestamp.Format(time.RFC3339))
+		})
+	}
+}
+
 func TestHostname(t *testing.T) {
 	tests := []struct {
 		input          string
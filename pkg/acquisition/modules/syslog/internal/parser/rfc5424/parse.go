@@ -1,6 +1,7 @@
 package rfc5424
 
 import (
+	"bytes"
 	"errors"
 	"time"
 
@@ -283,7 +284,14 @@ func (r *RFC5424) parseStructuredData() error {
 	return nil
 }
 
+// utf8BOM may be sent before the message to mark it as UTF-8 (RFC5424 section 6.4)
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 func (r *RFC5424) parseMessage() error {
+	if bytes.HasPrefix(r.buf[r.position:], utf8BOM) {
+		r.position += len(utf8BOM)
+	}
+
 	if r.position == r.len {
 		return errors.New("message is empty")
 	}
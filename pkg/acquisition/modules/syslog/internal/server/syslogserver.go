@@ -1,22 +1,38 @@
 package syslogserver
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
+	"net/netip"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/tomb.v2"
 )
 
 type SyslogServer struct {
-	listenAddr    string
-	port          int
-	channel       chan SyslogMessage
-	udpConn       *net.UDPConn
-	Logger        *log.Entry
-	MaxMessageLen int
+	listenAddr       string
+	port             int
+	socketPath       string
+	channel          chan SyslogMessage
+	conn             net.PacketConn
+	tcpListener      *net.TCPListener
+	tlsConfig        *tls.Config
+	maxConnections   int
+	connectionsGauge prometheus.Gauge
+	readTimeout      time.Duration
+	timeoutsCounter  prometheus.Counter
+	allowedSources   []netip.Prefix
+	rejectedSource   func(client string)
+	Logger           *log.Entry
+	MaxMessageLen    int
 }
 
 type SyslogMessage struct {
@@ -36,20 +52,93 @@ func (s *SyslogServer) Listen(listenAddr string, port int) error {
 		return fmt.Errorf("could not listen on port %d: %w", s.port, err)
 	}
 	s.Logger.Debugf("listening on %s:%d", s.listenAddr, s.port)
-	s.udpConn = udpConn
+	s.conn = udpConn
 
-	err = s.udpConn.SetReadDeadline(time.Now().UTC().Add(100 * time.Millisecond))
+	err = s.conn.SetReadDeadline(time.Now().UTC().Add(100 * time.Millisecond))
 	if err != nil {
 		return fmt.Errorf("could not set read deadline on UDP socket: %w", err)
 	}
 	return nil
 }
 
+// ListenUnix listens on a unix datagram socket, a stale socket file at the same path is replaced.
+func (s *SyslogServer) ListenUnix(socketPath string) error {
+	if fi, err := os.Lstat(socketPath); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return fmt.Errorf("could not remove stale socket %s: %w", socketPath, err)
+		}
+	}
+	unixConn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not listen on socket %s: %w", socketPath, err)
+	}
+	s.Logger.Debugf("listening on %s", socketPath)
+	s.socketPath = socketPath
+	s.conn = unixConn
+
+	err = s.conn.SetReadDeadline(time.Now().UTC().Add(100 * time.Millisecond))
+	if err != nil {
+		return fmt.Errorf("could not set read deadline on unix socket: %w", err)
+	}
+	return nil
+}
+
 func (s *SyslogServer) SetChannel(c chan SyslogMessage) {
 	s.channel = c
 }
 
+func (s *SyslogServer) clientName(addr net.Addr) string {
+	if s.socketPath != "" {
+		// senders on a unix socket are usually unnamed
+		return s.socketPath
+	}
+	return strings.Split(addr.String(), ":")[0]
+}
+
+// SetAllowedSources drops the UDP messages and closes the TCP connections from the addresses
+// that are not in one of the prefixes, calling rejected for each of them if not nil.
+// It doesn't apply to unix sockets.
+func (s *SyslogServer) SetAllowedSources(prefixes []netip.Prefix, rejected func(client string)) {
+	s.allowedSources = prefixes
+	s.rejectedSource = rejected
+}
+
+// sourceAllowed reports whether the messages from addr can be processed.
+func (s *SyslogServer) sourceAllowed(addr net.Addr) bool {
+	if len(s.allowedSources) == 0 {
+		return true
+	}
+
+	var ip netip.Addr
+
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.AddrPort().Addr().Unmap()
+	case *net.TCPAddr:
+		ip = a.AddrPort().Addr().Unmap()
+	default:
+		return true
+	}
+
+	if slices.ContainsFunc(s.allowedSources, func(p netip.Prefix) bool { return p.Contains(ip) }) {
+		return true
+	}
+
+	if s.rejectedSource != nil {
+		s.rejectedSource(s.clientName(addr))
+	}
+
+	return false
+}
+
 func (s *SyslogServer) StartServer() *tomb.Tomb {
+	if s.tcpListener != nil {
+		return s.startTCPServer()
+	}
+
 	t := tomb.Tomb{}
 
 	t.Go(func() error {
@@ -63,16 +152,16 @@ func (s *SyslogServer) StartServer() *tomb.Tomb {
 				//RFC3164 says 1024 bytes max
 				//RFC5424 says 480 bytes minimum, and should support up to 2048 bytes
 				b := make([]byte, s.MaxMessageLen)
-				n, addr, err := s.udpConn.ReadFrom(b)
+				n, addr, err := s.conn.ReadFrom(b)
 				if err != nil && !strings.Contains(err.Error(), "i/o timeout") {
 					s.Logger.Errorf("error while reading from socket : %s", err)
-					s.udpConn.Close()
+					s.conn.Close()
 					return err
 				}
-				if err == nil {
-					s.channel <- SyslogMessage{Message: b[:n], Client: strings.Split(addr.String(), ":")[0]}
+				if err == nil && s.sourceAllowed(addr) {
+					s.channel <- SyslogMessage{Message: b[:n], Client: s.clientName(addr)}
 				}
-				err = s.udpConn.SetReadDeadline(time.Now().UTC().Add(100 * time.Millisecond))
+				err = s.conn.SetReadDeadline(time.Now().UTC().Add(100 * time.Millisecond))
 				if err != nil {
 					return err
 				}
@@ -83,9 +172,14 @@ func (s *SyslogServer) StartServer() *tomb.Tomb {
 }
 
 func (s *SyslogServer) KillServer() error {
-	err := s.udpConn.Close()
+	err := s.conn.Close()
 	if err != nil {
-		return fmt.Errorf("could not close UDP connection: %w", err)
+		return fmt.Errorf("could not close connection: %w", err)
+	}
+	if s.socketPath != "" {
+		if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("could not remove socket %s: %w", s.socketPath, err)
+		}
 	}
 	close(s.channel)
 	return nil
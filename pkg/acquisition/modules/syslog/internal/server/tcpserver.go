@@ -0,0 +1,162 @@
+package syslogserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/tomb.v2"
+)
+
+// ListenTCP listens on a TCP socket. Messages are expected to be separated by a newline
+// (non-transparent framing, RFC 6587). If maxConnections is > 0, clients connecting while
+// that many connections are already open are disconnected right away.
+func (s *SyslogServer) ListenTCP(listenAddr string, port int, maxConnections int) error {
+	s.listenAddr = listenAddr
+	s.port = port
+	s.maxConnections = maxConnections
+	tcpAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(s.listenAddr, strconv.Itoa(s.port)))
+	if err != nil {
+		return fmt.Errorf("could not resolve addr %s: %w", s.listenAddr, err)
+	}
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return fmt.Errorf("could not listen on port %d: %w", s.port, err)
+	}
+	s.Logger.Debugf("listening on %s:%d (tcp)", s.listenAddr, s.port)
+	s.tcpListener = listener
+	return nil
+}
+
+// SetTLSConfig makes the TCP listener expect TLS connections (RFC 5425).
+func (s *SyslogServer) SetTLSConfig(c *tls.Config) {
+	s.tlsConfig = c
+}
+
+// SetConnectionsGauge sets a gauge to track the number of open TCP connections.
+func (s *SyslogServer) SetConnectionsGauge(g prometheus.Gauge) {
+	s.connectionsGauge = g
+}
+
+// SetReadTimeout closes the TCP connections that don't send a complete message within the timeout,
+// counting them with the provided counter if not nil.
+func (s *SyslogServer) SetReadTimeout(timeout time.Duration, counter prometheus.Counter) {
+	s.readTimeout = timeout
+	s.timeoutsCounter = counter
+}
+
+func (s *SyslogServer) startTCPServer() *tomb.Tomb {
+	t := tomb.Tomb{}
+	conns := make(map[net.Conn]struct{})
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	t.Go(func() error {
+		for {
+			select {
+			case <-t.Dying():
+				s.Logger.Info("Syslog server tomb is dying")
+				mu.Lock()
+				for conn := range conns {
+					conn.Close()
+				}
+				mu.Unlock()
+				s.tcpListener.Close()
+				// don't close the channel before all the connections are done writing to it
+				wg.Wait()
+				close(s.channel)
+				return nil
+			default:
+				if err := s.tcpListener.SetDeadline(time.Now().UTC().Add(100 * time.Millisecond)); err != nil {
+					return err
+				}
+				conn, err := s.tcpListener.Accept()
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					continue
+				}
+				if err != nil {
+					s.Logger.Errorf("error while accepting connection : %s", err)
+					s.tcpListener.Close()
+					return err
+				}
+				if !s.sourceAllowed(conn.RemoteAddr()) {
+					s.Logger.Debugf("rejecting connection from %s: source not allowed", conn.RemoteAddr())
+					conn.Close()
+					continue
+				}
+				mu.Lock()
+				if s.maxConnections > 0 && len(conns) >= s.maxConnections {
+					mu.Unlock()
+					s.Logger.Warningf("too many connections (%d), rejecting %s", s.maxConnections, conn.RemoteAddr())
+					conn.Close()
+					continue
+				}
+				if s.tlsConfig != nil {
+					// the handshake happens on the first read, within the read timeout
+					conn = tls.Server(conn, s.tlsConfig)
+				}
+				conns[conn] = struct{}{}
+				mu.Unlock()
+				if s.connectionsGauge != nil {
+					s.connectionsGauge.Inc()
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.handleTCPConn(&t, conn)
+					mu.Lock()
+					delete(conns, conn)
+					mu.Unlock()
+					if s.connectionsGauge != nil {
+						s.connectionsGauge.Dec()
+					}
+				}()
+			}
+		}
+	})
+	return &t
+}
+
+func (s *SyslogServer) handleTCPConn(t *tomb.Tomb, conn net.Conn) {
+	defer conn.Close()
+	client := s.clientName(conn.RemoteAddr())
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, s.MaxMessageLen), s.MaxMessageLen)
+	for {
+		if s.readTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(s.readTimeout)); err != nil {
+				s.Logger.Warningf("could not set read deadline for %s: %s", client, err)
+				return
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		// the scanner reuses its buffer
+		msg := append([]byte{}, scanner.Bytes()...)
+		select {
+		case s.channel <- SyslogMessage{Message: msg, Client: client}:
+		case <-t.Dying():
+			return
+		}
+	}
+	err := scanner.Err()
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, net.ErrClosed):
+	case errors.As(err, &netErr) && netErr.Timeout():
+		s.Logger.Debugf("closing connection from %s: no message received in %s", client, s.readTimeout)
+		if s.timeoutsCounter != nil {
+			s.timeoutsCounter.Inc()
+		}
+	default:
+		s.Logger.Warningf("error while reading from %s: %s", client, err)
+	}
+}
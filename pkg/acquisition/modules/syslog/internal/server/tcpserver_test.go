@@ -0,0 +1,23 @@
+package syslogserver
+
+import (
+	"net"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenTCPHostname(t *testing.T) {
+	s := SyslogServer{Logger: log.WithField("test", t.Name())}
+
+	err := s.ListenTCP("localhost", 0, 0)
+	require.NoError(t, err)
+
+	defer s.tcpListener.Close()
+
+	addr, ok := s.tcpListener.Addr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.True(t, addr.IP.IsLoopback(), "bound to %s", addr.IP)
+}
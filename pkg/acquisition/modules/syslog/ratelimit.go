@@ -0,0 +1,82 @@
+package syslogacquisition
+
+import (
+	"sync"
+	"time"
+
+	"github.com/crowdsecurity/crowdsec/pkg/time/rate"
+)
+
+// maxRateLimitedClients bounds the number of senders tracked by the rate limiter.
+const maxRateLimitedClients = 10000
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// clientLimiters holds a token bucket per remote client. A nil *clientLimiters allows everything.
+type clientLimiters struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	idle     time.Duration // time after which an unused bucket is full again, and can be forgotten
+	limiters map[string]*clientLimiter
+}
+
+func newClientLimiters(limit float64, burst int) *clientLimiters {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &clientLimiters{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		idle:     time.Duration(float64(burst) / limit * float64(time.Second)),
+		limiters: make(map[string]*clientLimiter),
+	}
+}
+
+// allow reports whether a message from client can be processed now.
+func (c *clientLimiters) allow(client string) bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	l, ok := c.limiters[client]
+	if !ok {
+		if len(c.limiters) >= maxRateLimitedClients {
+			c.evict(now)
+		}
+
+		l = &clientLimiter{limiter: rate.NewLimiter(c.limit, c.burst)}
+		c.limiters[client] = l
+	}
+
+	l.lastSeen = now
+
+	return l.limiter.AllowN(now, 1)
+}
+
+// evict forgets the clients whose bucket is full again. If none can be forgotten
+// without losing state, an arbitrary one is dropped to keep the map bounded.
+func (c *clientLimiters) evict(now time.Time) {
+	for client, l := range c.limiters {
+		if now.Sub(l.lastSeen) >= c.idle {
+			delete(c.limiters, client)
+		}
+	}
+
+	for client := range c.limiters {
+		if len(c.limiters) < maxRateLimitedClients {
+			break
+		}
+
+		delete(c.limiters, client)
+	}
+}
@@ -1,12 +1,22 @@
 package syslogacquisition
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	yaml "github.com/goccy/go-yaml"
@@ -21,24 +31,74 @@ import (
 	"github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/parser/rfc5424"
 	syslogserver "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/server"
 	"github.com/crowdsecurity/crowdsec/pkg/metrics"
+	"github.com/crowdsecurity/crowdsec/pkg/time/rate"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
 type SyslogConfiguration struct {
-	Proto                             string `yaml:"protocol,omitempty"`
-	Port                              int    `yaml:"listen_port,omitempty"`
-	Addr                              string `yaml:"listen_addr,omitempty"`
-	MaxMessageLen                     int    `yaml:"max_message_len,omitempty"`
-	DisableRFCParser                  bool   `yaml:"disable_rfc_parser,omitempty"` // if true, we don't try to be smart and just remove the PRI
+	Proto                             string           `yaml:"protocol,omitempty"`
+	Port                              int              `yaml:"listen_port,omitempty"`
+	Addr                              string           `yaml:"listen_addr,omitempty"`
+	UnixSocket                        string           `yaml:"unix_socket,omitempty"` // if set, listen on this unix datagram socket instead of UDP
+	Listeners                         []SyslogListener `yaml:"listeners,omitempty"`   // additional addresses to listen on
+	MaxMessageLen                     int              `yaml:"max_message_len,omitempty"`
+	MaxConnections                    int              `yaml:"max_connections,omitempty"`    // maximum number of simultaneous clients per TCP listener, 0 means no limit
+	ReadTimeout                       time.Duration    `yaml:"read_timeout,omitempty"`       // close TCP connections that don't send a message within this delay, 0 means no timeout
+	DisableRFCParser                  bool             `yaml:"disable_rfc_parser,omitempty"` // if true, we don't try to be smart and just remove the PRI
+	RateLimit                         float64          `yaml:"rate_limit,omitempty"`         // maximum number of messages per second from a single client, 0 means no limit
+	RateLimitBurst                    int              `yaml:"rate_limit_burst,omitempty"`   // number of messages a client can send at once above rate_limit, defaults to rate_limit
+	AllowedSources                    []string         `yaml:"allowed_sources,omitempty"`    // IPs or CIDRs of the senders to accept on UDP and TCP listeners, all if empty
+	DedupWindow                       time.Duration    `yaml:"dedup_window,omitempty"`       // send the repeats of a message from a client within this window as one event, disabled if 0
+	MessageFormat                     string           `yaml:"message_format,omitempty"`     // "cef" or "leef" to add the fields of the messages to the labels, the lines are sent as is if they can't be parsed
 	configuration.DataSourceCommonCfg `yaml:",inline"`
+
+	TLSCertFile     string   `yaml:"tls_cert_file,omitempty"`     // certificate of the listeners using the "tls" protocol
+	TLSKeyFile      string   `yaml:"tls_key_file,omitempty"`      // private key of the certificate
+	TLSMinVersion   string   `yaml:"tls_min_version,omitempty"`   // oldest accepted TLS version, from 1.0 to 1.3, defaults to 1.2
+	TLSCipherSuites []string `yaml:"tls_cipher_suites,omitempty"` // allowed cipher suites up to TLS 1.2, defaults to the secure ones
+
+	PreProcess   configuration.PreProcess   `yaml:"pre_process,omitempty"`   // rewrite the lines before sending them
+	OutputBuffer configuration.OutputBuffer `yaml:"output_buffer,omitempty"` // hold the events when they are not read fast enough, in server mode
+
+	RejectSampleInterval time.Duration `yaml:"reject_sample_interval,omitempty"` // log one of the messages rejected by the parser per interval, to diagnose format issues, disabled if 0
+	RejectSampleMaxLen   int           `yaml:"reject_sample_max_len,omitempty"`  // the logged messages are truncated to this length, defaults to 256
+	ForwardParseErrors   bool          `yaml:"forward_parse_errors,omitempty"`   // send the messages that can't be parsed as they are, with the parse_failed label, instead of dropping them
+
+	MetricsLevel metrics.MetricsLevelConfig `yaml:"metrics_level,omitempty"` // overrides the prometheus level for this source
+
+	// use the timestamp of the syslog header as the time of the events, ie. for replayed logs,
+	// or the reception time if the messages are not parsed
+	UseMessageTimestamp bool `yaml:"use_message_timestamp,omitempty"`
+
+	// year of the RFC3164 timestamps, which have none: "infer" (default) for the most recent date that is
+	// not in the future, ie. the previous year for a message of December received in January, or "current"
+	YearPolicy string `yaml:"year_policy,omitempty"`
+
+	// static labels added to each event, they take precedence over the source_hostname label
+	ExtraLabels map[string]string `yaml:"extra_labels,omitempty"`
+}
+
+type SyslogListener struct {
+	Addr  string `yaml:"listen_addr,omitempty"`
+	Port  int    `yaml:"listen_port,omitempty"`
+	Proto string `yaml:"protocol,omitempty"`
+}
+
+func (l SyslogListener) String() string {
+	return fmt.Sprintf("%s/%s", net.JoinHostPort(l.Addr, strconv.Itoa(l.Port)), l.Proto)
 }
 
 type SyslogSource struct {
 	metricsLevel metrics.AcquisitionMetricsLevel
 	config       SyslogConfiguration
+	listeners    []SyslogListener
+	replayFile   string // file of syslog messages to read in one shot mode
 	logger       *log.Entry
-	server       *syslogserver.SyslogServer
-	serverTomb   *tomb.Tomb
+	allowed      []netip.Prefix    // parsed allowed_sources
+	rejectSample *rate.Limiter     // nil if the rejected messages are not sampled
+	mu           sync.Mutex        // protects config and listeners when reloading
+	reload       chan syslogReload // set while streaming
+	tomb         *tomb.Tomb
 }
 
 func (s *SyslogSource) GetUuid() string {
@@ -62,38 +122,179 @@ func (s *SyslogSource) CanRun() error {
 }
 
 func (s *SyslogSource) GetMetrics() []prometheus.Collector {
-	return []prometheus.Collector{metrics.SyslogDataSourceLinesReceived, metrics.SyslogDataSourceLinesParsed}
+	return []prometheus.Collector{metrics.SyslogDataSourceLinesReceived, metrics.SyslogDataSourceLinesParsed, metrics.SyslogDataSourceLinesRejected, metrics.SyslogDataSourceTCPConnections, metrics.SyslogDataSourceTCPTimeouts, metrics.AcquisitionDroppedEvents}
 }
 
 func (s *SyslogSource) GetAggregMetrics() []prometheus.Collector {
-	return []prometheus.Collector{metrics.SyslogDataSourceLinesReceived, metrics.SyslogDataSourceLinesParsed}
+	return []prometheus.Collector{metrics.SyslogDataSourceLinesReceived, metrics.SyslogDataSourceLinesParsed, metrics.SyslogDataSourceLinesRejected, metrics.SyslogDataSourceTCPConnections, metrics.SyslogDataSourceTCPTimeouts, metrics.AcquisitionDroppedEvents}
 }
 
 func (s *SyslogSource) ConfigureByDSN(dsn string, labels map[string]string, logger *log.Entry, uuid string) error {
-	return errors.New("syslog datasource does not support one shot acquisition")
+	// format for the DSN is : syslog:///path/to/file?log_level=debug
+	if !strings.HasPrefix(dsn, "syslog://") {
+		return fmt.Errorf("invalid DSN %s for syslog source, must start with syslog://", dsn)
+	}
+
+	s.logger = logger
+	s.config = SyslogConfiguration{}
+	s.config.Mode = configuration.CAT_MODE
+	s.config.Labels = labels
+	s.config.UniqueId = uuid
+	// we're reading logs at once, it must be time-machine buckets
+	s.config.UseTimeMachine = true
+
+	args := strings.SplitN(strings.TrimPrefix(dsn, "syslog://"), "?", 2)
+
+	if args[0] == "" {
+		return errors.New("empty syslog:// DSN")
+	}
+
+	s.replayFile = args[0]
+
+	if len(args) == 2 && args[1] != "" {
+		params, err := url.ParseQuery(args[1])
+		if err != nil {
+			return fmt.Errorf("could not parse syslog DSN: %w", err)
+		}
+
+		for key, value := range params {
+			switch key {
+			case "log_level":
+				if len(value) != 1 {
+					return errors.New("expected zero or one value for 'log_level'")
+				}
+
+				lvl, err := log.ParseLevel(value[0])
+				if err != nil {
+					return fmt.Errorf("unknown level %s: %w", value[0], err)
+				}
+
+				s.logger.Logger.SetLevel(lvl)
+			case "disable_rfc_parser":
+				if len(value) != 1 {
+					return errors.New("expected zero or one value for 'disable_rfc_parser'")
+				}
+
+				disable, err := strconv.ParseBool(value[0])
+				if err != nil {
+					return fmt.Errorf("invalid value for 'disable_rfc_parser': %w", err)
+				}
+
+				s.config.DisableRFCParser = disable
+			default:
+				return fmt.Errorf("unsupported key %s in syslog DSN", key)
+			}
+		}
+	}
+
+	if s.config.MaxMessageLen == 0 {
+		s.config.MaxMessageLen = 2048
+	}
+
+	return nil
 }
 
-func (s *SyslogSource) OneShotAcquisition(_ context.Context, _ chan types.Event, _ *tomb.Tomb) error {
-	return errors.New("syslog datasource does not support one shot acquisition")
+// OneShotAcquisition reads the syslog messages of a file, one per line, as if they were received from the network.
+func (s *SyslogSource) OneShotAcquisition(_ context.Context, out chan types.Event, t *tomb.Tomb) error {
+	logger := s.logger.WithField("oneshot", s.replayFile)
+
+	fd, err := os.Open(s.replayFile)
+	if err != nil {
+		return fmt.Errorf("failed opening %s: %w", s.replayFile, err)
+	}
+
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 0, 64*1024), max(s.config.MaxMessageLen, bufio.MaxScanTokenSize))
+
+	logger.Infof("reading %s at once", s.replayFile)
+
+	for scanner.Scan() {
+		select {
+		case <-t.Dying():
+			logger.Info("Syslog datasource stopping")
+			return nil
+		default:
+		}
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		// the scanner reuses its buffer
+		syslogLine := syslogserver.SyslogMessage{Message: bytes.Clone(scanner.Bytes()), Client: s.replayFile}
+
+		line, hostname, ts, failed := s.parseLine(syslogLine)
+		if line == "" {
+			continue
+		}
+
+		out <- s.makeEvent(syslogLine, line, hostname, ts, failed)
+	}
+
+	if err := scanner.Err(); err != nil {
+		logger.Errorf("Error while reading file: %s", err)
+		t.Kill(err)
+
+		return err
+	}
+
+	t.Kill(nil)
+
+	return nil
 }
 
 func validatePort(port int) bool {
 	return port > 0 && port <= 65535
 }
 
-func validateAddr(addr string) bool {
-	return net.ParseIP(addr) != nil
+// looksLikeIP returns true if addr is meant to be an IP address rather than a hostname.
+func looksLikeIP(addr string) bool {
+	if strings.Contains(addr, ":") {
+		return true
+	}
+
+	return strings.Trim(addr, "0123456789.") == ""
+}
+
+// normalizeAddr validates a listen address, either an IP or a hostname that resolves,
+// and returns it in canonical form. Hostnames are resolved again when listening.
+func normalizeAddr(addr string) (string, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String(), nil
+	}
+
+	if looksLikeIP(addr) {
+		return "", fmt.Errorf("invalid listen IP %s", addr)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(addr, "."))
+
+	if _, err := net.LookupHost(host); err != nil {
+		return "", fmt.Errorf("cannot resolve listen address %s: %w", addr, err)
+	}
+
+	return host, nil
 }
 
 func (s *SyslogSource) UnmarshalConfig(yamlConfig []byte) error {
 	s.config = SyslogConfiguration{}
 	s.config.Mode = configuration.TAIL_MODE
 
-	err := yaml.UnmarshalWithOptions(yamlConfig, &s.config, yaml.Strict())
+	yamlConfig, err := configuration.ExpandEnv(yamlConfig)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.UnmarshalWithOptions(yamlConfig, &s.config, yaml.Strict())
 	if err != nil {
 		return fmt.Errorf("cannot parse syslog configuration: %s", yaml.FormatError(err, false, false))
 	}
 
+	// when only a list of listeners is provided, don't listen on the default address
+	useMainListener := len(s.config.Listeners) == 0 || s.config.Addr != "" || s.config.Port != 0
+
 	if s.config.Addr == "" {
 		s.config.Addr = "127.0.0.1" // do we want a usable or secure default ?
 	}
@@ -103,11 +304,106 @@ func (s *SyslogSource) UnmarshalConfig(yamlConfig []byte) error {
 	if s.config.MaxMessageLen == 0 {
 		s.config.MaxMessageLen = 2048
 	}
-	if !validatePort(s.config.Port) {
-		return fmt.Errorf("invalid port %d", s.config.Port)
+	if s.config.MaxConnections < 0 {
+		return fmt.Errorf("invalid max_connections %d", s.config.MaxConnections)
+	}
+	if s.config.ReadTimeout < 0 {
+		return fmt.Errorf("invalid read_timeout %s", s.config.ReadTimeout)
+	}
+	if s.config.RateLimit < 0 {
+		return fmt.Errorf("invalid rate_limit %v", s.config.RateLimit)
+	}
+	if s.config.RateLimitBurst < 0 {
+		return fmt.Errorf("invalid rate_limit_burst %d", s.config.RateLimitBurst)
+	}
+	if s.config.RateLimit > 0 && s.config.RateLimitBurst == 0 {
+		s.config.RateLimitBurst = max(1, int(math.Ceil(s.config.RateLimit)))
+	}
+	if err := s.config.PreProcess.Compile(); err != nil {
+		return err
+	}
+	if err := s.config.OutputBuffer.Validate(); err != nil {
+		return err
+	}
+
+	s.allowed, err = parseAllowedSources(s.config.AllowedSources)
+	if err != nil {
+		return err
+	}
+
+	if s.config.DedupWindow < 0 {
+		return fmt.Errorf("invalid dedup_window %s", s.config.DedupWindow)
+	}
+
+	switch s.config.MessageFormat {
+	case "", messageFormatCEF, messageFormatLEEF:
+	default:
+		return fmt.Errorf("invalid message_format %q: must be %s or %s", s.config.MessageFormat, messageFormatCEF, messageFormatLEEF)
+	}
+
+	switch s.config.YearPolicy {
+	case "":
+		s.config.YearPolicy = yearPolicyInfer
+	case yearPolicyInfer, yearPolicyCurrent:
+	default:
+		return fmt.Errorf("invalid year_policy %q: must be %s or %s", s.config.YearPolicy, yearPolicyInfer, yearPolicyCurrent)
+	}
+
+	if s.config.RejectSampleInterval < 0 {
+		return fmt.Errorf("invalid reject_sample_interval %s", s.config.RejectSampleInterval)
+	}
+	if s.config.RejectSampleMaxLen < 0 {
+		return fmt.Errorf("invalid reject_sample_max_len %d", s.config.RejectSampleMaxLen)
+	}
+	if s.config.RejectSampleMaxLen == 0 {
+		s.config.RejectSampleMaxLen = 256
+	}
+	if s.config.RejectSampleInterval > 0 {
+		s.rejectSample = rate.NewLimiter(rate.Every(s.config.RejectSampleInterval), 1)
+	}
+
+	s.listeners = []SyslogListener{}
+
+	if useMainListener && s.config.UnixSocket == "" {
+		s.listeners = append(s.listeners, SyslogListener{Addr: s.config.Addr, Port: s.config.Port, Proto: s.config.Proto})
+	}
+
+	s.listeners = append(s.listeners, s.config.Listeners...)
+
+	seen := make(map[string]bool, len(s.listeners))
+
+	for i := range s.listeners {
+		l := &s.listeners[i]
+		if l.Addr == "" {
+			l.Addr = "127.0.0.1"
+		}
+		if l.Port == 0 {
+			l.Port = 514
+		}
+		if l.Proto == "" {
+			l.Proto = "udp"
+		}
+		if !validatePort(l.Port) {
+			return fmt.Errorf("invalid port %d", l.Port)
+		}
+		addr, err := normalizeAddr(l.Addr)
+		if err != nil {
+			return err
+		}
+		l.Addr = addr
+		if l.Proto != "udp" && l.Proto != "tcp" && l.Proto != "tls" {
+			return fmt.Errorf("unsupported protocol %s", l.Proto)
+		}
+		if seen[l.String()] {
+			return fmt.Errorf("duplicate listener %s", l)
+		}
+		seen[l.String()] = true
 	}
-	if !validateAddr(s.config.Addr) {
-		return fmt.Errorf("invalid listen IP %s", s.config.Addr)
+
+	if slices.ContainsFunc(s.listeners, func(l SyslogListener) bool { return l.Proto == "tls" }) {
+		if _, err := s.config.tlsConfig(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -122,34 +418,280 @@ func (s *SyslogSource) Configure(yamlConfig []byte, logger *log.Entry, metricsLe
 		return err
 	}
 
+	s.metricsLevel, err = configuration.GetMetricsLevel(s.config.MetricsLevel, metricsLevel)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (s *SyslogSource) StreamingAcquisition(ctx context.Context, out chan types.Event, t *tomb.Tomb) error {
-	c := make(chan syslogserver.SyslogMessage)
-	s.server = &syslogserver.SyslogServer{Logger: s.logger.WithField("syslog", "internal"), MaxMessageLen: s.config.MaxMessageLen}
-	s.server.SetChannel(c)
-	err := s.server.Listen(s.config.Addr, s.config.Port)
+	// the buffer is shared by the servers, it's kept when they are restarted
+	out = s.config.OutputBuffer.Start(out, t.Dead(), s.incDropped)
+
+	servers, err := s.startServers(out, t)
 	if err != nil {
-		return fmt.Errorf("could not start syslog server: %w", err)
+		return err
 	}
-	s.serverTomb = s.server.StartServer()
+
+	s.mu.Lock()
+	s.reload = make(chan syslogReload)
+	s.tomb = t
+	s.mu.Unlock()
+
 	t.Go(func() error {
-		defer trace.CatchPanic("crowdsec/acquis/syslog/live")
-		return s.handleSyslogMsg(out, t, c)
+		defer trace.CatchPanic("crowdsec/acquis/syslog/reload")
+		for {
+			select {
+			case <-t.Dying():
+				// the handlers take care of stopping their own server
+				return nil
+			case req := <-s.reload:
+				s.logger.Info("syslog listeners configuration changed, restarting servers")
+				servers.stop()
+
+				s.mu.Lock()
+				s.applyListenerSettings(req.source)
+				s.mu.Unlock()
+
+				servers, err = s.startServers(out, t)
+				req.done <- err
+				if err != nil {
+					return err
+				}
+			}
+		}
 	})
+
 	return nil
 }
 
+// syslogServers are the servers started for the current configuration, along with
+// the goroutines reading their messages.
+type syslogServers struct {
+	tombs    []*tomb.Tomb
+	handlers sync.WaitGroup
+}
+
+// stop kills the servers and waits for them and their handlers to exit.
+func (ss *syslogServers) stop() {
+	for _, serverTomb := range ss.tombs {
+		serverTomb.Kill(nil)
+	}
+
+	for _, serverTomb := range ss.tombs {
+		<-serverTomb.Dead()
+	}
+
+	ss.handlers.Wait()
+}
+
+func (s *SyslogSource) startServers(out chan types.Event, t *tomb.Tomb) (*syslogServers, error) {
+	listens := []func(*syslogserver.SyslogServer) error{}
+
+	if s.config.UnixSocket != "" {
+		listens = append(listens, func(server *syslogserver.SyslogServer) error {
+			return server.ListenUnix(s.config.UnixSocket)
+		})
+	}
+
+	for _, l := range s.listeners {
+		listens = append(listens, func(server *syslogserver.SyslogServer) error {
+			if l.Proto == "udp" {
+				return server.Listen(l.Addr, l.Port)
+			}
+			if l.Proto == "tls" {
+				tlsConfig, err := s.config.tlsConfig()
+				if err != nil {
+					return err
+				}
+				server.SetTLSConfig(tlsConfig)
+			}
+			var timeouts prometheus.Counter
+			if s.metricsLevel != metrics.AcquisitionMetricsLevelNone {
+				labels := prometheus.Labels{"listener": l.String(), "datasource_type": "syslog", "acquis_type": s.config.Labels["type"]}
+				server.SetConnectionsGauge(metrics.SyslogDataSourceTCPConnections.With(labels))
+				timeouts = metrics.SyslogDataSourceTCPTimeouts.With(labels)
+			}
+			if s.config.ReadTimeout > 0 {
+				server.SetReadTimeout(s.config.ReadTimeout, timeouts)
+			}
+			return server.ListenTCP(l.Addr, l.Port, s.config.MaxConnections)
+		})
+	}
+
+	servers := &syslogServers{tombs: make([]*tomb.Tomb, 0, len(listens))}
+	channels := make([]chan syslogserver.SyslogMessage, 0, len(listens))
+
+	for _, listen := range listens {
+		c := make(chan syslogserver.SyslogMessage)
+		server := &syslogserver.SyslogServer{Logger: s.logger.WithField("syslog", "internal"), MaxMessageLen: s.config.MaxMessageLen}
+		server.SetChannel(c)
+		if len(s.allowed) > 0 {
+			server.SetAllowedSources(s.allowed, func(client string) {
+				s.logger.Tracef("source %s is not allowed, dropping message", client)
+				s.incRejected(client, rejectReasonSourceNotAllowed)
+			})
+		}
+		if err := listen(server); err != nil {
+			// stop the servers that have already been started
+			servers.stop()
+			return nil, fmt.Errorf("could not start syslog server: %w", err)
+		}
+		servers.tombs = append(servers.tombs, server.StartServer())
+		channels = append(channels, c)
+	}
+
+	// the limits apply to a client whatever the listener it sends to
+	limiters := newClientLimiters(s.config.RateLimit, s.config.RateLimitBurst)
+
+	for i := range servers.tombs {
+		servers.handlers.Add(1)
+		t.Go(func() error {
+			defer trace.CatchPanic("crowdsec/acquis/syslog/live")
+			defer servers.handlers.Done()
+			return s.handleSyslogMsg(out, t, servers.tombs[i], channels[i], limiters)
+		})
+	}
+
+	return servers, nil
+}
+
+// syslogReload is a request to restart the servers of a running datasource with the configuration of source.
+type syslogReload struct {
+	source *SyslogSource
+	done   chan error
+}
+
+// listenersChanged returns true if the servers must be restarted to go from the current configuration to the one of other.
+func (s *SyslogSource) listenersChanged(other *SyslogSource) bool {
+	return !slices.Equal(s.listeners, other.listeners) ||
+		s.config.UnixSocket != other.config.UnixSocket ||
+		s.config.MaxMessageLen != other.config.MaxMessageLen ||
+		s.config.MaxConnections != other.config.MaxConnections ||
+		s.config.ReadTimeout != other.config.ReadTimeout ||
+		s.config.RateLimit != other.config.RateLimit ||
+		s.config.RateLimitBurst != other.config.RateLimitBurst ||
+		!slices.Equal(s.allowed, other.allowed) ||
+		s.config.TLSCertFile != other.config.TLSCertFile ||
+		s.config.TLSKeyFile != other.config.TLSKeyFile ||
+		s.config.TLSMinVersion != other.config.TLSMinVersion ||
+		!slices.Equal(s.config.TLSCipherSuites, other.config.TLSCipherSuites)
+}
+
+// copyListenerSettings copies the settings compared by listenersChanged from src to dst.
+func copyListenerSettings(dst *SyslogConfiguration, src *SyslogConfiguration) {
+	dst.Proto = src.Proto
+	dst.Port = src.Port
+	dst.Addr = src.Addr
+	dst.UnixSocket = src.UnixSocket
+	dst.Listeners = src.Listeners
+	dst.MaxMessageLen = src.MaxMessageLen
+	dst.MaxConnections = src.MaxConnections
+	dst.ReadTimeout = src.ReadTimeout
+	dst.RateLimit = src.RateLimit
+	dst.RateLimitBurst = src.RateLimitBurst
+	dst.AllowedSources = src.AllowedSources
+	dst.TLSCertFile = src.TLSCertFile
+	dst.TLSKeyFile = src.TLSKeyFile
+	dst.TLSMinVersion = src.TLSMinVersion
+	dst.TLSCipherSuites = src.TLSCipherSuites
+}
+
+// applyListenerSettings copies the settings compared by listenersChanged from other, the rest of the
+// configuration and the state derived from it (output buffer, reject sampling...) are kept.
+func (s *SyslogSource) applyListenerSettings(other *SyslogSource) {
+	copyListenerSettings(&s.config, &other.config)
+	s.listeners = other.listeners
+	s.allowed = other.allowed
+}
+
+// otherSettingsChanged returns true if other changes settings that are not applied on reload:
+// they are used by the running goroutines, or when the datasource starts.
+func (s *SyslogSource) otherSettingsChanged(other *SyslogSource) bool {
+	cfg := other.config
+	copyListenerSettings(&cfg, &s.config)
+
+	// set by the acquisition, not in the yaml
+	cfg.UniqueId = s.config.UniqueId
+
+	// the compiled regexps are not compared
+	samePreProcess := slices.EqualFunc(cfg.PreProcess, s.config.PreProcess, func(a, b configuration.PreProcessRule) bool {
+		return a.Regexp == b.Regexp && a.Replace == b.Replace
+	})
+	cfg.PreProcess = s.config.PreProcess
+
+	return !samePreProcess || !reflect.DeepEqual(cfg, s.config) || other.metricsLevel != s.metricsLevel
+}
+
+// Reload parses newConfig and, if it changes the listeners or their settings, restarts
+// the servers. Events keep being sent to the same output channel. The other settings
+// can't be changed.
+func (s *SyslogSource) Reload(newConfig []byte, metricsLevel metrics.AcquisitionMetricsLevel) error {
+	reloaded := &SyslogSource{logger: s.logger}
+	if err := reloaded.UnmarshalConfig(newConfig); err != nil {
+		return err
+	}
+
+	var err error
+
+	reloaded.metricsLevel, err = configuration.GetMetricsLevel(reloaded.config.MetricsLevel, metricsLevel)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if reloaded.config.Mode != s.config.Mode {
+		mode := s.config.Mode
+		s.mu.Unlock()
+		return fmt.Errorf("cannot change mode from %s to %s on reload", mode, reloaded.config.Mode)
+	}
+
+	if s.otherSettingsChanged(reloaded) {
+		s.mu.Unlock()
+		return errors.New("only the listeners and their settings can be changed on reload")
+	}
+
+	if !s.listenersChanged(reloaded) {
+		s.mu.Unlock()
+		s.logger.Debug("syslog listeners configuration unchanged, nothing to reload")
+		return nil
+	}
+
+	if s.reload == nil {
+		// not started yet
+		s.applyListenerSettings(reloaded)
+		s.mu.Unlock()
+		return nil
+	}
+
+	reloadChan, t := s.reload, s.tomb
+	s.mu.Unlock()
+
+	req := syslogReload{source: reloaded, done: make(chan error, 1)}
+
+	select {
+	case reloadChan <- req:
+	case <-t.Dying():
+		return errors.New("syslog datasource is stopped")
+	}
+
+	return <-req.done
+}
+
+// syslogTimestampLayout is the format of the timestamp at the start of the lines built from the syslog header
+const syslogTimestampLayout = "Jan 2 15:04:05"
+
 func (s *SyslogSource) buildLogFromSyslog(ts time.Time, hostname string,
 	appname string, pid string, msg string,
 ) string {
 	ret := ""
 	if !ts.IsZero() {
-		ret += ts.Format("Jan 2 15:04:05")
+		ret += ts.Format(syslogTimestampLayout)
 	} else {
 		s.logger.Tracef("%s - missing TS", msg)
-		ret += time.Now().UTC().Format("Jan 2 15:04:05")
+		ret += time.Now().UTC().Format(syslogTimestampLayout)
 	}
 	if hostname != "" {
 		ret += " " + hostname
@@ -171,16 +713,131 @@ func (s *SyslogSource) buildLogFromSyslog(ts time.Time, hostname string,
 	return ret
 }
 
-func (s *SyslogSource) parseLine(syslogLine syslogserver.SyslogMessage) string {
-	var line string
+// sourceHostnameLabel is set on each event to the hostname of the sender
+const sourceHostnameLabel = "source_hostname"
+
+// parseFailedLabel is set on the events of the messages that could not be parsed, with forward_parse_errors
+const parseFailedLabel = "parse_failed"
+
+// values of year_policy
+const (
+	yearPolicyInfer   = "infer"
+	yearPolicyCurrent = "current"
+)
+
+const (
+	rejectReasonParseError  = "parse_error"
+	rejectReasonBadPriority = "bad_priority"
+	rejectReasonRateLimited = "rate_limited"
+	// for UDP messages, or TCP connections
+	rejectReasonSourceNotAllowed = "source_not_allowed"
+)
+
+// parseAllowedSources converts the IPs and CIDRs of allowed_sources to prefixes.
+func parseAllowedSources(sources []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(sources))
+
+	for _, source := range sources {
+		if ip, err := netip.ParseAddr(source); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed source %q: must be an IP or a CIDR", source)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
 
-	logger := s.logger.WithField("client", syslogLine.Client)
+// validatePRI checks that the message starts with a well-formed <PRI> header
+// and returns the position of the closing '>'.
+func validatePRI(msg []byte) (int, error) {
+	if len(msg) < 3 {
+		return 0, errors.New("missing PRI (message too short)")
+	}
+
+	if msg[0] != '<' {
+		return 0, errors.New("missing PRI beginning")
+	}
+
+	priEnd := bytes.Index(msg, []byte(">"))
+	if priEnd == -1 {
+		return 0, errors.New("missing PRI end")
+	}
+
+	if priEnd > 4 {
+		return 0, errors.New("PRI too long")
+	}
+
+	for i := 1; i < priEnd; i++ {
+		if msg[i] < '0' || msg[i] > '9' {
+			return 0, errors.New("PRI not a number")
+		}
+	}
+
+	return priEnd, nil
+}
+
+// sampleRejected logs a message rejected by the parser, at most once per reject_sample_interval.
+func (s *SyslogSource) sampleRejected(syslogLine syslogserver.SyslogMessage, reason string) {
+	if s.rejectSample == nil || !s.rejectSample.Allow() {
+		return
+	}
+
+	msg := syslogLine.Message
+	truncated := ""
+
+	if len(msg) > s.config.RejectSampleMaxLen {
+		msg = msg[:s.config.RejectSampleMaxLen]
+		truncated = " (truncated)"
+	}
+
+	s.logger.WithFields(log.Fields{"client": syslogLine.Client, "src": syslogLine.Client}).Warningf("sample of rejected message (%s): %q%s", reason, msg, truncated)
+}
+
+func (s *SyslogSource) incRejected(client string, reason string) {
+	if s.metricsLevel == metrics.AcquisitionMetricsLevelNone {
+		return
+	}
+
+	metrics.SyslogDataSourceLinesRejected.With(prometheus.Labels{"source": client, "reason": reason, "datasource_type": "syslog", "acquis_type": s.config.Labels["type"]}).Inc()
+}
+
+func (s *SyslogSource) incDropped(evt types.Event) {
+	if s.metricsLevel == metrics.AcquisitionMetricsLevelNone {
+		return
+	}
+
+	metrics.AcquisitionDroppedEvents.With(prometheus.Labels{"source": evt.Line.Src, "datasource_type": "syslog", "acquis_type": evt.Line.Labels["type"]}).Inc()
+}
+
+// parseLine returns the line to process, the hostname of the sender and the timestamp of the message,
+// as found in the syslog header or, if the message is not parsed, the remote address of the connection
+// and a zero time. The last value is true if the message could not be parsed, the line is then empty
+// unless forward_parse_errors is set.
+func (s *SyslogSource) parseLine(syslogLine syslogserver.SyslogMessage) (string, string, time.Time, bool) {
+	var (
+		line, hostname string
+		ts             time.Time
+	)
+
+	logger := s.logger.WithFields(log.Fields{"client": syslogLine.Client, "src": syslogLine.Client})
 	logger.Tracef("raw: %s", syslogLine)
 	if s.metricsLevel != metrics.AcquisitionMetricsLevelNone {
 		metrics.SyslogDataSourceLinesReceived.With(prometheus.Labels{"source": syslogLine.Client, "datasource_type": "syslog", "acquis_type": s.config.Labels["type"]}).Inc()
 	}
 	if !s.config.DisableRFCParser {
-		p := rfc3164.NewRFC3164Parser(rfc3164.WithCurrentYear())
+		yearOpt := rfc3164.WithInferredYear()
+		if s.config.YearPolicy == yearPolicyCurrent {
+			yearOpt = rfc3164.WithCurrentYear()
+		}
+
+		p := rfc3164.NewRFC3164Parser(yearOpt)
 		err := p.Parse(syslogLine.Message)
 		if err != nil {
 			logger.Debugf("could not parse as RFC3164 (%s)", err)
@@ -189,79 +846,141 @@ func (s *SyslogSource) parseLine(syslogLine syslogserver.SyslogMessage) string {
 			if err != nil {
 				logger.Errorf("could not parse message: %s", err)
 				logger.Debugf("could not parse as RFC5424 (%s) : %s", err, syslogLine.Message)
-				return ""
+
+				reason := rejectReasonParseError
+				if _, priErr := validatePRI(syslogLine.Message); priErr != nil {
+					reason = rejectReasonBadPriority
+				}
+
+				s.incRejected(syslogLine.Client, reason)
+				s.sampleRejected(syslogLine, reason)
+
+				return s.unparsedLine(syslogLine)
 			}
 			line = s.buildLogFromSyslog(p2.Timestamp, p2.Hostname, p2.Tag, p2.PID, p2.Message)
+			hostname = p2.Hostname
+			ts = p2.Timestamp
 			if s.metricsLevel != metrics.AcquisitionMetricsLevelNone {
 				metrics.SyslogDataSourceLinesParsed.With(prometheus.Labels{"source": syslogLine.Client, "type": "rfc5424", "datasource_type": "syslog", "acquis_type": s.config.Labels["type"]}).Inc()
 			}
 		} else {
 			line = s.buildLogFromSyslog(p.Timestamp, p.Hostname, p.Tag, p.PID, p.Message)
+			hostname = p.Hostname
+			ts = p.Timestamp
 			if s.metricsLevel != metrics.AcquisitionMetricsLevelNone {
 				metrics.SyslogDataSourceLinesParsed.With(prometheus.Labels{"source": syslogLine.Client, "type": "rfc3164", "datasource_type": "syslog", "acquis_type": s.config.Labels["type"]}).Inc()
 			}
 		}
 	} else {
-		if len(syslogLine.Message) < 3 {
-			logger.Errorf("malformated message, missing PRI (message too short)")
-			return ""
-		}
-		if syslogLine.Message[0] != '<' {
-			logger.Errorf("malformated message, missing PRI beginning")
-			return ""
-		}
-		priEnd := bytes.Index(syslogLine.Message, []byte(">"))
-		if priEnd == -1 {
-			logger.Errorf("malformated message, missing PRI end")
-			return ""
-		}
-		if priEnd > 4 {
-			logger.Errorf("malformated message, PRI too long")
-			return ""
-		}
-		for i := 1; i < priEnd; i++ {
-			if syslogLine.Message[i] < '0' || syslogLine.Message[i] > '9' {
-				logger.Errorf("malformated message, PRI not a number")
-				return ""
-			}
+		priEnd, err := validatePRI(syslogLine.Message)
+		if err != nil {
+			logger.Errorf("malformated message, %s", err)
+			s.incRejected(syslogLine.Client, rejectReasonBadPriority)
+			s.sampleRejected(syslogLine, rejectReasonBadPriority)
+			return s.unparsedLine(syslogLine)
 		}
 		line = string(syslogLine.Message[priEnd+1:])
 	}
 
-	return strings.TrimSuffix(line, "\n")
+	if hostname == "" {
+		hostname = syslogLine.Client
+	}
+
+	return strings.TrimSuffix(line, "\n"), hostname, ts, false
 }
 
-func (s *SyslogSource) handleSyslogMsg(out chan types.Event, t *tomb.Tomb, c chan syslogserver.SyslogMessage) error {
+// unparsedLine is the result of parseLine for the messages that could not be parsed.
+func (s *SyslogSource) unparsedLine(syslogLine syslogserver.SyslogMessage) (string, string, time.Time, bool) {
+	if !s.config.ForwardParseErrors {
+		return "", "", time.Time{}, true
+	}
+
+	return strings.TrimSuffix(string(syslogLine.Message), "\n"), syslogLine.Client, time.Time{}, true
+}
+
+func (s *SyslogSource) handleSyslogMsg(out chan types.Event, t *tomb.Tomb, serverTomb *tomb.Tomb, c chan syslogserver.SyslogMessage, limiters *clientLimiters) error {
+	repeats := newRepeatCoalescer(s.config.DedupWindow)
+
+	// nil, never ready, if the messages are not coalesced
+	var tick <-chan time.Time
+
+	if repeats != nil {
+		ticker := time.NewTicker(s.config.DedupWindow)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
 	killed := false
 	for {
 		select {
 		case <-t.Dying():
 			if !killed {
 				s.logger.Info("Syslog datasource is dying")
-				s.serverTomb.Kill(nil)
+				serverTomb.Kill(nil)
 				killed = true
 			}
-		case <-s.serverTomb.Dead():
+		case <-serverTomb.Dead():
 			s.logger.Info("Syslog server has exited")
+
+			for _, evt := range repeats.expire(time.Now(), true) {
+				out <- evt
+			}
+
 			return nil
+		case now := <-tick:
+			for _, evt := range repeats.expire(now, false) {
+				out <- evt
+			}
 		case syslogLine := <-c:
-			line := s.parseLine(syslogLine)
+			if !limiters.allow(syslogLine.Client) {
+				s.logger.Tracef("rate limit exceeded for %s, dropping message", syslogLine.Client)
+				// without source: the number of noisy clients is not bounded, unlike their limiters
+				s.incRejected("", rejectReasonRateLimited)
+				continue
+			}
+
+			line, hostname, ts, failed := s.parseLine(syslogLine)
 			if line == "" {
 				continue
 			}
 
-			var ts time.Time
-
-			l := types.Line{}
-			l.Raw = line
-			l.Module = s.GetName()
-			l.Labels = s.config.Labels
-			l.Time = ts
-			l.Src = syslogLine.Client
-			l.Process = true
-			evt := types.MakeEvent(s.config.UseTimeMachine, types.LOG, true)
-			evt.Line = l
-			out <- evt
+			key := repeatKey(line, !s.config.DisableRFCParser && !failed)
+
+			for _, evt := range repeats.add(syslogLine.Client, key, s.makeEvent(syslogLine, line, hostname, ts, failed), time.Now()) {
+				out <- evt
+			}
 		}
 	}
 }
+
+func (s *SyslogSource) makeEvent(syslogLine syslogserver.SyslogMessage, line string, hostname string, ts time.Time, failed bool) types.Event {
+	labels := make(map[string]string, len(s.config.Labels)+len(s.config.ExtraLabels)+2)
+	maps.Copy(labels, s.config.Labels)
+	labels[sourceHostnameLabel] = hostname
+
+	if failed {
+		labels[parseFailedLabel] = "true"
+	} else {
+		maps.Copy(labels, s.config.messageLabels(line))
+	}
+	maps.Copy(labels, s.config.ExtraLabels)
+
+	if !s.config.UseMessageTimestamp {
+		ts = time.Time{}
+	} else if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+
+	l := types.Line{}
+	l.Raw = s.config.PreProcess.Apply(line)
+	l.Module = s.GetName()
+	l.Labels = labels
+	l.Time = ts
+	l.Src = syslogLine.Client
+	l.Process = true
+	evt := types.MakeEvent(s.config.UseTimeMachine, types.LOG, true)
+	evt.Line = l
+
+	return evt
+}
@@ -2,18 +2,26 @@ package syslogacquisition
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/tomb.v2"
 
 	"github.com/crowdsecurity/go-cs-lib/cstest"
 
+	syslogserver "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog/internal/server"
 	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
@@ -51,6 +59,127 @@ source: syslog
 listen_addr: 10.0.0`,
 			expectedErr: "invalid listen IP 10.0.0",
 		},
+		{
+			config: `
+source: syslog
+listen_addr: 10.0.0.1`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+rate_limit: -1`,
+			expectedErr: "invalid rate_limit -1",
+		},
+		{
+			config: `
+source: syslog
+dedup_window: -1s`,
+			expectedErr: "invalid dedup_window -1s",
+		},
+		{
+			config: `
+source: syslog
+reject_sample_interval: -1s`,
+			expectedErr: "invalid reject_sample_interval -1s",
+		},
+		{
+			config: `
+source: syslog
+reject_sample_max_len: -1`,
+			expectedErr: "invalid reject_sample_max_len -1",
+		},
+		{
+			config: `
+source: syslog
+output_buffer:
+  size: -1`,
+			expectedErr: "invalid output_buffer.size -1: must be positive",
+		},
+		{
+			config: `
+source: syslog
+pre_process:
+  - regexp: "[a-"`,
+			expectedErr: `invalid pre_process regexp "[a-"`,
+		},
+		{
+			config: `
+source: syslog
+listen_addr: localhost`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+listen_addr: nonexistent.invalid`,
+			expectedErr: "cannot resolve listen address nonexistent.invalid",
+		},
+		{
+			config: `
+source: syslog
+listeners:
+  - listen_port: 4243
+  - listen_port: 4244
+    listen_addr: 127.0.0.1`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+listen_port: 4243
+listeners:
+  - listen_port: 4243`,
+			expectedErr: "duplicate listener 127.0.0.1:4243/udp",
+		},
+		{
+			config: `
+source: syslog
+listeners:
+  - listen_port: 4243
+    protocol: sctp`,
+			expectedErr: "unsupported protocol sctp",
+		},
+		{
+			config: `
+source: syslog
+max_connections: -1`,
+			expectedErr: "invalid max_connections -1",
+		},
+		{
+			config: `
+source: syslog
+read_timeout: -1s`,
+			expectedErr: "invalid read_timeout -1s",
+		},
+		{
+			config: `
+source: syslog
+allowed_sources:
+  - 10.0.0.1
+  - 192.168.0.0/16
+  - 2001:db8::/32`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+allowed_sources:
+  - 10.0.0.0/33`,
+			expectedErr: `invalid allowed source "10.0.0.0/33": must be an IP or a CIDR`,
+		},
+		{
+			config: `
+source: syslog
+year_policy: current`,
+			expectedErr: "",
+		},
+		{
+			config: `
+source: syslog
+year_policy: previous`,
+			expectedErr: `invalid year_policy "previous": must be infer or current`,
+		},
 	}
 
 	subLogger := log.WithField("type", "syslog")
@@ -63,6 +192,31 @@ listen_addr: 10.0.0`,
 	}
 }
 
+func TestConfigureEnvExpansion(t *testing.T) {
+	t.Setenv("SYSLOG_BIND", "127.0.0.2")
+	t.Setenv("SYSLOG_PORT", "4243")
+
+	subLogger := log.WithField("type", "syslog")
+
+	s := SyslogSource{}
+	err := s.Configure([]byte(`
+source: syslog
+listen_addr: ${SYSLOG_BIND}
+listen_port: $SYSLOG_PORT
+labels:
+  type: pa$$word`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", s.config.Addr)
+	assert.Equal(t, 4243, s.config.Port)
+	assert.Equal(t, "pa$word", s.config.Labels["type"])
+
+	s = SyslogSource{}
+	err = s.Configure([]byte(`
+source: syslog
+listen_addr: ${SYSLOG_UNDEFINED_BIND}`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	cstest.RequireErrorContains(t, err, "undefined environment variable(s) in configuration: SYSLOG_UNDEFINED_BIND")
+}
+
 func writeToSyslog(logs []string) {
 	conn, err := net.Dial("udp", "127.0.0.1:4242")
 	if err != nil {
@@ -98,6 +252,15 @@ listen_port: 4242
 listen_addr: 127.0.0.1`,
 			logs: []string{"foobar", "bla", "pouet"},
 		},
+		{
+			name: "invalid msgs - forwarded",
+			config: `source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1
+forward_parse_errors: true`,
+			expectedLines: 3,
+			logs:          []string{"foobar", "bla", "pouet"},
+		},
 		{
 			name: "RFC5424",
 			config: `source: syslog
@@ -195,3 +358,1033 @@ disable_rfc_parser: true`,
 		})
 	}
 }
+
+func TestSourceHostnameLabel(t *testing.T) {
+	ctx := t.Context()
+	tests := []struct {
+		name     string
+		config   string
+		log      string
+		expected string
+	}{
+		{
+			name: "RFC3164",
+			config: `source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1`,
+			log:      `<13>May 18 12:37:56 mantis sshd[49340]: blabla2`,
+			expected: "mantis",
+		},
+		{
+			name: "RFC5424",
+			config: `source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1`,
+			log:      `<13>1 2021-05-18T11:58:40.828081+02:00 mantis sshd 49340 - [timeQuality isSynced="0" tzKnown="1"] blabla`,
+			expected: "mantis",
+		},
+		{
+			name: "no parsing",
+			config: `source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1
+disable_rfc_parser: true`,
+			log:      `<13>May 18 12:37:56 mantis sshd[49340]: blabla2`,
+			expected: "127.0.0.1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			subLogger := log.WithField("type", "syslog")
+			s := SyslogSource{}
+			err := s.Configure([]byte(tc.config+`
+labels:
+  type: syslog`), subLogger, metrics.AcquisitionMetricsLevelNone)
+			require.NoError(t, err)
+
+			tomb := tomb.Tomb{}
+			out := make(chan types.Event)
+			err = s.StreamingAcquisition(ctx, out, &tomb)
+			require.NoError(t, err)
+
+			go writeToSyslog([]string{tc.log})
+
+			select {
+			case evt := <-out:
+				assert.Equal(t, tc.expected, evt.Line.Labels["source_hostname"])
+				assert.Equal(t, "syslog", evt.Line.Labels["type"])
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for event")
+			}
+
+			// the configured labels are not modified
+			assert.NotContains(t, s.config.Labels, "source_hostname")
+
+			tomb.Kill(nil)
+			err = tomb.Wait()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRFC5424BOM(t *testing.T) {
+	ctx := t.Context()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	go writeToSyslog([]string{"<13>1 2021-05-18T11:58:40.828081+02:00 mantis sshd 49340 - - \xef\xbb\xbfFailed password for r\u00e9mi"})
+
+	select {
+	case evt := <-out:
+		assert.Equal(t, "May 18 11:58:40 mantis sshd[49340]: Failed password for r\u00e9mi", evt.Line.Raw)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestMultipleListeners(t *testing.T) {
+	ctx := t.Context()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+listeners:
+  - listen_addr: 127.0.0.1
+    listen_port: 4243
+  - listen_addr: 127.0.0.1
+    listen_port: 4244`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+	// the default listener is not used when only listeners are provided
+	assert.Len(t, s.listeners, 2)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	go func() {
+		for _, port := range []int{4243, 4244, 4244} {
+			conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+			if err != nil {
+				fmt.Printf("could not establish connection to syslog server : %s", err)
+				return
+			}
+
+			fmt.Fprintf(conn, "<13>May 18 12:37:56 mantis sshd[49340]: sent to %d", port)
+			conn.Close()
+		}
+	}()
+
+	received := []string{}
+READLOOP:
+	for {
+		select {
+		case evt := <-out:
+			received = append(received, evt.Line.Raw)
+		case <-time.After(2 * time.Second):
+			break READLOOP
+		}
+	}
+
+	require.Len(t, received, 3)
+	assert.Contains(t, strings.Join(received, "\n"), "sent to 4243")
+	assert.Contains(t, strings.Join(received, "\n"), "sent to 4244")
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestMaxTCPConnections(t *testing.T) {
+	ctx := t.Context()
+
+	metrics.SyslogDataSourceTCPConnections.Reset()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+listen_addr: 127.0.0.1
+listen_port: 4245
+protocol: tcp
+max_connections: 2
+labels:
+  type: syslog`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	readLine := func() string {
+		select {
+		case evt := <-out:
+			return evt.Line.Raw
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+
+		return ""
+	}
+
+	accepted := []net.Conn{}
+
+	for i := range 2 {
+		conn, err := net.Dial("tcp", "127.0.0.1:4245")
+		require.NoError(t, err)
+
+		defer conn.Close()
+
+		accepted = append(accepted, conn)
+
+		fmt.Fprintf(conn, "<13>May 18 12:37:56 mantis sshd[49340]: conn %d\n", i)
+		assert.Contains(t, readLine(), fmt.Sprintf("conn %d", i))
+	}
+
+	gauge := metrics.SyslogDataSourceTCPConnections.With(prometheus.Labels{"listener": "127.0.0.1:4245/tcp", "datasource_type": "syslog", "acquis_type": "syslog"})
+	assert.InDelta(t, 2, testutil.ToFloat64(gauge), 0)
+
+	// the third connection is closed by the server
+	excess, err := net.Dial("tcp", "127.0.0.1:4245")
+	require.NoError(t, err)
+
+	defer excess.Close()
+
+	require.NoError(t, excess.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = excess.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// the accepted connections still work
+	fmt.Fprint(accepted[0], "<13>May 18 12:37:56 mantis sshd[49340]: again\n")
+	assert.Contains(t, readLine(), "again")
+
+	accepted[1].Close()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestTCPReadTimeout(t *testing.T) {
+	ctx := t.Context()
+
+	metrics.SyslogDataSourceTCPTimeouts.Reset()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+listen_addr: 127.0.0.1
+listen_port: 4246
+protocol: tcp
+read_timeout: 200ms
+labels:
+  type: syslog`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	active, err := net.Dial("tcp", "127.0.0.1:4246")
+	require.NoError(t, err)
+
+	defer active.Close()
+
+	idle, err := net.Dial("tcp", "127.0.0.1:4246")
+	require.NoError(t, err)
+
+	defer idle.Close()
+
+	// the timeout applies to each message, not to the whole connection
+	for i := range 3 {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintf(active, "<13>May 18 12:37:56 mantis sshd[49340]: msg %d\n", i)
+
+		select {
+		case evt := <-out:
+			assert.Contains(t, evt.Line.Raw, fmt.Sprintf("msg %d", i))
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+
+	// the idle connection has been closed by the server
+	require.NoError(t, idle.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = idle.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	counter := metrics.SyslogDataSourceTCPTimeouts.With(prometheus.Labels{"listener": "127.0.0.1:4246/tcp", "datasource_type": "syslog", "acquis_type": "syslog"})
+	assert.InDelta(t, 1, testutil.ToFloat64(counter), 0)
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestExtraLabels(t *testing.T) {
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+labels:
+  type: syslog
+extra_labels:
+  site: paris
+  env: prod`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	c := make(chan syslogserver.SyslogMessage)
+	out := make(chan types.Event, 10)
+
+	serverTomb := tomb.Tomb{}
+	serverTomb.Go(func() error {
+		<-serverTomb.Dying()
+		return nil
+	})
+
+	tomb := tomb.Tomb{}
+	tomb.Go(func() error {
+		return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+	})
+
+	c <- syslogserver.SyslogMessage{
+		Message: []byte("<13>May 18 12:37:56 mantis sshd[49340]: blabla"),
+		Client:  "10.0.0.1",
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+	close(out)
+
+	require.Len(t, out, 1)
+
+	evt := <-out
+	assert.Equal(t, map[string]string{"type": "syslog", "source_hostname": "mantis", "site": "paris", "env": "prod"}, evt.Line.Labels)
+}
+
+func TestAllowedSources(t *testing.T) {
+	ctx := t.Context()
+
+	metrics.SyslogDataSourceLinesRejected.Reset()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+listen_addr: 127.0.0.1
+listen_port: 4250
+allowed_sources:
+  - 127.0.0.1
+labels:
+  type: syslog`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	send := func(from string, msg string) {
+		t.Helper()
+		conn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.ParseIP(from)}, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4250})
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = fmt.Fprintf(conn, "<13>May 18 12:37:56 mantis sshd[49340]: %s\n", msg)
+		require.NoError(t, err)
+	}
+
+	// the whole 127.0.0.0/8 is on the loopback interface
+	send("127.0.0.2", "not allowed")
+	send("127.0.0.1", "allowed")
+
+	select {
+	case evt := <-out:
+		assert.Equal(t, "May 18 12:37:56 mantis sshd[49340]: allowed", evt.Line.Raw)
+		assert.Equal(t, "127.0.0.1", evt.Line.Src)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	counter := metrics.SyslogDataSourceLinesRejected.With(prometheus.Labels{"source": "127.0.0.2", "reason": "source_not_allowed", "datasource_type": "syslog", "acquis_type": "syslog"})
+	assert.InDelta(t, 1, testutil.ToFloat64(counter), 0)
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestReload(t *testing.T) {
+	ctx := t.Context()
+
+	config := func(port int, typ string) []byte {
+		return fmt.Appendf(nil, `source: syslog
+listen_addr: 127.0.0.1
+listen_port: %d
+labels:
+  type: %s`, port, typ)
+	}
+
+	send := func(port int, msg string) {
+		t.Helper()
+		conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = fmt.Fprintf(conn, "<13>May 18 12:37:56 mantis sshd[49340]: %s\n", msg)
+		require.NoError(t, err)
+	}
+
+	out := make(chan types.Event)
+
+	expectLine := func(port int, msg string) types.Event {
+		t.Helper()
+		send(port, msg)
+		select {
+		case evt := <-out:
+			assert.Contains(t, evt.Line.Raw, msg)
+			return evt
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %q", msg)
+		}
+
+		return types.Event{}
+	}
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure(config(4247, "syslog"), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	expectLine(4247, "before reload")
+
+	// same configuration: the server is not restarted
+	require.NoError(t, s.Reload(config(4247, "syslog"), metrics.AcquisitionMetricsLevelNone))
+	expectLine(4247, "after no-op reload")
+
+	// the other settings can't be changed
+	err = s.Reload(config(4248, "other"), metrics.AcquisitionMetricsLevelNone)
+	cstest.RequireErrorContains(t, err, "only the listeners and their settings can be changed on reload")
+
+	err = s.Reload(config(4248, "syslog"), metrics.AcquisitionMetricsLevelFull)
+	cstest.RequireErrorContains(t, err, "only the listeners and their settings can be changed on reload")
+
+	expectLine(4247, "after failed reload")
+
+	// new port: the server is restarted, events go to the same channel
+	require.NoError(t, s.Reload(config(4248, "syslog"), metrics.AcquisitionMetricsLevelNone))
+	evt := expectLine(4248, "after reload")
+	assert.Equal(t, "syslog", evt.Line.Labels["type"])
+
+	// the old port has been released
+	conn, err := net.ListenPacket("udp", "127.0.0.1:4247")
+	require.NoError(t, err)
+	conn.Close()
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	metrics.SyslogDataSourceLinesRejected.Reset()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+rate_limit: 0.01
+rate_limit_burst: 5
+labels:
+  type: syslog`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	c := make(chan syslogserver.SyslogMessage)
+	out := make(chan types.Event, 100)
+
+	serverTomb := tomb.Tomb{}
+	serverTomb.Go(func() error {
+		<-serverTomb.Dying()
+		return nil
+	})
+
+	tomb := tomb.Tomb{}
+	tomb.Go(func() error {
+		return s.handleSyslogMsg(out, &tomb, &serverTomb, c, newClientLimiters(s.config.RateLimit, s.config.RateLimitBurst))
+	})
+
+	msg := []byte("<13>May 18 12:37:56 mantis sshd[49340]: blabla")
+
+	// the noisy client is limited to its burst
+	for range 20 {
+		c <- syslogserver.SyslogMessage{Message: msg, Client: "10.0.0.1"}
+	}
+
+	// while the other one is unaffected
+	for range 3 {
+		c <- syslogserver.SyslogMessage{Message: msg, Client: "10.0.0.2"}
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+	close(out)
+
+	received := map[string]int{}
+	for evt := range out {
+		received[evt.Line.Src]++
+	}
+
+	assert.Equal(t, map[string]int{"10.0.0.1": 5, "10.0.0.2": 3}, received)
+
+	// the dropped lines are not counted by client
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.SyslogDataSourceLinesRejected))
+
+	rateLimited := metrics.SyslogDataSourceLinesRejected.With(prometheus.Labels{"source": "", "reason": "rate_limited", "datasource_type": "syslog", "acquis_type": "syslog"})
+	assert.InDelta(t, 15, testutil.ToFloat64(rateLimited), 0)
+}
+
+func TestPreProcess(t *testing.T) {
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+pre_process:
+  - regexp: '\[proxy [^\]]*\] '
+    replace: ''
+  - regexp: 'user=(\w+)'
+    replace: 'user=<$$1>'`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	c := make(chan syslogserver.SyslogMessage)
+	out := make(chan types.Event, 10)
+
+	serverTomb := tomb.Tomb{}
+	serverTomb.Go(func() error {
+		<-serverTomb.Dying()
+		return nil
+	})
+
+	tomb := tomb.Tomb{}
+	tomb.Go(func() error {
+		return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+	})
+
+	c <- syslogserver.SyslogMessage{
+		Message: []byte("<13>May 18 12:37:56 mantis sshd[49340]: [proxy 10.0.0.1] invalid user=root"),
+		Client:  "10.0.0.1",
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+	close(out)
+
+	require.Len(t, out, 1)
+
+	evt := <-out
+	assert.Equal(t, "May 18 12:37:56 mantis sshd[49340]: invalid user=<root>", evt.Line.Raw)
+}
+
+func TestRejectSampling(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+reject_sample_interval: 1h
+reject_sample_max_len: 10`), logger.WithField("type", "syslog"), metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	c := make(chan syslogserver.SyslogMessage)
+	out := make(chan types.Event, 10)
+
+	serverTomb := tomb.Tomb{}
+	serverTomb.Go(func() error {
+		<-serverTomb.Dying()
+		return nil
+	})
+
+	tomb := tomb.Tomb{}
+	tomb.Go(func() error {
+		return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+	})
+
+	for range 5 {
+		c <- syslogserver.SyslogMessage{
+			Message: []byte("<13>this is not a syslog message"),
+			Client:  "10.0.0.1",
+		}
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+
+	// only one message is logged per interval
+	samples := []string{}
+
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "sample of rejected message") {
+			samples = append(samples, entry.Message)
+		}
+	}
+
+	assert.Equal(t, []string{`sample of rejected message (parse_error): "<13>this i" (truncated)`}, samples)
+}
+
+func TestDedupWindow(t *testing.T) {
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+dedup_window: 1h`), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	c := make(chan syslogserver.SyslogMessage)
+	out := make(chan types.Event, 10)
+
+	serverTomb := tomb.Tomb{}
+	serverTomb.Go(func() error {
+		<-serverTomb.Dying()
+		return nil
+	})
+
+	tomb := tomb.Tomb{}
+	tomb.Go(func() error {
+		return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+	})
+
+	send := func(client string, msg string) {
+		c <- syslogserver.SyslogMessage{Message: []byte(msg), Client: client}
+	}
+
+	// the first occurrence is sent right away
+	send("10.0.0.1", "<13>May 18 12:37:56 mantis sshd[49340]: same line")
+
+	evt := <-out
+	assert.Equal(t, "May 18 12:37:56 mantis sshd[49340]: same line", evt.Line.Raw)
+	assert.NotContains(t, evt.Line.Labels, repeatCountLabel)
+
+	// the repeats are sent at different times
+	for _, ts := range []string{"12:37:57", "12:37:58", "12:37:59", "12:38:00"} {
+		send("10.0.0.1", "<13>May 18 "+ts+" mantis sshd[49340]: same line")
+	}
+
+	// another client doesn't interrupt the repetition
+	send("10.0.0.2", "<13>May 18 12:38:01 mantis sshd[49340]: same line")
+	send("10.0.0.1", "<13>May 18 12:38:02 mantis sshd[49340]: same line")
+	send("10.0.0.1", "<13>May 18 12:38:03 mantis sshd[49340]: other line")
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+	close(out)
+
+	require.Len(t, out, 3)
+
+	evt = <-out
+	assert.Equal(t, "May 18 12:38:01 mantis sshd[49340]: same line", evt.Line.Raw)
+	assert.Equal(t, "10.0.0.2", evt.Line.Src)
+	assert.NotContains(t, evt.Line.Labels, repeatCountLabel)
+
+	// the repeats are coalesced into the last one
+	evt = <-out
+	assert.Equal(t, "May 18 12:38:02 mantis sshd[49340]: same line", evt.Line.Raw)
+	assert.Equal(t, "10.0.0.1", evt.Line.Src)
+	assert.Equal(t, "5", evt.Line.Labels[repeatCountLabel])
+
+	evt = <-out
+	assert.Equal(t, "May 18 12:38:03 mantis sshd[49340]: other line", evt.Line.Raw)
+	assert.NotContains(t, evt.Line.Labels, repeatCountLabel)
+}
+
+func TestRepeatCoalescerExpire(t *testing.T) {
+	r := newRepeatCoalescer(time.Minute)
+	now := time.Now()
+
+	evt := func() types.Event {
+		return types.Event{Line: types.Line{Labels: map[string]string{}}}
+	}
+
+	assert.Len(t, r.add("client", "line", evt(), now), 1)
+	assert.Empty(t, r.add("client", "line", evt(), now.Add(time.Second)))
+	assert.Empty(t, r.add("client", "line", evt(), now.Add(2*time.Second)))
+	assert.Empty(t, r.expire(now.Add(30*time.Second), false))
+
+	// the window is over, the same line is sent again after its repeats
+	sent := r.add("client", "line", evt(), now.Add(time.Minute))
+	require.Len(t, sent, 2)
+	assert.Equal(t, "2", sent[0].Line.Labels[repeatCountLabel])
+	assert.NotContains(t, sent[1].Line.Labels, repeatCountLabel)
+
+	// nothing is held without repeats
+	assert.Empty(t, r.expire(now.Add(2*time.Minute), false))
+	assert.Empty(t, r.pending)
+
+	// without window, the events are sent as is
+	assert.Len(t, (*repeatCoalescer)(nil).add("client", "line", evt(), now), 1)
+}
+
+func TestRepeatKey(t *testing.T) {
+	assert.Equal(t, "mantis sshd[49340]: same line", repeatKey("May 18 12:37:56 mantis sshd[49340]: same line", true))
+	assert.Equal(t, "mantis sshd[49340]: same line", repeatKey("May 8 12:37:56 mantis sshd[49340]: same line", true))
+	assert.Equal(t, "May 18 12:37:56 mantis sshd[49340]: same line", repeatKey("May 18 12:37:56 mantis sshd[49340]: same line", false))
+}
+
+func TestClientLimitersBounded(t *testing.T) {
+	limiters := newClientLimiters(1, 1)
+
+	for i := range maxRateLimitedClients + 10 {
+		assert.True(t, limiters.allow(fmt.Sprintf("client%d", i)))
+	}
+
+	assert.Len(t, limiters.limiters, maxRateLimitedClients)
+
+	assert.True(t, (*clientLimiters)(nil).allow("client"))
+}
+
+func TestUnixSocketAcquisition(t *testing.T) {
+	cstest.SkipOnWindows(t)
+
+	ctx := t.Context()
+
+	socketPath := filepath.Join(t.TempDir(), "syslog.sock")
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+unix_socket: `+socketPath), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := net.Dial("unixgram", socketPath)
+		if err != nil {
+			fmt.Printf("could not connect to syslog socket : %s", err)
+			return
+		}
+		defer conn.Close()
+
+		for _, msg := range []string{
+			`<13>May 18 12:37:56 mantis sshd[49340]: blabla2[foobar]`,
+			`<13>May 18 12:37:56 mantis sshd[49340]: blabla2`,
+			`<13>May 18 12:37:56 mantis sshd: blabla2`,
+			"foobar",
+		} {
+			if _, err := fmt.Fprint(conn, msg); err != nil {
+				fmt.Printf("could not write to syslog socket : %s", err)
+				return
+			}
+		}
+	}()
+
+	actualLines := 0
+READLOOP:
+	for {
+		select {
+		case evt := <-out:
+			assert.Equal(t, socketPath, evt.Line.Src)
+			actualLines++
+		case <-time.After(2 * time.Second):
+			break READLOOP
+		}
+	}
+
+	assert.Equal(t, 3, actualLines)
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, socketPath)
+}
+
+func TestRejectedMetrics(t *testing.T) {
+	ctx := t.Context()
+
+	metrics.SyslogDataSourceLinesRejected.Reset()
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(`source: syslog
+listen_port: 4242
+listen_addr: 127.0.0.1
+labels:
+  type: syslog`), subLogger, metrics.AcquisitionMetricsLevelFull)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	go writeToSyslog([]string{
+		"foobar",
+		"bla",
+		"pouet",
+		"<13>garbage",
+		`<13>May 18 12:37:56 mantis sshd[49340]: blabla2`,
+		`<13>1 2021-05-18T11:58:40.828081+02:00 mantis sshd 49340 - [timeQuality isSynced="0" tzKnown="1"] blabla`,
+	})
+
+	actualLines := 0
+READLOOP:
+	for {
+		select {
+		case <-out:
+			actualLines++
+		case <-time.After(2 * time.Second):
+			break READLOOP
+		}
+	}
+
+	assert.Equal(t, 2, actualLines)
+
+	badPriority := metrics.SyslogDataSourceLinesRejected.With(prometheus.Labels{"source": "127.0.0.1", "reason": "bad_priority", "datasource_type": "syslog", "acquis_type": "syslog"})
+	parseError := metrics.SyslogDataSourceLinesRejected.With(prometheus.Labels{"source": "127.0.0.1", "reason": "parse_error", "datasource_type": "syslog", "acquis_type": "syslog"})
+
+	assert.InDelta(t, 3, testutil.ToFloat64(badPriority), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(parseError), 0)
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
+
+func TestConfigureByDSN(t *testing.T) {
+	tests := []struct {
+		dsn         string
+		expectedErr string
+	}{
+		{
+			dsn:         "asd://",
+			expectedErr: "invalid DSN asd:// for syslog source, must start with syslog://",
+		},
+		{
+			dsn:         "syslog://",
+			expectedErr: "empty syslog:// DSN",
+		},
+		{
+			dsn: "syslog:///var/log/syslog.replay",
+		},
+		{
+			dsn: "syslog:///var/log/syslog.replay?log_level=warn&disable_rfc_parser=true",
+		},
+		{
+			dsn:         "syslog:///var/log/syslog.replay?log_level=foobar",
+			expectedErr: "unknown level foobar: not a valid logrus Level:",
+		},
+		{
+			dsn:         "syslog:///var/log/syslog.replay?foo=bar",
+			expectedErr: "unsupported key foo in syslog DSN",
+		},
+	}
+
+	subLogger := log.WithField("type", "syslog")
+
+	for _, tc := range tests {
+		t.Run(tc.dsn, func(t *testing.T) {
+			s := SyslogSource{}
+			err := s.ConfigureByDSN(tc.dsn, map[string]string{"type": "testtype"}, subLogger, "")
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestOneShotReplay(t *testing.T) {
+	ctx := t.Context()
+
+	tests := []struct {
+		name          string
+		dsn           string
+		expectedErr   string
+		expectedLines int
+	}{
+		{
+			name:          "mixed RFC5424 and RFC3164",
+			dsn:           "syslog://testdata/replay.log",
+			expectedLines: 4,
+		},
+		{
+			name:          "no parsing",
+			dsn:           "syslog://testdata/replay.log?disable_rfc_parser=true",
+			expectedLines: 4,
+		},
+		{
+			name:        "missing file",
+			dsn:         "syslog://testdata/does_not_exist.log",
+			expectedErr: "failed opening testdata/does_not_exist.log",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			subLogger := log.WithField("type", "syslog")
+			s := SyslogSource{}
+			err := s.ConfigureByDSN(tc.dsn, map[string]string{"type": "testtype"}, subLogger, "")
+			require.NoError(t, err)
+			assert.Equal(t, "cat", s.GetMode())
+
+			out := make(chan types.Event, 10)
+			tomb := tomb.Tomb{}
+
+			err = s.OneShotAcquisition(ctx, out, &tomb)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			require.Len(t, out, tc.expectedLines)
+
+			evt := <-out
+			assert.Equal(t, "testdata/replay.log", evt.Line.Src)
+			assert.Equal(t, types.TIMEMACHINE, evt.ExpectMode)
+			assert.Equal(t, "testtype", evt.Line.Labels["type"])
+		})
+	}
+}
+
+func TestForwardParseErrors(t *testing.T) {
+	for _, forward := range []bool{false, true} {
+		t.Run(strconv.FormatBool(forward), func(t *testing.T) {
+			subLogger := log.WithField("type", "syslog")
+			s := SyslogSource{}
+			err := s.Configure([]byte(fmt.Sprintf(`source: syslog
+forward_parse_errors: %t`, forward)), subLogger, metrics.AcquisitionMetricsLevelNone)
+			require.NoError(t, err)
+
+			c := make(chan syslogserver.SyslogMessage)
+			out := make(chan types.Event, 10)
+
+			serverTomb := tomb.Tomb{}
+			serverTomb.Go(func() error {
+				<-serverTomb.Dying()
+				return nil
+			})
+
+			tomb := tomb.Tomb{}
+			tomb.Go(func() error {
+				return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+			})
+
+			for _, msg := range []string{"foobar", "bla", "<13>May 18 12:37:56 mantis sshd[49340]: valid", "pouet"} {
+				c <- syslogserver.SyslogMessage{Message: []byte(msg), Client: "10.0.0.1"}
+			}
+
+			tomb.Kill(nil)
+			err = tomb.Wait()
+			require.NoError(t, err)
+			close(out)
+
+			raw := []string{}
+			failed := []string{}
+
+			for evt := range out {
+				raw = append(raw, evt.Line.Raw)
+				failed = append(failed, evt.Line.Labels[parseFailedLabel])
+			}
+
+			if !forward {
+				assert.Equal(t, []string{"May 18 12:37:56 mantis sshd[49340]: valid"}, raw)
+				assert.Equal(t, []string{""}, failed)
+
+				return
+			}
+
+			assert.Equal(t, []string{"foobar", "bla", "May 18 12:37:56 mantis sshd[49340]: valid", "pouet"}, raw)
+			assert.Equal(t, []string{"true", "true", "", "true"}, failed)
+		})
+	}
+}
+
+func TestUseMessageTimestamp(t *testing.T) {
+	subLogger := log.WithField("type", "syslog")
+
+	run := func(t *testing.T, config string, messages ...string) []types.Event {
+		t.Helper()
+
+		s := SyslogSource{}
+		err := s.Configure([]byte(config), subLogger, metrics.AcquisitionMetricsLevelNone)
+		require.NoError(t, err)
+
+		c := make(chan syslogserver.SyslogMessage)
+		out := make(chan types.Event, 10)
+
+		serverTomb := tomb.Tomb{}
+		serverTomb.Go(func() error {
+			<-serverTomb.Dying()
+			return nil
+		})
+
+		tomb := tomb.Tomb{}
+		tomb.Go(func() error {
+			return s.handleSyslogMsg(out, &tomb, &serverTomb, c, nil)
+		})
+
+		for _, msg := range messages {
+			c <- syslogserver.SyslogMessage{Message: []byte(msg), Client: "10.0.0.1"}
+		}
+
+		tomb.Kill(nil)
+		err = tomb.Wait()
+		require.NoError(t, err)
+		close(out)
+
+		events := []types.Event{}
+		for evt := range out {
+			events = append(events, evt)
+		}
+
+		return events
+	}
+
+	msg := "<13>1 2021-05-18T11:58:40.828081+02:00 mantis sshd 49340 - [timeQuality isSynced=\"0\" tzKnown=\"1\"] blabla"
+	expected := time.Date(2021, 5, 18, 9, 58, 40, 828081000, time.UTC)
+
+	events := run(t, `source: syslog
+use_message_timestamp: true`, msg, "not a syslog message")
+	require.Len(t, events, 1)
+	assert.True(t, expected.Equal(events[0].Line.Time), "expected %s, got %s", expected, events[0].Line.Time)
+
+	// the reception time, if the message is not parsed
+	before := time.Now().UTC()
+	events = run(t, `source: syslog
+use_message_timestamp: true
+disable_rfc_parser: true`, msg)
+	require.Len(t, events, 1)
+	assert.False(t, events[0].Line.Time.Before(before))
+
+	// or if it can't be
+	events = run(t, `source: syslog
+use_message_timestamp: true
+forward_parse_errors: true`, "not a syslog message")
+	require.Len(t, events, 1)
+	assert.False(t, events[0].Line.Time.Before(before))
+
+	// the time is not set by default
+	events = run(t, `source: syslog`, msg)
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Line.Time.IsZero())
+}
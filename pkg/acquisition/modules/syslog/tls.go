@@ -0,0 +1,73 @@
+package syslogacquisition
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// tlsVersions are the accepted values of tls_min_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteIDs converts the names of cipher suites, as listed by tls.CipherSuites(), to their IDs.
+// Insecure cipher suites are refused.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := tls.CipherSuites()
+	ids := make([]uint16, 0, len(names))
+
+	for _, name := range names {
+		idx := slices.IndexFunc(suites, func(c *tls.CipherSuite) bool { return c.Name == name })
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+
+		ids = append(ids, suites[idx].ID)
+	}
+
+	return ids, nil
+}
+
+// tlsConfig returns the configuration of the TLS listeners.
+func (c *SyslogConfiguration) tlsConfig() (*tls.Config, error) {
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return nil, errors.New("tls_cert_file and tls_key_file are required for TLS listeners")
+	}
+
+	// TLS versions below 1.2 are considered insecure, but may be needed by old clients
+	minVersion := uint16(tls.VersionTLS12)
+
+	if c.TLSMinVersion != "" {
+		v, ok := tlsVersions[c.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls_min_version %q: must be one of 1.0, 1.1, 1.2, 1.3", c.TLSMinVersion)
+		}
+
+		minVersion = v
+	}
+
+	cipherSuites, err := cipherSuiteIDs(c.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("while loading TLS certificate: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}, nil
+}
@@ -0,0 +1,181 @@
+package syslogacquisition
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key, and returns their paths.
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestConfigureTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	tests := []struct {
+		config      string
+		expectedErr string
+	}{
+		{
+			config: `
+source: syslog
+protocol: tls`,
+			expectedErr: "tls_cert_file and tls_key_file are required for TLS listeners",
+		},
+		{
+			config: fmt.Sprintf(`
+source: syslog
+protocol: tls
+tls_cert_file: %s
+tls_key_file: %s`, certFile, keyFile),
+		},
+		{
+			config: fmt.Sprintf(`
+source: syslog
+protocol: tls
+tls_cert_file: %s
+tls_key_file: %s
+tls_min_version: "1.4"`, certFile, keyFile),
+			expectedErr: `invalid tls_min_version "1.4": must be one of 1.0, 1.1, 1.2, 1.3`,
+		},
+		{
+			config: fmt.Sprintf(`
+source: syslog
+protocol: tls
+tls_cert_file: %s
+tls_key_file: %s
+tls_cipher_suites:
+  - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+  - TLS_RSA_WITH_RC4_128_SHA`, certFile, keyFile),
+			expectedErr: `unknown or insecure TLS cipher suite "TLS_RSA_WITH_RC4_128_SHA"`,
+		},
+		{
+			config: fmt.Sprintf(`
+source: syslog
+protocol: tls
+tls_cert_file: %s
+tls_key_file: %s`, keyFile, certFile),
+			expectedErr: "while loading TLS certificate",
+		},
+		{
+			// the TLS options are not checked without a TLS listener
+			config: `
+source: syslog
+protocol: tcp
+tls_min_version: "1.4"`,
+		},
+	}
+
+	subLogger := log.WithField("type", "syslog")
+
+	for _, tc := range tests {
+		t.Run(tc.config, func(t *testing.T) {
+			s := SyslogSource{}
+			err := s.Configure([]byte(tc.config), subLogger, metrics.AcquisitionMetricsLevelNone)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	ctx := t.Context()
+
+	certFile, keyFile := writeTestCert(t)
+
+	subLogger := log.WithField("type", "syslog")
+	s := SyslogSource{}
+	err := s.Configure([]byte(fmt.Sprintf(`source: syslog
+listen_addr: 127.0.0.1
+listen_port: 4249
+protocol: tls
+tls_cert_file: %s
+tls_key_file: %s
+tls_min_version: "1.3"`, certFile, keyFile)), subLogger, metrics.AcquisitionMetricsLevelNone)
+	require.NoError(t, err)
+
+	tomb := tomb.Tomb{}
+	out := make(chan types.Event)
+	err = s.StreamingAcquisition(ctx, out, &tomb)
+	require.NoError(t, err)
+
+	dial := func(maxVersion uint16) (*tls.Conn, error) {
+		return tls.Dial("tcp", "127.0.0.1:4249", &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // self-signed test certificate
+			MaxVersion:         maxVersion,
+		})
+	}
+
+	// TLS 1.2 is refused
+	_, err = dial(tls.VersionTLS12)
+	require.Error(t, err)
+
+	// TLS 1.3 is accepted
+	conn, err := dial(tls.VersionTLS13)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	fmt.Fprint(conn, "<13>May 18 12:37:56 mantis sshd[49340]: over tls\n")
+
+	select {
+	case evt := <-out:
+		assert.Equal(t, "May 18 12:37:56 mantis sshd[49340]: over tls", evt.Line.Raw)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	tomb.Kill(nil)
+	err = tomb.Wait()
+	require.NoError(t, err)
+}
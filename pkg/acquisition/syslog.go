@@ -6,6 +6,8 @@ import (
 	syslogacquisition "github.com/crowdsecurity/crowdsec/pkg/acquisition/modules/syslog"
 )
 
+var _ ReloadableDataSource = (*syslogacquisition.SyslogSource)(nil)
+
 //nolint:gochecknoinits
 func init() {
 	registerDataSource("syslog", func() DataSource { return &syslogacquisition.SyslogSource{} })
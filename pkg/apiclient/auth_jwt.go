@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -168,7 +169,7 @@ func (t *JWTTransport) prepareRequest(req *http.Request) (*http.Request, error)
 
 	// We bypass the refresh if we are requesting the login endpoint, as it does not require a token,
 	// and it leads to do 2 requests instead of one (refresh + actual login request).
-	if req.URL.Path != "/"+t.VersionPrefix+"/watchers/login" && t.needsTokenRefresh() {
+	if !strings.HasSuffix(req.URL.Path, "/"+t.VersionPrefix+"/watchers/login") && t.needsTokenRefresh() {
 		if err := t.refreshJwtToken(req.Context()); err != nil {
 			return nil, err
 		}
@@ -1,6 +1,7 @@
 package apiclient
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
@@ -56,6 +57,10 @@ func (r retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 		attemptLeft--
 
 		resp, err := r.next.RoundTrip(clonedReq)
+		if errors.Is(err, errSPKIMismatch) {
+			return nil, backoff.Permanent(err)
+		}
+
 		if err != nil {
 			if attemptLeft > 0 {
 				log.Errorf("while performing request: %s; %d retries left", err, attemptLeft)
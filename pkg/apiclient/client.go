@@ -17,6 +17,7 @@ import (
 
 	"github.com/crowdsecurity/crowdsec/pkg/apiclient/useragent"
 	"github.com/crowdsecurity/crowdsec/pkg/models"
+	"github.com/crowdsecurity/crowdsec/pkg/modelscapi"
 )
 
 var (
@@ -31,6 +32,12 @@ type TokenSave func(ctx context.Context, tokenKey string, token string) error
 type ApiClient struct {
 	/*The http client used to make requests*/
 	client *http.Client
+	/*The http client used to fetch blocklists content, if nil a default client is used*/
+	blocklistClient *http.Client
+	/*Returns the Authorization header to send when fetching a blocklist, if any*/
+	blocklistAuthorization func(blocklist *modelscapi.BlocklistLink) string
+	/*Longer lines of a blocklist are skipped, DefaultBlocklistMaxLineLength if 0*/
+	blocklistMaxLineLength int
 	/*Reuse a single struct instead of allocating one for each service on the heap.*/
 	common service
 	/*config stuff*/
@@ -54,6 +61,21 @@ func (c *ApiClient) GetClient() *http.Client {
 	return c.client
 }
 
+// SetBlocklistClient sets the http client used to download the content of blocklists.
+func (c *ApiClient) SetBlocklistClient(client *http.Client) {
+	c.blocklistClient = client
+}
+
+// SetBlocklistAuthorization sets the function providing the Authorization header of blocklist requests.
+func (c *ApiClient) SetBlocklistAuthorization(f func(blocklist *modelscapi.BlocklistLink) string) {
+	c.blocklistAuthorization = f
+}
+
+// SetBlocklistMaxLineLength sets the size of the buffer used to read blocklists. Longer lines are skipped.
+func (c *ApiClient) SetBlocklistMaxLineLength(n int) {
+	c.blocklistMaxLineLength = n
+}
+
 func (c *ApiClient) IsEnrolled() bool {
 	jwtTransport := c.client.Transport.(*JWTTransport)
 	tokenStr := jwtTransport.Token
@@ -192,6 +214,10 @@ func NewClient(config *Config) *ApiClient {
 		tlsconfig.Certificates = []tls.Certificate{*Cert}
 	}
 
+	if len(config.PinnedSPKI) > 0 {
+		tlsconfig.VerifyConnection = verifyPinnedSPKI(config.PinnedSPKI)
+	}
+
 	if t.Transport != nil {
 		t.Transport.(*http.Transport).TLSClientConfig = &tlsconfig
 	}
@@ -1,6 +1,7 @@
 package apiclient
 
 import (
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
@@ -193,6 +194,66 @@ func TestNewClientKo(t *testing.T) {
 	log.Printf("err-> %s", err)
 }
 
+func TestNewClientPinnedSPKI(t *testing.T) {
+	ctx := t.Context()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/watchers/login":
+			_, err := w.Write([]byte(`{"code": 200, "expire": "2030-01-02T15:04:05Z", "token": "oklol"}`))
+			assert.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	oldPool := CaCertPool
+	CaCertPool = pool
+
+	t.Cleanup(func() { CaCertPool = oldPool })
+
+	apiURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		pins        []string
+		expectedErr string
+	}{
+		{
+			name: "no pin",
+		},
+		{
+			name: "matching pin",
+			pins: []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", SPKIHash(server.Certificate())},
+		},
+		{
+			name:        "other pin",
+			pins:        []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+			expectedErr: "the public key of the server certificate does not match the pinned keys",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(&Config{
+				MachineID:     "test_login",
+				Password:      "test_password",
+				URL:           apiURL,
+				VersionPrefix: "v1",
+				PinnedSPKI:    tc.pins,
+			})
+
+			_, _, err := client.Alerts.List(ctx, AlertsListOpts{})
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
 func TestNewDefaultClient(t *testing.T) {
 	ctx := t.Context()
 
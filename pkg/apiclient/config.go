@@ -19,4 +19,5 @@ type Config struct {
 	RegistrationToken string
 	UpdateScenario    func(context.Context) ([]string, error)
 	TokenSave         func(context.Context, string, string) error
+	PinnedSPKI        []string // if set, only accept a server certificate with one of these public keys (see SPKIHash)
 }
@@ -5,7 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
 	qs "github.com/google/go-querystring/query"
 	log "github.com/sirupsen/logrus"
@@ -37,6 +39,9 @@ type DecisionsStreamOpts struct {
 	ScenariosContaining    string `url:"scenarios_containing,omitempty"`
 	ScenariosNotContaining string `url:"scenarios_not_containing,omitempty"`
 	Origins                string `url:"origins,omitempty"`
+	// conditional request headers, not sent as query parameters
+	IfModifiedSince string `url:"-"`
+	IfNoneMatch     string `url:"-"`
 }
 
 func (o *DecisionsStreamOpts) addQueryParamsToURL(url string) (string, error) {
@@ -125,6 +130,7 @@ func (s *DecisionsService) GetDecisionsFromGroups(decisionsGroups []*modelscapi.
 				Value:    decision.Value,
 				Duration: decision.Duration,
 				Origin:   ptr.Of(types.CAPIOrigin),
+				Metadata: decision.Metadata,
 			}
 		}
 
@@ -174,6 +180,15 @@ func (s *DecisionsService) FetchV3Decisions(ctx context.Context, url string) (*m
 	return &v2Decisions, resp, nil
 }
 
+// BlocklistStatusError is returned when a blocklist server answers with an unexpected status code.
+type BlocklistStatusError struct {
+	StatusCode int
+}
+
+func (e *BlocklistStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+}
+
 func (s *DecisionsService) GetDecisionsFromBlocklist(ctx context.Context, blocklist *modelscapi.BlocklistLink, lastPullTimestamp string) ([]*models.Decision, bool, error) {
 	if blocklist.URL == nil {
 		return nil, false, errors.New("blocklist URL is nil")
@@ -181,7 +196,10 @@ func (s *DecisionsService) GetDecisionsFromBlocklist(ctx context.Context, blockl
 
 	log.Debugf("Fetching blocklist %s", *blocklist.URL)
 
-	client := http.Client{}
+	client := s.client.blocklistClient
+	if client == nil {
+		client = &http.Client{}
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *blocklist.URL, http.NoBody)
 	if err != nil {
@@ -192,6 +210,12 @@ func (s *DecisionsService) GetDecisionsFromBlocklist(ctx context.Context, blockl
 		req.Header.Set("If-Modified-Since", lastPullTimestamp)
 	}
 
+	if s.client.blocklistAuthorization != nil {
+		if auth := s.client.blocklistAuthorization(blocklist); auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+	}
+
 	log.Debugf("[URL] %s %s", req.Method, req.URL)
 
 	// we don't use client_http Do method because we need the reader and is not provided.
@@ -229,15 +253,19 @@ func (s *DecisionsService) GetDecisionsFromBlocklist(ctx context.Context, blockl
 	if resp.StatusCode != http.StatusOK {
 		log.Debugf("Received nok status code %d for blocklist %s", resp.StatusCode, *blocklist.URL)
 
-		return nil, false, nil
+		return nil, false, &BlocklistStatusError{StatusCode: resp.StatusCode}
 	}
 
 	decisions := make([]*models.Decision, 0)
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		decision := scanner.Text()
+	maxLineLength := s.client.blocklistMaxLineLength
+	if maxLineLength <= 0 {
+		maxLineLength = DefaultBlocklistMaxLineLength
+	}
+
+	skipped, err := readBlocklistLines(resp.Body, maxLineLength, func(decision string) {
 		decisions = append(decisions, &models.Decision{
+			// the origin is the same for all the blocklists, the scenario tells them apart
 			Scenario: blocklist.Name,
 			Scope:    blocklist.Scope,
 			Type:     blocklist.Remediation,
@@ -245,13 +273,51 @@ func (s *DecisionsService) GetDecisionsFromBlocklist(ctx context.Context, blockl
 			Duration: blocklist.Duration,
 			Origin:   ptr.Of(types.ListOrigin),
 		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("while reading blocklist %s: %w", *blocklist.URL, err)
+	}
+
+	if skipped > 0 {
+		log.Warningf("blocklist %s: skipped %d lines longer than %d bytes", *blocklist.URL, skipped, maxLineLength)
 	}
 
-	// here the upper go routine is finished because scanner.Scan() is blocking until pw.Close() is called
-	// so it's safe to use the isModified variable here
 	return decisions, true, nil
 }
 
+const DefaultBlocklistMaxLineLength = bufio.MaxScanTokenSize
+
+// readBlocklistLines calls fn for each non-empty line of r, using a buffer of maxLineLength bytes whatever the
+// size of the content. Lines that don't fit in the buffer are skipped, and their number is returned.
+func readBlocklistLines(r io.Reader, maxLineLength int, fn func(string)) (int, error) {
+	// +1 for the line feed
+	reader := bufio.NewReaderSize(r, maxLineLength+1)
+	skipped := 0
+
+	for {
+		line, err := reader.ReadSlice('\n')
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// discard the rest of the line
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+
+			skipped++
+		} else if value := strings.TrimRight(string(line), "\r\n"); value != "" {
+			fn(value)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return skipped, nil
+		}
+
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
+
 func (s *DecisionsService) GetStream(ctx context.Context, opts DecisionsStreamOpts) (*models.DecisionsStreamResponse, *Response, error) {
 	u, err := opts.addQueryParamsToURL(s.client.URLPrefix + "/decisions/stream")
 	if err != nil {
@@ -276,6 +342,14 @@ func (s *DecisionsService) GetStreamV3(ctx context.Context, opts DecisionsStream
 		return nil, nil, err
 	}
 
+	if opts.IfModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", opts.IfModifiedSince)
+	}
+
+	if opts.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+
 	decisions := modelscapi.GetDecisionsStreamResponse{}
 
 	resp, err := s.client.Do(ctx, req, &decisions)
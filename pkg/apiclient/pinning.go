@@ -0,0 +1,65 @@
+package apiclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// errSPKIMismatch is returned when the server certificate is not pinned, it's not worth retrying.
+var errSPKIMismatch = errors.New("the public key of the server certificate does not match the pinned keys")
+
+// SPKIHash returns the base64 encoded SHA-256 hash of the public key of a certificate,
+// the format of the pinned keys.
+func SPKIHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// verifyPinnedSPKI returns a tls.Config.VerifyConnection function that refuses
+// the servers whose certificate has a public key that is not one of the pinned ones.
+// It's called after the usual verification of the certificate chain.
+func verifyPinnedSPKI(pins []string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no server certificate to check against the pinned keys")
+		}
+
+		if !slices.Contains(pins, SPKIHash(cs.PeerCertificates[0])) {
+			return errSPKIMismatch
+		}
+
+		return nil
+	}
+}
+
+// PinnedHTTPClient returns a client for NewDefaultClient that, like the ones of NewClient, only accepts
+// the servers whose certificate has one of the pinned public keys. It returns nil if nothing is pinned.
+func PinnedHTTPClient(pins []string) *http.Client {
+	if len(pins) == 0 {
+		return nil
+	}
+
+	client := &http.Client{}
+
+	// can be httpmock.MockTransport
+	if ht, ok := http.DefaultTransport.(*http.Transport); ok {
+		ht = ht.Clone()
+		tlsconfig := tls.Config{InsecureSkipVerify: InsecureSkipVerify}
+		tlsconfig.RootCAs = CaCertPool
+
+		if Cert != nil {
+			tlsconfig.Certificates = []tls.Certificate{*Cert}
+		}
+
+		tlsconfig.VerifyConnection = verifyPinnedSPKI(pins)
+		ht.TLSClientConfig = &tlsconfig
+		client.Transport = ht
+	}
+
+	return client
+}
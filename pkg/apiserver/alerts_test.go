@@ -13,11 +13,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
 	"github.com/crowdsecurity/crowdsec/pkg/database"
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 	"github.com/crowdsecurity/crowdsec/pkg/models"
 )
 
@@ -229,6 +231,34 @@ func TestCreateAlertChannels(t *testing.T) {
 	apiServer.Close()
 }
 
+func TestCreateAlertPushQueueFull(t *testing.T) {
+	ctx := t.Context()
+	apiServer, config := NewAPIServer(t, ctx)
+	// nothing reads from the channel, it's full after the first alert
+	apiServer.controller.AlertsAddChan = make(chan []*models.Alert, 1)
+	err := apiServer.InitController()
+	require.NoError(t, err)
+
+	loginResp := LoginToTestAPI(t, ctx, apiServer.router, config)
+	lapi := LAPI{router: apiServer.router, loginResp: loginResp}
+
+	dropped := testutil.ToFloat64(metrics.LapiPushDroppedAlerts)
+
+	w := lapi.InsertAlertFromFile(t, ctx, "./tests/alert_ssh-bf.json")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.InDelta(t, dropped, testutil.ToFloat64(metrics.LapiPushDroppedAlerts), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(metrics.LapiPushQueueDepth), 0)
+
+	// the alert is still created, but not queued for CAPI
+	w = lapi.InsertAlertFromFile(t, ctx, "./tests/alert_ssh-bf.json")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.InDelta(t, dropped+1, testutil.ToFloat64(metrics.LapiPushDroppedAlerts), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(metrics.LapiPushQueueDepth), 0)
+
+	assert.Len(t, apiServer.controller.AlertsAddChan, 1)
+	apiServer.Close()
+}
+
 func TestAlertListFilters(t *testing.T) {
 	ctx := t.Context()
 	lapi := SetupLAPITest(t, ctx)
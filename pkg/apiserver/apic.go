@@ -2,25 +2,41 @@ package apiserver
 
 import (
 	"bufio"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/netip"
 	"net/url"
+	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/tomb.v2"
 
 	"github.com/crowdsecurity/go-cs-lib/ptr"
+	"github.com/crowdsecurity/go-cs-lib/slicetools"
 	"github.com/crowdsecurity/go-cs-lib/trace"
 
 	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
@@ -28,7 +44,11 @@ import (
 	"github.com/crowdsecurity/crowdsec/pkg/database"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/alert"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent/configitem"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/decision"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent/predicate"
+	"github.com/crowdsecurity/crowdsec/pkg/exprhelpers"
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 	"github.com/crowdsecurity/crowdsec/pkg/models"
 	"github.com/crowdsecurity/crowdsec/pkg/modelscapi"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
@@ -46,6 +66,27 @@ const (
 	usageMetricsIntervalDelta = time.Minute * 15
 )
 
+// version of the CAPI endpoints, unless configured otherwise
+const capiVersionDefault = "v3"
+
+// duration of the pulled decisions that have a missing or invalid one
+const decisionDurationDefault = 24 * time.Hour
+
+// maximum duration of WarmupPull, unless configured otherwise
+const warmupTimeoutDefault = 30 * time.Second
+
+// age of the last pull after which CAPIPullIsOld is true, unless configured otherwise
+const staleAfterDefault = 90 * time.Minute
+
+// number of alert batches that can wait to be processed by the push routine
+// before new ones are dropped
+const alertsAddChanSize = 100
+
+const (
+	decisionsStreamETagConfigItem         = "decisions_stream:etag"
+	decisionsStreamLastModifiedConfigItem = "decisions_stream:last_modified"
+)
+
 type apic struct {
 	// when changing the intervals in tests, always set *First too
 	// or they can be negative
@@ -61,19 +102,71 @@ type apic struct {
 	apiClient                 *apiclient.ApiClient
 	AlertsAddChan             chan []*models.Alert
 
-	mu            sync.Mutex
+	mu            sync.Mutex               // protects pushCache
+	pushCache     models.AddSignalsRequest // signals waiting for the next push
 	pushTomb      tomb.Tomb
 	pullTomb      tomb.Tomb
 	metricsTomb   tomb.Tomb
 	startup       bool
 	credentials   *csconfig.ApiCredentialsCfg
+	pinnedSPKI    []string // accepted public keys of the CAPI certificate, none if empty
+	capiBasePath  string
+	capiVersion   string
 	consoleConfig *csconfig.ConsoleConfig
 	isPulling     chan bool
 	whitelists    *csconfig.CapiWhitelist
+	asnResolver   func(netip.Addr) (uint, error) // defaults to the GeoIP ASN database
+
+	// SendMetrics is running, and will push the last metrics when metricsTomb dies
+	sendingMetrics atomic.Bool
 
 	pullBlocklists bool
 	pullCommunity  bool
 	shareSignals   bool
+	shareOSInfo    bool
+	// don't share manual decisions, even if the console options allow it
+	neverShareManual bool
+	// don't share the alerts that ended longer ago than this, disabled if 0
+	pushMaxAge time.Duration
+	// drop the largest context entries of a signal above this size, disabled if 0
+	maxContextSize int
+	// delay of the pushes after CAPI failures
+	pushBackoff pushBackoff
+
+	// "hash" or "omit" the names in the metrics, sent as is if empty
+	redactMachineNames string
+	redactBouncerNames string
+
+	// clients of the blocklists fetched through a proxy, by blocklist name, host or "*"
+	blocklistProxyClients map[string]*http.Client
+
+	minDecisionDuration time.Duration
+	defaultDuration     time.Duration
+	blocklistClient     *http.Client
+	checkBlocklistHash  bool
+	scenarioRemap       map[string]string
+	blocklistsAuth      map[string]csconfig.Secret
+	aggregateRanges     bool
+	pushDrainTimeout    time.Duration
+	spool               *signalSpool // failed signal batches, nil if disabled
+	decisionTypeAliases map[string]string
+	maxDecisions        int
+	deleteGracePeriod   time.Duration
+	sourceScopePrefix   string
+	blocklistMaxLength  int
+	blocklistBackoff    time.Duration
+	explodeAlerts       map[string]bool
+	allowEmpty          map[string]bool
+	localScenariosOnly  bool
+	warmupTimeout       time.Duration
+	staleAfter          time.Duration
+	maxBlocklists       int
+	tagPullBatch        bool
+	allowlistsInterval  time.Duration
+	allowlistsMu        sync.Mutex
+	allowlistLinks      []*modelscapi.AllowlistLink                 // subscribed allowlists, refreshed every allowlistsInterval
+	streamLinks         *modelscapi.GetDecisionsStreamResponseLinks // links of the last decisions stream, only used by PullTop
+	decisionObserver    DecisionObserver                            // nil if no observer is registered
 
 	TokenSave apiclient.TokenSave
 }
@@ -89,6 +182,8 @@ func randomDuration(d time.Duration, delta time.Duration) time.Duration {
 	return ret
 }
 
+// FetchScenariosListFromDB returns the scenarios installed on all the machines,
+// sorted and without duplicates.
 func (a *apic) FetchScenariosListFromDB(ctx context.Context) ([]string, error) {
 	scenarios := make([]string, 0)
 
@@ -101,13 +196,17 @@ func (a *apic) FetchScenariosListFromDB(ctx context.Context) ([]string, error) {
 		machineScenarios := strings.Split(v.Scenarios, ",")
 		log.Debugf("%d scenarios for machine %d", len(machineScenarios), v.ID)
 
+		// the list is sent by the machines, ignore the blanks and empty entries
 		for _, sv := range machineScenarios {
-			if !slices.Contains(scenarios, sv) && sv != "" {
+			if sv = strings.TrimSpace(sv); sv != "" {
 				scenarios = append(scenarios, sv)
 			}
 		}
 	}
 
+	slices.Sort(scenarios)
+	scenarios = slices.Compact(scenarios)
+
 	log.Debugf("Returning list of scenarios : %+v", scenarios)
 
 	return scenarios, nil
@@ -183,6 +282,60 @@ func alertToSignal(alert *models.Alert, scenarioTrust string, shareContext bool)
 	return signal
 }
 
+// contextItemSize returns the size of a context entry once serialized.
+func contextItemSize(item *models.AddSignalsRequestItemContextItems0) int {
+	content, err := json.Marshal(item)
+	if err != nil {
+		return 0
+	}
+
+	return len(content)
+}
+
+// truncateSignalContext drops the largest context entries of a signal until its serialized context
+// fits in maxSize bytes. It returns true if entries have been dropped.
+func truncateSignalContext(signal *models.AddSignalsRequestItem, maxSize int) bool {
+	if maxSize <= 0 || len(signal.Context) == 0 {
+		return false
+	}
+
+	sizes := make(map[*models.AddSignalsRequestItemContextItems0]int, len(signal.Context))
+	// the brackets of the list, and the commas between the entries
+	total := 2 + len(signal.Context) - 1
+
+	for _, item := range signal.Context {
+		sizes[item] = contextItemSize(item)
+		total += sizes[item]
+	}
+
+	if total <= maxSize {
+		return false
+	}
+
+	// drop the largest entries first, and keep the order of the remaining ones
+	bySize := slices.Clone(signal.Context)
+	slices.SortStableFunc(bySize, func(a, b *models.AddSignalsRequestItemContextItems0) int {
+		return cmp.Compare(sizes[b], sizes[a])
+	})
+
+	dropped := make(map[*models.AddSignalsRequestItemContextItems0]bool)
+
+	for _, item := range bySize {
+		if total <= maxSize {
+			break
+		}
+
+		dropped[item] = true
+		total -= sizes[item] + 1
+	}
+
+	signal.Context = slices.DeleteFunc(signal.Context, func(item *models.AddSignalsRequestItemContextItems0) bool {
+		return dropped[item]
+	})
+
+	return true
+}
+
 func NewAPIC(ctx context.Context, config *csconfig.OnlineApiClientCfg, dbClient *database.Client, consoleConfig *csconfig.ConsoleConfig, apicWhitelist *csconfig.CapiWhitelist) (*apic, error) {
 	var err error
 
@@ -191,11 +344,13 @@ func NewAPIC(ctx context.Context, config *csconfig.OnlineApiClientCfg, dbClient
 	}
 
 	ret := &apic{
-		AlertsAddChan:             make(chan []*models.Alert),
+		AlertsAddChan:             make(chan []*models.Alert, alertsAddChanSize),
 		dbClient:                  dbClient,
 		mu:                        sync.Mutex{},
 		startup:                   true,
 		credentials:               config.Credentials,
+		capiBasePath:              config.BasePath,
+		capiVersion:               cmp.Or(config.APIVersion, capiVersionDefault),
 		pullTomb:                  tomb.Tomb{},
 		pushTomb:                  tomb.Tomb{},
 		metricsTomb:               tomb.Tomb{},
@@ -213,11 +368,72 @@ func NewAPIC(ctx context.Context, config *csconfig.OnlineApiClientCfg, dbClient
 		pullBlocklists:            *config.PullConfig.Blocklists,
 		pullCommunity:             *config.PullConfig.Community,
 		shareSignals:              *config.Sharing,
+		shareOSInfo:               ptr.OrEmpty(config.ShareOSInfo),
+		neverShareManual:          config.NeverShareManual,
+		pushMaxAge:                config.PushMaxAge,
+		maxContextSize:            config.MaxContextSize,
+		pushBackoff:               pushBackoff{max: config.PushBackoffMax},
+		redactMachineNames:        config.RedactMachineNames,
+		redactBouncerNames:        config.RedactBouncerNames,
+		minDecisionDuration:       config.PullConfig.MinDecisionDuration,
+		defaultDuration:           cmp.Or(config.PullConfig.DefaultDuration, decisionDurationDefault),
+		checkBlocklistHash:        config.PullConfig.BlocklistHashCheck,
+		scenarioRemap:             config.PullConfig.ScenarioRemap,
+		blocklistsAuth:            config.PullConfig.BlocklistsAuth,
+		aggregateRanges:           config.PullConfig.BlocklistAggregateRanges,
+		pushDrainTimeout:          config.PushDrainTimeout,
+		maxDecisions:              config.PullConfig.MaxDecisions,
+		deleteGracePeriod:         config.PullConfig.DeleteGracePeriod,
+		sourceScopePrefix:         config.PullConfig.SourceScopePrefix,
+		blocklistMaxLength:        config.PullConfig.BlocklistMaxLineLength,
+		blocklistBackoff:          config.PullConfig.BlocklistBackoff,
+		explodeAlerts:             config.PullConfig.ExplodeAlerts,
+		allowEmpty:                config.PullConfig.AllowEmpty,
+		localScenariosOnly:        config.PullConfig.LocalScenariosOnly,
+		warmupTimeout:             config.PullConfig.WarmupTimeout,
+		staleAfter:                config.PullConfig.StaleAfter,
+		maxBlocklists:             config.PullConfig.MaxBlocklists,
+		tagPullBatch:              config.PullConfig.TagPullBatch,
+		allowlistsInterval:        config.PullConfig.AllowlistsInterval,
+	}
+
+	if len(config.PullConfig.DecisionTypeAliases) > 0 {
+		ret.decisionTypeAliases = make(map[string]string, len(config.PullConfig.DecisionTypeAliases))
+		for alias, decisionType := range config.PullConfig.DecisionTypeAliases {
+			ret.decisionTypeAliases[strings.ToLower(alias)] = strings.ToLower(decisionType)
+		}
+	}
+
+	if config.SpoolDir != "" {
+		ret.spool, err = newSignalSpool(config.SpoolDir, config.SpoolMaxBatches)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkRedaction("redact_machine_names", ret.redactMachineNames); err != nil {
+		return nil, err
+	}
+
+	if err := checkRedaction("redact_bouncer_names", ret.redactBouncerNames); err != nil {
+		return nil, err
+	}
+
+	var cache *dnsCache
+
+	if config.PullConfig.BlocklistDNSCacheTTL > 0 {
+		cache = newDNSCache(net.DefaultResolver, config.PullConfig.BlocklistDNSCacheTTL)
+		ret.blocklistClient = newBlocklistHTTPClient(cache, nil)
+	}
+
+	ret.blocklistProxyClients, err = newBlocklistProxyClients(config.PullConfig.BlocklistsProxy, cache)
+	if err != nil {
+		return nil, err
 	}
 
-	apiURL, err := url.Parse(config.Credentials.URL)
+	apiURL, err := capiURL(config.Credentials.URL, ret.capiBasePath)
 	if err != nil {
-		return nil, fmt.Errorf("while parsing '%s': %w", config.Credentials.URL, err)
+		return nil, err
 	}
 
 	papiURL, err := url.Parse(config.Credentials.PapiURL)
@@ -225,16 +441,24 @@ func NewAPIC(ctx context.Context, config *csconfig.OnlineApiClientCfg, dbClient
 		return nil, fmt.Errorf("while parsing '%s': %w", config.Credentials.PapiURL, err)
 	}
 
+	pinnedSPKI, err := capiPinnedSPKI(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.pinnedSPKI = pinnedSPKI
+
 	ret.apiClient = apiclient.NewClient(&apiclient.Config{
 		MachineID:      config.Credentials.Login,
 		Password:       strfmt.Password(config.Credentials.Password),
 		URL:            apiURL,
 		PapiURL:        papiURL,
-		VersionPrefix:  "v3",
+		VersionPrefix:  ret.capiVersion,
 		UpdateScenario: ret.FetchScenariosListFromDB,
 		TokenSave: func(ctx context.Context, tokenKey string, token string) error {
 			return dbClient.SaveAPICToken(ctx, tokenKey, token)
 		},
+		PinnedSPKI: pinnedSPKI,
 	})
 
 	err = ret.Authenticate(ctx, config)
@@ -242,6 +466,68 @@ func NewAPIC(ctx context.Context, config *csconfig.OnlineApiClientCfg, dbClient
 	return ret, err
 }
 
+// capiPinnedSPKI returns the hashes of the accepted public keys of the CAPI certificate,
+// from pinned_spki and the certificates of pinned_cert_file. Nothing is pinned if both are empty.
+func capiPinnedSPKI(config *csconfig.OnlineApiClientCfg) ([]string, error) {
+	pins := make([]string, 0, len(config.PinnedSPKI))
+
+	for _, pin := range config.PinnedSPKI {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid pinned_spki %q: must be a base64 encoded SHA-256 hash", pin)
+		}
+
+		pins = append(pins, pin)
+	}
+
+	if config.PinnedCertFile == "" {
+		return pins, nil
+	}
+
+	content, err := os.ReadFile(config.PinnedCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("while reading pinned_cert_file: %w", err)
+	}
+
+	found := false
+
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing pinned_cert_file: %w", err)
+		}
+
+		pins = append(pins, apiclient.SPKIHash(cert))
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no certificate found in pinned_cert_file %s", config.PinnedCertFile)
+	}
+
+	return pins, nil
+}
+
+// capiURL returns the URL of the central API, under basePath if the endpoints
+// are exposed with a prefix by a reverse proxy.
+func capiURL(rawURL string, basePath string) (*url.URL, error) {
+	apiURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing '%s': %w", rawURL, err)
+	}
+
+	if basePath != "" {
+		// keep the trailing slash, the endpoints are relative to it
+		apiURL = apiURL.JoinPath(basePath, "/")
+	}
+
+	return apiURL, nil
+}
+
 // Authenticate ensures the API client is authorized to communicate with the CAPI.
 // It attempts to reuse a previously saved JWT token from the database, falling back to
 // an authentication request if the token is missing, invalid, or expired.
@@ -284,12 +570,48 @@ func (a *apic) Authenticate(ctx context.Context, config *csconfig.OnlineApiClien
 	return a.dbClient.SaveAPICToken(ctx, apiclient.TokenDBField, authResp.Token)
 }
 
+// TestCredentials logs in to CAPI with the configured credentials and returns the expiration
+// of the token it got. The token is not saved, nor used by the pull and push routines.
+func (a *apic) TestCredentials(ctx context.Context) (time.Time, error) {
+	if a.credentials == nil {
+		return time.Time{}, errors.New("no CAPI credentials")
+	}
+
+	apiURL, err := capiURL(a.credentials.URL, a.capiBasePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the credentials must not be sent to a server that is not pinned
+	client, err := apiclient.NewDefaultClient(apiURL, a.capiVersion, "", apiclient.PinnedHTTPClient(a.pinnedSPKI))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("while creating client: %w", err)
+	}
+
+	password := strfmt.Password(a.credentials.Password)
+
+	authResp, _, err := client.Auth.AuthenticateWatcher(ctx, models.WatcherAuthRequest{
+		MachineID: &a.credentials.Login,
+		Password:  &password,
+		Scenarios: []string{},
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("authenticate watcher (%s): %w", a.credentials.Login, err)
+	}
+
+	var expiration time.Time
+
+	if err := expiration.UnmarshalText([]byte(authResp.Expire)); err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse jwt expiration: %w", err)
+	}
+
+	return expiration, nil
+}
+
 // keep track of all alerts in cache and push it to CAPI every PushInterval.
 func (a *apic) Push(ctx context.Context) error {
 	defer trace.CatchPanic("lapi/pushToAPIC")
 
-	var cache models.AddSignalsRequest
-
 	ticker := time.NewTicker(a.pushIntervalFirst)
 
 	log.Infof("Start push to CrowdSec Central API (interval: %s once, then %s)", a.pushIntervalFirst.Round(time.Second), a.pushInterval)
@@ -299,45 +621,141 @@ func (a *apic) Push(ctx context.Context) error {
 		case <-a.pushTomb.Dying(): // if one apic routine is dying, do we kill the others?
 			a.pullTomb.Kill(nil)
 			a.metricsTomb.Kill(nil)
+
+			a.mu.Lock()
+			cache := a.pushCache
+			a.pushCache = nil
+			a.mu.Unlock()
+
+			if a.pushDrainTimeout > 0 {
+				return a.drainPush(ctx, cache)
+			}
+
 			log.Infof("push tomb is dying, sending cache (%d elements) before exiting", len(cache))
 
 			if len(cache) == 0 {
 				return nil
 			}
 
-			go a.Send(ctx, &cache)
+			go a.Send(ctx, &cache) //nolint:errcheck // errors are logged per batch
 
 			return nil
 		case <-ticker.C:
 			ticker.Reset(a.pushInterval)
 
-			if len(cache) > 0 {
+			a.mu.Lock()
+			pending := len(a.pushCache)
+			a.mu.Unlock()
+
+			if wait := a.pushBackoff.remaining(time.Now()); wait > 0 && pending > 0 {
+				log.Debugf("Signal push: waiting %s after central API failures", wait.Round(time.Second))
+				ticker.Reset(wait)
+
+				continue
+			}
+
+			if pending > 0 {
 				a.mu.Lock()
-				cacheCopy := cache
-				cache = make(models.AddSignalsRequest, 0)
+				cacheCopy := a.pushCache
+				a.pushCache = make(models.AddSignalsRequest, 0)
 				a.mu.Unlock()
 				log.Infof("Signal push: %d signals to push", len(cacheCopy))
 
-				go a.Send(ctx, &cacheCopy)
+				go a.Send(ctx, &cacheCopy) //nolint:errcheck // errors are logged per batch
 			}
 		case alerts := <-a.AlertsAddChan:
-			var signals []*models.AddSignalsRequestItem
+			metrics.LapiPushQueueDepth.Set(float64(len(a.AlertsAddChan)))
 
-			for _, alert := range alerts {
-				if ok := shouldShareAlert(alert, a.consoleConfig, a.shareSignals); ok {
-					signals = append(signals, alertToSignal(alert, getScenarioTrustOfAlert(alert), *a.consoleConfig.ShareContext))
-				}
-			}
+			signals := a.alertsToSignals(alerts)
 
 			a.mu.Lock()
 
-			cache = append(cache, signals...)
+			a.pushCache = append(a.pushCache, signals...)
 
 			a.mu.Unlock()
 		}
 	}
 }
 
+func (a *apic) alertsToSignals(alerts []*models.Alert) []*models.AddSignalsRequestItem {
+	var signals []*models.AddSignalsRequestItem
+
+	for _, alert := range alerts {
+		if a.isStaleAlert(alert) {
+			log.Debugf("alert (id:%d) ended at %s, it's too old to be sent to CAPI", alert.ID, *alert.StopAt)
+			metrics.LapiPushStaleAlerts.Inc()
+
+			continue
+		}
+
+		if !shouldShareAlert(alert, a.consoleConfig, a.shareSignals, a.neverShareManual) {
+			if a.shareSignals && alert.Simulated != nil && *alert.Simulated {
+				metrics.LapiPushSimulatedAlerts.Inc()
+			}
+
+			continue
+		}
+
+		signal := alertToSignal(alert, getScenarioTrustOfAlert(alert), *a.consoleConfig.ShareContext)
+
+		if truncateSignalContext(signal, a.maxContextSize) {
+			log.Debugf("alert (id:%d) context is larger than %d bytes, the largest entries have been dropped", alert.ID, a.maxContextSize)
+			metrics.LapiPushTruncatedContext.Inc()
+		}
+
+		signals = append(signals, signal)
+	}
+
+	return signals
+}
+
+// isStaleAlert returns true if the alert ended longer ago than pushMaxAge.
+func (a *apic) isStaleAlert(alert *models.Alert) bool {
+	if a.pushMaxAge <= 0 || alert.StopAt == nil {
+		return false
+	}
+
+	stopAt, err := time.Parse(time.RFC3339, *alert.StopAt)
+	if err != nil {
+		log.Warningf("invalid stop_at %q for alert (id:%d): %s", *alert.StopAt, alert.ID, err)
+		return false
+	}
+
+	return time.Since(stopAt) > a.pushMaxAge
+}
+
+// drainPush sends the cached signals along with the alerts still waiting in the channel,
+// and waits for the push to complete for at most pushDrainTimeout.
+func (a *apic) drainPush(ctx context.Context, cache models.AddSignalsRequest) error {
+drain:
+	for {
+		select {
+		case alerts := <-a.AlertsAddChan:
+			cache = append(cache, a.alertsToSignals(alerts)...)
+		default:
+			break drain
+		}
+	}
+
+	metrics.LapiPushQueueDepth.Set(0)
+
+	log.Infof("push tomb is dying, sending cache (%d elements) before exiting", len(cache))
+
+	if len(cache) == 0 {
+		return nil
+	}
+
+	// the parent context may already be canceled if we are shutting down
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.pushDrainTimeout)
+	defer cancel()
+
+	if err := a.Send(ctx, &cache); err != nil {
+		log.Errorf("could not send all pending signals before exiting: %s", err)
+	}
+
+	return nil
+}
+
 func getScenarioTrustOfAlert(alert *models.Alert) string {
 	scenarioTrust := "certified"
 	if alert.ScenarioHash == nil || *alert.ScenarioHash == "" {
@@ -355,19 +773,30 @@ func getScenarioTrustOfAlert(alert *models.Alert) string {
 	return scenarioTrust
 }
 
-func shouldShareAlert(alert *models.Alert, consoleConfig *csconfig.ConsoleConfig, shareSignals bool) bool {
+func shouldShareAlert(alert *models.Alert, consoleConfig *csconfig.ConsoleConfig, shareSignals bool, neverShareManual bool) bool {
 	if !shareSignals {
 		log.Debugf("sharing signals is disabled")
 		return false
 	}
 
-	if *alert.Simulated {
+	// simulated alerts are kept in the local database, but never shared
+	if alert.Simulated != nil && *alert.Simulated {
 		log.Debugf("simulation enabled for alert (id:%d), will not be sent to CAPI", alert.ID)
 		return false
 	}
 
+	if alert.Scenario != nil && consoleConfig.IsScenarioExcludedFromSharing(*alert.Scenario) {
+		log.Debugf("scenario %s is excluded from sharing, will not be sent to CAPI", *alert.Scenario)
+		return false
+	}
+
 	switch scenarioTrust := getScenarioTrustOfAlert(alert); scenarioTrust {
 	case "manual":
+		if neverShareManual {
+			log.Debugf("manual decision generated an alert, doesn't send it to CAPI because never_share_manual is set")
+			return false
+		}
+
 		if !*consoleConfig.ShareManualDecisions {
 			log.Debugf("manual decision generated an alert, doesn't send it to CAPI because options is disabled")
 			return false
@@ -387,16 +816,93 @@ func shouldShareAlert(alert *models.Alert, consoleConfig *csconfig.ConsoleConfig
 	return true
 }
 
+// holdSignals keeps the signals that could not be sent because CAPI asked to retry later:
+// in the spool if there is one, in the push cache otherwise.
+func (a *apic) holdSignals(signals []*models.AddSignalsRequestItem, batchSize int) {
+	if a.spool == nil {
+		a.requeueSignals(signals)
+		return
+	}
+
+	for _, batch := range slicetools.Chunks(signals, batchSize) {
+		if err := a.spool.add(batch); err != nil {
+			log.Errorf("while spooling %d signals: %s", len(batch), err)
+		}
+	}
+}
+
 func (a *apic) sendBatch(ctx context.Context, signals []*models.AddSignalsRequestItem) error {
 	ctxBatch, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	_, _, err := a.apiClient.Signal.Add(ctxBatch, (*models.AddSignalsRequest)(&signals))
+	start := time.Now()
+
+	_, resp, err := a.apiClient.Signal.Add(ctxBatch, (*models.AddSignalsRequest)(&signals))
+
+	metrics.LapiPushDuration.Observe(time.Since(start).Seconds())
+
+	status := "error"
+	if resp != nil && resp.Response != nil {
+		status = strconv.Itoa(resp.Response.StatusCode)
+	}
+
+	metrics.LapiPushResponses.WithLabelValues(status).Inc()
+
+	if err != nil {
+		var httpResp *http.Response
+		if resp != nil {
+			httpResp = resp.Response
+		}
+
+		if delay := retryAfter(httpResp, time.Now()); delay > 0 {
+			return &pushThrottledError{retryAfter: delay, err: err}
+		}
+
+		if batchRejected(httpResp) {
+			return &pushRejectedError{statusCode: httpResp.StatusCode, err: err}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// requeueSignals puts the signals that could not be sent back in the push cache,
+// before the ones received in the meantime.
+func (a *apic) requeueSignals(signals []*models.AddSignalsRequestItem) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pushCache = append(slices.Clone(signals), a.pushCache...)
+}
+
+// recordPushResult updates the delay before the next push. A push with a failing batch counts as
+// one failure, whatever the number of batches.
+func (a *apic) recordPushResult(errs []error) {
+	if len(errs) == 0 {
+		a.pushBackoff.success()
+		return
+	}
+
+	var wait time.Duration
+
+	for _, err := range errs {
+		if throttled := (*pushThrottledError)(nil); errors.As(err, &throttled) {
+			wait = max(wait, throttled.retryAfter)
+		}
+	}
 
-	return err
+	delay := a.pushBackoff.failure(a.pushInterval, wait, time.Now())
+	log.Debugf("next signal push in %s at the earliest", delay.Round(time.Second))
 }
 
-func (a *apic) Send(ctx context.Context, cacheOrig *models.AddSignalsRequest) {
+// Send pushes the signals to CAPI in batches. A failing batch doesn't prevent
+// the following ones from being sent, all the errors are returned together.
+// With a spool, the failing batches are kept to be sent after the next successful one,
+// unless CAPI rejected their content. If CAPI asks to retry later, the push stops there and the remaining signals are spooled,
+// or put back in the push cache without a spool.
+func (a *apic) Send(ctx context.Context, cacheOrig *models.AddSignalsRequest) error {
 	/*we do have a problem with this :
 	The apic.Push background routine reads from alertToPush chan.
 	This chan is filled by Controller.CreateAlert
@@ -411,23 +917,81 @@ func (a *apic) Send(ctx context.Context, cacheOrig *models.AddSignalsRequest) {
 	var cache []*models.AddSignalsRequestItem = *cacheOrig
 
 	batchSize := 50
+	nbBatches := (len(cache) + batchSize - 1) / batchSize
+
+	var errs []error
+
+	sent := false
 
 	for start := 0; start < len(cache); start += batchSize {
 		end := min(start+batchSize, len(cache))
+		batch := start/batchSize + 1
 
 		if err := a.sendBatch(ctx, cache[start:end]); err != nil {
-			log.Errorf("sending signal to central API: %s", err)
-			return
+			log.Errorf("sending signal batch %d/%d to central API: %s", batch, nbBatches, err)
+			errs = append(errs, fmt.Errorf("batch %d/%d: %w", batch, nbBatches, err))
+
+			if throttled := (*pushThrottledError)(nil); errors.As(err, &throttled) {
+				a.holdSignals(cache[start:], batchSize)
+				break
+			}
+
+			// it would be rejected again
+			if rejected := (*pushRejectedError)(nil); errors.As(err, &rejected) {
+				log.Warningf("dropping signal batch %d/%d rejected by central API (status %d)", batch, nbBatches, rejected.statusCode)
+				continue
+			}
+
+			if a.spool != nil {
+				if err := a.spool.add(cache[start:end]); err != nil {
+					log.Errorf("while spooling signal batch %d/%d: %s", batch, nbBatches, err)
+				}
+			}
+
+			continue
+		}
+
+		sent = true
+	}
+
+	var replayErr error
+
+	// CAPI is reachable again, send what could not be sent before
+	if sent && a.spool != nil {
+		var replayed int
+
+		replayed, replayErr = a.spool.replay(ctx, a.sendBatch)
+		if replayed > 0 {
+			log.Infof("sent %d spooled signal batches to central API", replayed)
 		}
+
+		if replayErr != nil {
+			log.Errorf("while sending spooled signals: %s", replayErr)
+		}
+	}
+
+	if replayErr != nil {
+		a.recordPushResult(append(errs, replayErr))
+	} else {
+		a.recordPushResult(errs)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send %d/%d signal batches: %w", len(errs), nbBatches, errors.Join(errs...))
 	}
+
+	return nil
 }
 
 func (a *apic) CAPIPullIsOld(ctx context.Context) (bool, error) {
-	/*only pull community blocklist if it's older than 1h30 */
+	/*only pull community blocklist if it's older than stale_after */
+	staleAfter := cmp.Or(a.staleAfter, staleAfterDefault)
+
+	// on the primary: a lagging replica would trigger a pull that is not needed
 	alerts := a.dbClient.Ent.Alert.Query()
 
 	alerts = alerts.Where(alert.HasDecisionsWith(decision.OriginEQ(database.CapiMachineID)))
-	alerts = alerts.Where(alert.CreatedAtGTE(time.Now().UTC().Add(-time.Duration(1*time.Hour + 30*time.Minute)))) //nolint:unconvert
+	alerts = alerts.Where(alert.CreatedAtGTE(time.Now().UTC().Add(-staleAfter)))
 
 	count, err := alerts.Count(ctx)
 	if err != nil {
@@ -435,7 +999,7 @@ func (a *apic) CAPIPullIsOld(ctx context.Context) (bool, error) {
 	}
 
 	if count > 0 {
-		log.Info("last CAPI pull is newer than 1h30, skip.")
+		log.Infof("last CAPI pull is newer than %s, skip.", staleAfter)
 		return false, nil
 	}
 
@@ -443,7 +1007,15 @@ func (a *apic) CAPIPullIsOld(ctx context.Context) (bool, error) {
 }
 
 func (a *apic) HandleDeletedDecisionsV3(ctx context.Context, deletedDecisions []*modelscapi.GetDecisionsStreamResponseDeletedItem, deleteCounters map[string]map[string]int) (int, error) {
-	var nbDeleted int
+	var (
+		nbDeleted int
+		deleted   []*ent.Decision
+	)
+
+	// including the decisions expired before an error
+	defer func() {
+		a.notifyDecisionsDeleted(deleted)
+	}()
 
 	for _, decisions := range deletedDecisions {
 		scope := decisions.Scope
@@ -457,11 +1029,13 @@ func (a *apic) HandleDeletedDecisionsV3(ctx context.Context, deletedDecisions []
 				filter["scopes"] = []string{*scope}
 			}
 
-			dbCliDel, _, err := a.dbClient.ExpireDecisionsWithFilter(ctx, filter)
+			dbCliDel, expired, err := a.dbClient.ExpireDecisionsWithFilter(ctx, filter)
 			if err != nil {
 				return 0, fmt.Errorf("expiring decisions error: %w", err)
 			}
 
+			deleted = append(deleted, expired...)
+
 			updateCounterForDecision(deleteCounters, ptr.Of(types.CAPIOrigin), nil, dbCliDel)
 
 			nbDeleted += dbCliDel
@@ -552,6 +1126,20 @@ func createAlertForDecision(decision *models.Decision) *models.Alert {
 	}
 }
 
+// createAlertPerDecision returns one alert for each decision of a list, with the value
+// of the decision as source, instead of a single alert holding all of them.
+func createAlertPerDecision(decisions []*models.Decision, addCounters map[string]map[string]int) []*models.Alert {
+	alerts := make([]*models.Alert, 0, len(decisions))
+
+	for _, decision := range decisions {
+		alert := createAlertForDecision(decision)
+		alert.Source.Value = ptr.Of(*decision.Value)
+		alerts = append(alerts, fillAlertsWithDecisions([]*models.Alert{alert}, []*models.Decision{decision}, addCounters)...)
+	}
+
+	return alerts
+}
+
 // This function takes in list of parent alerts and decisions and then pairs them up.
 func fillAlertsWithDecisions(alerts []*models.Alert, decisions []*models.Decision, addCounters map[string]map[string]int) []*models.Alert {
 	for _, decision := range decisions {
@@ -602,8 +1190,6 @@ func fillAlertsWithDecisions(alerts []*models.Alert, decisions []*models.Decisio
 func (a *apic) PullTop(ctx context.Context, forcePull bool) error {
 	var err error
 
-	hasPulledAllowlists := false
-
 	// A mutex with TryLock would be a bit simpler
 	// But go does not guarantee that TryLock will be able to acquire the lock even if it is available
 	select {
@@ -635,24 +1221,82 @@ func (a *apic) PullTop(ctx context.Context, forcePull bool) error {
 	defer func() {
 		log.Debug("Releasing lock for pullCAPI")
 
-		if err := a.dbClient.ReleasePullCAPILock(ctx); err != nil {
+		// the lock must be released even if the pull timed out, ie. during the warmup
+		if err := a.dbClient.ReleasePullCAPILock(context.WithoutCancel(ctx)); err != nil {
 			log.Errorf("while releasing lock: %v", err)
 		}
 	}()
 
-	log.Infof("Starting community-blocklist update")
+	// id of this pull, stored with the decisions it creates
+	var pullBatchID string
 
-	log.Debugf("Community pull: %t | Blocklist pull: %t", a.pullCommunity, a.pullBlocklists)
+	if a.tagPullBatch {
+		pullBatchID = uuid.NewString()
 
-	data, _, err := a.apiClient.Decisions.GetStreamV3(ctx, apiclient.DecisionsStreamOpts{Startup: a.startup, CommunityPull: a.pullCommunity, AdditionalPull: a.pullBlocklists})
-	if err != nil {
-		return fmt.Errorf("get stream: %w", err)
+		log.Infof("Starting community-blocklist update (pull batch: %s)", pullBatchID)
+	} else {
+		log.Infof("Starting community-blocklist update")
 	}
 
-	a.startup = false
-	/*to count additions/deletions across lists*/
+	log.Debugf("Community pull: %t | Blocklist pull: %t", a.pullCommunity, a.pullBlocklists)
 
-	log.Debugf("Received %d new decisions", len(data.New))
+	streamOpts := apiclient.DecisionsStreamOpts{Startup: a.startup, CommunityPull: a.pullCommunity, AdditionalPull: a.pullBlocklists}
+
+	// without the links of the last stream, a 304 would leave the blocklists and allowlists
+	// without update until the stream changes
+	if !forcePull && a.streamLinks != nil {
+		if streamOpts.IfNoneMatch, err = a.dbClient.GetConfigItem(ctx, decisionsStreamETagConfigItem); err != nil {
+			return fmt.Errorf("while getting decisions stream etag: %w", err)
+		}
+
+		if streamOpts.IfModifiedSince, err = a.dbClient.GetConfigItem(ctx, decisionsStreamLastModifiedConfigItem); err != nil {
+			return fmt.Errorf("while getting decisions stream last modification date: %w", err)
+		}
+	}
+
+	data, resp, err := a.apiClient.Decisions.GetStreamV3(ctx, streamOpts)
+	if err != nil {
+		return fmt.Errorf("get stream: %w", err)
+	}
+
+	a.startup = false
+
+	communityModified := resp == nil || resp.Response == nil || resp.Response.StatusCode != http.StatusNotModified
+
+	if communityModified {
+		a.streamLinks = cmp.Or(data.Links, &modelscapi.GetDecisionsStreamResponseLinks{})
+	} else {
+		// the blocklists and allowlists have their own cache validation
+		log.Info("capi/community-blocklist : decisions stream hasn't been modified, skipping the community decisions")
+
+		data = &modelscapi.GetDecisionsStreamResponse{Links: a.streamLinks}
+	}
+
+	if err := a.applyDecisionsStream(ctx, data, forcePull, communityModified, pullBatchID); err != nil {
+		// don't store the validators, the next pull must return the stream again
+		return err
+	}
+
+	if communityModified && resp != nil && resp.Response != nil {
+		a.saveDecisionsStreamCacheHeaders(ctx, resp.Response.Header)
+	}
+
+	// a stream that hasn't been modified is a successful pull too
+	metrics.LapiLastPullTimestamp.SetToCurrentTime()
+
+	return nil
+}
+
+// applyDecisionsStream processes the content of a decisions stream: deletions, community blocklist
+// decisions, and the allowlists and blocklists it links to. If communityModified is false, only the
+// allowlists and blocklists are updated. The new decisions are tagged with pullBatchID, if not empty.
+// An error is returned if the community decisions could not be stored, the other errors are logged.
+func (a *apic) applyDecisionsStream(ctx context.Context, data *modelscapi.GetDecisionsStreamResponse, forcePull bool, communityModified bool, pullBatchID string) error {
+	var communityErr error
+
+	hasPulledAllowlists := false
+
+	log.Debugf("Received %d new decisions", len(data.New))
 	log.Debugf("Received %d deleted decisions", len(data.Deleted))
 
 	if data.Links != nil {
@@ -666,36 +1310,66 @@ func (a *apic) PullTop(ctx context.Context, forcePull bool) error {
 	nbDeleted, err := a.HandleDeletedDecisionsV3(ctx, data.Deleted, deleteCounters)
 	if err != nil {
 		log.Errorf("could not delete decisions from CAPI: %s", err)
+		communityErr = fmt.Errorf("deleting community decisions: %w", err)
 	}
 
 	log.Printf("capi/community-blocklist : %d explicit deletions", nbDeleted)
 
 	// Update allowlists before processing decisions
 	if data.Links != nil {
-		if len(data.Links.Allowlists) > 0 {
+		allowlists := data.Links.Allowlists
+
+		if a.allowlistsInterval > 0 {
+			// the known ones are refreshed by PullAllowlists
+			allowlists = a.trackAllowlists(allowlists)
+		}
+
+		if len(allowlists) > 0 {
 			hasPulledAllowlists = true
 
-			if err := a.UpdateAllowlists(ctx, data.Links.Allowlists, forcePull); err != nil {
+			if err := a.UpdateAllowlists(ctx, allowlists, forcePull); err != nil {
 				log.Errorf("could not update allowlists from CAPI: %s", err)
 			}
 		}
 	}
 
+	// CAPI is not supposed to send community decisions when the pull is disabled, but don't trust it
+	if len(data.New) > 0 && !a.pullCommunity {
+		log.Debugf("capi/community-blocklist : ignoring %d new entries, community blocklist pull is disabled", len(data.New))
+		data.New = nil
+	}
+
+	var decisions []*models.Decision
+
 	if len(data.New) > 0 {
-		// create one alert for community blocklist using the first decision
-		decisions := a.apiClient.Decisions.GetDecisionsFromGroups(data.New)
+		decisions = a.apiClient.Decisions.GetDecisionsFromGroups(data.New)
 		// apply APIC specific whitelists
 		decisions = a.ApplyApicWhitelists(ctx, decisions)
+		decisions = a.filterLocalScenarios(ctx, decisions)
+	}
+
+	if len(decisions) > 0 {
+		// create one alert for community blocklist using the first decision
+		a.fixDecisionDurations(decisions)
+		a.applyMinDecisionDuration(decisions)
+		a.normalizeDecisionTypes(decisions)
+		tagDecisionsWithPullBatch(decisions, pullBatchID)
+		remapped := a.remapScenarios(decisions)
 
 		alert := createAlertForDecision(decisions[0])
+		if len(remapped) > 0 {
+			alert.Message = ptr.Of("remapped scenarios: " + strings.Join(remapped, ", "))
+		}
+
 		alertsFromCapi := []*models.Alert{alert}
 		alertsFromCapi = fillAlertsWithDecisions(alertsFromCapi, decisions, addCounters)
 
 		err = a.SaveAlerts(ctx, alertsFromCapi, addCounters, deleteCounters)
 		if err != nil {
 			log.Errorf("could not save alert for CAPI pull: %s", err)
+			communityErr = errors.Join(communityErr, fmt.Errorf("saving community decisions: %w", err))
 		}
-	} else {
+	} else if communityModified {
 		if a.pullCommunity {
 			log.Info("capi/community-blocklist : received 0 new entries (expected if you just installed crowdsec)")
 		} else {
@@ -706,7 +1380,7 @@ func (a *apic) PullTop(ctx context.Context, forcePull bool) error {
 	// update allowlists/blocklists
 	if data.Links != nil {
 		if len(data.Links.Blocklists) > 0 {
-			if err := a.UpdateBlocklists(ctx, data.Links.Blocklists, addCounters, forcePull); err != nil {
+			if err := a.UpdateBlocklists(ctx, data.Links.Blocklists, addCounters, forcePull, pullBatchID); err != nil {
 				log.Errorf("could not update blocklists from CAPI: %s", err)
 			}
 		}
@@ -723,19 +1397,167 @@ func (a *apic) PullTop(ctx context.Context, forcePull bool) error {
 		}
 	}
 
+	if _, err := a.enforceMaxDecisions(ctx); err != nil {
+		log.Errorf("could not enforce max_decisions: %s", err)
+	}
+
+	return communityErr
+}
+
+// enforceMaxDecisions expires the pulled decisions (community blocklist and lists) that expire first,
+// so that no more than maxDecisions of them are active. Other decisions are not counted.
+// It returns the number of evicted decisions.
+func (a *apic) enforceMaxDecisions(ctx context.Context) (int, error) {
+	if a.maxDecisions <= 0 {
+		return 0, nil
+	}
+
+	pulled := []predicate.Decision{
+		decision.OriginIn(types.CAPIOrigin, types.ListOrigin),
+		decision.UntilGT(time.Now().UTC()),
+	}
+
+	// not on the replica: the decisions have just been written, and are evicted from the primary
+	count, err := a.dbClient.Ent.Decision.Query().Where(pulled...).Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("while counting pulled decisions: %w", err)
+	}
+
+	if count <= a.maxDecisions {
+		return 0, nil
+	}
+
+	toEvict, err := a.dbClient.Ent.Decision.Query().
+		Where(pulled...).
+		Order(ent.Asc(decision.FieldUntil), ent.Asc(decision.FieldID)).
+		Limit(count - a.maxDecisions).
+		All(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("while getting decisions to evict: %w", err)
+	}
+
+	evicted, err := a.dbClient.ExpireDecisions(ctx, toEvict)
+	if err != nil {
+		return 0, fmt.Errorf("while evicting decisions: %w", err)
+	}
+
+	log.Infof("capi : %d pulled decisions exceed max_decisions (%d), evicted %d decisions expiring first", count, a.maxDecisions, evicted)
+
+	return evicted, nil
+}
+
+// ApplyStreamFromFile reads a decisions stream saved as JSON, as returned by CAPI, and applies it
+// as if it had just been pulled.
+func (a *apic) ApplyStreamFromFile(ctx context.Context, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("while reading decisions stream: %w", err)
+	}
+
+	data := modelscapi.GetDecisionsStreamResponse{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("while parsing decisions stream %s: %w", path, err)
+	}
+
+	select {
+	case a.isPulling <- true:
+		defer func() {
+			<-a.isPulling
+		}()
+	default:
+		return errors.New("pull already in progress")
+	}
+
+	log.Infof("Applying decisions stream from %s", path)
+
+	return a.applyDecisionsStream(ctx, &data, false, true, "")
+}
+
+const (
+	ExportFormatPlaintext = "plaintext"
+	ExportFormatJSON      = "json"
+)
+
+// ExportDecisions writes the values of the active decisions as a blocklist, ordered by scope.
+// The simulated decisions are not exported. The plaintext format has one IP or range per line,
+// the decisions with another scope are left out. The json format is an object mapping each scope
+// to its list of values.
+func (a *apic) ExportDecisions(ctx context.Context, w io.Writer, format string) error {
+	if format != ExportFormatPlaintext && format != ExportFormatJSON {
+		return fmt.Errorf("unknown export format %q (expected %s or %s)", format, ExportFormatPlaintext, ExportFormatJSON)
+	}
+
+	decisions, err := a.dbClient.Reader().Decision.Query().
+		Where(
+			decision.UntilGT(time.Now().UTC()),
+			decision.SimulatedEQ(false),
+		).
+		Order(ent.Asc(decision.FieldScope), ent.Asc(decision.FieldValue)).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("while getting decisions to export: %w", err)
+	}
+
+	byScope := make(map[string][]string)
+
+	var prevScope, prevValue string
+
+	for i, d := range decisions {
+		// several decisions can apply to the same value
+		if i > 0 && d.Scope == prevScope && d.Value == prevValue {
+			continue
+		}
+
+		prevScope, prevValue = d.Scope, d.Value
+
+		if format == ExportFormatJSON {
+			byScope[d.Scope] = append(byScope[d.Scope], d.Value)
+			continue
+		}
+
+		// a country or an AS can't be told from an IP in a flat list
+		if d.Scope != types.Ip && d.Scope != types.Range {
+			continue
+		}
+
+		if _, err := fmt.Fprintln(w, d.Value); err != nil {
+			return fmt.Errorf("while exporting decisions: %w", err)
+		}
+	}
+
+	if format == ExportFormatJSON {
+		if err := json.NewEncoder(w).Encode(byScope); err != nil {
+			return fmt.Errorf("while exporting decisions: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// saveDecisionsStreamCacheHeaders stores the validators returned with the decisions stream,
+// so that the next pull can be conditional.
+func (a *apic) saveDecisionsStreamCacheHeaders(ctx context.Context, header http.Header) {
+	if err := a.dbClient.SetConfigItem(ctx, decisionsStreamETagConfigItem, header.Get("ETag")); err != nil {
+		log.Errorf("while setting decisions stream etag: %s", err)
+	}
+
+	if err := a.dbClient.SetConfigItem(ctx, decisionsStreamLastModifiedConfigItem, header.Get("Last-Modified")); err != nil {
+		log.Errorf("while setting decisions stream last modification date: %s", err)
+	}
+}
+
 // we receive a link to a blocklist, we pull the content of the blocklist and we create one alert
 func (a *apic) PullBlocklist(ctx context.Context, blocklist *modelscapi.BlocklistLink, forcePull bool) error {
 	addCounters, _ := makeAddAndDeleteCounters()
-	if err := a.UpdateBlocklists(ctx, []*modelscapi.BlocklistLink{blocklist}, addCounters, forcePull); err != nil {
+	if err := a.UpdateBlocklists(ctx, []*modelscapi.BlocklistLink{blocklist}, addCounters, forcePull, ""); err != nil {
 		return fmt.Errorf("while pulling blocklist: %w", err)
 	}
 
 	return nil
 }
 
+// PullAllowlist refreshes a single allowlist. Unless forcePull is set, the content is only
+// downloaded if it has been modified since the last pull.
 func (a *apic) PullAllowlist(ctx context.Context, allowlist *modelscapi.AllowlistLink, forcePull bool) error {
 	if err := a.UpdateAllowlists(ctx, []*modelscapi.AllowlistLink{allowlist}, forcePull); err != nil {
 		return fmt.Errorf("while pulling allowlist: %w", err)
@@ -744,6 +1566,56 @@ func (a *apic) PullAllowlist(ctx context.Context, allowlist *modelscapi.Allowlis
 	return nil
 }
 
+// trackAllowlists records the allowlists advertised by the decisions stream, to be refreshed with
+// allowlistsInterval, and returns the ones that were not known yet.
+func (a *apic) trackAllowlists(links []*modelscapi.AllowlistLink) []*modelscapi.AllowlistLink {
+	a.allowlistsMu.Lock()
+	defer a.allowlistsMu.Unlock()
+
+	added := []*modelscapi.AllowlistLink{}
+
+	for _, link := range links {
+		known := slices.ContainsFunc(a.allowlistLinks, func(l *modelscapi.AllowlistLink) bool {
+			return ptr.OrEmpty(l.Name) == ptr.OrEmpty(link.Name)
+		})
+		if !known {
+			added = append(added, link)
+		}
+	}
+
+	// the allowlists that are no longer advertised are not refreshed anymore
+	a.allowlistLinks = links
+
+	return added
+}
+
+// refreshAllowlists pulls the subscribed allowlists if they have been modified, and applies them
+// to the existing decisions.
+func (a *apic) refreshAllowlists(ctx context.Context) {
+	a.allowlistsMu.Lock()
+	links := a.allowlistLinks
+	a.allowlistsMu.Unlock()
+
+	if len(links) == 0 {
+		return
+	}
+
+	for _, link := range links {
+		if err := a.PullAllowlist(ctx, link, false); err != nil {
+			log.Errorf("capi pull allowlist: %s", err)
+		}
+	}
+
+	deleted, err := a.dbClient.ApplyAllowlistsToExistingDecisions(ctx)
+	if err != nil {
+		log.Errorf("could not apply allowlists to existing decisions: %s", err)
+	}
+
+	if deleted > 0 {
+		log.Infof("deleted %d decisions from allowlists", deleted)
+	}
+}
+
 func (a *apic) UpdateAllowlists(ctx context.Context, allowlistsLinks []*modelscapi.AllowlistLink, forcePull bool) error {
 	if len(allowlistsLinks) == 0 {
 		return nil
@@ -779,12 +1651,35 @@ func (a *apic) UpdateAllowlists(ctx context.Context, allowlistsLinks []*modelsca
 			description = *link.Description
 		}
 
+		list, err := a.dbClient.GetAllowListByID(ctx, *link.ID, false)
+		if err != nil {
+			if !ent.IsNotFound(err) {
+				log.Errorf("while getting allowlist %s: %s", *link.Name, err)
+				continue
+			}
+		}
+
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, *link.URL, http.NoBody)
 		if err != nil {
 			log.Errorf("while pulling allowlist: %s", err)
 			continue
 		}
 
+		allowlistConfigItemName := fmt.Sprintf("allowlist:%s:last_pull", *link.Name)
+
+		// an allowlist that is not in the database yet must be fetched in full
+		if !forcePull && list != nil {
+			lastPullTimestamp, err := a.dbClient.GetConfigItem(ctx, allowlistConfigItemName)
+			if err != nil {
+				log.Errorf("while getting last pull timestamp for allowlist %s: %s", *link.Name, err)
+				continue
+			}
+
+			if lastPullTimestamp != "" {
+				req.Header.Set("If-Modified-Since", lastPullTimestamp)
+			}
+		}
+
 		resp, err := defaultClient.GetClient().Do(req)
 		if err != nil {
 			log.Errorf("while pulling allowlist: %s", err)
@@ -792,6 +1687,16 @@ func (a *apic) UpdateAllowlists(ctx context.Context, allowlistsLinks []*modelsca
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode == http.StatusNotModified {
+			log.Infof("allowlist %s hasn't been modified since %s, skipping", *link.Name, req.Header.Get("If-Modified-Since"))
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			log.Errorf("while pulling allowlist %s: unexpected status code %d", *link.Name, resp.StatusCode)
+			continue
+		}
+
 		scanner := bufio.NewScanner(resp.Body)
 		items := make([]*models.AllowlistItem, 0)
 
@@ -807,14 +1712,6 @@ func (a *apic) UpdateAllowlists(ctx context.Context, allowlistsLinks []*modelsca
 			items = append(items, j)
 		}
 
-		list, err := a.dbClient.GetAllowListByID(ctx, *link.ID, false)
-		if err != nil {
-			if !ent.IsNotFound(err) {
-				log.Errorf("while getting allowlist %s: %s", *link.Name, err)
-				continue
-			}
-		}
-
 		if list == nil {
 			list, err = a.dbClient.CreateAllowList(ctx, *link.Name, description, *link.ID, true)
 			if err != nil {
@@ -839,52 +1736,120 @@ func (a *apic) UpdateAllowlists(ctx context.Context, allowlistsLinks []*modelsca
 			}
 		}
 
+		if err := a.dbClient.SetConfigItem(ctx, allowlistConfigItemName, time.Now().UTC().Format(http.TimeFormat)); err != nil {
+			log.Errorf("while setting last pull timestamp for allowlist %s: %s", *link.Name, err)
+		}
+
 		log.Infof("Allowlist %s updated", *link.Name)
 	}
 
 	return nil
 }
 
-// if decisions is whitelisted: return representation of the whitelist ip or cidr
-// if not whitelisted: empty string
-func (a *apic) whitelistedBy(decision *models.Decision, additionalIPs []netip.Addr, additionalRanges []netip.Prefix) string {
-	if decision.Value == nil {
-		return ""
+// isIPScope tells if the value of a decision is an IP or a range. Other scopes (username, session...)
+// can have values that look like IPs, they must not be parsed as such.
+func isIPScope(scope *string) bool {
+	return scope != nil && (strings.EqualFold(*scope, types.Ip) || strings.EqualFold(*scope, types.Range))
+}
+
+// whitelistedBy returns the allowlist or whitelist entry matching the decision, if any.
+// Centralized allowlists are checked first, fromAllowlist tells which one matched.
+func whitelistedBy(decision *models.Decision, whitelists *csconfig.CapiWhitelist, allowlistedIPs []netip.Addr, allowlistedRanges []netip.Prefix) (whitelister string, fromAllowlist bool) {
+	if decision.Value == nil || !isIPScope(decision.Scope) {
+		return "", false
 	}
 
 	ipval, err := netip.ParseAddr(*decision.Value)
 	if err != nil {
-		return ""
+		return "", false
+	}
+
+	for _, ip := range allowlistedIPs {
+		if ip == ipval {
+			return ip.String(), true
+		}
 	}
 
-	for _, cidr := range a.whitelists.Cidrs {
+	for _, cidr := range allowlistedRanges {
 		if cidr.Contains(ipval) {
-			return cidr.String()
+			return cidr.String(), true
 		}
 	}
 
-	for _, ip := range a.whitelists.Ips {
-		if ip == ipval {
-			return ip.String()
+	if whitelists == nil {
+		return "", false
+	}
+
+	for _, cidr := range whitelists.Cidrs {
+		if cidr.Contains(ipval) {
+			return cidr.String(), false
 		}
 	}
 
-	for _, ip := range additionalIPs {
+	for _, ip := range whitelists.Ips {
 		if ip == ipval {
-			return ip.String()
+			return ip.String(), false
 		}
 	}
 
-	for _, cidr := range additionalRanges {
-		if cidr.Contains(ipval) {
-			return cidr.String()
-		}
+	return "", false
+}
+
+var errASNDataUnavailable = errors.New("the GeoIP ASN database is not loaded")
+
+// geoIPASN returns the autonomous system number of an IP from the GeoIP ASN database.
+func geoIPASN(ip netip.Addr) (uint, error) {
+	ret, err := exprhelpers.GeoIPASNEnrich(ip.String())
+	if err != nil {
+		return 0, err
+	}
+
+	asn, ok := ret.(*geoip2.ASN)
+	if !ok || asn == nil {
+		return 0, errASNDataUnavailable
+	}
+
+	return asn.AutonomousSystemNumber, nil
+}
+
+// whitelistedByAS returns the AS of the decision's IP if it's in the whitelist_as list, or an empty string.
+func (a *apic) whitelistedByAS(decision *models.Decision, whitelists *csconfig.CapiWhitelist) (string, error) {
+	if decision.Value == nil || !isIPScope(decision.Scope) {
+		return "", nil
+	}
+
+	ip, err := netip.ParseAddr(*decision.Value)
+	if err != nil {
+		// ranges are not checked
+		return "", nil
+	}
+
+	resolver := a.asnResolver
+	if resolver == nil {
+		resolver = geoIPASN
 	}
 
-	return ""
+	asn, err := resolver(ip)
+	if err != nil {
+		return "", err
+	}
+
+	if slices.Contains(whitelists.AS, asn) {
+		return fmt.Sprintf("AS%d", asn), nil
+	}
+
+	return "", nil
 }
 
+// ApplyApicWhitelists drops the pulled decisions (community blocklist or third party lists) that match
+// an allowlist or a capi whitelist. It runs before the decisions are stored, so an allowlist entry always
+// takes precedence over a ban coming from CAPI, whatever its origin. Only the IP scopes are checked.
 func (a *apic) ApplyApicWhitelists(ctx context.Context, decisions []*models.Decision) []*models.Decision {
+	return a.applyWhitelists(ctx, decisions, true)
+}
+
+// applyWhitelists is ApplyApicWhitelists, without updating the metrics if countAllowlisted is false.
+func (a *apic) applyWhitelists(ctx context.Context, decisions []*models.Decision, countAllowlisted bool) []*models.Decision {
 	allowlisted_ips, allowlisted_cidrs, err := a.dbClient.GetAllowlistsContentForAPIC(ctx)
 	if err != nil {
 		log.Errorf("while getting allowlists content: %s", err)
@@ -894,16 +1859,37 @@ func (a *apic) ApplyApicWhitelists(ctx context.Context, decisions []*models.Deci
 		log.Warn("capi_whitelists_path is deprecated, please use centralized allowlists instead. See https://docs.crowdsec.net/docs/next/local_api/centralized_allowlists.")
 	}
 
-	if (a.whitelists == nil || len(a.whitelists.Cidrs) == 0 && len(a.whitelists.Ips) == 0) && len(allowlisted_ips) == 0 && len(allowlisted_cidrs) == 0 {
+	checkAS := a.whitelists != nil && len(a.whitelists.AS) > 0
+
+	if (a.whitelists == nil || len(a.whitelists.Cidrs) == 0 && len(a.whitelists.Ips) == 0) && !checkAS && len(allowlisted_ips) == 0 && len(allowlisted_cidrs) == 0 {
 		return decisions
 	}
 	// deal with CAPI whitelists for fire. We want to avoid having a second list, so we shrink in place
 	outIdx := 0
 
 	for _, decision := range decisions {
-		whitelister := a.whitelistedBy(decision, allowlisted_ips, allowlisted_cidrs)
+		whitelister, fromAllowlist := whitelistedBy(decision, a.whitelists, allowlisted_ips, allowlisted_cidrs)
+
+		if whitelister == "" && checkAS {
+			var err error
+
+			whitelister, err = a.whitelistedByAS(decision, a.whitelists)
+			if errors.Is(err, errASNDataUnavailable) {
+				log.Warningf("whitelist_as is ignored: %s", err)
+
+				checkAS = false
+			} else if err != nil {
+				log.Debugf("while looking up the AS of %s: %s", *decision.Value, err)
+			}
+		}
+
 		if whitelister != "" {
 			log.Infof("%s from %s is whitelisted by %s", *decision.Value, *decision.Scenario, whitelister)
+
+			if fromAllowlist && countAllowlisted {
+				metrics.LapiPulledDecisionsAllowlisted.With(prometheus.Labels{"origin": *decision.Origin}).Inc()
+			}
+
 			continue
 		}
 
@@ -914,64 +1900,254 @@ func (a *apic) ApplyApicWhitelists(ctx context.Context, decisions []*models.Deci
 	return decisions[:outIdx]
 }
 
-func (a *apic) SaveAlerts(ctx context.Context, alertsFromCapi []*models.Alert, addCounters map[string]map[string]int, deleteCounters map[string]map[string]int) error {
-	for _, alert := range alertsFromCapi {
-		setAlertScenario(alert, addCounters, deleteCounters)
-		log.Debugf("%s has %d decisions", *alert.Source.Scope, len(alert.Decisions))
+// PreviewWhitelist returns the active pulled decisions (community blocklist and lists) that the whitelist
+// would drop, with the same matching as PullTop, to check a configuration before applying it.
+// The centralized allowlists are not taken into account, nothing is changed in the database.
+func (a *apic) PreviewWhitelist(ctx context.Context, wl *csconfig.CapiWhitelist) ([]*ent.Decision, error) {
+	ret := []*ent.Decision{}
 
-		if a.dbClient.Type == "sqlite" && (a.dbClient.WalMode == nil || !*a.dbClient.WalMode) {
-			log.Warningf("sqlite is not using WAL mode, LAPI might become unresponsive when inserting the community blocklist")
-		}
+	if wl == nil {
+		return ret, nil
+	}
 
-		alertID, inserted, deleted, err := a.dbClient.UpdateCommunityBlocklist(ctx, alert)
-		if err != nil {
-			return fmt.Errorf("while saving alert from %s: %w", *alert.Source.Scope, err)
+	decisions, err := a.dbClient.Ent.Decision.Query().
+		Where(
+			decision.OriginIn(types.CAPIOrigin, types.ListOrigin),
+			decision.UntilGT(time.Now().UTC()),
+		).
+		Order(ent.Asc(decision.FieldID)).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while getting pulled decisions: %w", err)
+	}
+
+	checkAS := len(wl.AS) > 0
+
+	for _, d := range decisions {
+		candidate := &models.Decision{Scope: &d.Scope, Value: &d.Value}
+
+		whitelister, _ := whitelistedBy(candidate, wl, nil, nil)
+
+		if whitelister == "" && checkAS {
+			whitelister, err = a.whitelistedByAS(candidate, wl)
+			if errors.Is(err, errASNDataUnavailable) {
+				return nil, fmt.Errorf("can't check whitelist_as: %w", err)
+			} else if err != nil {
+				log.Debugf("while looking up the AS of %s: %s", d.Value, err)
+			}
 		}
 
-		log.Printf("%s : added %d entries, deleted %d entries (alert:%d)", *alert.Source.Scope, inserted, deleted, alertID)
+		if whitelister != "" {
+			ret = append(ret, d)
+		}
 	}
 
-	return nil
+	return ret, nil
 }
 
-func (a *apic) ShouldForcePullBlocklist(ctx context.Context, blocklist *modelscapi.BlocklistLink) (bool, error) {
-	// we should force pull if the blocklist decisions are about to expire or there's no decision in the db
-	alertQuery := a.dbClient.Ent.Alert.Query()
-	alertQuery.Where(alert.SourceScopeEQ(fmt.Sprintf("%s:%s", types.ListOrigin, *blocklist.Name)))
-	alertQuery.Order(ent.Desc(alert.FieldCreatedAt))
+// filterLocalScenarios drops the community decisions whose scenario is not run by any machine,
+// if local_scenarios_only is set. The decisions are kept if the scenarios can't be listed.
+func (a *apic) filterLocalScenarios(ctx context.Context, decisions []*models.Decision) []*models.Decision {
+	if !a.localScenariosOnly {
+		return decisions
+	}
 
-	alertInstance, err := alertQuery.First(ctx)
+	scenarios, err := a.FetchScenariosListFromDB(ctx)
 	if err != nil {
-		if ent.IsNotFound(err) {
-			log.Debugf("no alert found for %s, force refresh", *blocklist.Name)
-			return true, nil
-		}
-
-		return false, fmt.Errorf("while getting alert: %w", err)
+		log.Errorf("capi/community-blocklist : not filtering the decisions by local scenario: %s", err)
+		return decisions
 	}
 
-	decisionQuery := a.dbClient.Ent.Decision.Query()
-	decisionQuery.Where(decision.HasOwnerWith(alert.IDEQ(alertInstance.ID)))
+	ret := make([]*models.Decision, 0, len(decisions))
 
-	firstDecision, err := decisionQuery.First(ctx)
-	if err != nil {
-		if ent.IsNotFound(err) {
-			log.Debugf("no decision found for %s, force refresh", *blocklist.Name)
-			return true, nil
+	for _, decision := range decisions {
+		if decision.Scenario != nil && slices.Contains(scenarios, *decision.Scenario) {
+			ret = append(ret, decision)
 		}
+	}
 
-		return false, fmt.Errorf("while getting decision: %w", err)
+	if dropped := len(decisions) - len(ret); dropped > 0 {
+		log.Infof("capi/community-blocklist : dropped %d decisions of scenarios not run locally", dropped)
 	}
 
-	if firstDecision == nil || firstDecision.Until == nil || firstDecision.Until.Sub(time.Now().UTC()) < (a.pullInterval+15*time.Minute) {
-		log.Debugf("at least one decision found for %s, expire soon, force refresh", *blocklist.Name)
+	return ret
+}
+
+// remapScenarios renames the scenarios of the decisions according to the scenario_remap configuration.
+// It returns the list of applied renames, so that the original names can be kept in the alert.
+func (a *apic) remapScenarios(decisions []*models.Decision) []string {
+	if len(a.scenarioRemap) == 0 {
+		return nil
+	}
+
+	remapped := []string{}
+
+	for _, decision := range decisions {
+		if decision.Scenario == nil {
+			continue
+		}
+
+		newScenario, ok := a.scenarioRemap[*decision.Scenario]
+		if !ok {
+			continue
+		}
+
+		rename := fmt.Sprintf("%s (was %s)", newScenario, *decision.Scenario)
+		if !slices.Contains(remapped, rename) {
+			remapped = append(remapped, rename)
+		}
+
+		// the pointer can be shared between decisions of the same group
+		decision.Scenario = ptr.Of(newScenario)
+	}
+
+	slices.Sort(remapped)
+
+	return remapped
+}
+
+// normalizeDecisionTypes lowercases the type of the decisions, so that "Ban" or "BAN" are stored as "ban",
+// and replaces it if it's one of the aliases from the decision_type_aliases configuration.
+func (a *apic) normalizeDecisionTypes(decisions []*models.Decision) {
+	for _, decision := range decisions {
+		if decision.Type == nil {
+			continue
+		}
+
+		decisionType := strings.ToLower(strings.TrimSpace(*decision.Type))
+		if alias, ok := a.decisionTypeAliases[decisionType]; ok {
+			decisionType = alias
+		}
+
+		if decisionType != *decision.Type {
+			// the pointer can be shared by all the decisions of a blocklist
+			decision.Type = ptr.Of(decisionType)
+		}
+	}
+}
+
+// tagDecisionsWithPullBatch stores the id of a pull in the metadata of its decisions, see
+// database.DeleteDecisionsByPullBatch. Tagged decisions are not reused by the next pull like the
+// others: each pull inserts its own, so that reverting a pull can't remove the decisions of another.
+func tagDecisionsWithPullBatch(decisions []*models.Decision, pullBatchID string) {
+	if pullBatchID == "" {
+		return
+	}
+
+	for _, decision := range decisions {
+		// the map can be shared by several decisions
+		metadata := make(map[string]string, len(decision.Metadata)+1)
+		maps.Copy(metadata, decision.Metadata)
+		metadata[database.PullBatchMetadataKey] = pullBatchID
+		decision.Metadata = metadata
+	}
+}
+
+// fixDecisionDurations replaces the missing or invalid durations with defaultDuration, so that a
+// single malformed decision doesn't prevent the others from being saved.
+func (a *apic) fixDecisionDurations(decisions []*models.Decision) {
+	for _, decision := range decisions {
+		if decision.Duration == nil {
+			log.Warningf("decision %s has no duration, using %s", ptr.OrEmpty(decision.Value), a.defaultDuration)
+			decision.Duration = ptr.Of(a.defaultDuration.String())
+
+			continue
+		}
+
+		if _, err := time.ParseDuration(*decision.Duration); err != nil {
+			log.Warningf("decision %s has an invalid duration %q, using %s", ptr.OrEmpty(decision.Value), *decision.Duration, a.defaultDuration)
+			// don't modify the value in place, the pointer can be shared by several decisions
+			decision.Duration = ptr.Of(a.defaultDuration.String())
+		}
+	}
+}
+
+// applyMinDecisionDuration raises the duration of the decisions that are shorter than minDecisionDuration,
+// to avoid churn with lists that publish very short-lived decisions.
+func (a *apic) applyMinDecisionDuration(decisions []*models.Decision) {
+	if a.minDecisionDuration <= 0 {
+		return
+	}
+
+	for _, decision := range decisions {
+		if decision.Duration == nil {
+			continue
+		}
+
+		// invalid durations have been replaced by fixDecisionDurations, or are reported when the decisions are saved
+		duration, err := time.ParseDuration(*decision.Duration)
+		if err != nil {
+			continue
+		}
+
+		if duration < a.minDecisionDuration {
+			// don't modify the value in place, the pointer can be shared by all the decisions of a blocklist
+			decision.Duration = ptr.Of(a.minDecisionDuration.String())
+		}
+	}
+}
+
+func (a *apic) SaveAlerts(ctx context.Context, alertsFromCapi []*models.Alert, addCounters map[string]map[string]int, deleteCounters map[string]map[string]int) error {
+	for _, alert := range alertsFromCapi {
+		setAlertScenario(alert, addCounters, deleteCounters, a.sourceScopePrefix)
+		log.Debugf("%s has %d decisions", *alert.Source.Scope, len(alert.Decisions))
+
+		if a.dbClient.Type == "sqlite" && (a.dbClient.WalMode == nil || !*a.dbClient.WalMode) {
+			log.Warningf("sqlite is not using WAL mode, LAPI might become unresponsive when inserting the community blocklist")
+		}
+
+		alertID, inserted, deleted, err := a.dbClient.UpdateCommunityBlocklist(ctx, alert)
+		if err != nil {
+			return fmt.Errorf("while saving alert from %s: %w", *alert.Source.Scope, err)
+		}
+
+		log.Printf("%s : added %d entries, deleted %d entries (alert:%d)", *alert.Source.Scope, inserted, deleted, alertID)
+
+		a.notifyDecisionsAdded(alert.Decisions)
+	}
+
+	return nil
+}
+
+func (a *apic) ShouldForcePullBlocklist(ctx context.Context, blocklist *modelscapi.BlocklistLink) (bool, error) {
+	// we should force pull if the blocklist decisions are about to expire or there's no decision in the db.
+	// This is checked on the primary, a lagging replica would force a pull that is not needed
+	alertQuery := a.dbClient.Ent.Alert.Query()
+	alertQuery.Where(alert.SourceScopeEQ(fmt.Sprintf("%s%s:%s", a.sourceScopePrefix, types.ListOrigin, *blocklist.Name)))
+	alertQuery.Order(ent.Desc(alert.FieldCreatedAt))
+
+	alertInstance, err := alertQuery.First(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			log.Debugf("no alert found for %s, force refresh", *blocklist.Name)
+			return true, nil
+		}
+
+		return false, fmt.Errorf("while getting alert: %w", err)
+	}
+
+	decisionQuery := a.dbClient.Ent.Decision.Query()
+	decisionQuery.Where(decision.HasOwnerWith(alert.IDEQ(alertInstance.ID)))
+
+	firstDecision, err := decisionQuery.First(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			log.Debugf("no decision found for %s, force refresh", *blocklist.Name)
+			return true, nil
+		}
+
+		return false, fmt.Errorf("while getting decision: %w", err)
+	}
+
+	if firstDecision == nil || firstDecision.Until == nil || firstDecision.Until.Sub(time.Now().UTC()) < (a.pullInterval+15*time.Minute) {
+		log.Debugf("at least one decision found for %s, expire soon, force refresh", *blocklist.Name)
 		return true, nil
 	}
 
 	return false, nil
 }
 
-func (a *apic) updateBlocklist(ctx context.Context, client *apiclient.ApiClient, blocklist *modelscapi.BlocklistLink, addCounters map[string]map[string]int, forcePull bool) error {
+func (a *apic) updateBlocklist(ctx context.Context, client *apiclient.ApiClient, blocklist *modelscapi.BlocklistLink, addCounters map[string]map[string]int, forcePull bool, pullBatchID string) error {
 	if blocklist.Scope == nil {
 		log.Warningf("blocklist has no scope")
 		return nil
@@ -982,6 +2158,33 @@ func (a *apic) updateBlocklist(ctx context.Context, client *apiclient.ApiClient,
 		return nil
 	}
 
+	// the state of a blocklist is kept by name: when CAPI rotates its URL, the new one is pulled
+	// in full, without the conditions of the previous pulls, and the decisions are carried over
+	if !forcePull {
+		previousURL, err := a.dbClient.GetConfigItem(ctx, fmt.Sprintf("blocklist:%s:url", *blocklist.Name))
+		if err != nil {
+			return fmt.Errorf("while getting url for blocklist %s: %w", *blocklist.Name, err)
+		}
+
+		if previousURL != "" && blocklist.URL != nil && previousURL != *blocklist.URL {
+			log.Infof("blocklist %s moved from %s to %s, force refresh", *blocklist.Name, previousURL, *blocklist.URL)
+
+			forcePull = true
+		}
+	}
+
+	if a.blocklistBackoff > 0 && !forcePull {
+		backoff, err := a.getBlocklistBackoff(ctx, blocklist)
+		if err != nil {
+			return fmt.Errorf("while getting backoff state for blocklist %s: %w", *blocklist.Name, err)
+		}
+
+		if time.Now().UTC().Before(backoff.RetryAfter) {
+			log.Infof("blocklist %s failed %d times in a row, skipping until %s", *blocklist.Name, backoff.Failures, backoff.RetryAfter.Format(time.RFC3339))
+			return nil
+		}
+	}
+
 	if !forcePull {
 		_forcePull, err := a.ShouldForcePullBlocklist(ctx, blocklist)
 		if err != nil {
@@ -1007,9 +2210,28 @@ func (a *apic) updateBlocklist(ctx context.Context, client *apiclient.ApiClient,
 
 	decisions, hasChanged, err := client.Decisions.GetDecisionsFromBlocklist(ctx, blocklist, lastPullTimestamp)
 	if err != nil {
+		if a.blocklistBackoff > 0 && ctx.Err() == nil {
+			if err := a.recordBlocklistFailure(ctx, blocklist); err != nil {
+				log.Errorf("while recording failure of blocklist %s: %s", *blocklist.Name, err)
+			}
+		}
+
+		// an error status doesn't prevent the other blocklists from being pulled
+		var statusErr *apiclient.BlocklistStatusError
+		if errors.As(err, &statusErr) {
+			log.Warningf("while getting decisions from blocklist %s: %s, skipping", *blocklist.Name, err)
+			return nil
+		}
+
 		return fmt.Errorf("while getting decisions from blocklist %s: %w", *blocklist.Name, err)
 	}
 
+	if a.blocklistBackoff > 0 {
+		if err := a.resetBlocklistBackoff(ctx, blocklist); err != nil {
+			log.Errorf("while resetting backoff state of blocklist %s: %s", *blocklist.Name, err)
+		}
+	}
+
 	if !hasChanged {
 		if lastPullTimestamp == "" {
 			log.Infof("blocklist %s hasn't been modified or there was an error reading it, skipping", *blocklist.Name)
@@ -1020,30 +2242,394 @@ func (a *apic) updateBlocklist(ctx context.Context, client *apiclient.ApiClient,
 		return nil
 	}
 
+	decisions, invalid := normalizeIPDecisions(decisions)
+	if invalid > 0 {
+		log.Warningf("blocklist %s: skipped %d invalid IP addresses or ranges", *blocklist.Name, invalid)
+	}
+
+	blocklistHashConfigItemName := fmt.Sprintf("blocklist:%s:hash", *blocklist.Name)
+
+	var contentHash string
+
+	// servers that don't send Last-Modified always return the full content, compare it with the previous pull
+	if a.checkBlocklistHash {
+		contentHash = blocklistContentHash(decisions)
+
+		if !forcePull {
+			previousHash, err := a.dbClient.GetConfigItem(ctx, blocklistHashConfigItemName)
+			if err != nil {
+				return fmt.Errorf("while getting content hash for blocklist %s: %w", *blocklist.Name, err)
+			}
+
+			if previousHash == contentHash {
+				log.Infof("blocklist %s content hasn't changed since last pull, skipping", *blocklist.Name)
+				return nil
+			}
+		}
+	}
+
+	// an empty list is more likely being regenerated upstream than really empty: keep the
+	// current decisions, and don't record the pull so the list is fetched again next time
+	if len(decisions) == 0 && !a.allowEmpty[*blocklist.Name] {
+		log.Warningf("blocklist %s is empty, keeping the existing decisions", *blocklist.Name)
+		return nil
+	}
+
 	err = a.dbClient.SetConfigItem(ctx, blocklistConfigItemName, time.Now().UTC().Format(http.TimeFormat))
 	if err != nil {
 		return fmt.Errorf("while setting last pull timestamp for blocklist %s: %w", *blocklist.Name, err)
 	}
 
+	err = a.dbClient.SetConfigItem(ctx, fmt.Sprintf("blocklist:%s:url", *blocklist.Name), *blocklist.URL)
+	if err != nil {
+		return fmt.Errorf("while setting url for blocklist %s: %w", *blocklist.Name, err)
+	}
+
 	if len(decisions) == 0 {
 		log.Infof("blocklist %s has no decisions", *blocklist.Name)
+
+		if a.deleteGracePeriod > 0 {
+			if err := a.expireMissingBlocklistDecisions(ctx, blocklist, nil); err != nil {
+				return fmt.Errorf("while removing missing decisions of blocklist %s: %w", *blocklist.Name, err)
+			}
+		}
+
 		return nil
 	}
+
 	// apply APIC specific whitelists
 	decisions = a.ApplyApicWhitelists(ctx, decisions)
-	alert := createAlertForDecision(decisions[0])
-	alertsFromCapi := []*models.Alert{alert}
-	alertsFromCapi = fillAlertsWithDecisions(alertsFromCapi, decisions, addCounters)
 
-	err = a.SaveAlerts(ctx, alertsFromCapi, addCounters, nil)
+	if a.aggregateRanges {
+		before := len(decisions)
+		decisions = aggregateRangeDecisions(decisions)
+		log.Debugf("blocklist %s: %d decisions aggregated into %d", *blocklist.Name, before, len(decisions))
+	}
+
+	if len(decisions) == 0 {
+		// the decisions that were there before are expired below, as if the list was empty
+		log.Infof("blocklist %s: all the decisions are allowlisted", *blocklist.Name)
+	} else {
+		a.applyMinDecisionDuration(decisions)
+		a.normalizeDecisionTypes(decisions)
+		tagDecisionsWithPullBatch(decisions, pullBatchID)
+
+		var alertsFromCapi []*models.Alert
+
+		if a.explodeAlerts[*blocklist.Name] {
+			alertsFromCapi = createAlertPerDecision(decisions, addCounters)
+		} else {
+			alert := createAlertForDecision(decisions[0])
+			alertsFromCapi = []*models.Alert{alert}
+			alertsFromCapi = fillAlertsWithDecisions(alertsFromCapi, decisions, addCounters)
+		}
+
+		err = a.SaveAlerts(ctx, alertsFromCapi, addCounters, nil)
+		if err != nil {
+			return fmt.Errorf("while saving alert from blocklist %s: %w", *blocklist.Name, err)
+		}
+	}
+
+	if a.deleteGracePeriod > 0 {
+		if err := a.expireMissingBlocklistDecisions(ctx, blocklist, decisions); err != nil {
+			return fmt.Errorf("while removing missing decisions of blocklist %s: %w", *blocklist.Name, err)
+		}
+	}
+
+	if contentHash != "" {
+		err = a.dbClient.SetConfigItem(ctx, blocklistHashConfigItemName, contentHash)
+		if err != nil {
+			return fmt.Errorf("while setting content hash for blocklist %s: %w", *blocklist.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// maxBlocklistBackoff is the longest time a failing blocklist is skipped.
+const maxBlocklistBackoff = 24 * time.Hour
+
+// blocklistBackoff is the failure state of a blocklist, stored in the blocklist:<name>:backoff config item.
+type blocklistBackoff struct {
+	Failures   int       `json:"failures"`
+	RetryAfter time.Time `json:"retry_after"`
+}
+
+func blocklistBackoffConfigItemName(blocklist *modelscapi.BlocklistLink) string {
+	return fmt.Sprintf("blocklist:%s:backoff", *blocklist.Name)
+}
+
+func (a *apic) getBlocklistBackoff(ctx context.Context, blocklist *modelscapi.BlocklistLink) (blocklistBackoff, error) {
+	var backoff blocklistBackoff
+
+	value, err := a.dbClient.GetConfigItem(ctx, blocklistBackoffConfigItemName(blocklist))
+	if err != nil {
+		return backoff, err
+	}
+
+	if value == "" {
+		return backoff, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &backoff); err != nil {
+		log.Warningf("ignoring invalid backoff state for blocklist %s: %s", *blocklist.Name, err)
+		return blocklistBackoff{}, nil
+	}
+
+	return backoff, nil
+}
+
+// recordBlocklistFailure increments the consecutive failures of a blocklist, and skips
+// it for blocklistBackoff, doubled for each previous failure.
+func (a *apic) recordBlocklistFailure(ctx context.Context, blocklist *modelscapi.BlocklistLink) error {
+	backoff, err := a.getBlocklistBackoff(ctx, blocklist)
+	if err != nil {
+		return err
+	}
+
+	backoff.Failures++
+
+	delay := a.blocklistBackoff
+	for range backoff.Failures - 1 {
+		if delay >= maxBlocklistBackoff {
+			break
+		}
+
+		delay *= 2
+	}
+
+	delay = min(delay, maxBlocklistBackoff)
+
+	backoff.RetryAfter = time.Now().UTC().Add(delay)
+
+	value, err := json.Marshal(backoff)
 	if err != nil {
-		return fmt.Errorf("while saving alert from blocklist %s: %w", *blocklist.Name, err)
+		return err
+	}
+
+	log.Warningf("blocklist %s failed %d times in a row, will retry in %s", *blocklist.Name, backoff.Failures, delay)
+
+	return a.dbClient.SetConfigItem(ctx, blocklistBackoffConfigItemName(blocklist), string(value))
+}
+
+func (a *apic) resetBlocklistBackoff(ctx context.Context, blocklist *modelscapi.BlocklistLink) error {
+	value, err := a.dbClient.GetConfigItem(ctx, blocklistBackoffConfigItemName(blocklist))
+	if err != nil {
+		return err
+	}
+
+	if value == "" {
+		return nil
+	}
+
+	return a.dbClient.SetConfigItem(ctx, blocklistBackoffConfigItemName(blocklist), "")
+}
+
+// expireMissingBlocklistDecisions expires the active decisions of a blocklist whose value has been
+// missing from the pulls for longer than the grace period. The time each value was first found
+// missing is kept in a config item, so a value that comes back in the meantime is not removed.
+func (a *apic) expireMissingBlocklistDecisions(ctx context.Context, blocklist *modelscapi.BlocklistLink, decisions []*models.Decision) error {
+	missingConfigItemName := fmt.Sprintf("blocklist:%s:missing", *blocklist.Name)
+
+	missingSince := make(map[string]time.Time)
+
+	previous, err := a.dbClient.GetConfigItem(ctx, missingConfigItemName)
+	if err != nil {
+		return fmt.Errorf("while getting missing values: %w", err)
+	}
+
+	if previous != "" {
+		if err := json.Unmarshal([]byte(previous), &missingSince); err != nil {
+			log.Warningf("ignoring invalid missing values for blocklist %s: %s", *blocklist.Name, err)
+		}
+	}
+
+	present := make(map[string]struct{}, len(decisions))
+	for _, d := range decisions {
+		present[*d.Value] = struct{}{}
+	}
+
+	now := time.Now().UTC()
+
+	active, err := a.dbClient.Ent.Decision.Query().
+		Where(
+			decision.OriginEQ(types.ListOrigin),
+			decision.ScenarioEQ(*blocklist.Name),
+			decision.UntilGT(now),
+		).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("while getting active decisions: %w", err)
+	}
+
+	stillMissing := make(map[string]time.Time)
+	toExpire := []*ent.Decision{}
+
+	for _, d := range active {
+		if _, ok := present[d.Value]; ok {
+			continue
+		}
+
+		since, ok := missingSince[d.Value]
+		if !ok {
+			since = now
+		}
+
+		if now.Sub(since) >= a.deleteGracePeriod {
+			toExpire = append(toExpire, d)
+			continue
+		}
+
+		stillMissing[d.Value] = since
+	}
+
+	if len(toExpire) > 0 {
+		expired, err := a.dbClient.ExpireDecisions(ctx, toExpire)
+		if err != nil {
+			return fmt.Errorf("while expiring decisions: %w", err)
+		}
+
+		log.Infof("blocklist %s: expired %d decisions missing for more than %s", *blocklist.Name, expired, a.deleteGracePeriod)
+	}
+
+	content, err := json.Marshal(stillMissing)
+	if err != nil {
+		return err
+	}
+
+	if err := a.dbClient.SetConfigItem(ctx, missingConfigItemName, string(content)); err != nil {
+		return fmt.Errorf("while setting missing values: %w", err)
 	}
 
 	return nil
 }
 
-func (a *apic) UpdateBlocklists(ctx context.Context, blocklists []*modelscapi.BlocklistLink, addCounters map[string]map[string]int, forcePull bool) error {
+// blocklistContentHash returns a checksum of the values of a blocklist, in the order they were received.
+func blocklistContentHash(decisions []*models.Decision) string {
+	h := sha256.New()
+
+	for _, d := range decisions {
+		h.Write([]byte(*d.Value))
+		h.Write([]byte("\n"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeIPDecisions sets the scope of the IP and range decisions according to their value, either Ip
+// for an IPv4 or IPv6 address, or Range for a CIDR, and writes the value in its canonical form.
+// The decisions with an invalid value are removed, and their number is returned. Other scopes are left untouched.
+func normalizeIPDecisions(decisions []*models.Decision) ([]*models.Decision, int) {
+	ret := make([]*models.Decision, 0, len(decisions))
+	invalid := 0
+
+	for _, d := range decisions {
+		if d.Value == nil || !isIPScope(d.Scope) {
+			ret = append(ret, d)
+			continue
+		}
+
+		value := strings.TrimSpace(*d.Value)
+
+		if addr, err := netip.ParseAddr(value); err == nil {
+			d.Scope = ptr.Of(types.Ip)
+			d.Value = ptr.Of(addr.String())
+		} else if prefix, err := netip.ParsePrefix(value); err == nil {
+			d.Scope = ptr.Of(types.Range)
+			d.Value = ptr.Of(prefix.Masked().String())
+		} else {
+			invalid++
+			continue
+		}
+
+		ret = append(ret, d)
+	}
+
+	return ret, invalid
+}
+
+// aggregateRangeDecisions replaces the range decisions that overlap or are adjacent with
+// the smallest set of ranges covering the same addresses. Other decisions are left untouched.
+func aggregateRangeDecisions(decisions []*models.Decision) []*models.Decision {
+	ret := make([]*models.Decision, 0, len(decisions))
+	prefixes := make([]netip.Prefix, 0, len(decisions))
+
+	var template *models.Decision
+
+	for _, d := range decisions {
+		if d.Scope == nil || !strings.EqualFold(*d.Scope, types.Range) || d.Value == nil {
+			ret = append(ret, d)
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(*d.Value)
+		if err != nil {
+			ret = append(ret, d)
+			continue
+		}
+
+		if template == nil {
+			template = d
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	if template == nil {
+		return decisions
+	}
+
+	for _, prefix := range coalescePrefixes(prefixes) {
+		d := *template
+		d.Value = ptr.Of(prefix.String())
+		ret = append(ret, &d)
+	}
+
+	return ret
+}
+
+// coalescePrefixes removes the prefixes contained in other ones, and merges adjacent prefixes of the same size.
+func coalescePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+
+		return a.Bits() - b.Bits()
+	})
+
+	ret := make([]netip.Prefix, 0, len(prefixes))
+
+	for _, prefix := range prefixes {
+		if len(ret) > 0 {
+			last := ret[len(ret)-1]
+			if last.Bits() <= prefix.Bits() && last.Contains(prefix.Addr()) {
+				continue
+			}
+		}
+
+		ret = append(ret, prefix)
+
+		// merge with the previous prefix as long as they are the two halves of a bigger one
+		for len(ret) >= 2 {
+			a, b := ret[len(ret)-2], ret[len(ret)-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().BitLen() != b.Addr().BitLen() {
+				break
+			}
+
+			parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+			if parent != netip.PrefixFrom(b.Addr(), b.Bits()-1).Masked() {
+				break
+			}
+
+			ret = append(ret[:len(ret)-2], parent)
+		}
+	}
+
+	return ret
+}
+
+func (a *apic) UpdateBlocklists(ctx context.Context, blocklists []*modelscapi.BlocklistLink, addCounters map[string]map[string]int, forcePull bool, pullBatchID string) error {
 	if len(blocklists) == 0 {
 		return nil
 	}
@@ -1055,8 +2641,16 @@ func (a *apic) UpdateBlocklists(ctx context.Context, blocklists []*modelscapi.Bl
 		return fmt.Errorf("while creating default client: %w", err)
 	}
 
-	for _, blocklist := range blocklists {
-		if err := a.updateBlocklist(ctx, defaultClient, blocklist, addCounters, forcePull); err != nil {
+	if len(a.blocklistsAuth) > 0 {
+		defaultClient.SetBlocklistAuthorization(a.blocklistAuthorization)
+	}
+
+	defaultClient.SetBlocklistMaxLineLength(a.blocklistMaxLength)
+
+	for _, blocklist := range a.limitBlocklists(blocklists) {
+		defaultClient.SetBlocklistClient(a.blocklistHTTPClient(blocklist))
+
+		if err := a.updateBlocklist(ctx, defaultClient, blocklist, addCounters, forcePull, pullBatchID); err != nil {
 			return err
 		}
 	}
@@ -1064,15 +2658,158 @@ func (a *apic) UpdateBlocklists(ctx context.Context, blocklists []*modelscapi.Bl
 	return nil
 }
 
-func setAlertScenario(alert *models.Alert, addCounters map[string]map[string]int, deleteCounters map[string]map[string]int) {
+// limitBlocklists returns the first max_blocklists blocklists by name, to pull no more than that
+// whatever the order CAPI advertises them in. The others are logged as skipped.
+func (a *apic) limitBlocklists(blocklists []*modelscapi.BlocklistLink) []*modelscapi.BlocklistLink {
+	if a.maxBlocklists <= 0 || len(blocklists) <= a.maxBlocklists {
+		return blocklists
+	}
+
+	sorted := slices.Clone(blocklists)
+	slices.SortStableFunc(sorted, func(x, y *modelscapi.BlocklistLink) int {
+		return cmp.Compare(ptr.OrEmpty(x.Name), ptr.OrEmpty(y.Name))
+	})
+
+	for _, blocklist := range sorted[a.maxBlocklists:] {
+		log.Warningf("skipping blocklist %s: more than max_blocklists (%d) are subscribed", ptr.OrEmpty(blocklist.Name), a.maxBlocklists)
+	}
+
+	return sorted[:a.maxBlocklists]
+}
+
+// SubscribedBlocklist describes a blocklist decisions have been pulled from.
+type SubscribedBlocklist struct {
+	Name      string
+	URL       string
+	LastPull  time.Time
+	Decisions int
+}
+
+// ListSubscribedBlocklists returns the blocklists that have been pulled at least once,
+// along with the number of active decisions each of them currently holds.
+func (a *apic) ListSubscribedBlocklists(ctx context.Context) ([]SubscribedBlocklist, error) {
+	items, err := a.dbClient.Reader().ConfigItem.Query().
+		Where(configitem.NameHasPrefix("blocklist:"), configitem.NameHasSuffix(":last_pull")).
+		Order(ent.Asc(configitem.FieldName)).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while listing blocklists: %w", err)
+	}
+
+	ret := make([]SubscribedBlocklist, 0, len(items))
+
+	for _, item := range items {
+		name := strings.TrimSuffix(strings.TrimPrefix(item.Name, "blocklist:"), ":last_pull")
+
+		lastPull, err := http.ParseTime(item.Value)
+		if err != nil {
+			log.Warningf("invalid last pull timestamp for blocklist %s: %s", name, err)
+		}
+
+		blocklistURL, err := a.dbClient.GetConfigItem(ctx, fmt.Sprintf("blocklist:%s:url", name))
+		if err != nil {
+			return nil, fmt.Errorf("while getting url for blocklist %s: %w", name, err)
+		}
+
+		count, err := a.dbClient.Reader().Decision.Query().
+			Where(
+				decision.OriginEQ(types.ListOrigin),
+				decision.ScenarioEQ(name),
+				decision.UntilGT(time.Now().UTC()),
+			).
+			Count(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("while counting decisions for blocklist %s: %w", name, err)
+		}
+
+		ret = append(ret, SubscribedBlocklist{
+			Name:      name,
+			URL:       blocklistURL,
+			LastPull:  lastPull,
+			Decisions: count,
+		})
+	}
+
+	return ret, nil
+}
+
+// blocklistAuthorization returns the Authorization header configured for a blocklist,
+// looked up by blocklist name first, then by host.
+func (a *apic) blocklistAuthorization(blocklist *modelscapi.BlocklistLink) string {
+	if blocklist.Name != nil {
+		if auth, ok := a.blocklistsAuth[*blocklist.Name]; ok {
+			return string(auth)
+		}
+	}
+
+	if blocklist.URL == nil {
+		return ""
+	}
+
+	u, err := url.Parse(*blocklist.URL)
+	if err != nil {
+		return ""
+	}
+
+	return string(a.blocklistsAuth[u.Host])
+}
+
+// newBlocklistProxyClients returns the http clients of the blocklists_proxy entries, by blocklist name, host or "*".
+func newBlocklistProxyClients(proxies map[string]string, cache *dnsCache) (map[string]*http.Client, error) {
+	if len(proxies) == 0 {
+		return nil, nil
+	}
+
+	ret := make(map[string]*http.Client, len(proxies))
+
+	for key, proxy := range proxies {
+		// the URL can hold credentials, don't print it
+		u, err := url.Parse(proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid blocklists_proxy URL for %s", key)
+		}
+
+		ret[key] = newBlocklistHTTPClient(cache, u)
+	}
+
+	return ret, nil
+}
+
+// blocklistHTTPClient returns the client to fetch a blocklist with: the one of its proxy, looked up
+// by blocklist name, then by host, then "*". Without proxy, it's the default blocklist client, if any.
+func (a *apic) blocklistHTTPClient(blocklist *modelscapi.BlocklistLink) *http.Client {
+	if blocklist.Name != nil {
+		if client, ok := a.blocklistProxyClients[*blocklist.Name]; ok {
+			return client
+		}
+	}
+
+	if blocklist.URL != nil {
+		if u, err := url.Parse(*blocklist.URL); err == nil {
+			if client, ok := a.blocklistProxyClients[u.Host]; ok {
+				return client
+			}
+		}
+	}
+
+	if client, ok := a.blocklistProxyClients["*"]; ok {
+		return client
+	}
+
+	return a.blocklistClient
+}
+
+// setAlertScenario sets the source scope and scenario of a community or list alert before it's saved.
+// The source scope starts with prefix, if any.
+func setAlertScenario(alert *models.Alert, addCounters map[string]map[string]int, deleteCounters map[string]map[string]int, prefix string) {
 	switch *alert.Source.Scope {
 	case types.CAPIOrigin:
-		*alert.Source.Scope = types.CommunityBlocklistPullSourceScope
+		*alert.Source.Scope = prefix + types.CommunityBlocklistPullSourceScope
 		alert.Scenario = ptr.Of(fmt.Sprintf("update : +%d/-%d IPs",
 			addCounters[types.CAPIOrigin]["all"],
 			deleteCounters[types.CAPIOrigin]["all"]))
 	case types.ListOrigin:
-		*alert.Source.Scope = fmt.Sprintf("%s:%s", types.ListOrigin, *alert.Scenario)
+		*alert.Source.Scope = fmt.Sprintf("%s%s:%s", prefix, types.ListOrigin, *alert.Scenario)
 		alert.Scenario = ptr.Of(fmt.Sprintf("update : +%d/-%d IPs",
 			addCounters[types.ListOrigin][*alert.Scenario],
 			deleteCounters[types.ListOrigin][*alert.Scenario]))
@@ -1128,10 +2865,82 @@ func (a *apic) Pull(ctx context.Context) error {
 	}
 }
 
+// PullAllowlists refreshes the allowlists every allowlistsInterval, independently of the decisions pull,
+// until pullTomb dies. The allowlists are learnt from the decisions stream.
+func (a *apic) PullAllowlists(ctx context.Context) error {
+	defer trace.CatchPanic("lapi/pullAllowlistsFromAPIC")
+
+	log.Infof("Start pull of the allowlists from CrowdSec Central API (interval: %s)", a.allowlistsInterval)
+	ticker := time.NewTicker(a.allowlistsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.refreshAllowlists(ctx)
+		case <-a.pullTomb.Dying():
+			return nil
+		}
+	}
+}
+
+// WarmupPull pulls the decisions from CAPI once, and returns when they are stored or after warmup_timeout,
+// so that the bouncers don't get an empty list if they query right after startup.
+// It doesn't wait for the machines to register their scenarios, the pull is skipped without them.
+func (a *apic) WarmupPull(ctx context.Context) error {
+	scenarios, err := a.FetchScenariosListFromDB(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch scenarios from db: %w", err)
+	}
+
+	if len(scenarios) == 0 {
+		log.Info("scenario list is empty, skipping the warmup pull")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(a.warmupTimeout, warmupTimeoutDefault))
+	defer cancel()
+
+	start := time.Now()
+
+	if err := a.PullTop(ctx, false); err != nil {
+		return fmt.Errorf("warmup pull: %w", err)
+	}
+
+	log.Infof("warmup pull done in %s", time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
 func (a *apic) Shutdown() {
 	a.pushTomb.Kill(nil)
 	a.pullTomb.Kill(nil)
 	a.metricsTomb.Kill(nil)
+
+	if a.sendingMetrics.Load() {
+		timer := time.NewTimer(metricsFlushTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-a.metricsTomb.Dead():
+		case <-timer.C:
+			log.Warning("timeout while waiting for the last metrics to be sent")
+		}
+	}
+
+	if a.pushDrainTimeout <= 0 {
+		return
+	}
+
+	// give the push routine a chance to send the pending signals
+	timer := time.NewTimer(a.pushDrainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-a.pushTomb.Dead():
+	case <-timer.C:
+		log.Warning("timeout while waiting for pending signals to be sent")
+	}
 }
 
 func makeAddAndDeleteCounters() (map[string]map[string]int, map[string]map[string]int) {
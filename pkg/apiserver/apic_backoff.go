@@ -0,0 +1,134 @@
+package apiserver
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default upper limit of the delay between two pushes after failures
+const pushBackoffMaxDefault = 30 * time.Minute
+
+// pushBackoff delays the pushes after CAPI failures. The delay doubles with each failure
+// in a row up to max, unless CAPI asks to wait longer with a Retry-After header.
+type pushBackoff struct {
+	mu       sync.Mutex
+	max      time.Duration
+	failures int
+	until    time.Time
+}
+
+// failure records a failed push and returns the delay before the next one.
+// base is the delay of the first failure, retryAfter the one requested by CAPI, if any.
+func (b *pushBackoff) failure(base time.Duration, retryAfter time.Duration, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	maxDelay := b.max
+	if maxDelay <= 0 {
+		maxDelay = pushBackoffMaxDefault
+	}
+
+	delay := maxDelay
+	if b.failures < 20 {
+		delay = min(base<<b.failures, maxDelay)
+	}
+
+	b.failures++
+
+	delay = max(delay, retryAfter)
+
+	// up to 10% more, to spread the pushes of the instances rate limited at the same time
+	if jitter := int64(delay / 10); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+
+	b.until = now.Add(delay)
+
+	return delay
+}
+
+// success resets the delay after a successful push.
+func (b *pushBackoff) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.until = time.Time{}
+}
+
+// remaining returns how long to wait before the next push, 0 if it can be done now.
+func (b *pushBackoff) remaining(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return max(b.until.Sub(now), 0)
+}
+
+// pushThrottledError is returned when CAPI rejected a batch of signals with a Retry-After header.
+type pushThrottledError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *pushThrottledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pushThrottledError) Unwrap() error {
+	return e.err
+}
+
+// pushRejectedError is returned when CAPI rejected a batch of signals with a client error:
+// it would be rejected again, it must not be retried.
+type pushRejectedError struct {
+	statusCode int
+	err        error
+}
+
+func (e *pushRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pushRejectedError) Unwrap() error {
+	return e.err
+}
+
+// batchRejected returns true if CAPI refused the content of a batch of signals. The timeouts,
+// the rate limiting and the authentication failures don't depend on the batch.
+func batchRejected(resp *http.Response) bool {
+	if resp == nil || resp.StatusCode < 400 || resp.StatusCode >= 500 {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+
+	return true
+}
+
+// retryAfter returns the delay requested by a 429 response, in seconds or as a date, or 0.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+
+	return 0
+}
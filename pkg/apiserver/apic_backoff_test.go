@@ -0,0 +1,214 @@
+package apiserver
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+)
+
+func TestAPICPushRetryAfter(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.pushInterval = 10 * time.Millisecond
+	api.pushIntervalFirst = 10 * time.Millisecond
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	var mu sync.Mutex
+
+	calls := []time.Time{}
+	pushed := [][]string{}
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", func(req *http.Request) (*http.Response, error) {
+		var body io.Reader = req.Body
+
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			body = gz
+		}
+
+		var signals models.AddSignalsRequest
+		if err := json.NewDecoder(body).Decode(&signals); err != nil {
+			return nil, err
+		}
+
+		scenarios := []string{}
+		for _, signal := range signals {
+			scenarios = append(scenarios, *signal.Scenario)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls = append(calls, time.Now())
+		pushed = append(pushed, scenarios)
+
+		if len(calls) == 1 {
+			resp := httpmock.NewBytesResponse(http.StatusTooManyRequests, []byte{})
+			resp.Header.Set("Retry-After", "1")
+
+			return resp, nil
+		}
+
+		return httpmock.NewBytesResponse(http.StatusOK, []byte{}), nil
+	})
+
+	alerts := func(prefix string, n int) []*models.Alert {
+		ret := make([]*models.Alert, n)
+		for i := range ret {
+			ret[i] = &models.Alert{
+				Scenario:        ptr.Of(fmt.Sprintf("crowdsec/%s-%d", prefix, i)),
+				ScenarioHash:    ptr.Of("certified"),
+				ScenarioVersion: ptr.Of("v1.0"),
+				Simulated:       ptr.Of(false),
+				Source:          &models.Source{},
+			}
+		}
+
+		return ret
+	}
+
+	go func() {
+		// two batches, the push stops after the first one is rejected
+		api.AlertsAddChan <- alerts("first", 60)
+
+		// the next signals are held until CAPI accepts them again
+		for api.pushBackoff.remaining(time.Now()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		api.AlertsAddChan <- alerts("second", 1)
+
+		time.Sleep(2 * time.Second)
+		api.Shutdown()
+	}()
+
+	err = api.Push(ctx)
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, calls, 3)
+	assert.GreaterOrEqual(t, calls[1].Sub(calls[0]), time.Second)
+
+	// the rejected signals are sent again, before the new ones
+	require.Len(t, pushed[0], 50)
+
+	resent := append(pushed[1], pushed[2]...)
+	require.Len(t, resent, 61)
+	assert.Equal(t, pushed[0], resent[:50])
+	assert.Equal(t, "crowdsec/first-59", resent[59])
+	assert.Equal(t, "crowdsec/second-0", resent[60])
+}
+
+func TestPushBackoff(t *testing.T) {
+	now := time.Now()
+	b := pushBackoff{max: time.Minute}
+
+	// the delay doubles with each failure, plus up to 10%
+	delay := b.failure(10*time.Second, 0, now)
+	assert.GreaterOrEqual(t, delay, 10*time.Second)
+	assert.Less(t, delay, 11*time.Second)
+
+	delay = b.failure(10*time.Second, 0, now)
+	assert.GreaterOrEqual(t, delay, 20*time.Second)
+	assert.Less(t, delay, 22*time.Second)
+	assert.Equal(t, delay, b.remaining(now))
+
+	// up to max
+	for range 5 {
+		delay = b.failure(10*time.Second, 0, now)
+	}
+
+	assert.GreaterOrEqual(t, delay, time.Minute)
+	assert.Less(t, delay, 66*time.Second)
+
+	// unless CAPI asks for longer
+	delay = b.failure(10*time.Second, time.Hour, now)
+	assert.GreaterOrEqual(t, delay, time.Hour)
+
+	b.success()
+	assert.Zero(t, b.remaining(now))
+
+	delay = b.failure(10*time.Second, 0, now)
+	assert.Less(t, delay, 11*time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+
+	resp := func(status int, value string) *http.Response {
+		r := &http.Response{StatusCode: status, Header: http.Header{}}
+		if value != "" {
+			r.Header.Set("Retry-After", value)
+		}
+
+		return r
+	}
+
+	assert.Equal(t, 30*time.Second, retryAfter(resp(http.StatusTooManyRequests, "30"), now))
+	assert.InDelta(t, time.Minute, retryAfter(resp(http.StatusTooManyRequests, now.Add(time.Minute).UTC().Format(http.TimeFormat)), now), float64(time.Second))
+	assert.Zero(t, retryAfter(resp(http.StatusTooManyRequests, ""), now))
+	assert.Zero(t, retryAfter(resp(http.StatusTooManyRequests, "soon"), now))
+	assert.Zero(t, retryAfter(resp(http.StatusServiceUnavailable, "30"), now))
+	assert.Zero(t, retryAfter(nil, now))
+}
+
+func TestAPICSendFailureOncePerPush(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", httpmock.NewBytesResponder(http.StatusInternalServerError, []byte{}))
+
+	signals := make(models.AddSignalsRequest, 120)
+	for i := range signals {
+		signals[i] = &models.AddSignalsRequestItem{Scenario: ptr.Of("crowdsec/test")}
+	}
+
+	err = api.Send(ctx, &signals)
+	require.ErrorContains(t, err, "failed to send 3/3 signal batches")
+
+	// every batch was tried, but the push counts as a single failure
+	assert.Equal(t, 3, httpmock.GetTotalCallCount())
+	assert.Equal(t, 1, api.pushBackoff.failures)
+}
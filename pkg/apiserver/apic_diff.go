@@ -0,0 +1,97 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent/decision"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+// DecisionsDiff compares the community decisions of the database with the CAPI stream.
+// The decisions are identified by "scope:value", the lists are sorted.
+type DecisionsDiff struct {
+	Added     []string // in the stream, not in the database
+	Removed   []string // in the database, not in the stream
+	Unchanged []string // in both
+}
+
+func decisionKey(scope string, value string) string {
+	return types.NormalizeScope(scope) + ":" + value
+}
+
+// DiffAgainstStream fetches the whole community blocklist from CAPI and compares it with the
+// active decisions of the database, without changing anything. The whitelists are applied to
+// the stream like in PullTop, the subscribed blocklists are not compared.
+func (a *apic) DiffAgainstStream(ctx context.Context) (*DecisionsDiff, error) {
+	streamOpts := apiclient.DecisionsStreamOpts{Startup: true, CommunityPull: a.pullCommunity}
+
+	data, _, err := a.apiClient.Decisions.GetStreamV3(ctx, streamOpts)
+	if err != nil {
+		return nil, fmt.Errorf("get stream: %w", err)
+	}
+
+	upstream := make(map[string]bool)
+
+	// like PullTop, don't trust CAPI if the community pull is disabled
+	if a.pullCommunity && len(data.New) > 0 {
+		decisions := a.applyWhitelists(ctx, a.apiClient.Decisions.GetDecisionsFromGroups(data.New), false)
+
+		for _, d := range decisions {
+			if d.Scope == nil || d.Value == nil {
+				continue
+			}
+
+			upstream[decisionKey(*d.Scope, *d.Value)] = true
+		}
+	}
+
+	local, err := a.dbClient.Reader().Decision.Query().
+		Where(
+			decision.OriginEQ(types.CAPIOrigin),
+			decision.UntilGT(time.Now().UTC()),
+		).All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while getting community decisions: %w", err)
+	}
+
+	diff := &DecisionsDiff{
+		Added:     []string{},
+		Removed:   []string{},
+		Unchanged: []string{},
+	}
+
+	seen := make(map[string]bool)
+
+	for _, d := range local {
+		key := decisionKey(d.Scope, d.Value)
+
+		// several decisions can apply to the same value
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		if upstream[key] {
+			diff.Unchanged = append(diff.Unchanged, key)
+		} else {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	for key := range upstream {
+		if !seen[key] {
+			diff.Added = append(diff.Added, key)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Unchanged)
+
+	return diff, nil
+}
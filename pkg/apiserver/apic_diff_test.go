@@ -0,0 +1,94 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/modelscapi"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestAPICDiffAgainstStream(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	api.whitelists = &csconfig.CapiWhitelist{Ips: []netip.Addr{netip.MustParseAddr("10.0.0.1")}}
+
+	for _, d := range []struct {
+		origin string
+		value  string
+		until  time.Time
+	}{
+		{types.CAPIOrigin, "1.2.3.4", time.Now().Add(time.Hour)},
+		{types.CAPIOrigin, "1.2.3.4", time.Now().Add(2 * time.Hour)},
+		{types.CAPIOrigin, "9.9.9.9", time.Now().Add(time.Hour)},
+		{types.CAPIOrigin, "8.8.8.8", time.Now().Add(-time.Hour)},
+		{types.ListOrigin, "7.7.7.7", time.Now().Add(time.Hour)},
+	} {
+		api.dbClient.Ent.Decision.Create().
+			SetOrigin(d.origin).
+			SetType("ban").
+			SetValue(d.value).
+			SetScope("Ip").
+			SetScenario("crowdsecurity/ssh-bf").
+			SetUntil(d.until).
+			ExecX(ctx)
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", func(req *http.Request) (*http.Response, error) {
+		// the whole list is requested
+		assert.Equal(t, "true", req.URL.Query().Get("startup"))
+
+		return httpmock.NewBytesResponse(200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{Value: ptr.Of("1.2.3.4"), Duration: ptr.Of("24h")},
+							{Value: ptr.Of("5.6.7.8"), Duration: ptr.Of("24h")},
+							{Value: ptr.Of("10.0.0.1"), Duration: ptr.Of("24h")},
+						},
+					},
+				},
+			},
+		)), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	diff, err := api.DiffAgainstStream(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, &DecisionsDiff{
+		Added:     []string{"Ip:5.6.7.8"},
+		Removed:   []string{"Ip:9.9.9.9"},
+		Unchanged: []string{"Ip:1.2.3.4"},
+	}, diff)
+
+	// nothing has been changed
+	assertTotalDecisionCount(t, ctx, api.dbClient, 5)
+	assertTotalAlertCount(t, api.dbClient, 0)
+	assert.True(t, api.startup)
+}
@@ -0,0 +1,269 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tomb.v2"
+
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+	"github.com/crowdsecurity/go-cs-lib/trace"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/database"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent/decision"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+const (
+	// default delay between two forwards of the local decisions
+	forwardIntervalDefault = 10 * time.Second
+	// maximum number of alerts per request to the secondary API
+	forwardBatchSize = 100
+)
+
+// the decisions taken locally. The ones pulled from CAPI and the blocklists are not
+// forwarded, the secondary API can pull them itself.
+var forwardedOrigins = []string{types.CrowdSecOrigin, types.CscliOrigin, types.ConsoleOrigin, types.CscliImportOrigin}
+
+// decisionForwarder pushes the local decisions to a secondary LAPI, ie. the central instance of a
+// federated deployment. Like the blocklists, but outbound: the new decisions are sent as alerts,
+// and the deleted or expired ones are deleted by scope, value, type and origin. The secondary API
+// doesn't know the local ids, so a deletion is not forwarded while another decision with the same
+// scope, value, type and origin is still active locally.
+type decisionForwarder struct {
+	dbClient  *database.Client
+	apiClient *apiclient.ApiClient // authenticated as a machine of the secondary API
+	interval  time.Duration
+	tomb      tomb.Tomb
+	running   bool
+	// the decisions created or updated, and deleted or expired, up to these dates have been
+	// forwarded. All the active decisions are sent on the first run.
+	addedUntil   *time.Time
+	deletedUntil time.Time
+}
+
+func newDecisionForwarder(dbClient *database.Client, apiClient *apiclient.ApiClient, interval time.Duration) *decisionForwarder {
+	if interval <= 0 {
+		interval = forwardIntervalDefault
+	}
+
+	return &decisionForwarder{
+		dbClient:     dbClient,
+		apiClient:    apiClient,
+		interval:     interval,
+		deletedUntil: time.Now().UTC(),
+	}
+}
+
+// newDecisionForwarderFromConfig returns a forwarder authenticated with the credentials of forward_decisions.
+func newDecisionForwarderFromConfig(dbClient *database.Client, config *csconfig.ForwardDecisionsCfg) (*decisionForwarder, error) {
+	apiURL, err := url.Parse(config.Credentials.URL)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing '%s': %w", config.Credentials.URL, err)
+	}
+
+	apiClient := apiclient.NewClient(&apiclient.Config{
+		MachineID:     config.Credentials.Login,
+		Password:      strfmt.Password(config.Credentials.Password),
+		URL:           apiURL,
+		VersionPrefix: "v1",
+	})
+
+	return newDecisionForwarder(dbClient, apiClient, config.Interval), nil
+}
+
+func forwardFilter() map[string][]string {
+	return map[string][]string{
+		"origins": {strings.Join(forwardedOrigins, ",")},
+		"dedup":   {"false"},
+	}
+}
+
+// forwardedAlert returns the alert holding a local decision, as sent to the secondary API.
+func forwardedAlert(d *ent.Decision, now time.Time) *models.Alert {
+	startAt := now.Format(time.RFC3339)
+
+	return &models.Alert{
+		Scenario:        ptr.Of(d.Scenario),
+		ScenarioHash:    ptr.Of(""),
+		ScenarioVersion: ptr.Of(""),
+		Message:         ptr.Of(d.Scenario),
+		Events:          []*models.Event{},
+		EventsCount:     ptr.Of(int32(0)),
+		Capacity:        ptr.Of(int32(0)),
+		Leakspeed:       ptr.Of(""),
+		Simulated:       ptr.Of(false),
+		StartAt:         &startAt,
+		StopAt:          &startAt,
+		Remediation:     true,
+		Source: &models.Source{
+			Scope: ptr.Of(d.Scope),
+			Value: ptr.Of(d.Value),
+		},
+		Decisions: []*models.Decision{{
+			Duration: ptr.Of(d.Until.Sub(now).Round(time.Second).String()),
+			Origin:   ptr.Of(d.Origin),
+			Scenario: ptr.Of(d.Scenario),
+			Scope:    ptr.Of(d.Scope),
+			Type:     ptr.Of(d.Type),
+			Value:    ptr.Of(d.Value),
+		}},
+	}
+}
+
+// Forward sends the decisions added, and deleted or expired, since the previous call.
+func (f *decisionForwarder) Forward(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	added, err := f.dbClient.QueryNewDecisionsSinceWithFilters(ctx, f.addedUntil, forwardFilter())
+	if err != nil {
+		return fmt.Errorf("while getting new decisions: %w", err)
+	}
+
+	// by date of update, the cursor is moved after each chunk
+	slices.SortStableFunc(added, func(a, b *ent.Decision) int {
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	})
+
+	alerts := make([]*models.Alert, 0, len(added))
+	dates := make([]time.Time, 0, len(added))
+
+	for _, d := range added {
+		// it will be sent next time
+		if d.UpdatedAt.After(now) {
+			break
+		}
+
+		alerts = append(alerts, forwardedAlert(d, now))
+		dates = append(dates, d.UpdatedAt)
+	}
+
+	for start := 0; start < len(alerts); start += forwardBatchSize {
+		end := min(start+forwardBatchSize, len(alerts))
+
+		if _, _, err := f.apiClient.Alerts.Add(ctx, alerts[start:end]); err != nil {
+			return fmt.Errorf("while sending %d decisions (%d already forwarded): %w", end-start, start, err)
+		}
+
+		// the chunks already sent are skipped next time, unless the next chunk starts
+		// with a decision of the same date: the cursor can't move past it
+		if end == len(alerts) || dates[end].After(dates[end-1]) {
+			until := dates[end-1]
+			f.addedUntil = &until
+		}
+	}
+
+	if len(alerts) > 0 {
+		log.Infof("forwarded %d new decisions to %s", len(alerts), f.apiClient.BaseURL)
+	}
+
+	f.addedUntil = &now
+
+	deleted, err := f.dbClient.QueryExpiredDecisionsSinceWithFilters(ctx, &f.deletedUntil, forwardFilter())
+	if err != nil {
+		return fmt.Errorf("while getting deleted decisions: %w", err)
+	}
+
+	seen := make(map[apiclient.DecisionsDeleteOpts]bool)
+	nbDeleted := 0
+
+	for _, d := range deleted {
+		if d.Until == nil || d.Until.After(now) {
+			continue
+		}
+
+		opts := apiclient.DecisionsDeleteOpts{
+			ScopeEquals:  d.Scope,
+			ValueEquals:  d.Value,
+			TypeEquals:   d.Type,
+			OriginEquals: d.Origin,
+		}
+
+		if seen[opts] {
+			continue
+		}
+
+		seen[opts] = true
+
+		// the delete would also remove the newer decision, which has been forwarded too
+		stillActive, err := f.dbClient.Ent.Decision.Query().
+			Where(
+				decision.ScopeEQ(d.Scope),
+				decision.ValueEQ(d.Value),
+				decision.TypeEQ(d.Type),
+				decision.OriginEQ(d.Origin),
+				decision.UntilGT(now),
+			).
+			Exist(ctx)
+		if err != nil {
+			return fmt.Errorf("while checking active decisions for %s:%s: %w", d.Scope, d.Value, err)
+		}
+
+		if stillActive {
+			continue
+		}
+
+		nbDeleted++
+
+		if _, _, err := f.apiClient.Decisions.Delete(ctx, opts); err != nil {
+			return fmt.Errorf("while deleting decision %s:%s: %w", d.Scope, d.Value, err)
+		}
+	}
+
+	if nbDeleted > 0 {
+		log.Infof("forwarded %d deleted decisions to %s", nbDeleted, f.apiClient.BaseURL)
+	}
+
+	f.deletedUntil = now
+
+	return nil
+}
+
+// Run forwards the decisions every interval until Shutdown is called.
+func (f *decisionForwarder) Run(ctx context.Context) error {
+	defer trace.CatchPanic("lapi/forwardDecisions")
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	log.Infof("Start forwarding the local decisions to %s (interval: %s)", f.apiClient.BaseURL, f.interval)
+
+	for {
+		select {
+		case <-f.tomb.Dying():
+			return nil
+		case <-ticker.C:
+			if err := f.Forward(ctx); err != nil {
+				log.Errorf("while forwarding decisions: %s", err)
+			}
+		}
+	}
+}
+
+// Start runs the forwarder in the background.
+func (f *decisionForwarder) Start(ctx context.Context) {
+	f.running = true
+	f.tomb.Go(func() error { return f.Run(ctx) })
+}
+
+func (f *decisionForwarder) Shutdown() {
+	f.tomb.Kill(nil)
+
+	if !f.running {
+		return
+	}
+
+	if err := f.tomb.Wait(); err != nil {
+		log.Errorf("while stopping the decision forwarder: %s", err)
+	}
+}
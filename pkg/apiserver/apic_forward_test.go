@@ -0,0 +1,302 @@
+package apiserver
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent/decision"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestDecisionForwarder(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	added := []string{}
+	deleted := []url.Values{}
+
+	httpmock.RegisterResponder("POST", "http://central.example.com/v1/alerts", func(req *http.Request) (*http.Response, error) {
+		alerts := models.AddAlertsRequest{}
+		if err := json.NewDecoder(req.Body).Decode(&alerts); err != nil {
+			return nil, err
+		}
+
+		for _, alert := range alerts {
+			require.Len(t, alert.Decisions, 1)
+
+			d := alert.Decisions[0]
+			added = append(added, *d.Origin+":"+*d.Scope+":"+*d.Value+":"+*d.Duration)
+		}
+
+		return httpmock.NewJsonResponse(http.StatusCreated, []string{"1"})
+	})
+
+	httpmock.RegisterResponder("DELETE", "http://central.example.com/v1/decisions", func(req *http.Request) (*http.Response, error) {
+		deleted = append(deleted, req.URL.Query())
+		return httpmock.NewJsonResponse(http.StatusOK, models.DeleteDecisionResponse{NbDeleted: "1"})
+	})
+
+	centralURL, err := url.ParseRequestURI("http://central.example.com/")
+	require.NoError(t, err)
+
+	central, err := apiclient.NewDefaultClient(centralURL, "v1", "", nil)
+	require.NoError(t, err)
+
+	forwarder := newDecisionForwarder(api.dbClient, central, time.Minute)
+
+	alertInstance := api.dbClient.Ent.Alert.Create().
+		SetScenario("crowdsecurity/ssh-bf").
+		SaveX(ctx)
+
+	addDecision := func(origin string, value string) int {
+		return api.dbClient.Ent.Decision.Create().
+			SetOrigin(origin).
+			SetType("ban").
+			SetValue(value).
+			SetScope("Ip").
+			SetScenario("crowdsecurity/ssh-bf").
+			SetUntil(time.Now().Add(time.Hour)).
+			SetOwnerID(alertInstance.ID).
+			SaveX(ctx).ID
+	}
+
+	local := addDecision(types.CrowdSecOrigin, "1.2.3.4")
+	// pulled, not forwarded
+	addDecision(types.CAPIOrigin, "5.6.7.8")
+	addDecision(types.ListOrigin, "5.6.7.9")
+
+	err = forwarder.Forward(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"crowdsec:Ip:1.2.3.4:1h0m0s"}, added)
+	assert.Empty(t, deleted)
+
+	// the decision is deleted locally, and a new one is added
+	api.dbClient.Ent.Decision.UpdateOneID(local).SetUntil(time.Now().UTC()).ExecX(ctx)
+	addDecision(types.CscliOrigin, "9.9.9.9")
+
+	added = []string{}
+
+	err = forwarder.Forward(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"cscli:Ip:9.9.9.9:1h0m0s"}, added)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, url.Values{
+		"scope":  {"Ip"},
+		"value":  {"1.2.3.4"},
+		"type":   {"ban"},
+		"origin": {"crowdsec"},
+	}, deleted[0])
+
+	// a new decision replaces an older one for the same value: the old one must not
+	// be deleted from the secondary API, it would remove the new one too
+	older := addDecision(types.CrowdSecOrigin, "4.4.4.4")
+
+	err = forwarder.Forward(ctx)
+	require.NoError(t, err)
+
+	addDecision(types.CrowdSecOrigin, "4.4.4.4")
+	api.dbClient.Ent.Decision.UpdateOneID(older).SetUntil(time.Now().UTC()).ExecX(ctx)
+
+	added = []string{}
+	deleted = []url.Values{}
+
+	err = forwarder.Forward(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"crowdsec:Ip:4.4.4.4:1h0m0s"}, added)
+	assert.Empty(t, deleted)
+
+	// nothing changed since
+	added = []string{}
+	deleted = []url.Values{}
+
+	err = forwarder.Forward(ctx)
+	require.NoError(t, err)
+
+	assert.Empty(t, added)
+	assert.Empty(t, deleted)
+
+	// nothing is changed locally
+	assert.Equal(t, 4, api.dbClient.Ent.Decision.Query().Where(decision.UntilGT(time.Now())).CountX(ctx))
+}
+
+func TestDecisionForwarderPartialFailure(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	added := []string{}
+	calls := 0
+
+	httpmock.RegisterResponder("POST", "http://central.example.com/v1/alerts", func(req *http.Request) (*http.Response, error) {
+		calls++
+
+		// the second chunk fails the first time
+		if calls == 2 {
+			return httpmock.NewStringResponse(http.StatusInternalServerError, ""), nil
+		}
+
+		var body io.Reader = req.Body
+
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			body = gz
+		}
+
+		alerts := models.AddAlertsRequest{}
+		if err := json.NewDecoder(body).Decode(&alerts); err != nil {
+			return nil, err
+		}
+
+		for _, alert := range alerts {
+			added = append(added, *alert.Source.Value)
+		}
+
+		return httpmock.NewJsonResponse(http.StatusCreated, []string{"1"})
+	})
+
+	centralURL, err := url.ParseRequestURI("http://central.example.com/")
+	require.NoError(t, err)
+
+	central, err := apiclient.NewDefaultClient(centralURL, "v1", "", nil)
+	require.NoError(t, err)
+
+	forwarder := newDecisionForwarder(api.dbClient, central, time.Minute)
+
+	alertInstance := api.dbClient.Ent.Alert.Create().
+		SetScenario("crowdsecurity/ssh-bf").
+		SaveX(ctx)
+
+	start := time.Now().UTC().Add(-time.Hour)
+
+	for i := range forwardBatchSize + 50 {
+		api.dbClient.Ent.Decision.Create().
+			SetOrigin(types.CrowdSecOrigin).
+			SetType("ban").
+			SetValue(fmt.Sprintf("1.2.%d.%d", i/256, i%256)).
+			SetScope("Ip").
+			SetScenario("crowdsecurity/ssh-bf").
+			SetUpdatedAt(start.Add(time.Duration(i) * time.Second)).
+			SetUntil(time.Now().Add(time.Hour)).
+			SetOwnerID(alertInstance.ID).
+			SaveX(ctx)
+	}
+
+	err = forwarder.Forward(ctx)
+	require.ErrorContains(t, err, "while sending 50 decisions (100 already forwarded)")
+	assert.Len(t, added, forwardBatchSize)
+
+	// the first chunk is not sent again
+	added = []string{}
+
+	err = forwarder.Forward(ctx)
+	require.NoError(t, err)
+	assert.Len(t, added, 50)
+	assert.Equal(t, "1.2.0.100", added[0])
+}
+
+func TestDecisionForwarderFromConfig(t *testing.T) {
+	ctx := t.Context()
+
+	forwarded := make(chan string, 10)
+
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/watchers/login":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"code": 200, "expire": %q, "token": "token"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case "POST /v1/alerts":
+			alerts := models.AddAlertsRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			for _, alert := range alerts {
+				forwarded <- *alert.Source.Value
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `["1"]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer central.Close()
+
+	credentials := filepath.Join(t.TempDir(), "central_credentials.yaml")
+	err := os.WriteFile(credentials, fmt.Appendf(nil, "url: %s\nlogin: machine\npassword: secret\n", central.URL), 0o600)
+	require.NoError(t, err)
+
+	config := LoadTestConfig(t)
+	config.API.Server.ListenURI = "127.0.0.1:0"
+	config.API.Server.ForwardDecisions = &csconfig.ForwardDecisionsCfg{
+		CredentialsFilePath: credentials,
+		Interval:            50 * time.Millisecond,
+	}
+
+	err = config.API.Server.ForwardDecisions.Load()
+	require.NoError(t, err)
+
+	apiServer, err := NewServer(ctx, config.API.Server)
+	require.NoError(t, err)
+	require.NotNil(t, apiServer.forwarder)
+
+	alertInstance := apiServer.dbClient.Ent.Alert.Create().
+		SetScenario("crowdsecurity/ssh-bf").
+		SaveX(ctx)
+
+	apiServer.dbClient.Ent.Decision.Create().
+		SetOrigin(types.CrowdSecOrigin).
+		SetType("ban").
+		SetValue("1.2.3.4").
+		SetScope("Ip").
+		SetScenario("crowdsecurity/ssh-bf").
+		SetUntil(time.Now().Add(time.Hour)).
+		SetOwnerID(alertInstance.ID).
+		ExecX(ctx)
+
+	runErr := make(chan error, 1)
+
+	go func() {
+		runErr <- apiServer.Run(make(chan bool, 1))
+	}()
+
+	select {
+	case value := <-forwarded:
+		assert.Equal(t, "1.2.3.4", value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the decision has not been forwarded")
+	}
+
+	require.NoError(t, apiServer.Shutdown())
+	require.NoError(t, <-runErr)
+}
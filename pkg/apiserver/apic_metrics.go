@@ -2,7 +2,10 @@ package apiserver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"slices"
 	"strings"
@@ -19,6 +22,34 @@ import (
 	"github.com/crowdsecurity/crowdsec/pkg/models"
 )
 
+// values of redact_machine_names and redact_bouncer_names
+const (
+	redactHash = "hash"
+	redactOmit = "omit"
+)
+
+func checkRedaction(option string, mode string) error {
+	switch mode {
+	case "", redactHash, redactOmit:
+		return nil
+	default:
+		return fmt.Errorf("invalid %s %q: must be %q or %q", option, mode, redactHash, redactOmit)
+	}
+}
+
+// redactName returns the name to send in the metrics: as is, its SHA-256 hash, or nothing.
+func redactName(mode string, name string) string {
+	switch mode {
+	case redactHash:
+		sum := sha256.Sum256([]byte(name))
+		return hex.EncodeToString(sum[:])
+	case redactOmit:
+		return ""
+	default:
+		return name
+	}
+}
+
 type dbPayload struct {
 	Metrics []*models.DetailedMetrics `json:"metrics"`
 }
@@ -53,7 +84,7 @@ func (a *apic) GetUsageMetrics(ctx context.Context) (*models.AllMetrics, []int,
 		rcMetrics.Type = bouncer.Type
 		rcMetrics.FeatureFlags = strings.Split(bouncer.Featureflags, ",")
 		rcMetrics.Version = ptr.Of(bouncer.Version)
-		rcMetrics.Name = bouncer.Name
+		rcMetrics.Name = redactName(a.redactBouncerNames, bouncer.Name)
 
 		rcMetrics.LastPull = 0
 		if bouncer.LastPull != nil {
@@ -95,7 +126,7 @@ func (a *apic) GetUsageMetrics(ctx context.Context) (*models.AllMetrics, []int,
 		}
 		lpMetrics.FeatureFlags = strings.Split(lp.Featureflags, ",")
 		lpMetrics.Version = ptr.Of(lp.Version)
-		lpMetrics.Name = lp.MachineId
+		lpMetrics.Name = redactName(a.redactMachineNames, lp.MachineId)
 
 		lpMetrics.LastPush = 0
 		if lp.LastPush != nil {
@@ -196,10 +227,15 @@ func (a *apic) GetMetrics(ctx context.Context) (*models.Metrics, error) {
 	for i, machine := range machines {
 		machinesInfo[i] = &models.MetricsAgentInfo{
 			Version:    machine.Version,
-			Name:       machine.MachineId,
+			Name:       redactName(a.redactMachineNames, machine.MachineId),
 			LastUpdate: machine.UpdatedAt.Format(time.RFC3339),
 			LastPush:   ptr.OrEmpty(machine.LastPush).Format(time.RFC3339),
 		}
+
+		if a.shareOSInfo {
+			machinesInfo[i].OsName = machine.Osname
+			machinesInfo[i].OsVersion = machine.Osversion
+		}
 	}
 
 	bouncers, err := a.dbClient.ListBouncers(ctx)
@@ -217,10 +253,15 @@ func (a *apic) GetMetrics(ctx context.Context) (*models.Metrics, error) {
 
 		bouncersInfo[i] = &models.MetricsBouncerInfo{
 			Version:    bouncer.Version,
-			CustomName: bouncer.Name,
+			CustomName: redactName(a.redactBouncerNames, bouncer.Name),
 			Name:       bouncer.Type,
 			LastPull:   lastPull,
 		}
+
+		if a.shareOSInfo {
+			bouncersInfo[i].OsName = bouncer.Osname
+			bouncersInfo[i].OsVersion = bouncer.Osversion
+		}
 	}
 
 	return &models.Metrics{
@@ -246,14 +287,39 @@ func (a *apic) fetchMachineIDs(ctx context.Context) ([]string, error) {
 	return ret, nil
 }
 
+// metricsFlushTimeout bounds the time spent sending the last metrics on shutdown.
+const metricsFlushTimeout = 5 * time.Second
+
+// sendMetrics pushes the current metrics to CAPI. Errors are logged.
+func (a *apic) sendMetrics(ctx context.Context) {
+	metrics, err := a.GetMetrics(ctx)
+	if err != nil {
+		log.Errorf("unable to get metrics (%s)", err)
+	}
+	// metrics are nil if they could not be retrieved
+	if metrics == nil {
+		return
+	}
+
+	log.Info("capi metrics: sending")
+
+	if _, _, err = a.apiClient.Metrics.Add(ctx, metrics); err != nil {
+		log.Errorf("capi metrics: failed: %s", err)
+	}
+}
+
 // SendMetrics sends metrics to the API server until it receives a stop signal.
 //
 // Metrics are sent at start, then at the randomized metricsIntervalFirst,
 // then at regular metricsInterval. If a change is detected in the list
-// of machines, the next metrics are sent immediately.
+// of machines, the next metrics are sent immediately. When metricsTomb
+// dies, the metrics are sent one last time.
 func (a *apic) SendMetrics(ctx context.Context, stop chan bool) {
 	defer trace.CatchPanic("lapi/metricsToAPIC")
 
+	a.sendingMetrics.Store(true)
+	defer a.sendingMetrics.Store(false)
+
 	// verify the list of machines every <checkInt> interval
 	const checkInt = 20 * time.Second
 
@@ -311,19 +377,7 @@ func (a *apic) SendMetrics(ctx context.Context, stop chan bool) {
 		case <-metTicker.C:
 			metTicker.Stop()
 
-			metrics, err := a.GetMetrics(ctx)
-			if err != nil {
-				log.Errorf("unable to get metrics (%s)", err)
-			}
-			// metrics are nil if they could not be retrieved
-			if metrics != nil {
-				log.Info("capi metrics: sending")
-
-				_, _, err = a.apiClient.Metrics.Add(ctx, metrics)
-				if err != nil {
-					log.Errorf("capi metrics: failed: %s", err)
-				}
-			}
+			a.sendMetrics(ctx)
 
 			metTicker.Reset(nextMetInt())
 		case <-a.metricsTomb.Dying(): // if one apic routine is dying, do we kill the others?
@@ -332,6 +386,11 @@ func (a *apic) SendMetrics(ctx context.Context, stop chan bool) {
 			a.pullTomb.Kill(nil)
 			a.pushTomb.Kill(nil)
 
+			// send the metrics of the last interval, the parent context may already be canceled
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), metricsFlushTimeout)
+			a.sendMetrics(flushCtx)
+			cancel()
+
 			return
 		}
 	}
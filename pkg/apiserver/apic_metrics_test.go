@@ -100,3 +100,38 @@ func TestAPICSendMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestAPICSendMetricsOnShutdown(t *testing.T) {
+	ctx := t.Context()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/metrics/", httpmock.NewBytesResponder(200, []byte{}))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apiClient, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api := getAPIC(t, ctx)
+	api.apiClient = apiClient
+	api.metricsInterval = time.Hour
+	api.metricsIntervalFirst = time.Hour
+
+	api.metricsTomb.Go(func() error {
+		api.SendMetrics(ctx, make(chan bool))
+		return nil
+	})
+
+	// wait for the metrics sent at start
+	require.Eventually(t, func() bool {
+		return httpmock.GetCallCountInfo()["POST http://api.crowdsec.net/api/metrics/"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the next ones would be sent in an hour, but they are flushed on shutdown
+	api.Shutdown()
+
+	assert.Equal(t, 2, httpmock.GetCallCountInfo()["POST http://api.crowdsec.net/api/metrics/"])
+}
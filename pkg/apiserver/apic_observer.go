@@ -0,0 +1,42 @@
+package apiserver
+
+import (
+	v1 "github.com/crowdsecurity/crowdsec/pkg/apiserver/controllers/v1"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+)
+
+// DecisionObserver is notified of the decisions added and deleted when pulling the community
+// blocklist and the subscribed blocklists, ie. to forward them to an external system.
+// The methods are called synchronously by the pull, with one batch per alert or deletion list:
+// they must not block.
+type DecisionObserver interface {
+	DecisionsAdded(decisions []*models.Decision)
+	DecisionsDeleted(decisions []*models.Decision)
+}
+
+// SetDecisionObserver registers the observer of the decisions pulled from CAPI.
+// It has no effect if the online API client is disabled.
+func (s *APIServer) SetDecisionObserver(observer DecisionObserver) {
+	if s.apic == nil {
+		return
+	}
+
+	s.apic.decisionObserver = observer
+}
+
+func (a *apic) notifyDecisionsAdded(decisions []*models.Decision) {
+	if a.decisionObserver == nil || len(decisions) == 0 {
+		return
+	}
+
+	a.decisionObserver.DecisionsAdded(decisions)
+}
+
+func (a *apic) notifyDecisionsDeleted(decisions []*ent.Decision) {
+	if a.decisionObserver == nil || len(decisions) == 0 {
+		return
+	}
+
+	a.decisionObserver.DecisionsDeleted(v1.FormatDecisions(decisions))
+}
@@ -0,0 +1,187 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+)
+
+// default number of signal batches kept in the spool
+const spoolMaxBatchesDefault = 1000
+
+const spoolFileSuffix = ".json"
+
+// signalSpool keeps on disk the signal batches that could not be sent to CAPI,
+// to send them again after the next successful push.
+type signalSpool struct {
+	mu         sync.Mutex // protects the files
+	replayMu   sync.Mutex // one replay at a time
+	dir        string
+	maxBatches int // the oldest batches are dropped above this number
+}
+
+func newSignalSpool(dir string, maxBatches int) (*signalSpool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("while creating signal spool directory: %w", err)
+	}
+
+	if maxBatches <= 0 {
+		maxBatches = spoolMaxBatchesDefault
+	}
+
+	return &signalSpool{
+		dir:        dir,
+		maxBatches: maxBatches,
+	}, nil
+}
+
+// files returns the names of the spooled batches, oldest first.
+func (s *signalSpool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []string{}
+
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			ret = append(ret, entry.Name())
+		}
+	}
+
+	// the names start with the spooling time
+	slices.Sort(ret)
+
+	return ret, nil
+}
+
+// add writes a batch to the spool, and drops the oldest ones if there are too many.
+func (s *signalSpool) add(signals []*models.AddSignalsRequestItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := json.Marshal(signals)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, fmt.Sprintf("%020d-*%s", time.Now().UnixNano(), spoolFileSuffix))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	for len(files) > s.maxBatches {
+		log.Warningf("signal spool is full, dropping the oldest batch %s", files[0])
+
+		if err := os.Remove(filepath.Join(s.dir, files[0])); err != nil {
+			return err
+		}
+
+		files = files[1:]
+	}
+
+	return nil
+}
+
+// remove deletes a batch from the spool, unless it has already been dropped.
+func (s *signalSpool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// replay sends the spooled batches, oldest first, and removes them once sent. The batches rejected
+// by CAPI are dropped. It stops at the other failures, the remaining batches are kept for the next time.
+// The spool is not locked while sending, batches can be added in the meantime.
+func (s *signalSpool) replay(ctx context.Context, send func(context.Context, []*models.AddSignalsRequestItem) error) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	files, err := s.files()
+	s.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+
+	for _, name := range files {
+		content, err := os.ReadFile(filepath.Join(s.dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			// dropped because the spool is full
+			continue
+		}
+
+		if err != nil {
+			return sent, err
+		}
+
+		var signals []*models.AddSignalsRequestItem
+
+		if err := json.Unmarshal(content, &signals); err != nil {
+			log.Warningf("dropping unreadable spooled signals %s: %s", name, err)
+
+			if err := s.remove(name); err != nil {
+				return sent, err
+			}
+
+			continue
+		}
+
+		err = send(ctx, signals)
+
+		rejected := (*pushRejectedError)(nil)
+
+		switch {
+		case errors.As(err, &rejected):
+			log.Warningf("dropping spooled signals %s rejected by central API (status %d)", name, rejected.statusCode)
+		case err != nil:
+			return sent, err
+		default:
+			sent++
+		}
+
+		if err := s.remove(name); err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
@@ -0,0 +1,191 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+)
+
+func TestAPICSendSpool(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	spool, err := newSignalSpool(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	api.spool = spool
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	status := http.StatusInternalServerError
+	received := []string{}
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", func(req *http.Request) (*http.Response, error) {
+		signals := models.AddSignalsRequest{}
+		if err := json.NewDecoder(req.Body).Decode(&signals); err != nil {
+			return nil, err
+		}
+
+		if status == http.StatusOK {
+			for _, signal := range signals {
+				received = append(received, *signal.Scenario)
+			}
+		}
+
+		return httpmock.NewBytesResponse(status, []byte{}), nil
+	})
+
+	signal := func(scenario string) *models.AddSignalsRequestItem {
+		return &models.AddSignalsRequestItem{Scenario: ptr.Of(scenario)}
+	}
+
+	// CAPI is down, the batch is spooled
+	err = api.Send(ctx, &models.AddSignalsRequest{signal("crowdsecurity/ssh-bf")})
+	require.Error(t, err)
+
+	files, err := spool.files()
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// it's sent after the next successful push
+	status = http.StatusOK
+
+	err = api.Send(ctx, &models.AddSignalsRequest{signal("crowdsecurity/http-probing")})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"crowdsecurity/http-probing", "crowdsecurity/ssh-bf"}, received)
+	assert.Equal(t, 3, httpmock.GetTotalCallCount())
+
+	files, err = spool.files()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSignalSpoolMaxBatches(t *testing.T) {
+	ctx := t.Context()
+
+	spool, err := newSignalSpool(t.TempDir(), 2)
+	require.NoError(t, err)
+
+	for i := range 3 {
+		err = spool.add([]*models.AddSignalsRequestItem{{Scenario: ptr.Of(fmt.Sprintf("scenario%d", i))}})
+		require.NoError(t, err)
+	}
+
+	files, err := spool.files()
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// the oldest batch has been dropped
+	replayed := []string{}
+
+	sent, err := spool.replay(ctx, func(_ context.Context, signals []*models.AddSignalsRequestItem) error {
+		replayed = append(replayed, *signals[0].Scenario)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"scenario1", "scenario2"}, replayed)
+}
+
+func TestSignalSpoolReplayRejected(t *testing.T) {
+	ctx := t.Context()
+
+	spool, err := newSignalSpool(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	for i := range 4 {
+		err = spool.add([]*models.AddSignalsRequestItem{{Scenario: ptr.Of(fmt.Sprintf("scenario%d", i))}})
+		require.NoError(t, err)
+	}
+
+	replayed := []string{}
+
+	sent, err := spool.replay(ctx, func(_ context.Context, signals []*models.AddSignalsRequestItem) error {
+		scenario := *signals[0].Scenario
+
+		switch scenario {
+		case "scenario0":
+			// the batch is dropped, the following ones are sent
+			return &pushRejectedError{statusCode: http.StatusBadRequest, err: errors.New("bad request")}
+		case "scenario2":
+			// the replay stops, the batch is kept
+			return errors.New("connection refused")
+		}
+
+		replayed = append(replayed, scenario)
+
+		// the spool is not locked while sending
+		return spool.add([]*models.AddSignalsRequestItem{{Scenario: ptr.Of("new")}})
+	})
+	require.EqualError(t, err, "connection refused")
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"scenario1"}, replayed)
+
+	files, err := spool.files()
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	replayed = []string{}
+
+	sent, err = spool.replay(ctx, func(_ context.Context, signals []*models.AddSignalsRequestItem) error {
+		replayed = append(replayed, *signals[0].Scenario)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, []string{"scenario2", "scenario3", "new"}, replayed)
+}
+
+func TestAPICSendRejected(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	spool, err := newSignalSpool(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	api.spool = spool
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", httpmock.NewBytesResponder(http.StatusBadRequest, []byte{}))
+
+	// CAPI refuses the content of the batch, it's not spooled to be sent again
+	err = api.Send(ctx, &models.AddSignalsRequest{{Scenario: ptr.Of("crowdsecurity/ssh-bf")}})
+	require.Error(t, err)
+
+	files, err := spool.files()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -3,20 +3,28 @@ package apiserver
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-openapi/strfmt"
 	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/tomb.v2"
@@ -28,8 +36,10 @@ import (
 	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
 	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
 	"github.com/crowdsecurity/crowdsec/pkg/database"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/decision"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/machine"
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 	"github.com/crowdsecurity/crowdsec/pkg/models"
 	"github.com/crowdsecurity/crowdsec/pkg/modelscapi"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
@@ -69,10 +79,12 @@ func getAPIC(t *testing.T, ctx context.Context) *apic {
 			ShareCustomScenarios:  ptr.Of(false),
 			ShareContext:          ptr.Of(false),
 		},
-		isPulling:      make(chan bool, 1),
-		shareSignals:   true,
-		pullBlocklists: true,
-		pullCommunity:  true,
+		isPulling:       make(chan bool, 1),
+		capiVersion:     capiVersionDefault,
+		defaultDuration: decisionDurationDefault,
+		shareSignals:    true,
+		pullBlocklists:  true,
+		pullCommunity:   true,
 	}
 }
 
@@ -144,6 +156,44 @@ func TestAPICCAPIPullIsOld(t *testing.T) {
 	assert.False(t, isOld)
 }
 
+func TestAPICCAPIPullIsOldStaleAfter(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	decision := api.dbClient.Ent.Decision.Create().
+		SetUntil(time.Now().Add(time.Hour)).
+		SetScenario("crowdsec/test").
+		SetType("IP").
+		SetScope("Country").
+		SetValue("Blah").
+		SetOrigin(types.CAPIOrigin).
+		SaveX(ctx)
+
+	api.dbClient.Ent.Alert.Create().
+		SetCreatedAt(time.Now().Add(-15 * time.Minute)).
+		SetScenario("crowdsec/test").
+		AddDecisions(
+			decision,
+		).
+		SaveX(ctx)
+
+	for _, tc := range []struct {
+		staleAfter time.Duration
+		expected   bool
+	}{
+		{staleAfter: 10 * time.Minute, expected: true},
+		{staleAfter: 20 * time.Minute, expected: false},
+		// default
+		{staleAfter: 0, expected: false},
+	} {
+		api.staleAfter = tc.staleAfter
+
+		isOld, err := api.CAPIPullIsOld(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, isOld, "stale_after %s", tc.staleAfter)
+	}
+}
+
 func TestAPICFetchScenariosListFromDB(t *testing.T) {
 	ctx := t.Context()
 
@@ -157,7 +207,7 @@ func TestAPICFetchScenariosListFromDB(t *testing.T) {
 			machineIDsWithScenarios: map[string]string{
 				"a": "crowdsecurity/http-bf,crowdsecurity/ssh-bf",
 			},
-			expectedScenarios: []string{"crowdsecurity/ssh-bf", "crowdsecurity/http-bf"},
+			expectedScenarios: []string{"crowdsecurity/http-bf", "crowdsecurity/ssh-bf"},
 		},
 		{
 			name: "Multi machine with custom+hub scenarios",
@@ -165,7 +215,23 @@ func TestAPICFetchScenariosListFromDB(t *testing.T) {
 				"a": "crowdsecurity/http-bf,crowdsecurity/ssh-bf,my_scenario",
 				"b": "crowdsecurity/http-bf,crowdsecurity/ssh-bf,foo_scenario",
 			},
-			expectedScenarios: []string{"crowdsecurity/ssh-bf", "crowdsecurity/http-bf", "my_scenario", "foo_scenario"},
+			expectedScenarios: []string{"crowdsecurity/http-bf", "crowdsecurity/ssh-bf", "foo_scenario", "my_scenario"},
+		},
+		{
+			name: "Empty entries and blanks",
+			machineIDsWithScenarios: map[string]string{
+				"a": "a,,b, c ,",
+			},
+			expectedScenarios: []string{"a", "b", "c"},
+		},
+		{
+			name: "Blanks and duplicates across machines",
+			machineIDsWithScenarios: map[string]string{
+				"a": "a,,b, c ,",
+				"b": " a,c,, ,d",
+				"c": "",
+			},
+			expectedScenarios: []string{"a", "b", "c", "d"},
 		},
 	}
 
@@ -188,7 +254,7 @@ func TestAPICFetchScenariosListFromDB(t *testing.T) {
 				api.dbClient.Ent.Machine.Delete().Where(machine.MachineIdEQ(machineID)).ExecX(ctx)
 			}
 
-			assert.ElementsMatch(t, tc.expectedScenarios, scenarios)
+			assert.Equal(t, tc.expectedScenarios, scenarios)
 		})
 	}
 }
@@ -241,6 +307,15 @@ func TestNewAPIC(t *testing.T) {
 			},
 			expectedErr: "first path segment in URL cannot contain colon",
 		},
+		{
+			name:   "invalid redaction",
+			action: func() { testConfig.RedactBouncerNames = "mask" },
+			args: args{
+				dbClient:      getDBClient(t, ctx),
+				consoleConfig: LoadTestConfig(t).API.Server.ConsoleConfig,
+			},
+			expectedErr: `invalid redact_bouncer_names "mask": must be "hash" or "omit"`,
+		},
 	}
 
 	for _, tc := range tests {
@@ -265,6 +340,182 @@ func TestNewAPIC(t *testing.T) {
 	}
 }
 
+func TestAPICBasePath(t *testing.T) {
+	ctx := t.Context()
+
+	config := &csconfig.OnlineApiClientCfg{
+		Credentials: &csconfig.ApiCredentialsCfg{
+			URL:      "http://foobar/",
+			Login:    "foo",
+			Password: "bar",
+		},
+		Sharing: ptr.Of(true),
+		PullConfig: csconfig.CapiPullConfig{
+			Community:  ptr.Of(true),
+			Blocklists: ptr.Of(true),
+		},
+		BasePath:   "/proxy/capi",
+		APIVersion: "v3-beta",
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "http://foobar/proxy/capi/v3-beta/watchers/login", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			models.WatcherAuthResponse{
+				Code:   200,
+				Expire: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+				Token:  "MyToken",
+			},
+		),
+	))
+	httpmock.RegisterResponder("GET", "http://foobar/proxy/capi/v3-beta/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(modelscapi.GetDecisionsStreamResponse{}),
+	))
+
+	api, err := NewAPIC(ctx, config, getDBClient(t, ctx), LoadTestConfig(t).API.Server.ConsoleConfig, nil)
+	require.NoError(t, err)
+
+	_, _, err = api.apiClient.Decisions.GetStreamV3(ctx, apiclient.DecisionsStreamOpts{Startup: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]int{
+		"POST http://foobar/proxy/capi/v3-beta/watchers/login":  1,
+		"GET http://foobar/proxy/capi/v3-beta/decisions/stream": 1,
+	}, httpmock.GetCallCountInfo())
+
+	_, err = api.TestCredentials(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, httpmock.GetCallCountInfo()["POST http://foobar/proxy/capi/v3-beta/watchers/login"])
+}
+
+func TestAPICTestCredentials(t *testing.T) {
+	ctx := t.Context()
+
+	tests := []struct {
+		name        string
+		status      int
+		response    any
+		expectedErr string
+	}{
+		{
+			name:   "valid credentials",
+			status: 200,
+			response: models.WatcherAuthResponse{
+				Code:   200,
+				Expire: "2023-01-12T22:51:43Z",
+				Token:  "MyToken",
+			},
+		},
+		{
+			name:        "invalid credentials",
+			status:      403,
+			response:    models.ErrorResponse{Message: ptr.Of("access forbidden")},
+			expectedErr: "authenticate watcher (foo): API error: access forbidden",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := getAPIC(t, ctx)
+			api.credentials = &csconfig.ApiCredentialsCfg{
+				URL:      "http://foobar/",
+				Login:    "foo",
+				Password: "bar",
+			}
+
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			httpmock.RegisterResponder("POST", "http://foobar/v3/watchers/login", httpmock.NewBytesResponder(
+				tc.status, jsonMarshalX(tc.response),
+			))
+
+			expiration, err := api.TestCredentials(ctx)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			assert.Equal(t, 1, httpmock.GetTotalCallCount())
+
+			// the token is not saved
+			_, _, valid := api.dbClient.LoadAPICToken(ctx, logrus.StandardLogger())
+			assert.False(t, valid)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			assert.Equal(t, time.Date(2023, 1, 12, 22, 51, 43, 0, time.UTC), expiration)
+		})
+	}
+}
+
+func TestAPICTestCredentialsPinned(t *testing.T) {
+	ctx := t.Context()
+
+	logins := 0
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/watchers/login" {
+			logins++
+		}
+
+		_, err := w.Write([]byte(`{"code": 200, "expire": "2030-01-02T15:04:05Z", "token": "MyToken"}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	oldPool := apiclient.CaCertPool
+	apiclient.CaCertPool = pool
+
+	t.Cleanup(func() { apiclient.CaCertPool = oldPool })
+
+	tests := []struct {
+		name        string
+		pins        []string
+		expectedErr string
+	}{
+		{
+			name: "matching pin",
+			pins: []string{apiclient.SPKIHash(server.Certificate())},
+		},
+		{
+			name:        "other pin",
+			pins:        []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+			expectedErr: "the public key of the server certificate does not match the pinned keys",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logins = 0
+
+			api := getAPIC(t, ctx)
+			api.pinnedSPKI = tc.pins
+			api.credentials = &csconfig.ApiCredentialsCfg{
+				URL:      server.URL + "/",
+				Login:    "foo",
+				Password: "bar",
+			}
+
+			_, err := api.TestCredentials(ctx)
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			// the credentials are not sent to a server that is not pinned
+			if tc.expectedErr != "" {
+				assert.Equal(t, 0, logins)
+				return
+			}
+
+			assert.Equal(t, 1, logins)
+		})
+	}
+}
+
 func TestAPICGetMetrics(t *testing.T) {
 	ctx := t.Context()
 
@@ -273,10 +524,12 @@ func TestAPICGetMetrics(t *testing.T) {
 		api.dbClient.Ent.Machine.Delete().ExecX(ctx)
 	}
 	tests := []struct {
-		name           string
-		machineIDs     []string
-		bouncers       []string
-		expectedMetric *models.Metrics
+		name               string
+		machineIDs         []string
+		bouncers           []string
+		redactMachineNames string
+		redactBouncerNames string
+		expectedMetric     *models.Metrics
 	}{
 		{
 			name:       "no bouncers nor machines should still have bouncers/machines keys in output",
@@ -325,11 +578,42 @@ func TestAPICGetMetrics(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:               "redacted names",
+			machineIDs:         []string{"a", "b"},
+			bouncers:           []string{"1", "2"},
+			redactMachineNames: "hash",
+			redactBouncerNames: "omit",
+			expectedMetric: &models.Metrics{
+				ApilVersion: ptr.Of(version.String()),
+				Bouncers: []*models.MetricsBouncerInfo{
+					{
+						LastPull: time.Time{}.Format(time.RFC3339),
+					}, {
+						LastPull: time.Time{}.Format(time.RFC3339),
+					},
+				},
+				Machines: []*models.MetricsAgentInfo{
+					{
+						Name:       "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+						LastPush:   time.Time{}.Format(time.RFC3339),
+						LastUpdate: time.Time{}.Format(time.RFC3339),
+					},
+					{
+						Name:       "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d",
+						LastPush:   time.Time{}.Format(time.RFC3339),
+						LastUpdate: time.Time{}.Format(time.RFC3339),
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			apiClient := getAPIC(t, ctx)
+			apiClient.redactMachineNames = tc.redactMachineNames
+			apiClient.redactBouncerNames = tc.redactBouncerNames
 			cleanUp(apiClient)
 
 			for i, machineID := range tc.machineIDs {
@@ -362,6 +646,67 @@ func TestAPICGetMetrics(t *testing.T) {
 	}
 }
 
+func TestAPICGetMetricsOSInfo(t *testing.T) {
+	ctx := t.Context()
+
+	for _, shareOSInfo := range []bool{true, false} {
+		t.Run(fmt.Sprintf("share_os_info=%t", shareOSInfo), func(t *testing.T) {
+			api := getAPIC(t, ctx)
+			api.shareOSInfo = shareOSInfo
+
+			api.dbClient.Ent.Machine.Create().
+				SetMachineId("a").
+				SetPassword(testPassword.String()).
+				SetIpAddress("1.2.3.4").
+				SetScenarios("crowdsecurity/test").
+				SetVersion("v1.6.0").
+				SetOsname("ubuntu").
+				SetOsversion("24.04").
+				SetLastPush(time.Time{}).
+				SetUpdatedAt(time.Time{}).
+				ExecX(ctx)
+
+			api.dbClient.Ent.Bouncer.Create().
+				SetIPAddress("1.2.3.4").
+				SetName("fw").
+				SetType("crowdsec-firewall-bouncer").
+				SetAPIKey("foobar").
+				SetRevoked(false).
+				SetVersion("v0.0.30").
+				SetOsname("debian").
+				SetOsversion("12").
+				SetLastPull(time.Time{}).
+				ExecX(ctx)
+
+			foundMetrics, err := api.GetMetrics(ctx)
+			require.NoError(t, err)
+
+			expectedBouncer := &models.MetricsBouncerInfo{
+				CustomName: "fw",
+				Name:       "crowdsec-firewall-bouncer",
+				Version:    "v0.0.30",
+				LastPull:   time.Time{}.Format(time.RFC3339),
+			}
+			expectedMachine := &models.MetricsAgentInfo{
+				Name:       "a",
+				Version:    "v1.6.0",
+				LastPush:   time.Time{}.Format(time.RFC3339),
+				LastUpdate: time.Time{}.Format(time.RFC3339),
+			}
+
+			if shareOSInfo {
+				expectedBouncer.OsName = "debian"
+				expectedBouncer.OsVersion = "12"
+				expectedMachine.OsName = "ubuntu"
+				expectedMachine.OsVersion = "24.04"
+			}
+
+			assert.Equal(t, []*models.MetricsBouncerInfo{expectedBouncer}, foundMetrics.Bouncers)
+			assert.Equal(t, []*models.MetricsAgentInfo{expectedMachine}, foundMetrics.Machines)
+		})
+	}
+}
+
 func TestCreateAlertsForDecision(t *testing.T) {
 	httpBfDecisionList := &models.Decision{
 		Origin:   ptr.Of(types.ListOrigin),
@@ -745,9 +1090,159 @@ func TestAPICWhitelists(t *testing.T) {
 	assert.Equal(t, 2, decisionScenarioFreq["crowdsecurity/test1"], 2)
 }
 
+func TestAPICWhitelistAS(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	api.whitelists = &csconfig.CapiWhitelist{AS: []uint{64500}}
+
+	makeDecisions := func() []*models.Decision {
+		decisions := []*models.Decision{}
+		for _, value := range []string{"1.2.3.4", "5.6.7.8", "10.0.0.0/8"} {
+			decisions = append(decisions, &models.Decision{
+				Scenario: ptr.Of("crowdsecurity/test"),
+				Scope:    ptr.Of("Ip"),
+				Value:    ptr.Of(value),
+				Origin:   ptr.Of(types.CAPIOrigin),
+			})
+		}
+
+		return decisions
+	}
+
+	values := func(decisions []*models.Decision) []string {
+		ret := []string{}
+		for _, d := range decisions {
+			ret = append(ret, *d.Value)
+		}
+
+		return ret
+	}
+
+	api.asnResolver = func(ip netip.Addr) (uint, error) {
+		if ip == netip.MustParseAddr("1.2.3.4") {
+			return 64500, nil
+		}
+
+		return 64501, nil
+	}
+
+	decisions := api.ApplyApicWhitelists(ctx, makeDecisions())
+	assert.Equal(t, []string{"5.6.7.8", "10.0.0.0/8"}, values(decisions))
+
+	// without ASN data, nothing is dropped
+	api.asnResolver = func(netip.Addr) (uint, error) {
+		return 0, errASNDataUnavailable
+	}
+
+	decisions = api.ApplyApicWhitelists(ctx, makeDecisions())
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.8", "10.0.0.0/8"}, values(decisions))
+}
+
+func TestAPICPreviewWhitelist(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	alertInstance := api.dbClient.Ent.Alert.Create().
+		SetScenario("update list").
+		SetSourceScope("list:blocklist1").
+		SetSourceValue("list:blocklist1").
+		SaveX(ctx)
+
+	addDecision := func(origin string, scope string, value string, until time.Time) int {
+		return api.dbClient.Ent.Decision.Create().
+			SetOrigin(origin).
+			SetType("ban").
+			SetValue(value).
+			SetScope(scope).
+			SetScenario("crowdsecurity/test").
+			SetUntil(until).
+			SetOwnerID(alertInstance.ID).
+			SaveX(ctx).ID
+	}
+
+	active := time.Now().Add(time.Hour)
+
+	byIP := addDecision(types.CAPIOrigin, "Ip", "1.2.3.4", active)
+	byCIDR := addDecision(types.CAPIOrigin, "Ip", "10.0.0.5", active)
+	fromList := addDecision(types.ListOrigin, "Ip", "10.0.0.6", active)
+	byAS := addDecision(types.CAPIOrigin, "Ip", "9.9.9.9", active)
+	addDecision(types.CAPIOrigin, "Ip", "5.6.7.8", active)
+	// ranges are not checked, like in PullTop
+	addDecision(types.CAPIOrigin, "Range", "10.0.0.0/28", active)
+	// expired
+	addDecision(types.CAPIOrigin, "Ip", "10.0.0.7", time.Now().Add(-time.Hour))
+	// not pulled
+	addDecision(types.CrowdSecOrigin, "Ip", "1.2.3.4", active)
+
+	wl := &csconfig.CapiWhitelist{
+		Ips:   []netip.Addr{netip.MustParseAddr("1.2.3.4")},
+		Cidrs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+	}
+
+	ids := func(decisions []*ent.Decision) []int {
+		ret := []int{}
+		for _, d := range decisions {
+			ret = append(ret, d.ID)
+		}
+
+		return ret
+	}
+
+	decisions, err := api.PreviewWhitelist(ctx, wl)
+	require.NoError(t, err)
+	assert.Equal(t, []int{byIP, byCIDR, fromList}, ids(decisions))
+
+	api.asnResolver = func(ip netip.Addr) (uint, error) {
+		if ip == netip.MustParseAddr("9.9.9.9") {
+			return 64500, nil
+		}
+
+		return 64501, nil
+	}
+
+	wl.AS = []uint{64500}
+
+	decisions, err = api.PreviewWhitelist(ctx, wl)
+	require.NoError(t, err)
+	assert.Equal(t, []int{byIP, byCIDR, fromList, byAS}, ids(decisions))
+
+	api.asnResolver = func(netip.Addr) (uint, error) {
+		return 0, errASNDataUnavailable
+	}
+
+	_, err = api.PreviewWhitelist(ctx, wl)
+	require.ErrorIs(t, err, errASNDataUnavailable)
+
+	// nothing is deleted
+	assertTotalDecisionCount(t, ctx, api.dbClient, 8)
+}
+
+// decisionRecorder is a DecisionObserver that keeps the values of the decisions.
+type decisionRecorder struct {
+	added   []string
+	deleted []string
+}
+
+func (r *decisionRecorder) DecisionsAdded(decisions []*models.Decision) {
+	for _, d := range decisions {
+		r.added = append(r.added, *d.Value)
+	}
+}
+
+func (r *decisionRecorder) DecisionsDeleted(decisions []*models.Decision) {
+	for _, d := range decisions {
+		r.deleted = append(r.deleted, *d.Value)
+	}
+}
+
 func TestAPICPullTop(t *testing.T) {
 	ctx := t.Context()
 	api := getAPIC(t, ctx)
+
+	observer := &decisionRecorder{}
+	api.decisionObserver = observer
+
 	api.dbClient.Ent.Decision.Create().
 		SetOrigin(types.CAPIOrigin).
 		SetType("ban").
@@ -837,9 +1332,15 @@ func TestAPICPullTop(t *testing.T) {
 	require.NoError(t, err)
 
 	api.apiClient = apic
+
+	metrics.LapiLastPullTimestamp.Set(0)
+
 	err = api.PullTop(ctx, false)
 	require.NoError(t, err)
 
+	lastPull := time.Unix(int64(testutil.ToFloat64(metrics.LapiLastPullTimestamp)), 0)
+	assert.WithinDuration(t, time.Now(), lastPull, time.Minute)
+
 	assertTotalDecisionCount(t, ctx, api.dbClient, 5)
 	assertTotalValidDecisionCount(t, api.dbClient, 4)
 	assertTotalAlertCount(t, api.dbClient, 3) // 2 for list sub , 1 for community list.
@@ -868,39 +1369,201 @@ func TestAPICPullTop(t *testing.T) {
 	assert.Equal(t, 1, decisionScenarioFreq["blocklist2"], 1)
 	assert.Equal(t, 1, decisionScenarioFreq["crowdsecurity/test1"], 1)
 	assert.Equal(t, 1, decisionScenarioFreq["crowdsecurity/test2"], 1)
+
+	assert.ElementsMatch(t, []string{"1.2.3.4", "1.2.3.5", "1.2.3.6", "1.2.3.7"}, observer.added)
+	assert.Equal(t, []string{"9.9.9.9"}, observer.deleted)
 }
 
-func TestAPICPullTopBLCacheFirstCall(t *testing.T) {
+func TestAPICPullTopLocalScenariosOnly(t *testing.T) {
 	ctx := t.Context()
-	// no decision in db, no last modified parameter.
-	api := getAPIC(t, ctx)
 
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
+	tests := []struct {
+		name               string
+		localScenariosOnly bool
+		expectedScenarios  []string
+	}{
+		{
+			name:              "disabled",
+			expectedScenarios: []string{"crowdsecurity/http-probing", "crowdsecurity/ssh-bf"},
+		},
+		{
+			name:               "enabled",
+			localScenariosOnly: true,
+			expectedScenarios:  []string{"crowdsecurity/ssh-bf"},
+		},
+	}
 
-	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
-		200, jsonMarshalX(
-			modelscapi.GetDecisionsStreamResponse{
-				New: modelscapi.GetDecisionsStreamResponseNew{
-					&modelscapi.GetDecisionsStreamResponseNewItem{
-						Scenario: ptr.Of("crowdsecurity/test1"),
-						Scope:    ptr.Of("Ip"),
-						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
-							{
-								Value:    ptr.Of("1.2.3.4"),
-								Duration: ptr.Of("24h"),
-							},
-						},
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := getAPIC(t, ctx)
+			api.localScenariosOnly = tc.localScenariosOnly
+
+			api.dbClient.Ent.Machine.Create().
+				SetMachineId("machine").
+				SetPassword(testPassword.String()).
+				SetIpAddress("1.2.3.4").
+				SetScenarios("crowdsecurity/ssh-bf,crowdsecurity/nginx-bf").
+				ExecX(ctx)
+
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+				200, jsonMarshalX(
+					modelscapi.GetDecisionsStreamResponse{
+						New: modelscapi.GetDecisionsStreamResponseNew{
+							&modelscapi.GetDecisionsStreamResponseNewItem{
+								Scenario: ptr.Of("crowdsecurity/ssh-bf"),
+								Scope:    ptr.Of("Ip"),
+								Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+									{Value: ptr.Of("1.2.3.4"), Duration: ptr.Of("24h")},
+								},
+							},
+							// not run by the machine
+							&modelscapi.GetDecisionsStreamResponseNewItem{
+								Scenario: ptr.Of("crowdsecurity/http-probing"),
+								Scope:    ptr.Of("Ip"),
+								Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+									{Value: ptr.Of("1.2.3.5"), Duration: ptr.Of("24h")},
+									{Value: ptr.Of("1.2.3.6"), Duration: ptr.Of("24h")},
+								},
+							},
+						},
+					},
+				),
+			))
+
+			url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+			require.NoError(t, err)
+
+			apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+			require.NoError(t, err)
+
+			api.apiClient = apic
+
+			err = api.PullTop(ctx, false)
+			require.NoError(t, err)
+
+			scenarios := api.dbClient.Ent.Decision.Query().
+				Order(ent.Asc(decision.FieldScenario)).
+				Select(decision.FieldScenario).
+				StringsX(ctx)
+			assert.Equal(t, tc.expectedScenarios, slices.Compact(scenarios))
+		})
+	}
+}
+
+func TestAPICWarmupPull(t *testing.T) {
+	ctx := t.Context()
+
+	setup := func(t *testing.T, responder httpmock.Responder) *apic {
+		api := getAPIC(t, ctx)
+
+		api.dbClient.Ent.Machine.Create().
+			SetMachineId("machine").
+			SetPassword(testPassword.String()).
+			SetIpAddress("1.2.3.4").
+			SetScenarios("crowdsecurity/ssh-bf").
+			ExecX(ctx)
+
+		httpmock.Activate()
+		t.Cleanup(httpmock.DeactivateAndReset)
+
+		httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", responder)
+
+		url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+		require.NoError(t, err)
+
+		apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+		require.NoError(t, err)
+
+		api.apiClient = apic
+
+		return api
+	}
+
+	t.Run("decisions are stored before returning", func(t *testing.T) {
+		api := setup(t, httpmock.NewBytesResponder(
+			200, jsonMarshalX(
+				modelscapi.GetDecisionsStreamResponse{
+					New: modelscapi.GetDecisionsStreamResponseNew{
+						&modelscapi.GetDecisionsStreamResponseNewItem{
+							Scenario: ptr.Of("crowdsecurity/ssh-bf"),
+							Scope:    ptr.Of("Ip"),
+							Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+								{Value: ptr.Of("1.2.3.4"), Duration: ptr.Of("24h")},
+							},
+						},
 					},
 				},
-				Links: &modelscapi.GetDecisionsStreamResponseLinks{
-					Blocklists: []*modelscapi.BlocklistLink{
-						{
-							URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
-							Name:        ptr.Of("blocklist1"),
-							Scope:       ptr.Of("Ip"),
-							Remediation: ptr.Of("ban"),
-							Duration:    ptr.Of("24h"),
+			),
+		))
+
+		err := api.WarmupPull(ctx)
+		require.NoError(t, err)
+		assertTotalDecisionCount(t, ctx, api.dbClient, 1)
+	})
+
+	t.Run("CAPI hangs", func(t *testing.T) {
+		api := setup(t, func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+		api.warmupTimeout = 100 * time.Millisecond
+
+		start := time.Now()
+		err := api.WarmupPull(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assertTotalDecisionCount(t, ctx, api.dbClient, 0)
+
+		// the next pull is not prevented
+		assert.Zero(t, api.dbClient.Ent.Lock.Query().CountX(ctx))
+	})
+}
+
+func TestAPICPullTopNonIPScope(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	// the username looks like a whitelisted IP
+	api.whitelists = &csconfig.CapiWhitelist{Ips: []netip.Addr{netip.MustParseAddr("1.2.3.4")}}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("username"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test2"),
+						Scope:    ptr.Of("session"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("d41d8cd98f00b204"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test3"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
 						},
 					},
 				},
@@ -908,69 +1571,34 @@ func TestAPICPullTopBLCacheFirstCall(t *testing.T) {
 		),
 	))
 
-	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
-		assert.Empty(t, req.Header.Get("If-Modified-Since"))
-		return httpmock.NewStringResponse(200, "1.2.3.4"), nil
-	})
-
 	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
 	require.NoError(t, err)
 
-	apic, err := apiclient.NewDefaultClient(
-		url,
-		"/api",
-		"",
-		nil,
-	)
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
 	require.NoError(t, err)
 
 	api.apiClient = apic
-	err = api.PullTop(ctx, false)
-	require.NoError(t, err)
 
-	blocklistConfigItemName := "blocklist:blocklist1:last_pull"
-	lastPullTimestamp, err := api.dbClient.GetConfigItem(ctx, blocklistConfigItemName)
+	err = api.PullTop(ctx, false)
 	require.NoError(t, err)
-	assert.NotEmpty(t, lastPullTimestamp)
 
-	// new call should return 304 and should not change lastPullTimestamp
-	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
-		assert.NotEmpty(t, req.Header.Get("If-Modified-Since"))
-		return httpmock.NewStringResponse(304, ""), nil
-	})
+	// the IP is whitelisted, the username and the session are not
+	decisions := api.dbClient.Ent.Decision.Query().Order(ent.Asc(decision.FieldScope)).AllX(ctx)
+	require.Len(t, decisions, 2)
 
-	err = api.PullTop(ctx, false)
-	require.NoError(t, err)
-	secondLastPullTimestamp, err := api.dbClient.GetConfigItem(ctx, blocklistConfigItemName)
-	require.NoError(t, err)
-	assert.Equal(t, lastPullTimestamp, secondLastPullTimestamp)
+	assert.Equal(t, "session", decisions[0].Scope)
+	assert.Equal(t, "d41d8cd98f00b204", decisions[0].Value)
+	assert.Equal(t, "username", decisions[1].Scope)
+	assert.Equal(t, "1.2.3.4", decisions[1].Value)
 }
 
-func TestAPICPullTopBLCacheForceCall(t *testing.T) {
+func TestAPICPullTopTwice(t *testing.T) {
 	ctx := t.Context()
 	api := getAPIC(t, ctx)
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	// create a decision about to expire. It should force fetch
-	alertInstance := api.dbClient.Ent.Alert.
-		Create().
-		SetScenario("update list").
-		SetSourceScope("list:blocklist1").
-		SetSourceValue("list:blocklist1").
-		SaveX(ctx)
-
-	api.dbClient.Ent.Decision.Create().
-		SetOrigin(types.ListOrigin).
-		SetType("ban").
-		SetValue("9.9.9.9").
-		SetScope("Ip").
-		SetScenario("blocklist1").
-		SetUntil(time.Now().Add(time.Hour)).
-		SetOwnerID(alertInstance.ID).
-		ExecX(ctx)
-
 	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
 		200, jsonMarshalX(
 			modelscapi.GetDecisionsStreamResponse{
@@ -983,6 +1611,10 @@ func TestAPICPullTopBLCacheForceCall(t *testing.T) {
 								Value:    ptr.Of("1.2.3.4"),
 								Duration: ptr.Of("24h"),
 							},
+							{
+								Value:    ptr.Of("1.2.3.5"),
+								Duration: ptr.Of("24h"),
+							},
 						},
 					},
 				},
@@ -1001,10 +1633,9 @@ func TestAPICPullTopBLCacheForceCall(t *testing.T) {
 		),
 	))
 
-	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
-		assert.Empty(t, req.Header.Get("If-Modified-Since"))
-		return httpmock.NewStringResponse(304, ""), nil
-	})
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.6",
+	))
 
 	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
 	require.NoError(t, err)
@@ -1018,21 +1649,68 @@ func TestAPICPullTopBLCacheForceCall(t *testing.T) {
 	require.NoError(t, err)
 
 	api.apiClient = apic
-	err = api.PullTop(ctx, false)
+
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+
+	assertTotalDecisionCount(t, ctx, api.dbClient, 3)
+
+	first := api.dbClient.Ent.Decision.Query().Order(ent.Asc(decision.FieldID)).WithOwner().AllX(ctx)
+
+	err = api.PullTop(ctx, true)
 	require.NoError(t, err)
+
+	// the decisions are updated, not inserted again
+	assertTotalDecisionCount(t, ctx, api.dbClient, 3)
+	assertTotalValidDecisionCount(t, api.dbClient, 3)
+
+	second := api.dbClient.Ent.Decision.Query().Order(ent.Asc(decision.FieldID)).WithOwner().AllX(ctx)
+	require.Len(t, second, 3)
+
+	for i, d := range second {
+		assert.Equal(t, first[i].ID, d.ID)
+		assert.Equal(t, first[i].Value, d.Value)
+		assert.False(t, d.Until.Before(*first[i].Until))
+		// they belong to the alerts of the last pull
+		assert.NotEqual(t, first[i].Edges.Owner.ID, d.Edges.Owner.ID)
+	}
+
+	sourceScopes := map[string]int{}
+
+	for _, d := range second {
+		sourceScopes[d.Edges.Owner.SourceScope]++
+	}
+
+	assert.Equal(t, map[string]int{types.CommunityBlocklistPullSourceScope: 2, "lists:blocklist1": 1}, sourceScopes)
 }
 
-func TestAPICPullBlocklistCall(t *testing.T) {
+func TestAPICPullTopTwiceStream(t *testing.T) {
 	ctx := t.Context()
 	api := getAPIC(t, ctx)
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
-		assert.Empty(t, req.Header.Get("If-Modified-Since"))
-		return httpmock.NewStringResponse(200, "1.2.3.4"), nil
-	})
+	streamResponder := func(duration string) httpmock.Responder {
+		return httpmock.NewBytesResponder(
+			200, jsonMarshalX(
+				modelscapi.GetDecisionsStreamResponse{
+					New: modelscapi.GetDecisionsStreamResponseNew{
+						&modelscapi.GetDecisionsStreamResponseNewItem{
+							Scenario: ptr.Of("crowdsecurity/test1"),
+							Scope:    ptr.Of("Ip"),
+							Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+								{
+									Value:    ptr.Of("1.2.3.4"),
+									Duration: ptr.Of(duration),
+								},
+							},
+						},
+					},
+				},
+			),
+		)
+	}
 
 	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
 	require.NoError(t, err)
@@ -1046,294 +1724,2970 @@ func TestAPICPullBlocklistCall(t *testing.T) {
 	require.NoError(t, err)
 
 	api.apiClient = apic
-	err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
-		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
-		Name:        ptr.Of("blocklist1"),
-		Scope:       ptr.Of("Ip"),
-		Remediation: ptr.Of("ban"),
-		Duration:    ptr.Of("24h"),
-	}, true)
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", streamResponder("24h"))
+
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+
+	// a bouncer pulls the stream after the first pull
+	lastPull := time.Now().UTC()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", streamResponder("48h"))
+
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+
+	assertTotalDecisionCount(t, ctx, api.dbClient, 1)
+
+	// the decision is updated, the bouncer receives the new expiration on its next pull
+	decisions, err := api.dbClient.QueryNewDecisionsSinceWithFilters(ctx, &lastPull, map[string][]string{})
 	require.NoError(t, err)
+	require.Len(t, decisions, 1)
+
+	assert.Equal(t, "1.2.3.4", decisions[0].Value)
+	assert.WithinDuration(t, time.Now().UTC().Add(48*time.Hour), *decisions[0].Until, time.Minute)
 }
 
-func TestAPICPush(t *testing.T) {
+func TestAPICPullTopNoCommunity(t *testing.T) {
 	ctx := t.Context()
-	tests := []struct {
-		name          string
-		alerts        []*models.Alert
-		expectedCalls int
-	}{
-		{
-			name: "simple single alert",
-			alerts: []*models.Alert{
-				{
-					Scenario:        ptr.Of("crowdsec/test"),
-					ScenarioHash:    ptr.Of("certified"),
-					ScenarioVersion: ptr.Of("v1.0"),
-					Simulated:       ptr.Of(false),
-					Source:          &models.Source{},
-				},
-			},
-			expectedCalls: 1,
-		},
-		{
-			name: "simulated alert is not pushed",
-			alerts: []*models.Alert{
-				{
-					Scenario:        ptr.Of("crowdsec/test"),
-					ScenarioHash:    ptr.Of("certified"),
-					ScenarioVersion: ptr.Of("v1.0"),
-					Simulated:       ptr.Of(true),
-					Source:          &models.Source{},
-				},
-			},
-			expectedCalls: 0,
-		},
-		{
-			name:          "1 request per 50 alerts",
-			expectedCalls: 2,
-			alerts: func() []*models.Alert {
-				alerts := make([]*models.Alert, 100)
-				for i := range 100 {
-					alerts[i] = &models.Alert{
-						Scenario:        ptr.Of("crowdsec/test"),
-						ScenarioHash:    ptr.Of("certified"),
-						ScenarioVersion: ptr.Of("v1.0"),
-						Simulated:       ptr.Of(false),
-						Source:          &models.Source{},
-					}
-				}
+	api := getAPIC(t, ctx)
+	api.pullCommunity = false
 
-				return alerts
-			}(),
-		},
-	}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			api := getAPIC(t, ctx)
-			api.pushInterval = time.Millisecond
-			api.pushIntervalFirst = time.Millisecond
-			url, err := url.ParseRequestURI("http://api.crowdsec.net/")
-			require.NoError(t, err)
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "false", req.URL.Query().Get("community_pull"))
 
-			httpmock.Activate()
-			defer httpmock.DeactivateAndReset()
+		// community decisions are sent anyway, they must be ignored
+		return httpmock.NewBytesResponse(200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+				},
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+							Name:        ptr.Of("blocklist1"),
+							Scope:       ptr.Of("Ip"),
+							Remediation: ptr.Of("ban"),
+							Duration:    ptr.Of("24h"),
+						},
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/blocklist2"),
+							Name:        ptr.Of("blocklist2"),
+							Scope:       ptr.Of("Ip"),
+							Remediation: ptr.Of("ban"),
+							Duration:    ptr.Of("24h"),
+						},
+					},
+				},
+			},
+		)), nil
+	})
 
-			apic, err := apiclient.NewDefaultClient(
-				url,
-				"/api",
-				"",
-				nil,
-			)
-			require.NoError(t, err)
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.6",
+	))
 
-			api.apiClient = apic
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist2", httpmock.NewStringResponder(
+		200, "1.2.3.7",
+	))
 
-			httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", httpmock.NewBytesResponder(200, []byte{}))
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
 
-			// capture the alerts to avoid datarace
-			alerts := tc.alerts
-			go func() {
-				api.AlertsAddChan <- alerts
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
 
-				time.Sleep(time.Second)
-				api.Shutdown()
-			}()
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
 
-			err = api.Push(ctx)
-			require.NoError(t, err)
-			assert.Equal(t, tc.expectedCalls, httpmock.GetTotalCallCount())
-		})
+	assertTotalValidDecisionCount(t, api.dbClient, 2)
+	assertTotalAlertCount(t, api.dbClient, 2) // only the list subscriptions
+
+	alertScenario := make(map[string]int)
+
+	for _, alert := range api.dbClient.Ent.Alert.Query().AllX(ctx) {
+		alertScenario[alert.SourceScope]++
+	}
+
+	assert.Equal(t, map[string]int{"lists:blocklist1": 1, "lists:blocklist2": 1}, alertScenario)
+
+	for _, decision := range api.dbClient.Ent.Decision.Query().AllX(ctx) {
+		assert.Equal(t, types.ListOrigin, decision.Origin)
 	}
 }
 
-func TestAPICPull(t *testing.T) {
+func TestAPICPullTopScenarioRemap(t *testing.T) {
 	ctx := t.Context()
 	api := getAPIC(t, ctx)
-	tests := []struct {
-		name                  string
-		setUp                 func()
-		expectedDecisionCount int
-		logContains           string
-	}{
-		{
-			name:        "test pull if no scenarios are present",
-			setUp:       func() {},
-			logContains: "scenario list is empty, will not pull yet",
-		},
-		{
-			name: "test pull",
-			setUp: func() {
-				api.dbClient.Ent.Machine.Create().
-					SetMachineId("1.2.3.4").
-					SetPassword(testPassword.String()).
-					SetIpAddress("1.2.3.4").
-					SetScenarios("crowdsecurity/ssh-bf").
-					ExecX(ctx)
+	api.scenarioRemap = map[string]string{"crowdsecurity/test1": "internal/test1"}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
+							{
+								Value:    ptr.Of("1.2.3.5"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test2"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.6"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+				},
 			},
-			expectedDecisionCount: 1,
-		},
+		),
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	scenarios := map[string]string{}
+	for _, d := range api.dbClient.Ent.Decision.Query().AllX(ctx) {
+		scenarios[d.Value] = d.Scenario
 	}
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			api = getAPIC(t, ctx)
-			api.pullInterval = time.Millisecond
-			api.pullIntervalFirst = time.Millisecond
-			url, err := url.ParseRequestURI("http://api.crowdsec.net/")
-			require.NoError(t, err)
-			httpmock.Activate()
+	assert.Equal(t, map[string]string{
+		"1.2.3.4": "internal/test1",
+		"1.2.3.5": "internal/test1",
+		"1.2.3.6": "crowdsecurity/test2",
+	}, scenarios)
 
-			defer httpmock.DeactivateAndReset()
+	alert := api.dbClient.Ent.Alert.Query().OnlyX(ctx)
+	assert.Equal(t, "remapped scenarios: internal/test1 (was crowdsecurity/test1)", alert.Message)
+}
 
-			apic, err := apiclient.NewDefaultClient(
-				url,
-				"/api",
-				"",
-				nil,
-			)
-			require.NoError(t, err)
+func TestAPICPullTopSourceScopePrefix(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.sourceScopePrefix = "edge1/"
 
-			api.apiClient = apic
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
 
-			httpmock.RegisterNoResponder(httpmock.NewBytesResponder(200, jsonMarshalX(
-				modelscapi.GetDecisionsStreamResponse{
-					New: modelscapi.GetDecisionsStreamResponseNew{
-						&modelscapi.GetDecisionsStreamResponseNewItem{
-							Scenario: ptr.Of("crowdsecurity/ssh-bf"),
-							Scope:    ptr.Of("Ip"),
-							Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
-								{
-									Value:    ptr.Of("1.2.3.5"),
-									Duration: ptr.Of("24h"),
-								},
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
 							},
 						},
 					},
 				},
-			)))
-			tc.setUp()
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{blocklist},
+				},
+			},
+		),
+	))
 
-			var buf bytes.Buffer
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.5",
+	))
 
-			go func() {
-				logrus.SetOutput(&buf)
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
 
-				if err := api.Pull(ctx); err != nil {
-					panic(err)
-				}
-			}()
+	api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
 
-			// Slightly long because the CI runner for windows are slow, and this can lead to random failure
-			time.Sleep(time.Millisecond * 500)
-			logrus.SetOutput(os.Stderr)
-			assert.Contains(t, buf.String(), tc.logContains)
-			assertTotalDecisionCount(t, ctx, api.dbClient, tc.expectedDecisionCount)
-		})
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	scopes := []string{}
+	for _, alert := range api.dbClient.Ent.Alert.Query().AllX(ctx) {
+		scopes = append(scopes, alert.SourceScope)
+	}
+
+	assert.ElementsMatch(t, []string{"edge1/" + types.CommunityBlocklistPullSourceScope, "edge1/lists:blocklist1"}, scopes)
+
+	// the alert of the blocklist is found with its prefixed scope
+	forcePull, err := api.ShouldForcePullBlocklist(ctx, blocklist)
+	require.NoError(t, err)
+	assert.False(t, forcePull)
+
+	// without their decisions, the pulled alerts are still hidden by include_capi=false,
+	// but not the local alerts with a similar scope
+	api.dbClient.SourceScopePrefix = "edge1/"
+	api.dbClient.Ent.Decision.Delete().ExecX(ctx)
+
+	for _, scope := range []string{"edge2/lists:blocklist1", "custom/" + types.CommunityBlocklistPullSourceScope} {
+		api.dbClient.Ent.Alert.Create().
+			SetScenario("crowdsecurity/test1").
+			SetSourceScope(scope).
+			SaveX(ctx)
 	}
+
+	alerts, err := api.dbClient.QueryAlertWithFilter(ctx, map[string][]string{"include_capi": {"false"}})
+	require.NoError(t, err)
+
+	scopes = []string{}
+	for _, alert := range alerts {
+		scopes = append(scopes, alert.SourceScope)
+	}
+
+	assert.ElementsMatch(t, []string{"edge2/lists:blocklist1", "custom/" + types.CommunityBlocklistPullSourceScope}, scopes)
 }
 
-func TestShouldShareAlert(t *testing.T) {
-	tests := []struct {
+func TestAPICPullTopDecisionMetadata(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewStringResponder(
+		200, `{
+  "new": [
+    {
+      "scenario": "crowdsecurity/test1",
+      "scope": "Ip",
+      "decisions": [
+        {"value": "1.2.3.4", "duration": "24h", "metadata": {"scenario_version": "0.3", "threat_type": "bruteforce"}},
+        {"value": "1.2.3.5", "duration": "24h"}
+      ]
+    }
+  ],
+  "deleted": []
+}`,
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	metadata := map[string]map[string]string{}
+	for _, d := range api.dbClient.Ent.Decision.Query().AllX(ctx) {
+		metadata[d.Value] = d.Metadata
+	}
+
+	assert.Equal(t, map[string]map[string]string{
+		"1.2.3.4": {"scenario_version": "0.3", "threat_type": "bruteforce"},
+		"1.2.3.5": nil,
+	}, metadata)
+}
+
+func TestAPICPullTopInvalidDuration(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.defaultDuration = time.Hour
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
+							{
+								Value:    ptr.Of("1.2.3.5"),
+								Duration: ptr.Of("banana"),
+							},
+						},
+					},
+				},
+			},
+		),
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	assertTotalDecisionCount(t, ctx, api.dbClient, 2)
+
+	valid := api.dbClient.Ent.Decision.Query().Where(decision.ValueEQ("1.2.3.4")).OnlyX(ctx)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), *valid.Until, time.Minute)
+
+	// the invalid duration is replaced by the default one
+	invalid := api.dbClient.Ent.Decision.Query().Where(decision.ValueEQ("1.2.3.5")).OnlyX(ctx)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *invalid.Until, time.Minute)
+
+	cstest.RequireLogContains(t, hook, `decision 1.2.3.5 has an invalid duration "banana", using 1h0m0s`)
+}
+
+func TestAPICPullTopBLCacheFirstCall(t *testing.T) {
+	ctx := t.Context()
+	// no decision in db, no last modified parameter.
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+				},
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+							Name:        ptr.Of("blocklist1"),
+							Scope:       ptr.Of("Ip"),
+							Remediation: ptr.Of("ban"),
+							Duration:    ptr.Of("24h"),
+						},
+					},
+				},
+			},
+		),
+	))
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
+		assert.Empty(t, req.Header.Get("If-Modified-Since"))
+		return httpmock.NewStringResponse(200, "1.2.3.4"), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	blocklistConfigItemName := "blocklist:blocklist1:last_pull"
+	lastPullTimestamp, err := api.dbClient.GetConfigItem(ctx, blocklistConfigItemName)
+	require.NoError(t, err)
+	assert.NotEmpty(t, lastPullTimestamp)
+
+	// new call should return 304 and should not change lastPullTimestamp
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
+		assert.NotEmpty(t, req.Header.Get("If-Modified-Since"))
+		return httpmock.NewStringResponse(304, ""), nil
+	})
+
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+	secondLastPullTimestamp, err := api.dbClient.GetConfigItem(ctx, blocklistConfigItemName)
+	require.NoError(t, err)
+	assert.Equal(t, lastPullTimestamp, secondLastPullTimestamp)
+}
+
+func TestAPICPullTopStreamCache(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", func(req *http.Request) (*http.Response, error) {
+		assert.Empty(t, req.Header.Get("If-None-Match"))
+		assert.Empty(t, req.Header.Get("If-Modified-Since"))
+
+		resp, err := httpmock.NewJsonResponse(200, modelscapi.GetDecisionsStreamResponse{
+			New: modelscapi.GetDecisionsStreamResponseNew{
+				&modelscapi.GetDecisionsStreamResponseNewItem{
+					Scenario: ptr.Of("crowdsecurity/test1"),
+					Scope:    ptr.Of("Ip"),
+					Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+						{
+							Value:    ptr.Of("1.2.3.4"),
+							Duration: ptr.Of("24h"),
+						},
+					},
+				},
+			},
+			Links: &modelscapi.GetDecisionsStreamResponseLinks{
+				Blocklists: []*modelscapi.BlocklistLink{
+					{
+						URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+						Name:        ptr.Of("blocklist1"),
+						Scope:       ptr.Of("Ip"),
+						Remediation: ptr.Of("ban"),
+						Duration:    ptr.Of("24h"),
+					},
+				},
+			},
+		})
+		resp.Header.Set("ETag", `"stream-v1"`)
+		resp.Header.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+
+		return resp, err
+	})
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, "1.2.3.5"))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	assertTotalDecisionCount(t, ctx, api.dbClient, 2)
+	assertTotalAlertCount(t, api.dbClient, 2)
+
+	etag, err := api.dbClient.GetConfigItem(ctx, decisionsStreamETagConfigItem)
+	require.NoError(t, err)
+	assert.Equal(t, `"stream-v1"`, etag)
+
+	// forget about the last pull so that CAPIPullIsOld doesn't skip the next one
+	api.dbClient.Ent.Decision.Delete().ExecX(ctx)
+	api.dbClient.Ent.Alert.Delete().ExecX(ctx)
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, `"stream-v1"`, req.Header.Get("If-None-Match"))
+		assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 GMT", req.Header.Get("If-Modified-Since"))
+
+		return httpmock.NewStringResponse(304, ""), nil
+	})
+
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	// the stream wasn't modified, only the blocklist of the last stream has been pulled again
+	assert.Equal(t, 4, httpmock.GetTotalCallCount())
+	assert.Equal(t, 2, httpmock.GetCallCountInfo()["GET http://api.crowdsec.net/blocklist1"])
+
+	decisions := api.dbClient.Ent.Decision.Query().AllX(ctx)
+	require.Len(t, decisions, 1)
+	assert.Equal(t, "1.2.3.5", decisions[0].Value)
+	assert.Equal(t, types.ListOrigin, decisions[0].Origin)
+
+	etag, err = api.dbClient.GetConfigItem(ctx, decisionsStreamETagConfigItem)
+	require.NoError(t, err)
+	assert.Equal(t, `"stream-v1"`, etag)
+}
+
+func TestAPICPullTopBLCacheForceCall(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	// create a decision about to expire. It should force fetch
+	alertInstance := api.dbClient.Ent.Alert.
+		Create().
+		SetScenario("update list").
+		SetSourceScope("list:blocklist1").
+		SetSourceValue("list:blocklist1").
+		SaveX(ctx)
+
+	api.dbClient.Ent.Decision.Create().
+		SetOrigin(types.ListOrigin).
+		SetType("ban").
+		SetValue("9.9.9.9").
+		SetScope("Ip").
+		SetScenario("blocklist1").
+		SetUntil(time.Now().Add(time.Hour)).
+		SetOwnerID(alertInstance.ID).
+		ExecX(ctx)
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/test1"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of("1.2.3.4"),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+				},
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+							Name:        ptr.Of("blocklist1"),
+							Scope:       ptr.Of("Ip"),
+							Remediation: ptr.Of("ban"),
+							Duration:    ptr.Of("24h"),
+						},
+					},
+				},
+			},
+		),
+	))
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
+		assert.Empty(t, req.Header.Get("If-Modified-Since"))
+		return httpmock.NewStringResponse(304, ""), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+}
+
+func TestAPICPullTopMaxBlocklists(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.maxBlocklists = 2
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	blocklistLink := func(name string) *modelscapi.BlocklistLink {
+		return &modelscapi.BlocklistLink{
+			URL:         ptr.Of("http://api.crowdsec.net/" + name),
+			Name:        ptr.Of(name),
+			Scope:       ptr.Of("Ip"),
+			Remediation: ptr.Of("ban"),
+			Duration:    ptr.Of("24h"),
+		}
+	}
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					// the blocklists are picked by name, not in the advertised order
+					Blocklists: []*modelscapi.BlocklistLink{
+						blocklistLink("blocklist3"),
+						blocklistLink("blocklist1"),
+						blocklistLink("blocklist2"),
+					},
+				},
+			},
+		),
+	))
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, "1.2.3.1"))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist2", httpmock.NewStringResponder(200, "1.2.3.2"))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist3", httpmock.NewStringResponder(200, "1.2.3.3"))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, calls["GET http://api.crowdsec.net/blocklist1"])
+	assert.Equal(t, 1, calls["GET http://api.crowdsec.net/blocklist2"])
+	assert.Equal(t, 0, calls["GET http://api.crowdsec.net/blocklist3"])
+
+	values := []string{}
+	for _, d := range api.dbClient.Ent.Decision.Query().Order(ent.Asc(decision.FieldValue)).AllX(ctx) {
+		values = append(values, d.Value)
+	}
+
+	assert.Equal(t, []string{"1.2.3.1", "1.2.3.2"}, values)
+}
+
+func TestAPICPullTopPullBatch(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.tagPullBatch = true
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	pulls := 0
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", func(_ *http.Request) (*http.Response, error) {
+		pulls++
+
+		return httpmock.NewBytesResponse(200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario: ptr.Of("crowdsecurity/ssh-bf"),
+						Scope:    ptr.Of("Ip"),
+						Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+							{
+								Value:    ptr.Of(fmt.Sprintf("1.1.1.%d", pulls)),
+								Duration: ptr.Of("24h"),
+							},
+						},
+					},
+				},
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+							Name:        ptr.Of("blocklist1"),
+							Scope:       ptr.Of("Ip"),
+							Remediation: ptr.Of("ban"),
+							Duration:    ptr.Of("24h"),
+						},
+					},
+				},
+			},
+		)), nil
+	})
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(_ *http.Request) (*http.Response, error) {
+		// 9.9.9.9 is in every pull
+		return httpmock.NewStringResponse(200, fmt.Sprintf("1.2.3.%d\n9.9.9.9", pulls)), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	// the values of each pull, by batch id
+	batches := func() map[string][]string {
+		ret := map[string][]string{}
+
+		for _, d := range api.dbClient.Ent.Decision.Query().Where(decision.UntilGT(time.Now())).Order(ent.Asc(decision.FieldValue)).AllX(ctx) {
+			batchID := d.Metadata[database.PullBatchMetadataKey]
+			ret[batchID] = append(ret[batchID], d.Value)
+		}
+
+		return ret
+	}
+
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+
+	first := batches()
+	require.Len(t, first, 1)
+
+	var firstID string
+
+	for batchID, values := range first {
+		firstID = batchID
+		assert.NotEmpty(t, batchID)
+		assert.Equal(t, []string{"1.1.1.1", "1.2.3.1", "9.9.9.9"}, values)
+	}
+
+	repeatedID := api.dbClient.Ent.Decision.Query().Where(decision.ValueEQ("9.9.9.9")).OnlyIDX(ctx)
+
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+
+	second := batches()
+	require.Len(t, second, 2)
+	assert.Equal(t, []string{"1.1.1.1", "1.2.3.1"}, second[firstID])
+
+	// a tagged decision is not reused, the value now belongs to the second pull only
+	repeated := api.dbClient.Ent.Decision.Query().Where(decision.ValueEQ("9.9.9.9")).OnlyX(ctx)
+	assert.NotEqual(t, repeatedID, repeated.ID)
+	assert.NotEqual(t, firstID, repeated.Metadata[database.PullBatchMetadataKey])
+
+	deleted, err := api.dbClient.DeleteDecisionsByPullBatch(ctx, firstID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	remaining := batches()
+	require.Len(t, remaining, 1)
+	assert.NotContains(t, remaining, firstID)
+
+	for _, values := range remaining {
+		assert.Equal(t, []string{"1.1.1.2", "1.2.3.2", "9.9.9.9"}, values)
+	}
+}
+
+func TestAPICPullBlocklistCall(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(req *http.Request) (*http.Response, error) {
+		assert.Empty(t, req.Header.Get("If-Modified-Since"))
+		return httpmock.NewStringResponse(200, "1.2.3.4"), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}, true)
+	require.NoError(t, err)
+}
+
+func TestAPICPullAllowlist(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	content := `{"value":"10.2.3.4"}`
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/allowlist1", func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-Modified-Since") != "" {
+			return httpmock.NewStringResponse(http.StatusNotModified, ""), nil
+		}
+
+		return httpmock.NewStringResponse(200, content), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	link := &modelscapi.AllowlistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/allowlist1"),
+		Name:        ptr.Of("allowlist1"),
+		ID:          ptr.Of("1"),
+		Description: ptr.Of("test"),
+		CreatedAt:   ptr.Of(strfmt.DateTime(time.Now())),
+	}
+
+	allowlistValues := func() []string {
+		list, err := api.dbClient.GetAllowListByID(ctx, "1", true)
+		require.NoError(t, err)
+
+		values := []string{}
+		for _, item := range list.Edges.AllowlistItems {
+			values = append(values, item.Value)
+		}
+
+		return values
+	}
+
+	// first pull, the allowlist is not known yet
+	err = api.PullAllowlist(ctx, link, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.2.3.4"}, allowlistValues())
+
+	lastPull, err := api.dbClient.GetConfigItem(ctx, "allowlist:allowlist1:last_pull")
+	require.NoError(t, err)
+	require.NotEmpty(t, lastPull)
+
+	// not modified since the last pull: content is kept
+	content = `{"value":"10.2.3.5"}`
+	err = api.PullAllowlist(ctx, link, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.2.3.4"}, allowlistValues())
+
+	// forced refresh: the cache is bypassed and the timestamp updated
+	oldTimestamp := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	err = api.dbClient.SetConfigItem(ctx, "allowlist:allowlist1:last_pull", oldTimestamp)
+	require.NoError(t, err)
+
+	err = api.PullAllowlist(ctx, link, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.2.3.5"}, allowlistValues())
+
+	lastPull, err = api.dbClient.GetConfigItem(ctx, "allowlist:allowlist1:last_pull")
+	require.NoError(t, err)
+	assert.NotEqual(t, oldTimestamp, lastPull)
+
+	assert.Equal(t, 3, httpmock.GetCallCountInfo()["GET http://api.crowdsec.net/allowlist1"])
+}
+
+func TestAPICPullAllowlistsInterval(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.allowlistsInterval = 50 * time.Millisecond
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+							Name:        ptr.Of("blocklist1"),
+							Scope:       ptr.Of("Ip"),
+							Remediation: ptr.Of("ban"),
+							Duration:    ptr.Of("24h"),
+						},
+					},
+					Allowlists: []*modelscapi.AllowlistLink{
+						{
+							URL:         ptr.Of("http://api.crowdsec.net/allowlist1"),
+							Name:        ptr.Of("allowlist1"),
+							ID:          ptr.Of("1"),
+							Description: ptr.Of("test"),
+							CreatedAt:   ptr.Of(strfmt.DateTime(time.Now())),
+						},
+					},
+				},
+			},
+		),
+	))
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, "1.2.3.4"))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/allowlist1", httpmock.NewStringResponder(200, `{"value":"10.2.3.4"}`))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	calls := func(name string) int {
+		return httpmock.GetCallCountInfo()["GET http://api.crowdsec.net/"+name]
+	}
+
+	// the allowlist is pulled with the decisions only the first time it's advertised
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+	err = api.PullTop(ctx, true)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, calls("blocklist1"))
+	assert.Equal(t, 1, calls("allowlist1"))
+	assertTotalValidDecisionCount(t, api.dbClient, 1)
+
+	// the refreshed allowlist is applied to the existing decisions
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/allowlist1", httpmock.NewStringResponder(200, `{"value":"1.2.3.4"}`))
+
+	api.pullTomb.Go(func() error { return api.PullAllowlists(ctx) })
+
+	require.Eventually(t, func() bool {
+		return calls("allowlist1") >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	api.pullTomb.Kill(nil)
+	require.NoError(t, api.pullTomb.Wait())
+
+	assert.Equal(t, 2, calls("blocklist1"))
+	assertTotalValidDecisionCount(t, api.dbClient, 0)
+}
+
+func TestAPICPullBlocklistBackoff(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.blocklistBackoff = 200 * time.Millisecond
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	status := http.StatusInternalServerError
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(_ *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(status, "1.2.3.4"), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	calls := func() int {
+		return httpmock.GetCallCountInfo()["GET http://api.crowdsec.net/blocklist1"]
+	}
+
+	pull := func() {
+		t.Helper()
+		require.NoError(t, api.PullBlocklist(ctx, blocklist, false))
+	}
+
+	// first failure, the list is skipped for 200ms
+	pull()
+	assert.Equal(t, 1, calls())
+	pull()
+	assert.Equal(t, 1, calls())
+
+	// second failure, the list is skipped for 400ms
+	time.Sleep(250 * time.Millisecond)
+	pull()
+	assert.Equal(t, 2, calls())
+	time.Sleep(250 * time.Millisecond)
+	pull()
+	assert.Equal(t, 2, calls())
+
+	// retried after the backoff, the failures are reset on success
+	time.Sleep(200 * time.Millisecond)
+
+	status = http.StatusOK
+
+	pull()
+	assert.Equal(t, 3, calls())
+	assertTotalDecisionCount(t, ctx, api.dbClient, 1)
+
+	backoff, err := api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:backoff")
+	require.NoError(t, err)
+	assert.Empty(t, backoff)
+}
+
+func TestAPICPullBlocklistExplodeAlerts(t *testing.T) {
+	ctx := t.Context()
+
+	for _, explode := range []bool{false, true} {
+		t.Run(fmt.Sprintf("explode=%t", explode), func(t *testing.T) {
+			api := getAPIC(t, ctx)
+			api.explodeAlerts = map[string]bool{"blocklist1": explode}
+
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+				200, "1.2.3.4\n1.2.3.5\n1.2.3.6",
+			))
+
+			url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+			require.NoError(t, err)
+
+			apic, err := apiclient.NewDefaultClient(
+				url,
+				"/api",
+				"",
+				nil,
+			)
+			require.NoError(t, err)
+
+			api.apiClient = apic
+
+			err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+				URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+				Name:        ptr.Of("blocklist1"),
+				Scope:       ptr.Of("Ip"),
+				Remediation: ptr.Of("ban"),
+				Duration:    ptr.Of("24h"),
+			}, false)
+			require.NoError(t, err)
+
+			assertTotalDecisionCount(t, ctx, api.dbClient, 3)
+
+			alerts := api.dbClient.Ent.Alert.Query().WithDecisions().AllX(ctx)
+
+			if !explode {
+				require.Len(t, alerts, 1)
+				assert.Len(t, alerts[0].Edges.Decisions, 3)
+
+				return
+			}
+
+			require.Len(t, alerts, 3)
+
+			values := []string{}
+
+			for _, alert := range alerts {
+				assert.Equal(t, "lists:blocklist1", alert.SourceScope)
+				require.Len(t, alert.Edges.Decisions, 1)
+				assert.Equal(t, alert.Edges.Decisions[0].Value, alert.SourceValue)
+				values = append(values, alert.SourceValue)
+			}
+
+			assert.ElementsMatch(t, []string{"1.2.3.4", "1.2.3.5", "1.2.3.6"}, values)
+		})
+	}
+}
+
+func TestAPICPullBlocklistMinDuration(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.minDecisionDuration = time.Hour
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.4",
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("5m"),
+	}, true)
+	require.NoError(t, err)
+
+	decisions := api.dbClient.Ent.Decision.Query().AllX(ctx)
+	require.Len(t, decisions, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *decisions[0].Until, time.Minute)
+}
+
+func TestAPICPullBlocklistIPv6(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "2001:DB8:0:0::1\n2001:db8:0:1::42/64\n1.2.3.0/24\n2001:db8::zz\n2001:db8::/129",
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}, true)
+	require.NoError(t, err)
+
+	// the malformed values are skipped
+	decisions := api.dbClient.Ent.Decision.Query().Order(ent.Asc(decision.FieldValue)).AllX(ctx)
+	require.Len(t, decisions, 3)
+
+	assert.Equal(t, types.Range, decisions[0].Scope)
+	assert.Equal(t, "1.2.3.0/24", decisions[0].Value)
+	assert.Equal(t, types.Range, decisions[1].Scope)
+	assert.Equal(t, "2001:db8:0:1::/64", decisions[1].Value)
+	assert.Equal(t, types.Ip, decisions[2].Scope)
+	assert.Equal(t, "2001:db8::1", decisions[2].Value)
+}
+
+func TestAPICPullBlocklistEmpty(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	content := "1.2.3.4"
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(_ *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(200, content), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	err = api.PullBlocklist(ctx, blocklist, true)
+	require.NoError(t, err)
+	assertTotalDecisionCount(t, ctx, api.dbClient, 1)
+
+	lastPull, err := api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:last_pull")
+	require.NoError(t, err)
+	require.NotEmpty(t, lastPull)
+
+	// an empty response doesn't touch the existing decisions
+	content = ""
+
+	// last_pull has a one second precision
+	time.Sleep(time.Second)
+
+	err = api.PullBlocklist(ctx, blocklist, true)
+	require.NoError(t, err)
+	assertTotalDecisionCount(t, ctx, api.dbClient, 1)
+
+	newLastPull, err := api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:last_pull")
+	require.NoError(t, err)
+	assert.Equal(t, lastPull, newLastPull)
+
+	// unless the list is allowed to be empty
+	api.allowEmpty = map[string]bool{"blocklist1": true}
+
+	err = api.PullBlocklist(ctx, blocklist, true)
+	require.NoError(t, err)
+
+	newLastPull, err = api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:last_pull")
+	require.NoError(t, err)
+	assert.NotEqual(t, lastPull, newLastPull)
+}
+
+func TestAPICPullBlocklistSameContent(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.checkBlocklistHash = true
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	// no Last-Modified header, the whole content is returned each time
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.4\n1.2.3.5",
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+
+	hash, err := api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:hash")
+	require.NoError(t, err)
+	assert.NotEmpty(t, hash)
+
+	alerts := api.dbClient.Ent.Alert.Query().AllX(ctx)
+	require.Len(t, alerts, 1)
+
+	decisions := api.dbClient.Ent.Decision.Query().AllX(ctx)
+	require.Len(t, decisions, 2)
+
+	// same content: nothing is written
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+
+	assert.Equal(t, alerts[0].ID, api.dbClient.Ent.Alert.Query().OnlyX(ctx).ID)
+	assert.ElementsMatch(t,
+		[]int{decisions[0].ID, decisions[1].ID},
+		api.dbClient.Ent.Decision.Query().IDsX(ctx))
+
+	// the content changed, the list is updated
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.4\n1.2.3.6",
+	))
+
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+
+	newHash, err := api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:hash")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, newHash)
+	// removed entries are kept until they expire
+	assertTotalValidDecisionCount(t, api.dbClient, 3)
+	assert.NotEqual(t, []int{alerts[0].ID}, api.dbClient.Ent.Alert.Query().IDsX(ctx))
+}
+
+func TestAPICPullBlocklistURLChange(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.checkBlocklistHash = true
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.4\n1.2.3.5",
+	))
+
+	// the previous pull conditions don't apply to the new URL
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1-rotated", func(req *http.Request) (*http.Response, error) {
+		assert.Empty(t, req.Header.Get("If-Modified-Since"))
+		return httpmock.NewStringResponse(200, "1.2.3.4\n1.2.3.5"), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+
+	decisions := api.dbClient.Ent.Decision.Query().AllX(ctx)
+	require.Len(t, decisions, 2)
+
+	blocklist.URL = ptr.Of("http://api.crowdsec.net/blocklist1-rotated")
+
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET http://api.crowdsec.net/blocklist1-rotated"])
+
+	// same list, same decisions
+	assert.ElementsMatch(t,
+		[]int{decisions[0].ID, decisions[1].ID},
+		api.dbClient.Ent.Decision.Query().IDsX(ctx))
+	assertTotalValidDecisionCount(t, api.dbClient, 2)
+
+	blocklistURL, err := api.dbClient.GetConfigItem(ctx, "blocklist:blocklist1:url")
+	require.NoError(t, err)
+	assert.Equal(t, "http://api.crowdsec.net/blocklist1-rotated", blocklistURL)
+
+	subscribed, err := api.ListSubscribedBlocklists(ctx)
+	require.NoError(t, err)
+	require.Len(t, subscribed, 1)
+	assert.Equal(t, "blocklist1", subscribed[0].Name)
+	assert.Equal(t, 2, subscribed[0].Decisions)
+}
+
+func TestAPICPullBlocklistAllowlistPrecedence(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	metrics.LapiPulledDecisionsAllowlisted.Reset()
+
+	allowlist, err := api.dbClient.CreateAllowList(ctx, "test", "test", "", false)
+	require.NoError(t, err)
+
+	_, err = api.dbClient.AddToAllowlist(ctx, allowlist, []*models.AllowlistItem{{Value: "1.2.3.4"}})
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.4\n1.2.3.5",
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}, true)
+	require.NoError(t, err)
+
+	// the allowlisted IP is never stored
+	decisions := api.dbClient.Ent.Decision.Query().AllX(ctx)
+	require.Len(t, decisions, 1)
+	assert.Equal(t, "1.2.3.5", decisions[0].Value)
+
+	assert.InDelta(t, 1, testutil.ToFloat64(metrics.LapiPulledDecisionsAllowlisted.WithLabelValues(types.ListOrigin)), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(metrics.LapiPulledDecisionsAllowlisted.WithLabelValues(types.CAPIOrigin)), 0)
+}
+
+func TestAPICListSubscribedBlocklists(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "1.2.3.4\n1.2.3.5",
+	))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist2", httpmock.NewStringResponder(
+		200, "1.2.3.6",
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklists, err := api.ListSubscribedBlocklists(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, blocklists)
+
+	before := time.Now().UTC().Truncate(time.Second)
+
+	for _, name := range []string{"blocklist1", "blocklist2"} {
+		err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+			URL:         ptr.Of("http://api.crowdsec.net/" + name),
+			Name:        ptr.Of(name),
+			Scope:       ptr.Of("Ip"),
+			Remediation: ptr.Of("ban"),
+			Duration:    ptr.Of("24h"),
+		}, true)
+		require.NoError(t, err)
+	}
+
+	blocklists, err = api.ListSubscribedBlocklists(ctx)
+	require.NoError(t, err)
+	require.Len(t, blocklists, 2)
+
+	assert.Equal(t, "blocklist1", blocklists[0].Name)
+	assert.Equal(t, "http://api.crowdsec.net/blocklist1", blocklists[0].URL)
+	assert.Equal(t, 2, blocklists[0].Decisions)
+
+	assert.Equal(t, "blocklist2", blocklists[1].Name)
+	assert.Equal(t, "http://api.crowdsec.net/blocklist2", blocklists[1].URL)
+	assert.Equal(t, 1, blocklists[1].Decisions)
+
+	for _, bl := range blocklists {
+		assert.False(t, bl.LastPull.Before(before), "last pull of %s is too old: %s", bl.Name, bl.LastPull)
+		assert.WithinDuration(t, time.Now().UTC(), bl.LastPull, time.Minute)
+	}
+}
+
+func TestAPICPush(t *testing.T) {
+	ctx := t.Context()
+	tests := []struct {
+		name          string
+		alerts        []*models.Alert
+		expectedCalls int
+	}{
+		{
+			name: "simple single alert",
+			alerts: []*models.Alert{
+				{
+					Scenario:        ptr.Of("crowdsec/test"),
+					ScenarioHash:    ptr.Of("certified"),
+					ScenarioVersion: ptr.Of("v1.0"),
+					Simulated:       ptr.Of(false),
+					Source:          &models.Source{},
+				},
+			},
+			expectedCalls: 1,
+		},
+		{
+			name: "simulated alert is not pushed",
+			alerts: []*models.Alert{
+				{
+					Scenario:        ptr.Of("crowdsec/test"),
+					ScenarioHash:    ptr.Of("certified"),
+					ScenarioVersion: ptr.Of("v1.0"),
+					Simulated:       ptr.Of(true),
+					Source:          &models.Source{},
+				},
+			},
+			expectedCalls: 0,
+		},
+		{
+			name:          "1 request per 50 alerts",
+			expectedCalls: 2,
+			alerts: func() []*models.Alert {
+				alerts := make([]*models.Alert, 100)
+				for i := range 100 {
+					alerts[i] = &models.Alert{
+						Scenario:        ptr.Of("crowdsec/test"),
+						ScenarioHash:    ptr.Of("certified"),
+						ScenarioVersion: ptr.Of("v1.0"),
+						Simulated:       ptr.Of(false),
+						Source:          &models.Source{},
+					}
+				}
+
+				return alerts
+			}(),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := getAPIC(t, ctx)
+			api.pushInterval = time.Millisecond
+			api.pushIntervalFirst = time.Millisecond
+			url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+			require.NoError(t, err)
+
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			apic, err := apiclient.NewDefaultClient(
+				url,
+				"/api",
+				"",
+				nil,
+			)
+			require.NoError(t, err)
+
+			api.apiClient = apic
+
+			httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", httpmock.NewBytesResponder(200, []byte{}))
+
+			// capture the alerts to avoid datarace
+			alerts := tc.alerts
+			go func() {
+				api.AlertsAddChan <- alerts
+
+				time.Sleep(time.Second)
+				api.Shutdown()
+			}()
+
+			err = api.Push(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCalls, httpmock.GetTotalCallCount())
+		})
+	}
+}
+
+func TestAPICPushSimulatedAlert(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.pushInterval = time.Millisecond
+	api.pushIntervalFirst = time.Millisecond
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", httpmock.NewBytesResponder(200, []byte{}))
+
+	before := testutil.ToFloat64(metrics.LapiPushSimulatedAlerts)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	alert := &models.Alert{
+		Scenario:        ptr.Of("crowdsec/test"),
+		ScenarioHash:    ptr.Of("certified"),
+		ScenarioVersion: ptr.Of("v1.0"),
+		Message:         ptr.Of(""),
+		EventsCount:     ptr.Of(int32(1)),
+		StartAt:         ptr.Of(now),
+		StopAt:          ptr.Of(now),
+		Capacity:        ptr.Of(int32(0)),
+		Leakspeed:       ptr.Of(""),
+		Simulated:       ptr.Of(true),
+		Source:          &models.Source{Scope: ptr.Of(types.Ip), Value: ptr.Of("1.2.3.4")},
+	}
+
+	_, err = api.dbClient.CreateAlert(ctx, "", []*models.Alert{alert})
+	require.NoError(t, err)
+
+	stored := api.dbClient.Ent.Alert.Query().AllX(ctx)
+	require.Len(t, stored, 1)
+	assert.True(t, stored[0].Simulated)
+
+	go func() {
+		api.AlertsAddChan <- []*models.Alert{alert}
+
+		time.Sleep(time.Second)
+		api.Shutdown()
+	}()
+
+	err = api.Push(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, httpmock.GetTotalCallCount())
+	assert.InDelta(t, before+1, testutil.ToFloat64(metrics.LapiPushSimulatedAlerts), 0)
+}
+
+func TestAPICPushMaxAge(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.pushMaxAge = time.Hour
+
+	before := testutil.ToFloat64(metrics.LapiPushStaleAlerts)
+
+	makeAlert := func(value string, stopAt time.Time) *models.Alert {
+		return &models.Alert{
+			Scenario:        ptr.Of("crowdsec/test"),
+			ScenarioHash:    ptr.Of("certified"),
+			ScenarioVersion: ptr.Of("v1.0"),
+			Message:         ptr.Of(""),
+			EventsCount:     ptr.Of(int32(1)),
+			StartAt:         ptr.Of(stopAt.Format(time.RFC3339)),
+			StopAt:          ptr.Of(stopAt.Format(time.RFC3339)),
+			Capacity:        ptr.Of(int32(0)),
+			Leakspeed:       ptr.Of(""),
+			Simulated:       ptr.Of(false),
+			Source:          &models.Source{Scope: ptr.Of(types.Ip), Value: ptr.Of(value)},
+		}
+	}
+
+	// an alert buffered by an agent that was offline for a month
+	stale := makeAlert("1.2.3.4", time.Now().UTC().Add(-30*24*time.Hour))
+	recent := makeAlert("1.2.3.5", time.Now().UTC())
+
+	signals := api.alertsToSignals([]*models.Alert{stale, recent})
+	require.Len(t, signals, 1)
+	assert.Equal(t, recent.StopAt, signals[0].StopAt)
+	assert.InDelta(t, before+1, testutil.ToFloat64(metrics.LapiPushStaleAlerts), 0)
+
+	// the filter is disabled by default
+	api.pushMaxAge = 0
+
+	signals = api.alertsToSignals([]*models.Alert{stale, recent})
+	assert.Len(t, signals, 2)
+}
+
+func TestAPICPushMaxContextSize(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.consoleConfig.ShareContext = ptr.Of(true)
+	api.maxContextSize = 512
+
+	before := testutil.ToFloat64(metrics.LapiPushTruncatedContext)
+
+	alert := &models.Alert{
+		Scenario:        ptr.Of("crowdsec/test"),
+		ScenarioHash:    ptr.Of("certified"),
+		ScenarioVersion: ptr.Of("v1.0"),
+		Message:         ptr.Of(""),
+		EventsCount:     ptr.Of(int32(1)),
+		StartAt:         ptr.Of(time.Now().UTC().Format(time.RFC3339)),
+		StopAt:          ptr.Of(time.Now().UTC().Format(time.RFC3339)),
+		Capacity:        ptr.Of(int32(0)),
+		Leakspeed:       ptr.Of(""),
+		Simulated:       ptr.Of(false),
+		Source:          &models.Source{Scope: ptr.Of(types.Ip), Value: ptr.Of("1.2.3.4")},
+		Meta: models.Meta{
+			{Key: "target_uri", Value: `["/login"]`},
+			{Key: "user_agent", Value: strings.Repeat("a", 300)},
+			{Key: "payload", Value: strings.Repeat("b", 1000)},
+			{Key: "method", Value: `["POST"]`},
+		},
+	}
+
+	signals := api.alertsToSignals([]*models.Alert{alert})
+	require.Len(t, signals, 1)
+
+	content, err := json.Marshal(signals[0].Context)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(content), api.maxContextSize)
+
+	// only the largest entry has been dropped, the others keep their order
+	keys := []string{}
+	for _, item := range signals[0].Context {
+		keys = append(keys, item.Key)
+	}
+
+	assert.Equal(t, []string{"target_uri", "user_agent", "method"}, keys)
+	assert.InDelta(t, before+1, testutil.ToFloat64(metrics.LapiPushTruncatedContext), 0)
+
+	// the context is kept whole by default
+	api.maxContextSize = 0
+
+	signals = api.alertsToSignals([]*models.Alert{alert})
+	require.Len(t, signals, 1)
+	assert.Len(t, signals[0].Context, 4)
+	assert.InDelta(t, before+1, testutil.ToFloat64(metrics.LapiPushTruncatedContext), 0)
+}
+
+func TestAPICSendPartialFailure(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	calls := 0
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", func(_ *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return httpmock.NewStringResponse(500, "{}"), nil
+		}
+
+		return httpmock.NewBytesResponse(200, []byte{}), nil
+	})
+
+	signals := make(models.AddSignalsRequest, 100)
+	for i := range signals {
+		signals[i] = &models.AddSignalsRequestItem{Scenario: ptr.Of("crowdsec/test")}
+	}
+
+	err = api.Send(ctx, &signals)
+	cstest.RequireErrorContains(t, err, "failed to send 1/2 signal batches: batch 1/2:")
+	// the second batch is sent even if the first one failed
+	assert.Equal(t, 2, calls)
+}
+
+func TestAPICPushRequestMetrics(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals",
+		httpmock.NewBytesResponder(200, []byte{}).Delay(100*time.Millisecond))
+
+	histogram := func() *dto.Histogram {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.LapiPushDuration.Write(m))
+
+		return m.GetHistogram()
+	}
+
+	metrics.LapiPushResponses.Reset()
+
+	before := histogram()
+
+	signals := models.AddSignalsRequest{&models.AddSignalsRequestItem{Scenario: ptr.Of("crowdsec/test")}}
+	err = api.Send(ctx, &signals)
+	require.NoError(t, err)
+
+	after := histogram()
+	assert.Equal(t, before.GetSampleCount()+1, after.GetSampleCount())
+	assert.GreaterOrEqual(t, after.GetSampleSum()-before.GetSampleSum(), 0.1)
+
+	assert.InDelta(t, 1, testutil.ToFloat64(metrics.LapiPushResponses.WithLabelValues("200")), 0)
+}
+
+func TestAPICPull(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	tests := []struct {
+		name                  string
+		setUp                 func()
+		expectedDecisionCount int
+		logContains           string
+	}{
+		{
+			name:        "test pull if no scenarios are present",
+			setUp:       func() {},
+			logContains: "scenario list is empty, will not pull yet",
+		},
+		{
+			name: "test pull",
+			setUp: func() {
+				api.dbClient.Ent.Machine.Create().
+					SetMachineId("1.2.3.4").
+					SetPassword(testPassword.String()).
+					SetIpAddress("1.2.3.4").
+					SetScenarios("crowdsecurity/ssh-bf").
+					ExecX(ctx)
+			},
+			expectedDecisionCount: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api = getAPIC(t, ctx)
+			api.pullInterval = time.Millisecond
+			api.pullIntervalFirst = time.Millisecond
+			url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+			require.NoError(t, err)
+			httpmock.Activate()
+
+			defer httpmock.DeactivateAndReset()
+
+			apic, err := apiclient.NewDefaultClient(
+				url,
+				"/api",
+				"",
+				nil,
+			)
+			require.NoError(t, err)
+
+			api.apiClient = apic
+
+			httpmock.RegisterNoResponder(httpmock.NewBytesResponder(200, jsonMarshalX(
+				modelscapi.GetDecisionsStreamResponse{
+					New: modelscapi.GetDecisionsStreamResponseNew{
+						&modelscapi.GetDecisionsStreamResponseNewItem{
+							Scenario: ptr.Of("crowdsecurity/ssh-bf"),
+							Scope:    ptr.Of("Ip"),
+							Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+								{
+									Value:    ptr.Of("1.2.3.5"),
+									Duration: ptr.Of("24h"),
+								},
+							},
+						},
+					},
+				},
+			)))
+			tc.setUp()
+
+			var buf bytes.Buffer
+
+			go func() {
+				logrus.SetOutput(&buf)
+
+				if err := api.Pull(ctx); err != nil {
+					panic(err)
+				}
+			}()
+
+			// Slightly long because the CI runner for windows are slow, and this can lead to random failure
+			time.Sleep(time.Millisecond * 500)
+			logrus.SetOutput(os.Stderr)
+			assert.Contains(t, buf.String(), tc.logContains)
+			assertTotalDecisionCount(t, ctx, api.dbClient, tc.expectedDecisionCount)
+		})
+	}
+}
+
+func TestShouldShareAlert(t *testing.T) {
+	tests := []struct {
+		name             string
+		consoleConfig    *csconfig.ConsoleConfig
+		shareSignals     bool
+		neverShareManual bool
+		alert            *models.Alert
+		expectedRet      bool
+		expectedTrust    string
+	}{
+		{
+			name: "custom alert should be shared if config enables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareCustomScenarios: ptr.Of(true),
+			},
+			alert:         &models.Alert{Simulated: ptr.Of(false)},
+			shareSignals:  true,
+			expectedRet:   true,
+			expectedTrust: "custom",
+		},
+		{
+			name: "custom alert should not be shared if config disables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareCustomScenarios: ptr.Of(false),
+			},
+			alert:         &models.Alert{Simulated: ptr.Of(false)},
+			shareSignals:  true,
+			expectedRet:   false,
+			expectedTrust: "custom",
+		},
+		{
+			name: "manual alert should be shared if config enables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareManualDecisions: ptr.Of(true),
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated: ptr.Of(false),
+				Decisions: []*models.Decision{{Origin: ptr.Of(types.CscliOrigin)}},
+			},
+			expectedRet:   true,
+			expectedTrust: "manual",
+		},
+		{
+			name: "manual alert should not be shared if config disables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareManualDecisions: ptr.Of(false),
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated: ptr.Of(false),
+				Decisions: []*models.Decision{{Origin: ptr.Of(types.CscliOrigin)}},
+			},
+			expectedRet:   false,
+			expectedTrust: "manual",
+		},
+		{
+			name: "manual alert should not be shared if never_share_manual is set, even if config enables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareManualDecisions: ptr.Of(true),
+			},
+			shareSignals:     true,
+			neverShareManual: true,
+			alert: &models.Alert{
+				Simulated: ptr.Of(false),
+				Decisions: []*models.Decision{{Origin: ptr.Of(types.CscliOrigin)}},
+			},
+			expectedRet:   false,
+			expectedTrust: "manual",
+		},
+		{
+			name: "never_share_manual doesn't affect the other alerts",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareCustomScenarios: ptr.Of(true),
+				ShareManualDecisions: ptr.Of(true),
+			},
+			shareSignals:     true,
+			neverShareManual: true,
+			alert:            &models.Alert{Simulated: ptr.Of(false)},
+			expectedRet:      true,
+			expectedTrust:    "custom",
+		},
+		{
+			name: "manual alert should be shared if config enables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareTaintedScenarios: ptr.Of(true),
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated:    ptr.Of(false),
+				ScenarioHash: ptr.Of("whateverHash"),
+			},
+			expectedRet:   true,
+			expectedTrust: "tainted",
+		},
+		{
+			name: "manual alert should not be shared if config disables it",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareTaintedScenarios: ptr.Of(false),
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated:    ptr.Of(false),
+				ScenarioHash: ptr.Of("whateverHash"),
+			},
+			expectedRet:   false,
+			expectedTrust: "tainted",
+		},
+		{
+			name: "manual alert should not be shared if global sharing is disabled",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareManualDecisions: ptr.Of(true),
+			},
+			shareSignals: false,
+			alert: &models.Alert{
+				Simulated:    ptr.Of(false),
+				ScenarioHash: ptr.Of("whateverHash"),
+			},
+			expectedRet:   false,
+			expectedTrust: "manual",
+		},
+		{
+			name: "custom alert should not be shared if the scenario is excluded",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareCustomScenarios:   ptr.Of(true),
+				ScenarioSharingExclude: []string{"foo/bar", "myorg/honeypot-*"},
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated: ptr.Of(false),
+				Scenario:  ptr.Of("myorg/honeypot-ssh"),
+			},
+			expectedRet:   false,
+			expectedTrust: "custom",
+		},
+		{
+			name: "custom alert should be shared if the scenario is not excluded",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareCustomScenarios:   ptr.Of(true),
+				ScenarioSharingExclude: []string{"foo/bar", "myorg/honeypot-*"},
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated: ptr.Of(false),
+				Scenario:  ptr.Of("myorg/ssh-bf"),
+			},
+			expectedRet:   true,
+			expectedTrust: "custom",
+		},
+		{
+			name: "simulated alert should not be shared",
+			consoleConfig: &csconfig.ConsoleConfig{
+				ShareCustomScenarios: ptr.Of(true),
+			},
+			shareSignals: true,
+			alert: &models.Alert{
+				Simulated: ptr.Of(true),
+				Scenario:  ptr.Of("myorg/ssh-bf"),
+			},
+			expectedRet:   false,
+			expectedTrust: "custom",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metrics.LapiPushSimulatedAlerts)
+
+			ret := shouldShareAlert(tc.alert, tc.consoleConfig, tc.shareSignals, tc.neverShareManual)
+			assert.Equal(t, tc.expectedRet, ret)
+
+			// counted by alertsToSignals, when the alert is dropped
+			assert.InDelta(t, before, testutil.ToFloat64(metrics.LapiPushSimulatedAlerts), 0)
+		})
+	}
+}
+
+func TestAPICPullBlocklistAuthorization(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1",
+		func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Authorization") != "Bearer s3cr3t" {
+				return httpmock.NewStringResponse(http.StatusUnauthorized, ""), nil
+			}
+
+			return httpmock.NewStringResponse(http.StatusOK, "1.2.3.4"), nil
+		})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	// no credentials
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+	assertTotalDecisionCount(t, ctx, api.dbClient, 0)
+
+	// wrong credentials
+	api.blocklistsAuth = map[string]csconfig.Secret{"blocklist1": "Bearer wrong"}
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+	assertTotalDecisionCount(t, ctx, api.dbClient, 0)
+
+	// credentials by host
+	api.blocklistsAuth = map[string]csconfig.Secret{"api.crowdsec.net": "Bearer s3cr3t"}
+	err = api.PullBlocklist(ctx, blocklist, false)
+	require.NoError(t, err)
+	assertTotalDecisionCount(t, ctx, api.dbClient, 1)
+
+	// credentials by name take precedence
+	api.blocklistsAuth = map[string]csconfig.Secret{
+		"blocklist1":       "Bearer s3cr3t",
+		"api.crowdsec.net": "Bearer wrong",
+	}
+	assert.Equal(t, "Bearer s3cr3t", api.blocklistAuthorization(blocklist))
+}
+
+func TestAPICPullBlocklistProxy(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	// the proxy records the requests and serves the blocklists itself
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		fmt.Fprint(w, "1.2.3.4")
+	}))
+	defer proxy.Close()
+
+	var err error
+
+	api.blocklistProxyClients, err = newBlocklistProxyClients(map[string]string{"blocklist1": proxy.URL}, nil)
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist2", httpmock.NewStringResponder(200, "1.2.3.5"))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	blocklist := func(name string, url string) *modelscapi.BlocklistLink {
+		return &modelscapi.BlocklistLink{
+			URL:         ptr.Of(url),
+			Name:        ptr.Of(name),
+			Scope:       ptr.Of("Ip"),
+			Remediation: ptr.Of("ban"),
+			Duration:    ptr.Of("24h"),
+		}
+	}
+
+	// blocklist1 goes through the proxy
+	err = api.PullBlocklist(ctx, blocklist("blocklist1", "http://blocklists.test/blocklist1"), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://blocklists.test/blocklist1"}, proxied)
+	assert.Equal(t, 0, httpmock.GetTotalCallCount())
+
+	// blocklist2 uses the CAPI client
+	err = api.PullBlocklist(ctx, blocklist("blocklist2", "http://api.crowdsec.net/blocklist2"), false)
+	require.NoError(t, err)
+	assert.Len(t, proxied, 1)
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+
+	assertTotalDecisionCount(t, ctx, api.dbClient, 2)
+
+	// the URL is not in the error, it can contain credentials
+	_, err = newBlocklistProxyClients(map[string]string{"*": "://user:pass@proxy"}, nil)
+	require.EqualError(t, err, "invalid blocklists_proxy URL for *")
+}
+
+func TestAPICBlocklistAttribution(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	blocklist1 := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+	blocklist2 := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist2"),
+		Name:        ptr.Of("blocklist2"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				Links: &modelscapi.GetDecisionsStreamResponseLinks{
+					Blocklists: []*modelscapi.BlocklistLink{blocklist1},
+				},
+			},
+		),
+	))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, "1.2.3.4\n1.2.3.5"))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist2", httpmock.NewStringResponder(200, "1.2.3.6\n1.2.3.7"))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	// blocklist1 through the stream, blocklist2 directly
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	err = api.PullBlocklist(ctx, blocklist2, false)
+	require.NoError(t, err)
+
+	byScenario := map[string][]string{}
+
+	for _, d := range api.dbClient.Ent.Decision.Query().Order(ent.Asc(decision.FieldValue)).WithOwner().AllX(ctx) {
+		assert.Equal(t, types.ListOrigin, d.Origin)
+		// the alert tells the blocklist too
+		assert.Equal(t, types.ListOrigin+":"+d.Scenario, d.Edges.Owner.SourceScope)
+
+		byScenario[d.Scenario] = append(byScenario[d.Scenario], d.Value)
+	}
+
+	assert.Equal(t, map[string][]string{
+		"blocklist1": {"1.2.3.4", "1.2.3.5"},
+		"blocklist2": {"1.2.3.6", "1.2.3.7"},
+	}, byScenario)
+
+	// the decisions of a blocklist can be queried by scenario
+	found, err := api.dbClient.QueryDecisionWithFilter(ctx, map[string][]string{"origin": {types.ListOrigin}, "scenarios_containing": {"blocklist2"}})
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+}
+
+func TestAPICPullBlocklistAggregateRanges(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.aggregateRanges = true
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(
+		200, "10.0.0.0/24\n10.0.0.0/25\n10.0.1.0/24\n192.168.0.128/25\n192.168.0.0/25\n172.16.0.1/24",
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Range"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}, false)
+	require.NoError(t, err)
+
+	alerts := api.dbClient.Ent.Alert.Query().AllX(ctx)
+	require.Len(t, alerts, 1)
+
+	values := []string{}
+	for _, d := range api.dbClient.Ent.Decision.Query().AllX(ctx) {
+		assert.Equal(t, "blocklist1", d.Scenario)
+		assert.Equal(t, "Range", d.Scope)
+		values = append(values, d.Value)
+	}
+
+	assert.ElementsMatch(t, []string{"10.0.0.0/23", "172.16.0.0/24", "192.168.0.0/24"}, values)
+}
+
+func TestAPICPullBlocklistDeleteGracePeriod(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.deleteGracePeriod = 500 * time.Millisecond
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	content := "1.2.3.4\n1.2.3.5"
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", func(_ *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(200, content), nil
+	})
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	activeValues := func() []string {
+		values := []string{}
+		for _, d := range api.dbClient.Ent.Decision.Query().Where(decision.UntilGT(time.Now().UTC())).AllX(ctx) {
+			values = append(values, d.Value)
+		}
+
+		return values
+	}
+
+	require.NoError(t, api.PullBlocklist(ctx, blocklist, true))
+	assert.ElementsMatch(t, []string{"1.2.3.4", "1.2.3.5"}, activeValues())
+
+	// 1.2.3.5 is missing, but not for long enough
+	content = "1.2.3.4"
+
+	require.NoError(t, api.PullBlocklist(ctx, blocklist, true))
+	assert.ElementsMatch(t, []string{"1.2.3.4", "1.2.3.5"}, activeValues())
+
+	time.Sleep(600 * time.Millisecond)
+
+	// still missing after the grace period
+	require.NoError(t, api.PullBlocklist(ctx, blocklist, true))
+	assert.ElementsMatch(t, []string{"1.2.3.4"}, activeValues())
+}
+
+func TestAPICPullBlocklistAllAllowlisted(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.deleteGracePeriod = 500 * time.Millisecond
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, "1.2.3.4"))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+	require.NoError(t, err)
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	require.NoError(t, api.PullBlocklist(ctx, blocklist, true))
+	assertTotalValidDecisionCount(t, api.dbClient, 1)
+
+	// the only value of the list is now allowlisted
+	api.whitelists = &csconfig.CapiWhitelist{Ips: []netip.Addr{netip.MustParseAddr("1.2.3.4")}}
+
+	require.NoError(t, api.PullBlocklist(ctx, blocklist, true))
+	assertTotalValidDecisionCount(t, api.dbClient, 1)
+
+	time.Sleep(600 * time.Millisecond)
+
+	// the existing decision is expired like any missing value
+	require.NoError(t, api.PullBlocklist(ctx, blocklist, true))
+	assertTotalValidDecisionCount(t, api.dbClient, 0)
+}
+
+func TestAPICPullBlocklistLongLines(t *testing.T) {
+	ctx := t.Context()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	blocklist := &modelscapi.BlocklistLink{
+		URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+		Name:        ptr.Of("blocklist1"),
+		Scope:       ptr.Of("Ip"),
+		Remediation: ptr.Of("ban"),
+		Duration:    ptr.Of("24h"),
+	}
+
+	tests := []struct {
 		name          string
-		consoleConfig *csconfig.ConsoleConfig
-		shareSignals  bool
-		alert         *models.Alert
-		expectedRet   bool
-		expectedTrust string
+		maxLineLength int
+		content       string
 	}{
 		{
-			name: "custom alert should be shared if config enables it",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareCustomScenarios: ptr.Of(true),
-			},
-			alert:         &models.Alert{Simulated: ptr.Of(false)},
-			shareSignals:  true,
-			expectedRet:   true,
-			expectedTrust: "custom",
+			name:    "huge line, default buffer",
+			content: "1.2.3.4\n" + strings.Repeat("a", 1024*1024) + "\n\n1.2.3.5\r\n1.2.3.6",
 		},
 		{
-			name: "custom alert should not be shared if config disables it",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareCustomScenarios: ptr.Of(false),
-			},
-			alert:         &models.Alert{Simulated: ptr.Of(false)},
-			shareSignals:  true,
-			expectedRet:   false,
-			expectedTrust: "custom",
+			name:          "configured buffer",
+			maxLineLength: 16,
+			content:       "1.2.3.4\n" + strings.Repeat("a", 17) + "\n1.2.3.5\n1.2.3.6\n" + strings.Repeat("b", 100),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := getAPIC(t, ctx)
+			api.blocklistMaxLength = tc.maxLineLength
+
+			api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+			require.NoError(t, err)
+
+			httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, tc.content))
+
+			err = api.UpdateBlocklists(ctx, []*modelscapi.BlocklistLink{blocklist}, map[string]map[string]int{}, true, "")
+			require.NoError(t, err)
+
+			values := []string{}
+			for _, d := range api.dbClient.Ent.Decision.Query().AllX(ctx) {
+				values = append(values, d.Value)
+			}
+
+			assert.ElementsMatch(t, []string{"1.2.3.4", "1.2.3.5", "1.2.3.6"}, values)
+		})
+	}
+}
+
+func TestCoalescePrefixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		expected []string
+	}{
+		{
+			name:     "overlap",
+			prefixes: []string{"10.0.0.0/25", "10.0.0.0/24"},
+			expected: []string{"10.0.0.0/24"},
 		},
 		{
-			name: "manual alert should be shared if config enables it",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareManualDecisions: ptr.Of(true),
-			},
-			shareSignals: true,
-			alert: &models.Alert{
-				Simulated: ptr.Of(false),
-				Decisions: []*models.Decision{{Origin: ptr.Of(types.CscliOrigin)}},
-			},
-			expectedRet:   true,
-			expectedTrust: "manual",
+			name:     "adjacent halves",
+			prefixes: []string{"10.0.0.128/25", "10.0.0.0/25"},
+			expected: []string{"10.0.0.0/24"},
 		},
 		{
-			name: "manual alert should not be shared if config disables it",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareManualDecisions: ptr.Of(false),
-			},
-			shareSignals: true,
-			alert: &models.Alert{
-				Simulated: ptr.Of(false),
-				Decisions: []*models.Decision{{Origin: ptr.Of(types.CscliOrigin)}},
-			},
-			expectedRet:   false,
-			expectedTrust: "manual",
+			name:     "adjacent but not halves",
+			prefixes: []string{"10.0.1.0/24", "10.0.2.0/24"},
+			expected: []string{"10.0.1.0/24", "10.0.2.0/24"},
 		},
 		{
-			name: "manual alert should be shared if config enables it",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareTaintedScenarios: ptr.Of(true),
-			},
-			shareSignals: true,
-			alert: &models.Alert{
-				Simulated:    ptr.Of(false),
-				ScenarioHash: ptr.Of("whateverHash"),
-			},
-			expectedRet:   true,
-			expectedTrust: "tainted",
+			name:     "cascading merges",
+			prefixes: []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/24"},
+			expected: []string{"10.0.0.0/23"},
 		},
 		{
-			name: "manual alert should not be shared if config disables it",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareTaintedScenarios: ptr.Of(false),
+			name:     "ipv6",
+			prefixes: []string{"2001:db8::/33", "2001:db8:8000::/33", "2001:db8::/48", "10.0.0.0/8"},
+			expected: []string{"10.0.0.0/8", "2001:db8::/32"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prefixes := make([]netip.Prefix, len(tc.prefixes))
+			for i, p := range tc.prefixes {
+				prefixes[i] = netip.MustParsePrefix(p)
+			}
+
+			got := []string{}
+			for _, p := range coalescePrefixes(prefixes) {
+				got = append(got, p.String())
+			}
+
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestAPICPushDrainOnShutdown(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.pushInterval = time.Hour
+	api.pushIntervalFirst = time.Hour
+	api.pushDrainTimeout = 5 * time.Second
+	api.AlertsAddChan = make(chan []*models.Alert, 10)
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	httpmock.RegisterResponder("POST", "http://api.crowdsec.net/api/signals", httpmock.NewBytesResponder(200, []byte{}))
+
+	for range 2 {
+		api.AlertsAddChan <- []*models.Alert{
+			{
+				Scenario:        ptr.Of("crowdsec/test"),
+				ScenarioHash:    ptr.Of("certified"),
+				ScenarioVersion: ptr.Of("v1.0"),
+				Simulated:       ptr.Of(false),
+				Source:          &models.Source{},
 			},
-			shareSignals: true,
-			alert: &models.Alert{
-				Simulated:    ptr.Of(false),
-				ScenarioHash: ptr.Of("whateverHash"),
+		}
+	}
+
+	api.pushTomb.Go(func() error { return api.Push(ctx) })
+	api.Shutdown()
+
+	// the queued alerts have been sent before Shutdown returned
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	assert.Empty(t, api.AlertsAddChan)
+}
+
+func TestAPICPullBlocklistNormalizeDecisionType(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.decisionTypeAliases = map[string]string{"block": "ban"}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	remediations := map[string]string{
+		"blocklist1": "Ban",
+		"blocklist2": "CAPTCHA",
+		"blocklist3": " Block",
+	}
+
+	blocklists := []*modelscapi.BlocklistLink{}
+
+	for i, name := range []string{"blocklist1", "blocklist2", "blocklist3"} {
+		httpmock.RegisterResponder("GET", "http://api.crowdsec.net/"+name, httpmock.NewStringResponder(
+			200, fmt.Sprintf("1.2.3.%d\n1.2.4.%d", i, i),
+		))
+
+		blocklists = append(blocklists, &modelscapi.BlocklistLink{
+			URL:         ptr.Of("http://api.crowdsec.net/" + name),
+			Name:        ptr.Of(name),
+			Scope:       ptr.Of("Ip"),
+			Remediation: ptr.Of(remediations[name]),
+			Duration:    ptr.Of("24h"),
+		})
+	}
+
+	addCounters, _ := makeAddAndDeleteCounters()
+	err = api.UpdateBlocklists(ctx, blocklists, addCounters, false, "")
+	require.NoError(t, err)
+
+	expected := map[string]string{
+		"blocklist1": "ban",
+		"blocklist2": "captcha",
+		"blocklist3": "ban",
+	}
+
+	decisions := api.dbClient.Ent.Decision.Query().AllX(ctx)
+	require.Len(t, decisions, 6)
+
+	for _, d := range decisions {
+		assert.Equal(t, expected[d.Scenario], d.Type, d.Scenario)
+	}
+
+	// the type of the links is not modified
+	assert.Equal(t, "Ban", *blocklists[0].Remediation)
+}
+
+func TestAPICApplyStreamFromFile(t *testing.T) {
+	ctx := t.Context()
+
+	stream := jsonMarshalX(modelscapi.GetDecisionsStreamResponse{
+		Deleted: modelscapi.GetDecisionsStreamResponseDeleted{
+			&modelscapi.GetDecisionsStreamResponseDeletedItem{
+				Decisions: []string{"9.9.9.9"},
+				Scope:     ptr.Of("Ip"),
 			},
-			expectedRet:   false,
-			expectedTrust: "tainted",
 		},
-		{
-			name: "manual alert should not be shared if global sharing is disabled",
-			consoleConfig: &csconfig.ConsoleConfig{
-				ShareManualDecisions: ptr.Of(true),
+		New: modelscapi.GetDecisionsStreamResponseNew{
+			&modelscapi.GetDecisionsStreamResponseNewItem{
+				Scenario: ptr.Of("crowdsecurity/test1"),
+				Scope:    ptr.Of("Ip"),
+				Decisions: []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+					{
+						Value:    ptr.Of("1.2.3.4"),
+						Duration: ptr.Of("24h"),
+					},
+					{
+						Value:    ptr.Of("1.2.3.5"),
+						Duration: ptr.Of("24h"),
+					},
+				},
 			},
-			shareSignals: false,
-			alert: &models.Alert{
-				Simulated:    ptr.Of(false),
-				ScenarioHash: ptr.Of("whateverHash"),
+		},
+		Links: &modelscapi.GetDecisionsStreamResponseLinks{
+			Blocklists: []*modelscapi.BlocklistLink{
+				{
+					URL:         ptr.Of("http://api.crowdsec.net/blocklist1"),
+					Name:        ptr.Of("blocklist1"),
+					Scope:       ptr.Of("Ip"),
+					Remediation: ptr.Of("ban"),
+					Duration:    ptr.Of("24h"),
+				},
 			},
-			expectedRet:   false,
-			expectedTrust: "manual",
 		},
+	})
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(200, stream))
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/blocklist1", httpmock.NewStringResponder(200, "1.2.3.6"))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	newAPIC := func() *apic {
+		api := getAPIC(t, ctx)
+		api.dbClient.Ent.Decision.Create().
+			SetOrigin(types.CAPIOrigin).
+			SetType("ban").
+			SetValue("9.9.9.9").
+			SetScope("Ip").
+			SetScenario("crowdsecurity/ssh-bf").
+			SetUntil(time.Now().Add(time.Hour)).
+			ExecX(ctx)
+
+		api.apiClient, err = apiclient.NewDefaultClient(url, "/api", "", nil)
+		require.NoError(t, err)
+
+		return api
 	}
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			ret := shouldShareAlert(tc.alert, tc.consoleConfig, tc.shareSignals)
-			assert.Equal(t, tc.expectedRet, ret)
+	pulled := newAPIC()
+	err = pulled.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	streamFile := filepath.Join(t.TempDir(), "stream.json")
+	err = os.WriteFile(streamFile, stream, 0o600)
+	require.NoError(t, err)
+
+	replayed := newAPIC()
+	err = replayed.ApplyStreamFromFile(ctx, streamFile)
+	require.NoError(t, err)
+
+	// the stream itself was only fetched once
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET http://api.crowdsec.net/api/decisions/stream"])
+
+	for _, api := range []*apic{pulled, replayed} {
+		assertTotalDecisionCount(t, ctx, api.dbClient, 4)
+		assertTotalValidDecisionCount(t, api.dbClient, 3)
+		assertTotalAlertCount(t, api.dbClient, 2)
+	}
+
+	err = replayed.ApplyStreamFromFile(ctx, filepath.Join(t.TempDir(), "missing.json"))
+	require.ErrorContains(t, err, "while reading decisions stream")
+
+	err = os.WriteFile(streamFile, []byte("{not json"), 0o600)
+	require.NoError(t, err)
+
+	err = replayed.ApplyStreamFromFile(ctx, streamFile)
+	require.ErrorContains(t, err, "while parsing decisions stream")
+}
+
+func TestAPICPullTopMaxDecisions(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+	api.maxDecisions = 3
+
+	// manual decisions are not counted, nor evicted
+	api.dbClient.Ent.Decision.Create().
+		SetOrigin(types.CscliOrigin).
+		SetType("ban").
+		SetValue("9.9.9.9").
+		SetScope("Ip").
+		SetScenario("manual").
+		SetUntil(time.Now().Add(time.Minute)).
+		ExecX(ctx)
+
+	newDecisions := []*modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{}
+	for i := 1; i <= 5; i++ {
+		newDecisions = append(newDecisions, &modelscapi.GetDecisionsStreamResponseNewItemDecisionsItems0{
+			Value:    ptr.Of(fmt.Sprintf("1.2.3.%d", i)),
+			Duration: ptr.Of(fmt.Sprintf("%dh", i)),
 		})
 	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://api.crowdsec.net/api/decisions/stream", httpmock.NewBytesResponder(
+		200, jsonMarshalX(
+			modelscapi.GetDecisionsStreamResponse{
+				New: modelscapi.GetDecisionsStreamResponseNew{
+					&modelscapi.GetDecisionsStreamResponseNewItem{
+						Scenario:  ptr.Of("crowdsecurity/test1"),
+						Scope:     ptr.Of("Ip"),
+						Decisions: newDecisions,
+					},
+				},
+			},
+		),
+	))
+
+	url, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(
+		url,
+		"/api",
+		"",
+		nil,
+	)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+	err = api.PullTop(ctx, false)
+	require.NoError(t, err)
+
+	assertTotalDecisionCount(t, ctx, api.dbClient, 6)
+	assertTotalValidDecisionCount(t, api.dbClient, 4)
+
+	validDecisions := api.dbClient.Ent.Decision.Query().Where(decision.UntilGT(time.Now())).AllX(ctx)
+
+	values := []string{}
+	for _, d := range validDecisions {
+		values = append(values, d.Value)
+	}
+
+	assert.ElementsMatch(t, []string{"9.9.9.9", "1.2.3.3", "1.2.3.4", "1.2.3.5"}, values)
+}
+
+func TestAPICMaxDecisionsWithReplica(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	replicaPath := filepath.Join(dir, "replica.db")
+
+	// an empty replica, lagging behind the primary
+	replicaClient, err := database.NewClient(ctx, &csconfig.DatabaseCfg{
+		Type:   "sqlite",
+		DbPath: replicaPath,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { replicaClient.Close() })
+
+	api := getAPIC(t, ctx)
+	api.maxDecisions = 3
+
+	api.dbClient, err = database.NewClient(ctx, &csconfig.DatabaseCfg{
+		Type:       "sqlite",
+		DbPath:     filepath.Join(dir, "primary.db"),
+		ReplicaDSN: "file:" + replicaPath + "?_busy_timeout=100000&_fk=1",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { api.dbClient.Close() })
+
+	for i := 1; i <= 5; i++ {
+		api.dbClient.Ent.Decision.Create().
+			SetOrigin(types.CAPIOrigin).
+			SetType("ban").
+			SetValue(fmt.Sprintf("1.2.3.%d", i)).
+			SetScope("Ip").
+			SetScenario("crowdsecurity/test1").
+			SetUntil(time.Now().Add(time.Duration(i) * time.Hour)).
+			ExecX(ctx)
+	}
+
+	evicted, err := api.enforceMaxDecisions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+	assertTotalValidDecisionCount(t, api.dbClient, 3)
+}
+
+func TestAPICPullFreshnessWithReplica(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	replicaPath := filepath.Join(dir, "replica.db")
+
+	// an empty replica, lagging behind the primary
+	replicaClient, err := database.NewClient(ctx, &csconfig.DatabaseCfg{
+		Type:   "sqlite",
+		DbPath: replicaPath,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { replicaClient.Close() })
+
+	api := getAPIC(t, ctx)
+
+	api.dbClient, err = database.NewClient(ctx, &csconfig.DatabaseCfg{
+		Type:       "sqlite",
+		DbPath:     filepath.Join(dir, "primary.db"),
+		ReplicaDSN: "file:" + replicaPath + "?_busy_timeout=100000&_fk=1",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { api.dbClient.Close() })
+
+	for _, scope := range []string{types.CommunityBlocklistPullSourceScope, types.ListOrigin + ":blocklist1"} {
+		alertInstance := api.dbClient.Ent.Alert.Create().
+			SetScenario("crowdsecurity/test1").
+			SetSourceScope(scope).
+			SaveX(ctx)
+
+		api.dbClient.Ent.Decision.Create().
+			SetOrigin(types.CAPIOrigin).
+			SetType("ban").
+			SetValue("1.2.3.4").
+			SetScope("Ip").
+			SetScenario("crowdsecurity/test1").
+			SetUntil(time.Now().Add(24 * time.Hour)).
+			SetOwnerID(alertInstance.ID).
+			ExecX(ctx)
+	}
+
+	// the recent pull is found on the primary
+	isOld, err := api.CAPIPullIsOld(ctx)
+	require.NoError(t, err)
+	assert.False(t, isOld)
+
+	forcePull, err := api.ShouldForcePullBlocklist(ctx, &modelscapi.BlocklistLink{Name: ptr.Of("blocklist1")})
+	require.NoError(t, err)
+	assert.False(t, forcePull)
+}
+
+func TestAPICExportDecisions(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	for _, d := range []struct {
+		value     string
+		scope     string
+		until     time.Duration
+		simulated bool
+	}{
+		{"1.2.3.4", "Ip", time.Hour, false},
+		{"1.2.3.4", "Ip", 2 * time.Hour, false}, // same value, exported once
+		{"5.6.7.8", "Ip", time.Hour, false},
+		{"9.9.9.9", "Ip", -time.Hour, false}, // expired
+		{"8.8.8.8", "Ip", time.Hour, true},   // simulated
+		{"10.0.0.0/8", "Range", time.Hour, false},
+		{"FR", "Country", time.Hour, false}, // only in the json format
+		{"12345", "AS", time.Hour, true},    // simulated
+	} {
+		api.dbClient.Ent.Decision.Create().
+			SetOrigin(types.CAPIOrigin).
+			SetType("ban").
+			SetValue(d.value).
+			SetScope(d.scope).
+			SetScenario("crowdsecurity/ssh-bf").
+			SetUntil(time.Now().UTC().Add(d.until)).
+			SetSimulated(d.simulated).
+			ExecX(ctx)
+	}
+
+	buf := bytes.Buffer{}
+	require.NoError(t, api.ExportDecisions(ctx, &buf, ExportFormatPlaintext))
+	assert.Equal(t, "1.2.3.4\n5.6.7.8\n10.0.0.0/8\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, api.ExportDecisions(ctx, &buf, ExportFormatJSON))
+	assert.JSONEq(t, `{"Country":["FR"],"Ip":["1.2.3.4","5.6.7.8"],"Range":["10.0.0.0/8"]}`, buf.String())
+
+	err := api.ExportDecisions(ctx, &buf, "csv")
+	cstest.RequireErrorContains(t, err, `unknown export format "csv"`)
 }
@@ -45,6 +45,7 @@ type APIServer struct {
 	httpServer     *http.Server
 	apic           *apic
 	papi           *Papi
+	forwarder      *decisionForwarder // nil if the decisions are not forwarded to a secondary API
 	httpServerTomb tomb.Tomb
 	consoleConfig  *csconfig.ConsoleConfig
 }
@@ -173,6 +174,10 @@ func NewServer(ctx context.Context, config *csconfig.LocalApiServerCfg) (*APISer
 		return nil, fmt.Errorf("unable to init database client: %w", err)
 	}
 
+	if config.OnlineClient != nil {
+		dbClient.SourceScopePrefix = config.OnlineClient.PullConfig.SourceScopePrefix
+	}
+
 	if config.DbConfig.Flush != nil {
 		flushScheduler, err = dbClient.StartFlushScheduler(ctx, config.DbConfig.Flush)
 		if err != nil {
@@ -275,6 +280,15 @@ func NewServer(ctx context.Context, config *csconfig.LocalApiServerCfg) (*APISer
 		}
 	}
 
+	var forwarder *decisionForwarder
+
+	if config.ForwardDecisions != nil && config.ForwardDecisions.Credentials != nil {
+		forwarder, err = newDecisionForwarderFromConfig(dbClient, config.ForwardDecisions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	trustedIPs, err := config.GetTrustedIPs()
 	if err != nil {
 		return nil, err
@@ -293,6 +307,7 @@ func NewServer(ctx context.Context, config *csconfig.LocalApiServerCfg) (*APISer
 		router:         router,
 		apic:           apiClient,
 		papi:           papiClient,
+		forwarder:      forwarder,
 		httpServerTomb: tomb.Tomb{},
 		consoleConfig:  config.ConsoleConfig,
 	}, nil
@@ -342,6 +357,10 @@ func (s *APIServer) initAPIC(ctx context.Context) {
 	s.apic.pushTomb.Go(func() error { return s.apicPush(ctx) })
 	s.apic.pullTomb.Go(func() error { return s.apicPull(ctx) })
 
+	if s.apic.allowlistsInterval > 0 {
+		s.apic.pullTomb.Go(func() error { return s.apic.PullAllowlists(ctx) })
+	}
+
 	if s.apic.apiClient.IsEnrolled() {
 		if s.papi != nil {
 			if s.papi.URL != "" {
@@ -389,9 +408,20 @@ func (s *APIServer) Run(apiReady chan bool) error {
 	ctx := context.TODO()
 
 	if s.apic != nil {
+		// CAPI being unreachable must not prevent the API from starting
+		if s.apic.warmupTimeout > 0 {
+			if err := s.apic.WarmupPull(ctx); err != nil {
+				log.Warningf("serving the API without the community decisions: %s", err)
+			}
+		}
+
 		s.initAPIC(ctx)
 	}
 
+	if s.forwarder != nil {
+		s.forwarder.Start(ctx)
+	}
+
 	s.httpServerTomb.Go(func() error {
 		return s.listenAndServeLAPI(apiReady)
 	})
@@ -510,7 +540,11 @@ func (s *APIServer) Close() {
 		s.papi.Shutdown() // papi also uses the dbClient
 	}
 
-	s.dbClient.Ent.Close()
+	if s.forwarder != nil {
+		s.forwarder.Shutdown()
+	}
+
+	s.dbClient.Close()
 
 	if s.flushScheduler != nil {
 		s.flushScheduler.Stop()
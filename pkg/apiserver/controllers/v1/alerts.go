@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,6 +16,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
+	"github.com/crowdsecurity/crowdsec/pkg/metrics"
 	"github.com/crowdsecurity/crowdsec/pkg/models"
 	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
@@ -90,6 +92,7 @@ func FormatOneAlert(alert *ent.Alert) *models.Alert {
 			Origin:    &decisionItem.Origin,
 			Simulated: outputAlert.Simulated,
 			ID:        int64(decisionItem.ID),
+			Metadata:  decisionItem.Metadata,
 		})
 	}
 
@@ -129,7 +132,7 @@ func (c *Controller) isAllowListed(ctx context.Context, alert *models.Alert) (bo
 		return false, ""
 	}
 
-	if alert.Source.Scope != nil && (*alert.Source.Scope == types.Ip || *alert.Source.Scope == types.Range) && // Allowlist only works for IP/range
+	if alert.Source.Scope != nil && (strings.EqualFold(*alert.Source.Scope, types.Ip) || strings.EqualFold(*alert.Source.Scope, types.Range)) && // Allowlist only works for IP/range
 		alert.Source.Value != nil { // Is this possible ?
 		isAllowlisted, reason, err := c.DBClient.IsAllowlisted(ctx, *alert.Source.Value)
 		if err == nil && isAllowlisted {
@@ -285,7 +288,10 @@ func (c *Controller) CreateAlert(gctx *gin.Context) {
 			log.Debug("alert sent to CAPI channel")
 		default:
 			log.Warning("Cannot send alert to Central API channel")
+			metrics.LapiPushDroppedAlerts.Add(float64(len(alertsToSave)))
 		}
+
+		metrics.LapiPushQueueDepth.Set(float64(len(c.AlertsAddChan)))
 	}
 
 	gctx.JSON(http.StatusCreated, alerts)
@@ -30,6 +30,7 @@ func FormatDecisions(decisions []*ent.Decision) []*models.Decision {
 			Type:     &dbDecision.Type,
 			Origin:   &dbDecision.Origin,
 			UUID:     dbDecision.UUID,
+			Metadata: dbDecision.Metadata,
 		}
 		results = append(results, &decision)
 	}
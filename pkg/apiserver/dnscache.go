@@ -0,0 +1,118 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache keeps the result of host lookups for a short time, to avoid
+// resolving the same blocklist host again for each list during a pull.
+type dnsCache struct {
+	resolver hostResolver
+	ttl      time.Duration
+	dialer   net.Dialer
+	mu       sync.Mutex
+	entries  map[string]dnsCacheEntry
+}
+
+func newDNSCache(resolver hostResolver, ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		resolver: resolver,
+		ttl:      ttl,
+		dialer: net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		entries: make(map[string]dnsCacheEntry),
+	}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsCacheEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return addrs, nil
+}
+
+func (c *dnsCache) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address found for %s", host)
+	}
+
+	var errs []error
+
+	for _, addr := range addrs {
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// newBlocklistHTTPClient returns an http client resolving hosts through the given cache,
+// and sending the requests to proxy, if they are not nil.
+func newBlocklistHTTPClient(cache *dnsCache, proxy *url.URL) *http.Client {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+
+	if cache != nil {
+		transport.DialContext = cache.DialContext
+	}
+
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport}
+}
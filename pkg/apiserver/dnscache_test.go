@@ -0,0 +1,96 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+
+	"github.com/crowdsecurity/crowdsec/pkg/apiclient"
+	"github.com/crowdsecurity/crowdsec/pkg/modelscapi"
+)
+
+type countingResolver struct {
+	mu      sync.Mutex
+	lookups map[string]int
+}
+
+func (r *countingResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lookups[host]++
+
+	if host != "blocklists.test" {
+		return nil, fmt.Errorf("unknown host %s", host)
+	}
+
+	return []string{"127.0.0.1"}, nil
+}
+
+func TestBlocklistDNSCache(t *testing.T) {
+	ctx := t.Context()
+	api := getAPIC(t, ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/blocklist1":
+			fmt.Fprint(w, "1.2.3.4\n1.2.3.5")
+		case "/blocklist2":
+			fmt.Fprint(w, "1.2.3.6")
+		}
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	apiURL, err := url.ParseRequestURI("http://api.crowdsec.net/")
+	require.NoError(t, err)
+
+	apic, err := apiclient.NewDefaultClient(apiURL, "/api", "", nil)
+	require.NoError(t, err)
+
+	api.apiClient = apic
+
+	resolver := &countingResolver{lookups: map[string]int{}}
+	api.blocklistClient = newBlocklistHTTPClient(newDNSCache(resolver, time.Minute), nil)
+	// make sure each fetch dials a new connection
+	api.blocklistClient.Transport.(*http.Transport).DisableKeepAlives = true
+
+	for _, name := range []string{"blocklist1", "blocklist2", "blocklist1"} {
+		err = api.PullBlocklist(ctx, &modelscapi.BlocklistLink{
+			URL:         ptr.Of("http://blocklists.test:" + serverURL.Port() + "/" + name),
+			Name:        ptr.Of(name),
+			Scope:       ptr.Of("Ip"),
+			Remediation: ptr.Of("ban"),
+			Duration:    ptr.Of("24h"),
+		}, true)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, map[string]int{"blocklists.test": 1}, resolver.lookups)
+	assertTotalValidDecisionCount(t, api.dbClient, 3)
+
+	// once the ttl has expired, the host is resolved again
+	cache := newDNSCache(resolver, time.Millisecond)
+
+	_, err = cache.lookup(ctx, "blocklists.test")
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = cache.lookup(ctx, "blocklists.test")
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, resolver.lookups["blocklists.test"])
+}
@@ -41,8 +41,51 @@ type ApiCredentialsCfg struct {
 }
 
 type CapiPullConfig struct {
-	Community  *bool `yaml:"community,omitempty"`
-	Blocklists *bool `yaml:"blocklists,omitempty"`
+	Community                *bool             `yaml:"community,omitempty"`
+	Blocklists               *bool             `yaml:"blocklists,omitempty"`
+	MinDecisionDuration      time.Duration     `yaml:"min_decision_duration,omitempty"`      // shorter durations from CAPI or blocklists are raised to this value
+	BlocklistDNSCacheTTL     time.Duration     `yaml:"blocklist_dns_cache_ttl,omitempty"`    // cache the DNS resolution of blocklist hosts, disabled if 0
+	BlocklistHashCheck       bool              `yaml:"blocklist_hash_check,omitempty"`       // skip blocklists whose content is the same as the previous pull
+	ScenarioRemap            map[string]string `yaml:"scenario_remap,omitempty"`             // rename the scenarios of community blocklist decisions
+	BlocklistsAuth           map[string]Secret `yaml:"blocklists_auth,omitempty"`            // Authorization header to send when fetching a blocklist, by blocklist name or host
+	BlocklistsProxy          map[string]string `yaml:"blocklists_proxy,omitempty"`           // proxy URL to fetch a blocklist through instead of the CAPI one, by blocklist name, host, or "*" for all
+	BlocklistAggregateRanges bool              `yaml:"blocklist_aggregate_ranges,omitempty"` // merge overlapping and adjacent ranges of a blocklist
+	DecisionTypeAliases      map[string]string `yaml:"decision_type_aliases,omitempty"`      // replace the type of pulled decisions, after they are lowercased
+	MaxDecisions             int               `yaml:"max_decisions,omitempty"`              // maximum number of active decisions from CAPI and blocklists, the ones expiring first are evicted
+	DeleteGracePeriod        time.Duration     `yaml:"delete_grace_period,omitempty"`        // remove the decisions missing from a blocklist after they have been absent for this long, disabled if 0
+	SourceScopePrefix        string            `yaml:"source_scope_prefix,omitempty"`        // prepended to the source scope of community and list alerts, ie. to tell instances apart
+	BlocklistMaxLineLength   int               `yaml:"blocklist_max_line_length,omitempty"`  // longer lines of a blocklist are skipped, defaults to 64KiB
+	BlocklistBackoff         time.Duration     `yaml:"blocklist_backoff,omitempty"`          // skip a failing blocklist for this long, doubled after each consecutive failure, disabled if 0
+	ExplodeAlerts            map[string]bool   `yaml:"explode_alerts,omitempty"`             // create one alert per decision instead of one per pull, by blocklist name
+	AllowEmpty               map[string]bool   `yaml:"allow_empty,omitempty"`                // accept an empty content, by blocklist name, instead of keeping the previous decisions
+	DefaultDuration          time.Duration     `yaml:"default_duration,omitempty"`           // replaces missing or invalid decision durations, defaults to 24h
+	LocalScenariosOnly       bool              `yaml:"local_scenarios_only,omitempty"`       // drop the community decisions of scenarios that no local machine runs
+	WarmupTimeout            time.Duration     `yaml:"warmup_timeout,omitempty"`             // on startup, wait up to this long for a first pull before serving the API, disabled if 0
+	StaleAfter               time.Duration     `yaml:"stale_after,omitempty"`                // the community decisions are pulled again on startup if the last pull is older than this, defaults to 1h30
+	MaxBlocklists            int               `yaml:"max_blocklists,omitempty"`             // pull at most this many of the subscribed blocklists, in name order, no limit if 0
+	TagPullBatch             bool              `yaml:"tag_pull_batch,omitempty"`             // store an id unique to each pull in the metadata of the decisions it creates, to revert a bad pull; the decisions are inserted again by each pull
+	AllowlistsInterval       time.Duration     `yaml:"allowlists_interval,omitempty"`        // refresh the allowlists on their own schedule instead of with each pull, the new ones are still pulled right away; disabled if 0
+}
+
+const redacted = "********"
+
+// Secret is a configuration value that is not displayed when the configuration is printed or dumped.
+type Secret string
+
+func (Secret) String() string {
+	return redacted
+}
+
+func (Secret) GoString() string {
+	return redacted
+}
+
+func (Secret) MarshalYAML() (any, error) {
+	return redacted, nil
+}
+
+func (Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
 }
 
 /*global api config (for lapi->capi)*/
@@ -51,6 +94,22 @@ type OnlineApiClientCfg struct {
 	Credentials         *ApiCredentialsCfg `yaml:"-"`
 	PullConfig          CapiPullConfig     `yaml:"pull,omitempty"`
 	Sharing             *bool              `yaml:"sharing,omitempty"`
+	PushDrainTimeout    time.Duration      `yaml:"push_drain_timeout,omitempty"` // on shutdown, wait up to this long for the pending signals to be sent
+	ShareOSInfo         *bool              `yaml:"share_os_info,omitempty"`      // send the OS of the bouncers and machines with the metrics
+	BasePath            string             `yaml:"base_path,omitempty"`          // prefix of the CAPI endpoints, for reverse proxies that rewrite the paths
+	APIVersion          string             `yaml:"api_version,omitempty"`        // version in the path of the CAPI endpoints, defaults to v3
+	SpoolDir            string             `yaml:"spool_dir,omitempty"`          // keep the signals that could not be pushed here, and send them again after the next successful push
+	SpoolMaxBatches     int                `yaml:"spool_max_batches,omitempty"`  // the oldest spooled batches are dropped above this number, defaults to 1000
+	NeverShareManual    bool               `yaml:"never_share_manual,omitempty"` // don't push the alerts of manual decisions, whatever the console share_manual_decisions option
+	PushMaxAge          time.Duration      `yaml:"push_max_age,omitempty"`       // don't push the alerts that ended longer ago than this, ie. buffered by an agent that was offline, disabled if 0
+	PinnedSPKI          []string           `yaml:"pinned_spki,omitempty"`        // base64 SHA-256 hashes of the accepted public keys of the CAPI certificate, to defend against MITM
+	PinnedCertFile      string             `yaml:"pinned_cert_file,omitempty"`   // PEM file with the accepted CAPI certificates, added to pinned_spki
+	MaxContextSize      int                `yaml:"max_context_size,omitempty"`   // with share_context, drop the largest context entries of an alert above this size in bytes, disabled if 0
+	PushBackoffMax      time.Duration      `yaml:"push_backoff_max,omitempty"`   // after failures the push delay doubles up to this, or follows Retry-After if longer, defaults to 30m
+
+	// "hash" or "omit" the names of the machines and bouncers in the metrics sent to CAPI, they are sent as is by default
+	RedactMachineNames string `yaml:"redact_machine_names,omitempty"`
+	RedactBouncerNames string `yaml:"redact_bouncer_names,omitempty"`
 }
 
 /*local api config (for crowdsec/cscli->lapi)*/
@@ -217,6 +276,56 @@ func (l *LocalApiClientCfg) Load() error {
 	return nil
 }
 
+// ForwardDecisionsCfg configures the forwarding of the local decisions to a secondary LAPI,
+// ie. the central instance of a federated deployment.
+type ForwardDecisionsCfg struct {
+	CredentialsFilePath string             `yaml:"credentials_path"` // machine registered on the secondary LAPI, same format as local_api_credentials.yaml
+	Credentials         *ApiCredentialsCfg `yaml:"-"`
+	Interval            time.Duration      `yaml:"interval,omitempty"` // delay between two forwards, defaults to 10s
+}
+
+func (f *ForwardDecisionsCfg) Load() error {
+	if f.CredentialsFilePath == "" {
+		return errors.New("credentials_path is required")
+	}
+
+	fcontent, err := os.ReadFile(f.CredentialsFilePath)
+	if err != nil {
+		return err
+	}
+
+	configData := csstring.StrictExpand(string(fcontent), os.LookupEnv)
+
+	dec := yaml.NewDecoder(strings.NewReader(configData))
+	dec.KnownFields(true)
+
+	f.Credentials = new(ApiCredentialsCfg)
+
+	if err := dec.Decode(f.Credentials); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse secondary api credentials configuration file '%s': %w", f.CredentialsFilePath, err)
+	}
+
+	// only the login/password authentication is supported
+	switch {
+	case f.Credentials.URL == "":
+		return fmt.Errorf("missing url field in '%s'", f.CredentialsFilePath)
+	case f.Credentials.Login == "":
+		return fmt.Errorf("missing login field in '%s'", f.CredentialsFilePath)
+	case f.Credentials.Password == "":
+		return fmt.Errorf("missing password field in '%s'", f.CredentialsFilePath)
+	}
+
+	if !strings.HasSuffix(f.Credentials.URL, "/") {
+		f.Credentials.URL += "/"
+	}
+
+	if f.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+
+	return nil
+}
+
 /*local api service configuration*/
 type LocalApiServerCfg struct {
 	Enable                        *bool                    `yaml:"enable"`
@@ -245,6 +354,7 @@ type LocalApiServerCfg struct {
 	CapiWhitelistsPath            string                   `yaml:"capi_whitelists_path,omitempty"`
 	CapiWhitelists                *CapiWhitelist           `yaml:"-"`
 	AutoRegister                  *LocalAPIAutoRegisterCfg `yaml:"auto_registration,omitempty"`
+	ForwardDecisions              *ForwardDecisionsCfg     `yaml:"forward_decisions,omitempty"`
 }
 
 func (c *LocalApiServerCfg) GetTrustedIPs() ([]net.IPNet, error) {
@@ -279,6 +389,7 @@ func toValidCIDR(ip string) string {
 type CapiWhitelist struct {
 	Ips   []netip.Addr   `yaml:"ips,omitempty"`
 	Cidrs []netip.Prefix `yaml:"cidrs,omitempty"`
+	AS    []uint         `yaml:"whitelist_as,omitempty"` // autonomous system numbers, requires the GeoIP ASN database
 }
 
 type LocalAPIAutoRegisterCfg struct {
@@ -356,6 +467,14 @@ func (c *Config) LoadAPIServer(inCli bool, skipOnlineCreds bool) error {
 		}
 	}
 
+	if c.API.Server.ForwardDecisions != nil && !inCli {
+		if err := c.API.Server.ForwardDecisions.Load(); err != nil {
+			return fmt.Errorf("loading forward_decisions: %w", err)
+		}
+
+		log.Infof("forwarding the local decisions to %s", c.API.Server.ForwardDecisions.Credentials.URL)
+	}
+
 	if (c.API.Server.OnlineClient == nil || c.API.Server.OnlineClient.Credentials == nil) && !inCli {
 		log.Info("push and pull to Central API disabled")
 	}
@@ -373,6 +492,10 @@ func (c *Config) LoadAPIServer(inCli bool, skipOnlineCreds bool) error {
 		if c.API.Server.OnlineClient.Sharing == nil {
 			c.API.Server.OnlineClient.Sharing = ptr.Of(true)
 		}
+
+		if c.API.Server.OnlineClient.ShareOSInfo == nil {
+			c.API.Server.OnlineClient.ShareOSInfo = ptr.Of(true)
+		}
 	}
 
 	if err := c.LoadDBConfig(inCli); err != nil {
@@ -384,7 +507,7 @@ func (c *Config) LoadAPIServer(inCli bool, skipOnlineCreds bool) error {
 	}
 
 	if c.API.Server.CapiWhitelistsPath != "" && !inCli {
-		log.Infof("loaded capi whitelist from %s: %d IPs, %d CIDRs", c.API.Server.CapiWhitelistsPath, len(c.API.Server.CapiWhitelists.Ips), len(c.API.Server.CapiWhitelists.Cidrs))
+		log.Infof("loaded capi whitelist from %s: %d IPs, %d CIDRs, %d AS", c.API.Server.CapiWhitelistsPath, len(c.API.Server.CapiWhitelists.Ips), len(c.API.Server.CapiWhitelists.Cidrs), len(c.API.Server.CapiWhitelists.AS))
 	}
 
 	if err := c.API.Server.LoadAutoRegister(); err != nil {
@@ -436,6 +559,7 @@ func (c *Config) LoadAPIServer(inCli bool, skipOnlineCreds bool) error {
 type capiWhitelists struct {
 	Ips   []string `yaml:"ips"`
 	Cidrs []string `yaml:"cidrs"`
+	AS    []uint   `yaml:"whitelist_as"`
 }
 
 func parseCapiWhitelists(fd io.Reader) (*CapiWhitelist, error) {
@@ -453,6 +577,7 @@ func parseCapiWhitelists(fd io.Reader) (*CapiWhitelist, error) {
 	ret := &CapiWhitelist{
 		Ips:   make([]netip.Addr, len(fromCfg.Ips)),
 		Cidrs: make([]netip.Prefix, len(fromCfg.Cidrs)),
+		AS:    fromCfg.AS,
 	}
 
 	for idx, v := range fromCfg.Ips {
@@ -1,10 +1,13 @@
 package csconfig
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/netip"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -137,6 +140,67 @@ func TestLoadOnlineApiClientCfg(t *testing.T) {
 	}
 }
 
+func TestLoadForwardDecisionsCfg(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       *ForwardDecisionsCfg
+		expected    *ApiCredentialsCfg
+		expectedErr string
+	}{
+		{
+			name: "basic valid configuration",
+			input: &ForwardDecisionsCfg{
+				CredentialsFilePath: "./testdata/online-api-secrets.yaml",
+			},
+			expected: &ApiCredentialsCfg{
+				URL:      "http://crowdsec.api/",
+				Login:    "test",
+				Password: "testpassword",
+			},
+		},
+		{
+			name:        "no credentials",
+			input:       &ForwardDecisionsCfg{},
+			expectedErr: "credentials_path is required",
+		},
+		{
+			name: "invalid configuration",
+			input: &ForwardDecisionsCfg{
+				CredentialsFilePath: "./testdata/bad_lapi-secrets.yaml",
+			},
+			expectedErr: "failed to parse secondary api credentials",
+		},
+		{
+			name: "missing field configuration",
+			input: &ForwardDecisionsCfg{
+				CredentialsFilePath: "./testdata/bad_online-api-secrets.yaml",
+			},
+			expectedErr: "missing url field in './testdata/bad_online-api-secrets.yaml'",
+		},
+		{
+			name: "negative interval",
+			input: &ForwardDecisionsCfg{
+				CredentialsFilePath: "./testdata/online-api-secrets.yaml",
+				Interval:            -time.Second,
+			},
+			expectedErr: "interval must be positive",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.input.Load()
+			cstest.RequireErrorContains(t, err, tc.expectedErr)
+
+			if tc.expectedErr != "" {
+				return
+			}
+
+			assert.Equal(t, tc.expected, tc.input.Credentials)
+		})
+	}
+}
+
 func TestLoadAPIServer(t *testing.T) {
 	tmpLAPI := &LocalApiServerCfg{
 		ProfilesPath: "./testdata/profiles.yaml",
@@ -216,7 +280,8 @@ func TestLoadAPIServer(t *testing.T) {
 						Password: "testpassword",
 						PapiURL:  types.PAPIBaseURL,
 					},
-					Sharing: ptr.Of(true),
+					Sharing:     ptr.Of(true),
+					ShareOSInfo: ptr.Of(true),
 					PullConfig: CapiPullConfig{
 						Community:  ptr.Of(true),
 						Blocklists: ptr.Of(true),
@@ -311,6 +376,15 @@ func TestParseCapiWhitelists(t *testing.T) {
 				Cidrs: []netip.Prefix{netip.MustParsePrefix("1.2.3.0/24")},
 			},
 		},
+		{
+			name:  "some AS",
+			input: `{"whitelist_as": [64500, 64501]}`,
+			expected: &CapiWhitelist{
+				Ips:   []netip.Addr{},
+				Cidrs: []netip.Prefix{},
+				AS:    []uint{64500, 64501},
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -326,3 +400,28 @@ func TestParseCapiWhitelists(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretRedaction(t *testing.T) {
+	cfg := CapiPullConfig{
+		BlocklistsAuth: map[string]Secret{"my_list": "Bearer s3cr3t"},
+	}
+
+	out, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "s3cr3t")
+	assert.Contains(t, string(out), "my_list")
+
+	out, err = json.Marshal(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "s3cr3t")
+
+	assert.NotContains(t, fmt.Sprintf("%v %+v %#v", cfg, cfg, cfg), "s3cr3t")
+
+	// the value itself is still available
+	assert.Equal(t, "Bearer s3cr3t", string(cfg.BlocklistsAuth["my_list"]))
+
+	// and can be loaded
+	err = yaml.Unmarshal([]byte("blocklists_auth:\n  my_list: Basic Zm9vOmJhcg==\n"), &cfg)
+	require.NoError(t, err)
+	assert.Equal(t, Secret("Basic Zm9vOmJhcg=="), cfg.BlocklistsAuth["my_list"])
+}
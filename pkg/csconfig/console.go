@@ -3,6 +3,7 @@ package csconfig
 import (
 	"fmt"
 	"os"
+	"path"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -30,11 +31,12 @@ var CONSOLE_CONFIGS_HELP = map[string]string{
 var DefaultConsoleConfigFilePath = DefaultConfigPath("console.yaml")
 
 type ConsoleConfig struct {
-	ShareManualDecisions  *bool `yaml:"share_manual_decisions"`
-	ShareTaintedScenarios *bool `yaml:"share_tainted"`
-	ShareCustomScenarios  *bool `yaml:"share_custom"`
-	ConsoleManagement     *bool `yaml:"console_management"`
-	ShareContext          *bool `yaml:"share_context"`
+	ShareManualDecisions   *bool    `yaml:"share_manual_decisions"`
+	ShareTaintedScenarios  *bool    `yaml:"share_tainted"`
+	ShareCustomScenarios   *bool    `yaml:"share_custom"`
+	ConsoleManagement      *bool    `yaml:"console_management"`
+	ShareContext           *bool    `yaml:"share_context"`
+	ScenarioSharingExclude []string `yaml:"scenario_sharing_exclude,omitempty"` // scenarios (or glob patterns) that are never shared, whatever their category
 }
 
 func (c *ConsoleConfig) EnabledOptions() []string {
@@ -66,6 +68,22 @@ func (c *ConsoleConfig) EnabledOptions() []string {
 	return ret
 }
 
+// IsScenarioExcludedFromSharing returns true if the scenario matches one of the scenario_sharing_exclude patterns.
+func (c *ConsoleConfig) IsScenarioExcludedFromSharing(scenario string) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, pattern := range c.ScenarioSharingExclude {
+		// patterns are validated when the configuration is loaded
+		if ok, _ := path.Match(pattern, scenario); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *ConsoleConfig) IsPAPIEnabled() bool {
 	if c == nil || c.ConsoleManagement == nil {
 		return false
@@ -123,6 +141,12 @@ func (c *LocalApiServerCfg) LoadConsoleConfig() error {
 		c.ConsoleConfig.ShareContext = ptr.Of(false)
 	}
 
+	for _, pattern := range c.ConsoleConfig.ScenarioSharingExclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s' in scenario_sharing_exclude: %w", pattern, err)
+		}
+	}
+
 	log.Debugf("Console configuration '%s' loaded successfully", c.ConsoleConfigPath)
 
 	return nil
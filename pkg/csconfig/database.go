@@ -45,6 +45,7 @@ type DatabaseCfg struct {
 	MaxOpenConns     int         `yaml:"max_open_conns,omitempty"`
 	UseWal           *bool       `yaml:"use_wal,omitempty"`
 	DecisionBulkSize int         `yaml:"decision_bulk_size,omitempty"`
+	ReplicaDSN       string      `yaml:"replica_dsn,omitempty"` // connection string of a read-only replica, used for some read-heavy queries
 }
 
 type AuthGCCfg struct {
@@ -3,7 +3,6 @@ package database
 import (
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -33,13 +32,7 @@ func handleOriginFilter(filter map[string][]string, predicates *[]predicate.Aler
 }
 
 func handleScopeFilter(scope string, predicates *[]predicate.Alert) {
-	if strings.ToLower(scope) == "ip" {
-		scope = types.Ip
-	} else if strings.ToLower(scope) == "range" {
-		scope = types.Range
-	}
-
-	*predicates = append(*predicates, alert.SourceScopeEQ(scope))
+	*predicates = append(*predicates, alert.SourceScopeEQ(types.NormalizeScope(scope)))
 }
 
 func handleTimeFilters(param, value string, predicates *[]predicate.Alert) error {
@@ -152,7 +145,7 @@ func handleAlertIPPredicates(rng csnet.Range, contains bool, predicates *[]predi
 	}
 }
 
-func handleIncludeCapiFilter(value string, predicates *[]predicate.Alert) error {
+func handleIncludeCapiFilter(value string, sourceScopePrefix string, predicates *[]predicate.Alert) error {
 	if value == "false" {
 		*predicates = append(*predicates, alert.And(
 			// do not show alerts with active decisions having origin CAPI or lists
@@ -162,11 +155,12 @@ func handleIncludeCapiFilter(value string, predicates *[]predicate.Alert) error
 			),
 			alert.Not(
 				alert.And(
-					// do not show neither alerts with no decisions if the Source Scope is lists: or CAPI
+					// do not show neither alerts with no decisions if the Source Scope is lists: or CAPI,
+					// after the configured source_scope_prefix
 					alert.Not(alert.HasDecisions()),
 					alert.Or(
-						alert.SourceScopeHasPrefix(types.ListOrigin+":"),
-						alert.SourceScopeEQ(types.CommunityBlocklistPullSourceScope),
+						alert.SourceScopeHasPrefix(sourceScopePrefix+types.ListOrigin+":"),
+						alert.SourceScopeEQ(sourceScopePrefix+types.CommunityBlocklistPullSourceScope),
 					),
 				),
 			),
@@ -178,7 +172,7 @@ func handleIncludeCapiFilter(value string, predicates *[]predicate.Alert) error
 	return nil
 }
 
-func alertPredicatesFromFilter(filter map[string][]string) ([]predicate.Alert, error) {
+func alertPredicatesFromFilter(filter map[string][]string, sourceScopePrefix string) ([]predicate.Alert, error) {
 	predicates := make([]predicate.Alert, 0)
 
 	var (
@@ -225,7 +219,7 @@ func alertPredicatesFromFilter(filter map[string][]string) ([]predicate.Alert, e
 		case "origin":
 			predicates = append(predicates, alert.HasDecisionsWith(decision.OriginEQ(value[0])))
 		case "include_capi": // allows to exclude one or more specific origins
-			if err = handleIncludeCapiFilter(value[0], &predicates); err != nil {
+			if err = handleIncludeCapiFilter(value[0], sourceScopePrefix, &predicates); err != nil {
 				return nil, err
 			}
 		case "has_active_decision":
@@ -258,8 +252,8 @@ func alertPredicatesFromFilter(filter map[string][]string) ([]predicate.Alert, e
 	return predicates, nil
 }
 
-func applyAlertFilter(alerts *ent.AlertQuery, filter map[string][]string) (*ent.AlertQuery, error) {
-	preds, err := alertPredicatesFromFilter(filter)
+func applyAlertFilter(alerts *ent.AlertQuery, filter map[string][]string, sourceScopePrefix string) (*ent.AlertQuery, error) {
+	preds, err := alertPredicatesFromFilter(filter, sourceScopePrefix)
 	if err != nil {
 		return nil, err
 	}
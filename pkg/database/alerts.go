@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,6 +24,7 @@ import (
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/event"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/meta"
 	"github.com/crowdsecurity/crowdsec/pkg/models"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
 const (
@@ -156,7 +158,7 @@ func (c *Client) CreateOrUpdateAlert(ctx context.Context, machineID string, aler
 			SetEndSuffix(rng.End.Sfx).
 			SetIPSize(int64(rng.Size())).
 			SetValue(*decisionItem.Value).
-			SetScope(*decisionItem.Scope).
+			SetScope(types.NormalizeScope(*decisionItem.Scope)).
 			SetOrigin(*decisionItem.Origin).
 			SetSimulated(*alertItem.Simulated).
 			SetUUID(decisionItem.UUID)
@@ -191,10 +193,22 @@ func (c *Client) CreateOrUpdateAlert(ctx context.Context, machineID string, aler
 	return "", nil
 }
 
+// upsertKey identifies the decisions of a pull that can reuse a decision of a previous pull,
+// since only their expiration and owner need to change
+type upsertKey struct {
+	value        string
+	scope        string
+	decisionType string
+	scenario     string
+	simulated    bool
+}
+
 // UpdateCommunityBlocklist is called to update either the community blocklist (or other lists the user subscribed to)
-// it takes care of creating the new alert with the associated decisions, and it will as well deleted the "older" overlapping decisions:
+// it takes care of creating the new alert with the associated decisions, and it will as well replace the "older" overlapping decisions:
 // 1st pull, you get decisions [1,2,3]. it inserts [1,2,3]
-// 2nd pull, you get decisions [1,2,3,4]. it inserts [1,2,3,4] and will try to delete [1,2,3,4] with a different alert ID and same origin
+// 2nd pull, you get decisions [1,2,3,4]. it moves [1,2,3] to the new alert with their new expiration, inserts [4],
+// and deletes the other decisions with the same values, a different alert ID and the same origin
+// Updated decisions are counted as inserted.
 func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models.Alert) (int, int, int, error) {
 	if alertItem == nil {
 		return 0, 0, 0, errors.New("nil alert")
@@ -225,7 +239,13 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 		ts = time.Now().UTC()
 	}
 
-	alertB := c.Ent.Alert.
+	// the alert and its decisions are created in the same transaction, a failure doesn't leave a partial alert
+	txClient, err := c.Ent.Tx(ctx)
+	if err != nil {
+		return 0, 0, 0, errors.Wrapf(BulkError, "error creating transaction : %s", err)
+	}
+
+	alertB := txClient.Alert.
 		Create().
 		SetScenario(*alertItem.Scenario).
 		SetMessage(*alertItem.Message).
@@ -250,16 +270,15 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 
 	alertRef, err := alertB.Save(ctx)
 	if err != nil {
-		return 0, 0, 0, errors.Wrapf(BulkError, "error creating alert : %s", err)
+		return 0, 0, 0, rollbackOnError(txClient, err, "error creating alert")
 	}
 
 	if len(alertItem.Decisions) == 0 {
-		return alertRef.ID, 0, 0, nil
-	}
+		if err := txClient.Commit(); err != nil {
+			return 0, 0, 0, rollbackOnError(txClient, err, "error committing transaction")
+		}
 
-	txClient, err := c.Ent.Tx(ctx)
-	if err != nil {
-		return 0, 0, 0, errors.Wrapf(BulkError, "error creating transaction : %s", err)
+		return alertRef.ID, 0, 0, nil
 	}
 
 	decOrigin := CapiMachineID
@@ -273,9 +292,38 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 	deleted := 0
 	inserted := 0
 
-	decisionBuilders := make([]*ent.DecisionCreate, 0, len(alertItem.Decisions))
 	valueList := make([]string, 0, len(alertItem.Decisions))
 
+	for _, decisionItem := range alertItem.Decisions {
+		if decisionItem.Value != nil {
+			valueList = append(valueList, *decisionItem.Value)
+		}
+	}
+
+	// the decisions of the previous pulls with the same values, to be updated or deleted
+	previous := make(map[upsertKey][]*ent.Decision)
+
+	for _, chunk := range slicetools.Chunks(valueList, c.decisionBulkSize) {
+		found, err := txClient.Decision.Query().
+			Where(decision.And(
+				decision.OriginEQ(decOrigin),
+				decision.Not(decision.HasOwnerWith(alert.IDEQ(alertRef.ID))),
+				decision.ValueIn(chunk...),
+			)).All(ctx)
+		if err != nil {
+			return 0, 0, 0, rollbackOnError(txClient, err, "getting older community blocklist decisions")
+		}
+
+		for _, d := range found {
+			key := upsertKey{value: d.Value, scope: d.Scope, decisionType: d.Type, scenario: d.Scenario, simulated: d.Simulated}
+			previous[key] = append(previous[key], d)
+		}
+	}
+
+	decisionBuilders := make([]*ent.DecisionCreate, 0, len(alertItem.Decisions))
+	// IDs of the decisions to keep, by new expiration
+	updates := make(map[time.Time][]int)
+
 	for _, decisionItem := range alertItem.Decisions {
 		if decisionItem.Duration == nil {
 			log.Warning("nil duration in community decision")
@@ -292,6 +340,28 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 			continue
 		}
 
+		if decisionItem.Value == nil {
+			log.Warning("nil value in community decision")
+			continue
+		}
+
+		until := ts.Add(duration)
+		scope := types.NormalizeScope(*decisionItem.Scope)
+
+		// only the expiration and the owner of a decision can be updated: reuse an identical one.
+		// The decisions with metadata are always inserted, the pull batch id in particular must
+		// not be carried over to the next pull (see DeleteDecisionsByPullBatch)
+		if len(decisionItem.Metadata) == 0 {
+			key := upsertKey{value: *decisionItem.Value, scope: scope, decisionType: *decisionItem.Type, scenario: *decisionItem.Scenario, simulated: *alertItem.Simulated}
+
+			if idx := slices.IndexFunc(previous[key], func(d *ent.Decision) bool { return len(d.Metadata) == 0 }); idx >= 0 {
+				updates[until] = append(updates[until], previous[key][idx].ID)
+				previous[key] = slices.Delete(previous[key], idx, idx+1)
+
+				continue
+			}
+		}
+
 		var rng csnet.Range
 
 		/*if the scope is IP or Range, convert the value to integers */
@@ -303,8 +373,8 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 		}
 
 		/*bulk insert some new decisions*/
-		decisionBuilder := c.Ent.Decision.Create().
-			SetUntil(ts.Add(duration)).
+		decisionBuilder := txClient.Decision.Create().
+			SetUntil(until).
 			SetScenario(*decisionItem.Scenario).
 			SetType(*decisionItem.Type).
 			SetStartIP(rng.Start.Addr).
@@ -313,32 +383,29 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 			SetEndSuffix(rng.End.Sfx).
 			SetIPSize(int64(rng.Size())).
 			SetValue(*decisionItem.Value).
-			SetScope(*decisionItem.Scope).
+			SetScope(scope).
 			SetOrigin(*decisionItem.Origin).
 			SetSimulated(*alertItem.Simulated).
 			SetOwner(alertRef)
 
-		decisionBuilders = append(decisionBuilders, decisionBuilder)
-
-		/*for bulk delete of duplicate decisions*/
-		if decisionItem.Value == nil {
-			log.Warning("nil value in community decision")
-			continue
+		if len(decisionItem.Metadata) > 0 {
+			decisionBuilder.SetMetadata(decisionItem.Metadata)
 		}
 
-		valueList = append(valueList, *decisionItem.Value)
+		decisionBuilders = append(decisionBuilders, decisionBuilder)
 	}
 
-	deleteChunks := slicetools.Chunks(valueList, c.decisionBulkSize)
+	// the older decisions that have not been reused are replaced
+	toDelete := []int{}
 
-	for _, deleteChunk := range deleteChunks {
-		// Deleting older decisions from capi
-		deletedDecisions, err := txClient.Decision.Delete().
-			Where(decision.And(
-				decision.OriginEQ(decOrigin),
-				decision.Not(decision.HasOwnerWith(alert.IDEQ(alertRef.ID))),
-				decision.ValueIn(deleteChunk...),
-			)).Exec(ctx)
+	for _, decisions := range previous {
+		for _, d := range decisions {
+			toDelete = append(toDelete, d.ID)
+		}
+	}
+
+	for _, deleteChunk := range slicetools.Chunks(toDelete, c.decisionBulkSize) {
+		deletedDecisions, err := txClient.Decision.Delete().Where(decision.IDIn(deleteChunk...)).Exec(ctx)
 		if err != nil {
 			return 0, 0, 0, rollbackOnError(txClient, err, "deleting older community blocklist decisions")
 		}
@@ -346,6 +413,21 @@ func (c *Client) UpdateCommunityBlocklist(ctx context.Context, alertItem *models
 		deleted += deletedDecisions
 	}
 
+	for until, ids := range updates {
+		for _, updateChunk := range slicetools.Chunks(ids, c.decisionBulkSize) {
+			updatedDecisions, err := txClient.Decision.Update().
+				Where(decision.IDIn(updateChunk...)).
+				SetUntil(until).
+				SetOwner(alertRef).
+				Save(ctx)
+			if err != nil {
+				return 0, 0, 0, rollbackOnError(txClient, err, "updating older community blocklist decisions")
+			}
+
+			inserted += updatedDecisions
+		}
+	}
+
 	builderChunks := slicetools.Chunks(decisionBuilders, c.decisionBulkSize)
 
 	for _, builderChunk := range builderChunks {
@@ -397,7 +479,7 @@ func (c *Client) createDecisionChunk(ctx context.Context, simulated bool, stopAt
 			SetEndSuffix(rng.End.Sfx).
 			SetIPSize(int64(rng.Size())).
 			SetValue(*decisionItem.Value).
-			SetScope(*decisionItem.Scope).
+			SetScope(types.NormalizeScope(*decisionItem.Scope)).
 			SetOrigin(*decisionItem.Origin).
 			SetSimulated(simulated).
 			SetUUID(decisionItem.UUID)
@@ -750,7 +832,7 @@ func (c *Client) AlertsCountPerScenario(ctx context.Context, filter map[string][
 
 	query := c.Ent.Alert.Query()
 
-	query, err := applyAlertFilter(query, filter)
+	query, err := applyAlertFilter(query, filter, c.SourceScopePrefix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build alert request: %w", err)
 	}
@@ -801,7 +883,7 @@ func (c *Client) QueryAlertWithFilter(ctx context.Context, filter map[string][]s
 	for {
 		alerts := c.Ent.Alert.Query()
 
-		alerts, err := applyAlertFilter(alerts, filter)
+		alerts, err := applyAlertFilter(alerts, filter, c.SourceScopePrefix)
 		if err != nil {
 			return nil, err
 		}
@@ -953,7 +1035,7 @@ func (c *Client) DeleteAlertByID(ctx context.Context, id int) error {
 }
 
 func (c *Client) DeleteAlertWithFilter(ctx context.Context, filter map[string][]string) (int, error) {
-	preds, err := alertPredicatesFromFilter(filter)
+	preds, err := alertPredicatesFromFilter(filter, c.SourceScopePrefix)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
+	"github.com/crowdsecurity/crowdsec/pkg/database/ent/hook"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestUpdateCommunityBlocklistRollback(t *testing.T) {
+	ctx := t.Context()
+	dbClient := getDBClient(t, ctx)
+
+	// the decisions can't be inserted, after the alert has been
+	dbClient.Ent.Decision.Use(func(next ent.Mutator) ent.Mutator {
+		return hook.DecisionFunc(func(ctx context.Context, m *ent.DecisionMutation) (ent.Value, error) {
+			if value, _ := m.Value(); value == "1.2.3.5" {
+				return nil, errors.New("injected failure")
+			}
+
+			return next.Mutate(ctx, m)
+		})
+	})
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	decisions := []*models.Decision{}
+	for _, value := range []string{"1.2.3.4", "1.2.3.5"} {
+		decisions = append(decisions, &models.Decision{
+			Duration: ptr.Of("1h"),
+			Origin:   ptr.Of(types.CAPIOrigin),
+			Scenario: ptr.Of("crowdsecurity/ssh-bf"),
+			Scope:    ptr.Of(types.Ip),
+			Type:     ptr.Of("ban"),
+			Value:    ptr.Of(value),
+		})
+	}
+
+	_, _, _, err := dbClient.UpdateCommunityBlocklist(ctx, &models.Alert{
+		Scenario:        ptr.Of(types.CAPIOrigin),
+		ScenarioHash:    ptr.Of(""),
+		ScenarioVersion: ptr.Of(""),
+		Message:         ptr.Of(""),
+		EventsCount:     ptr.Of(int32(0)),
+		StartAt:         ptr.Of(now),
+		StopAt:          ptr.Of(now),
+		Capacity:        ptr.Of(int32(0)),
+		Leakspeed:       ptr.Of(""),
+		Simulated:       ptr.Of(false),
+		Source:          &models.Source{Scope: ptr.Of(types.CAPIOrigin), Value: ptr.Of("")},
+		Decisions:       decisions,
+	})
+	cstest.RequireErrorContains(t, err, "injected failure")
+
+	// nothing is left of the alert
+	assert.Zero(t, dbClient.Ent.Alert.Query().CountX(ctx))
+	assert.Zero(t, dbClient.Ent.Decision.Query().CountX(ctx))
+}
@@ -26,6 +26,9 @@ type Client struct {
 	Type             string
 	WalMode          *bool
 	decisionBulkSize int
+	replica          *ent.Client
+	// prepended to the source scope of the community and list alerts, see source_scope_prefix
+	SourceScopePrefix string
 }
 
 func getEntDriver(dbtype string, dbdialect string, dsn string, config *csconfig.DatabaseCfg) (*entsql.Driver, error) {
@@ -103,6 +106,22 @@ func NewClient(ctx context.Context, config *csconfig.DatabaseCfg) (*Client, erro
 		return nil, fmt.Errorf("failed creating schema resources: %w", err)
 	}
 
+	var replica *ent.Client
+
+	// the replica is expected to be kept in sync by the database itself: no schema migration here
+	if config.ReplicaDSN != "" {
+		replicaDrv, err := getEntDriver(typ, dia, config.ReplicaDSN, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed opening connection to %s replica: %w", config.Type, err)
+		}
+
+		replica = ent.NewClient(ent.Driver(replicaDrv), entOpt)
+
+		if config.LogLevel != nil && *config.LogLevel >= log.DebugLevel {
+			replica = replica.Debug()
+		}
+	}
+
 	return &Client{
 		Ent:              client,
 		Log:              clog,
@@ -110,5 +129,28 @@ func NewClient(ctx context.Context, config *csconfig.DatabaseCfg) (*Client, erro
 		Type:             config.Type,
 		WalMode:          config.UseWal,
 		decisionBulkSize: config.DecisionBulkSize,
+		replica:          replica,
 	}, nil
 }
+
+// Reader returns the client to use for read-only queries that can tolerate
+// some replication lag: the replica if one is configured, the primary otherwise.
+// It's meant for reporting, the queries that drive a pull or a write must use the primary.
+func (c *Client) Reader() *ent.Client {
+	if c.replica != nil {
+		return c.replica
+	}
+
+	return c.Ent
+}
+
+// Close closes the connections to the primary database and to the replica.
+func (c *Client) Close() error {
+	err := c.Ent.Close()
+
+	if c.replica != nil {
+		err = errors.Join(err, c.replica.Close())
+	}
+
+	return err
+}
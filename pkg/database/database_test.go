@@ -0,0 +1,58 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/crowdsec/pkg/csconfig"
+)
+
+func TestReplica(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	replicaPath := filepath.Join(dir, "replica.db")
+
+	// creates the schema on the replica
+	replicaClient, err := NewClient(ctx, &csconfig.DatabaseCfg{
+		Type:   "sqlite",
+		DbPath: replicaPath,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { replicaClient.Close() })
+
+	dbClient, err := NewClient(ctx, &csconfig.DatabaseCfg{
+		Type:       "sqlite",
+		DbPath:     filepath.Join(dir, "primary.db"),
+		ReplicaDSN: "file:" + replicaPath + "?_busy_timeout=100000&_fk=1",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { dbClient.Close() })
+
+	// writes go to the primary
+	err = dbClient.SetConfigItem(ctx, "written", "primary")
+	require.NoError(t, err)
+
+	err = replicaClient.SetConfigItem(ctx, "replicated", "replica")
+	require.NoError(t, err)
+
+	value, err := dbClient.GetConfigItem(ctx, "written")
+	require.NoError(t, err)
+	assert.Equal(t, "primary", value)
+
+	value, err = replicaClient.GetConfigItem(ctx, "written")
+	require.NoError(t, err)
+	assert.Empty(t, value)
+
+	// reads hit the replica
+	names := dbClient.Reader().ConfigItem.Query().Select("name").StringsX(ctx)
+	assert.Equal(t, []string{"replicated"}, names)
+
+	// and fall back to the primary without one
+	assert.Same(t, replicaClient.Ent, replicaClient.Reader())
+}
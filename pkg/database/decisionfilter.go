@@ -45,16 +45,7 @@ func applyDecisionFilter(query *ent.DecisionQuery, filter map[string][]string) (
 		case "scopes", "scope": // Swagger mentions both of them, let's just support both to make sure we don't break anything
 			scopes := strings.Split(value[0], ",")
 			for i, scope := range scopes {
-				switch strings.ToLower(scope) {
-				case "ip":
-					scopes[i] = types.Ip
-				case "range":
-					scopes[i] = types.Range
-				case "country":
-					scopes[i] = types.Country
-				case "as":
-					scopes[i] = types.AS
-				}
+				scopes[i] = types.NormalizeScope(scope)
 			}
 
 			query = query.Where(decision.ScopeIn(scopes...))
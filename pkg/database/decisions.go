@@ -3,10 +3,12 @@ package database
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"strconv"
 	"time"
 
 	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqljson"
 	"github.com/pkg/errors"
 
 	"github.com/crowdsecurity/go-cs-lib/slicetools"
@@ -14,6 +16,7 @@ import (
 	"github.com/crowdsecurity/crowdsec/pkg/csnet"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent"
 	"github.com/crowdsecurity/crowdsec/pkg/database/ent/decision"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
 )
 
 const decisionDeleteBulkSize = 256 // scientifically proven to be the best value for bulk delete
@@ -200,7 +203,9 @@ func (c *Client) QueryNewDecisionsSinceWithFilters(ctx context.Context, since *t
 	errorMsg := "new decisions"
 
 	if since != nil {
-		query = query.Where(decision.CreatedAtGT(*since))
+		// the community blocklist decisions are updated when they are pulled again,
+		// the bouncers must receive their new expiration
+		query = query.Where(decision.UpdatedAtGT(*since))
 
 		errorMsg = fmt.Sprintf("%s since %q", errorMsg, since)
 	}
@@ -249,7 +254,7 @@ func (c *Client) ExpireDecisionsWithFilter(ctx context.Context, filter map[strin
 				return 0, nil, errors.Wrapf(InvalidFilter, "invalid contains value : %s", err)
 			}
 		case "scopes":
-			decisions = decisions.Where(decision.ScopeEQ(value[0]))
+			decisions = decisions.Where(decision.ScopeEQ(types.NormalizeScope(value[0])))
 		case "uuid":
 			decisions = decisions.Where(decision.UUIDIn(value...))
 		case "origin":
@@ -381,6 +386,32 @@ func (c *Client) ExpireDecisionByID(ctx context.Context, decisionID int) (int, [
 	return count, toUpdate, err
 }
 
+// PullBatchMetadataKey is the metadata of the pulled decisions holding the id of the pull that created them,
+// when the tag_pull_batch option is enabled. Like any decision with metadata, they are inserted again by
+// each pull instead of being updated.
+const PullBatchMetadataKey = "pull_batch_id"
+
+// DeleteDecisionsByPullBatch reverts a pull by expiring the active decisions it created. They are
+// expired rather than removed, like with "cscli decisions delete", so that the bouncers are notified.
+func (c *Client) DeleteDecisionsByPullBatch(ctx context.Context, batchID string) (int, error) {
+	if batchID == "" {
+		return 0, errors.New("empty pull batch id")
+	}
+
+	toExpire, err := c.Ent.Decision.Query().Where(
+		decision.UntilGT(time.Now().UTC()),
+		decision.OriginIn(types.CAPIOrigin, types.ListOrigin),
+		func(s *sql.Selector) {
+			s.Where(sqljson.ValueEQ(s.C(decision.FieldMetadata), batchID, sqljson.Path(PullBatchMetadataKey)))
+		},
+	).All(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("while getting the decisions of pull batch %s: %w", batchID, err)
+	}
+
+	return c.ExpireDecisions(ctx, toExpire)
+}
+
 func (c *Client) CountDecisionsByValue(ctx context.Context, value string, since *time.Time, onlyActive bool) (int, error) {
 	rng, err := csnet.NewRange(value)
 	if err != nil {
@@ -411,6 +442,35 @@ func (c *Client) CountDecisionsByValue(ctx context.Context, value string, since
 	return count, nil
 }
 
+// DecisionsContaining returns the active decisions that cover an IP: the decisions on the IP itself,
+// and the decisions on a range that contains it.
+func (c *Client) DecisionsContaining(ctx context.Context, ip netip.Addr) ([]*ent.Decision, error) {
+	if !ip.IsValid() {
+		return nil, errors.New("invalid IP address")
+	}
+
+	rng, err := csnet.NewRange(ip.Unmap().String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert '%s' to int: %w", ip, err)
+	}
+
+	decisions := c.Ent.Decision.Query().Where(
+		decision.UntilGT(time.Now().UTC()),
+	)
+
+	decisions, err = decisionIPFilter(decisions, true, rng)
+	if err != nil {
+		return nil, fmt.Errorf("fail to apply StartIpEndIpFilter: %w", err)
+	}
+
+	ret, err := decisions.Order(ent.Asc(decision.FieldID)).All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get decisions: %w", err)
+	}
+
+	return ret, nil
+}
+
 func (c *Client) GetActiveDecisionsTimeLeftByValue(ctx context.Context, decisionValue string) (time.Duration, error) {
 	rng, err := csnet.NewRange(decisionValue)
 	if err != nil {
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/ptr"
+
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+	"github.com/crowdsecurity/crowdsec/pkg/types"
+)
+
+func TestDecisionScopeNormalization(t *testing.T) {
+	ctx := t.Context()
+	dbClient := getDBClient(t, ctx)
+
+	decisions := []*models.Decision{}
+	for _, scope := range []string{"ip", "IP", "Ip"} {
+		decisions = append(decisions, &models.Decision{
+			Duration: ptr.Of("1h"),
+			Origin:   ptr.Of(types.CscliOrigin),
+			Scenario: ptr.Of("test"),
+			Scope:    ptr.Of(scope),
+			Type:     ptr.Of("ban"),
+			Value:    ptr.Of("1.2.3.4"),
+		})
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err := dbClient.CreateAlert(ctx, "", []*models.Alert{{
+		Scenario:        ptr.Of("test"),
+		ScenarioHash:    ptr.Of(""),
+		ScenarioVersion: ptr.Of(""),
+		Message:         ptr.Of(""),
+		EventsCount:     ptr.Of(int32(1)),
+		StartAt:         ptr.Of(now),
+		StopAt:          ptr.Of(now),
+		Capacity:        ptr.Of(int32(0)),
+		Leakspeed:       ptr.Of(""),
+		Simulated:       ptr.Of(false),
+		Source:          &models.Source{Scope: ptr.Of("ip"), Value: ptr.Of("1.2.3.4")},
+		Decisions:       decisions,
+	}})
+	require.NoError(t, err)
+
+	// all the decisions are stored with the canonical scope
+	for _, d := range dbClient.Ent.Decision.Query().AllX(ctx) {
+		assert.Equal(t, types.Ip, d.Scope)
+	}
+
+	// and are found whatever the case of the filter
+	for _, scope := range []string{"ip", "IP", "Ip"} {
+		found, err := dbClient.QueryDecisionWithFilter(ctx, map[string][]string{"scopes": {scope}, "value": {"1.2.3.4"}})
+		require.NoError(t, err)
+		assert.Len(t, found, 3, "scope %s", scope)
+	}
+
+	// so they collide when expiring by scope
+	count, _, err := dbClient.ExpireDecisionsWithFilter(ctx, map[string][]string{"scopes": {"IP"}, "value": {"1.2.3.4"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestDecisionsContaining(t *testing.T) {
+	ctx := t.Context()
+	dbClient := getDBClient(t, ctx)
+
+	decisions := []*models.Decision{}
+	for _, d := range []struct{ scope, value string }{
+		{types.Ip, "1.2.3.4"},
+		{types.Range, "1.2.3.0/24"},
+		{types.Ip, "1.2.3.5"},
+		{types.Range, "10.0.0.0/8"},
+		{"Country", "FR"},
+	} {
+		decisions = append(decisions, &models.Decision{
+			Duration: ptr.Of("1h"),
+			Origin:   ptr.Of(types.CscliOrigin),
+			Scenario: ptr.Of("test"),
+			Scope:    ptr.Of(d.scope),
+			Type:     ptr.Of("ban"),
+			Value:    ptr.Of(d.value),
+		})
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err := dbClient.CreateAlert(ctx, "", []*models.Alert{{
+		Scenario:        ptr.Of("test"),
+		ScenarioHash:    ptr.Of(""),
+		ScenarioVersion: ptr.Of(""),
+		Message:         ptr.Of(""),
+		EventsCount:     ptr.Of(int32(1)),
+		StartAt:         ptr.Of(now),
+		StopAt:          ptr.Of(now),
+		Capacity:        ptr.Of(int32(0)),
+		Leakspeed:       ptr.Of(""),
+		Simulated:       ptr.Of(false),
+		Source:          &models.Source{Scope: ptr.Of("ip"), Value: ptr.Of("1.2.3.4")},
+		Decisions:       decisions,
+	}})
+	require.NoError(t, err)
+
+	values := func(ip string) []string {
+		found, err := dbClient.DecisionsContaining(ctx, netip.MustParseAddr(ip))
+		require.NoError(t, err)
+
+		ret := []string{}
+		for _, d := range found {
+			ret = append(ret, d.Value)
+		}
+
+		return ret
+	}
+
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.0/24"}, values("1.2.3.4"))
+	assert.Equal(t, []string{"1.2.3.0/24"}, values("1.2.3.77"))
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.0/24"}, values("::ffff:1.2.3.4"))
+	assert.Empty(t, values("192.168.1.1"))
+	assert.Empty(t, values("2001:db8::1"))
+
+	_, err = dbClient.DecisionsContaining(ctx, netip.Addr{})
+	require.Error(t, err)
+}
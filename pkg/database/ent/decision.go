@@ -3,6 +3,7 @@
 package ent
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -50,6 +51,8 @@ type Decision struct {
 	UUID string `json:"uuid,omitempty"`
 	// AlertDecisions holds the value of the "alert_decisions" field.
 	AlertDecisions int `json:"alert_decisions,omitempty"`
+	// Metadata holds the value of the "metadata" field.
+	Metadata map[string]string `json:"metadata,omitempty"`
 	// Edges holds the relations/edges for other nodes in the graph.
 	// The values are being populated by the DecisionQuery when eager-loading is set.
 	Edges        DecisionEdges `json:"edges"`
@@ -81,6 +84,8 @@ func (*Decision) scanValues(columns []string) ([]any, error) {
 	values := make([]any, len(columns))
 	for i := range columns {
 		switch columns[i] {
+		case decision.FieldMetadata:
+			values[i] = new([]byte)
 		case decision.FieldSimulated:
 			values[i] = new(sql.NullBool)
 		case decision.FieldID, decision.FieldStartIP, decision.FieldEndIP, decision.FieldStartSuffix, decision.FieldEndSuffix, decision.FieldIPSize, decision.FieldAlertDecisions:
@@ -207,6 +212,14 @@ func (d *Decision) assignValues(columns []string, values []any) error {
 			} else if value.Valid {
 				d.AlertDecisions = int(value.Int64)
 			}
+		case decision.FieldMetadata:
+			if value, ok := values[i].(*[]byte); !ok {
+				return fmt.Errorf("unexpected type %T for field metadata", values[i])
+			} else if value != nil && len(*value) > 0 {
+				if err := json.Unmarshal(*value, &d.Metadata); err != nil {
+					return fmt.Errorf("unmarshal field metadata: %w", err)
+				}
+			}
 		default:
 			d.selectValues.Set(columns[i], values[i])
 		}
@@ -297,6 +310,9 @@ func (d *Decision) String() string {
 	builder.WriteString(", ")
 	builder.WriteString("alert_decisions=")
 	builder.WriteString(fmt.Sprintf("%v", d.AlertDecisions))
+	builder.WriteString(", ")
+	builder.WriteString("metadata=")
+	builder.WriteString(fmt.Sprintf("%v", d.Metadata))
 	builder.WriteByte(')')
 	return builder.String()
 }
@@ -46,6 +46,8 @@ const (
 	FieldUUID = "uuid"
 	// FieldAlertDecisions holds the string denoting the alert_decisions field in the database.
 	FieldAlertDecisions = "alert_decisions"
+	// FieldMetadata holds the string denoting the metadata field in the database.
+	FieldMetadata = "metadata"
 	// EdgeOwner holds the string denoting the owner edge name in mutations.
 	EdgeOwner = "owner"
 	// Table holds the table name of the decision in the database.
@@ -78,6 +80,7 @@ var Columns = []string{
 	FieldSimulated,
 	FieldUUID,
 	FieldAlertDecisions,
+	FieldMetadata,
 }
 
 // ValidColumn reports if the column name is valid (part of the table columns).
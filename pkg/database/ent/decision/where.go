@@ -955,6 +955,16 @@ func AlertDecisionsNotNil() predicate.Decision {
 	return predicate.Decision(sql.FieldNotNull(FieldAlertDecisions))
 }
 
+// MetadataIsNil applies the IsNil predicate on the "metadata" field.
+func MetadataIsNil() predicate.Decision {
+	return predicate.Decision(sql.FieldIsNull(FieldMetadata))
+}
+
+// MetadataNotNil applies the NotNil predicate on the "metadata" field.
+func MetadataNotNil() predicate.Decision {
+	return predicate.Decision(sql.FieldNotNull(FieldMetadata))
+}
+
 // HasOwner applies the HasEdge predicate on the "owner" edge.
 func HasOwner() predicate.Decision {
 	return predicate.Decision(func(s *sql.Selector) {
@@ -207,6 +207,12 @@ func (dc *DecisionCreate) SetNillableAlertDecisions(i *int) *DecisionCreate {
 	return dc
 }
 
+// SetMetadata sets the "metadata" field.
+func (dc *DecisionCreate) SetMetadata(m map[string]string) *DecisionCreate {
+	dc.mutation.SetMetadata(m)
+	return dc
+}
+
 // SetOwnerID sets the "owner" edge to the Alert entity by ID.
 func (dc *DecisionCreate) SetOwnerID(id int) *DecisionCreate {
 	dc.mutation.SetOwnerID(id)
@@ -388,6 +394,10 @@ func (dc *DecisionCreate) createSpec() (*Decision, *sqlgraph.CreateSpec) {
 		_spec.SetField(decision.FieldUUID, field.TypeString, value)
 		_node.UUID = value
 	}
+	if value, ok := dc.mutation.Metadata(); ok {
+		_spec.SetField(decision.FieldMetadata, field.TypeJSON, value)
+		_node.Metadata = value
+	}
 	if nodes := dc.mutation.OwnerIDs(); len(nodes) > 0 {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.M2O,
@@ -555,6 +565,9 @@ func (u *DecisionUpsertOne) UpdateNewValues() *DecisionUpsertOne {
 		if _, exists := u.create.mutation.UUID(); exists {
 			s.SetIgnore(decision.FieldUUID)
 		}
+		if _, exists := u.create.mutation.Metadata(); exists {
+			s.SetIgnore(decision.FieldMetadata)
+		}
 	}))
 	return u
 }
@@ -857,6 +870,9 @@ func (u *DecisionUpsertBulk) UpdateNewValues() *DecisionUpsertBulk {
 			if _, exists := b.mutation.UUID(); exists {
 				s.SetIgnore(decision.FieldUUID)
 			}
+			if _, exists := b.mutation.Metadata(); exists {
+				s.SetIgnore(decision.FieldMetadata)
+			}
 		}
 	}))
 	return u
@@ -6078,6 +6078,7 @@ type DecisionMutation struct {
 	origin          *string
 	simulated       *bool
 	uuid            *string
+	metadata        *map[string]string
 	clearedFields   map[string]struct{}
 	owner           *int
 	clearedowner    bool
@@ -6969,6 +6970,55 @@ func (m *DecisionMutation) ResetAlertDecisions() {
 	delete(m.clearedFields, decision.FieldAlertDecisions)
 }
 
+// SetMetadata sets the "metadata" field.
+func (m *DecisionMutation) SetMetadata(value map[string]string) {
+	m.metadata = &value
+}
+
+// Metadata returns the value of the "metadata" field in the mutation.
+func (m *DecisionMutation) Metadata() (r map[string]string, exists bool) {
+	v := m.metadata
+	if v == nil {
+		return
+	}
+	return *v, true
+}
+
+// OldMetadata returns the old "metadata" field's value of the Decision entity.
+// If the Decision object wasn't provided to the builder, the object is fetched from the database.
+// An error is returned if the mutation operation is not UpdateOne, or the database query fails.
+func (m *DecisionMutation) OldMetadata(ctx context.Context) (v map[string]string, err error) {
+	if !m.op.Is(OpUpdateOne) {
+		return v, errors.New("OldMetadata is only allowed on UpdateOne operations")
+	}
+	if m.id == nil || m.oldValue == nil {
+		return v, errors.New("OldMetadata requires an ID field in the mutation")
+	}
+	oldValue, err := m.oldValue(ctx)
+	if err != nil {
+		return v, fmt.Errorf("querying old value for OldMetadata: %w", err)
+	}
+	return oldValue.Metadata, nil
+}
+
+// ClearMetadata clears the value of the "metadata" field.
+func (m *DecisionMutation) ClearMetadata() {
+	m.metadata = nil
+	m.clearedFields[decision.FieldMetadata] = struct{}{}
+}
+
+// MetadataCleared returns if the "metadata" field was cleared in this mutation.
+func (m *DecisionMutation) MetadataCleared() bool {
+	_, ok := m.clearedFields[decision.FieldMetadata]
+	return ok
+}
+
+// ResetMetadata resets all changes to the "metadata" field.
+func (m *DecisionMutation) ResetMetadata() {
+	m.metadata = nil
+	delete(m.clearedFields, decision.FieldMetadata)
+}
+
 // SetOwnerID sets the "owner" edge to the Alert entity by id.
 func (m *DecisionMutation) SetOwnerID(id int) {
 	m.owner = &id
@@ -7043,7 +7093,7 @@ func (m *DecisionMutation) Type() string {
 // order to get all numeric fields that were incremented/decremented, call
 // AddedFields().
 func (m *DecisionMutation) Fields() []string {
-	fields := make([]string, 0, 16)
+	fields := make([]string, 0, 17)
 	if m.created_at != nil {
 		fields = append(fields, decision.FieldCreatedAt)
 	}
@@ -7092,6 +7142,9 @@ func (m *DecisionMutation) Fields() []string {
 	if m.owner != nil {
 		fields = append(fields, decision.FieldAlertDecisions)
 	}
+	if m.metadata != nil {
+		fields = append(fields, decision.FieldMetadata)
+	}
 	return fields
 }
 
@@ -7132,6 +7185,8 @@ func (m *DecisionMutation) Field(name string) (ent.Value, bool) {
 		return m.UUID()
 	case decision.FieldAlertDecisions:
 		return m.AlertDecisions()
+	case decision.FieldMetadata:
+		return m.Metadata()
 	}
 	return nil, false
 }
@@ -7173,6 +7228,8 @@ func (m *DecisionMutation) OldField(ctx context.Context, name string) (ent.Value
 		return m.OldUUID(ctx)
 	case decision.FieldAlertDecisions:
 		return m.OldAlertDecisions(ctx)
+	case decision.FieldMetadata:
+		return m.OldMetadata(ctx)
 	}
 	return nil, fmt.Errorf("unknown Decision field %s", name)
 }
@@ -7294,6 +7351,13 @@ func (m *DecisionMutation) SetField(name string, value ent.Value) error {
 		}
 		m.SetAlertDecisions(v)
 		return nil
+	case decision.FieldMetadata:
+		v, ok := value.(map[string]string)
+		if !ok {
+			return fmt.Errorf("unexpected type %T for field %s", value, name)
+		}
+		m.SetMetadata(v)
+		return nil
 	}
 	return fmt.Errorf("unknown Decision field %s", name)
 }
@@ -7411,6 +7475,9 @@ func (m *DecisionMutation) ClearedFields() []string {
 	if m.FieldCleared(decision.FieldAlertDecisions) {
 		fields = append(fields, decision.FieldAlertDecisions)
 	}
+	if m.FieldCleared(decision.FieldMetadata) {
+		fields = append(fields, decision.FieldMetadata)
+	}
 	return fields
 }
 
@@ -7449,6 +7516,9 @@ func (m *DecisionMutation) ClearField(name string) error {
 	case decision.FieldAlertDecisions:
 		m.ClearAlertDecisions()
 		return nil
+	case decision.FieldMetadata:
+		m.ClearMetadata()
+		return nil
 	}
 	return fmt.Errorf("unknown Decision nullable field %s", name)
 }
@@ -7505,6 +7575,9 @@ func (m *DecisionMutation) ResetField(name string) error {
 	case decision.FieldAlertDecisions:
 		m.ResetAlertDecisions()
 		return nil
+	case decision.FieldMetadata:
+		m.ResetMetadata()
+		return nil
 	}
 	return fmt.Errorf("unknown Decision field %s", name)
 }
@@ -1,7 +1,20 @@
 package metrics
 
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
 var AcquisitionMetricsNames = []string{}
 
 func RegisterAcquisitionMetric(metricName string) {
 	AcquisitionMetricsNames = append(AcquisitionMetricsNames, metricName)
 }
+
+const AcquisitionDroppedEventsMetricName = "cs_acquisition_dropped_events_total"
+
+var AcquisitionDroppedEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: AcquisitionDroppedEventsMetricName,
+		Help: "Total events dropped because the output buffer of the datasource was full.",
+	},
+	[]string{"source", "datasource_type", "acquis_type"})
@@ -24,6 +24,33 @@ var SyslogDataSourceLinesParsed = prometheus.NewCounterVec(
 	},
 	[]string{"source", "type", "datasource_type", "acquis_type"})
 
+const SyslogDataSourceLinesRejectedMetricName = "cs_syslogsource_rejected_total"
+
+var SyslogDataSourceLinesRejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: SyslogDataSourceLinesRejectedMetricName,
+		Help: "Total lines that were rejected by the parser, the rate limit or allowed_sources",
+	},
+	[]string{"source", "reason", "datasource_type", "acquis_type"})
+
+const SyslogDataSourceTCPConnectionsMetricName = "cs_syslogsource_tcp_connections"
+
+var SyslogDataSourceTCPConnections = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: SyslogDataSourceTCPConnectionsMetricName,
+		Help: "Number of currently open TCP connections",
+	},
+	[]string{"listener", "datasource_type", "acquis_type"})
+
+const SyslogDataSourceTCPTimeoutsMetricName = "cs_syslogsource_tcp_timeouts_total"
+
+var SyslogDataSourceTCPTimeouts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: SyslogDataSourceTCPTimeoutsMetricName,
+		Help: "Total TCP connections closed because of a read timeout",
+	},
+	[]string{"listener", "datasource_type", "acquis_type"})
+
 //nolint:gochecknoinits
 func init() {
 	RegisterAcquisitionMetric(SyslogDataSourceLinesParsedMetricName)
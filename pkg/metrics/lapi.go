@@ -70,3 +70,91 @@ var LapiResponseTime = prometheus.NewHistogramVec(
 	},
 	[]string{"endpoint", "method"},
 )
+
+/*decisions pulled from CAPI (community blocklist or subscribed lists) that were dropped because of an allowlist*/
+const LapiPulledDecisionsAllowlistedMetricName = "cs_lapi_pulled_decisions_allowlisted_total"
+
+var LapiPulledDecisionsAllowlisted = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: LapiPulledDecisionsAllowlistedMetricName,
+		Help: "Number of decisions pulled from CAPI that were not applied because of an allowlist.",
+	},
+	[]string{"origin"},
+)
+
+/*alerts waiting to be pushed to CAPI*/
+const LapiPushQueueDepthMetricName = "cs_lapi_push_queue_depth"
+
+var LapiPushQueueDepth = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: LapiPushQueueDepthMetricName,
+		Help: "Number of alert batches waiting to be sent to CAPI.",
+	},
+)
+
+const LapiPushDroppedAlertsMetricName = "cs_lapi_push_dropped_alerts_total"
+
+var LapiPushDroppedAlerts = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: LapiPushDroppedAlertsMetricName,
+		Help: "Number of alerts not sent to CAPI because the push queue was full.",
+	},
+)
+
+const LapiPushSimulatedAlertsMetricName = "cs_lapi_push_simulated_alerts_total"
+
+var LapiPushSimulatedAlerts = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: LapiPushSimulatedAlertsMetricName,
+		Help: "Number of simulated alerts stored locally but not sent to CAPI.",
+	},
+)
+
+/*decisions stream pulled from CAPI*/
+const LapiLastPullTimestampMetricName = "cs_lapi_last_pull_timestamp_seconds"
+
+var LapiLastPullTimestamp = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: LapiLastPullTimestampMetricName,
+		Help: "Unix timestamp of the last successful pull of the decisions stream from CAPI.",
+	},
+)
+
+const LapiPushStaleAlertsMetricName = "cs_lapi_push_stale_alerts_total"
+
+var LapiPushStaleAlerts = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: LapiPushStaleAlertsMetricName,
+		Help: "Number of alerts not sent to CAPI because they are older than push_max_age.",
+	},
+)
+
+const LapiPushTruncatedContextMetricName = "cs_lapi_push_truncated_context_total"
+
+var LapiPushTruncatedContext = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: LapiPushTruncatedContextMetricName,
+		Help: "Number of alerts sent to CAPI with part of their context dropped because it was larger than max_context_size.",
+	},
+)
+
+/*signals sent to CAPI*/
+const LapiPushDurationMetricName = "cs_lapi_push_duration_seconds"
+
+var LapiPushDuration = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    LapiPushDurationMetricName,
+		Help:    "Duration of the requests sending signals to CAPI.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+	},
+)
+
+const LapiPushResponsesMetricName = "cs_lapi_push_responses_total"
+
+var LapiPushResponses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: LapiPushResponsesMetricName,
+		Help: "Number of requests sending signals to CAPI, by HTTP status code (\"error\" if there was no response).",
+	},
+	[]string{"status"},
+)
@@ -36,14 +36,15 @@ func RegisterMetrics(metricsLevel MetricsLevelConfig) error {
 		prometheus.MustRegister(GlobalParserHits, GlobalParserHitsOk, GlobalParserHitsKo,
 			GlobalCsInfo, GlobalParsingHistogram, GlobalPourHistogram,
 			BucketsUnderflow, BucketsCanceled, BucketsInstantiation, BucketsOverflow,
-			LapiRouteHits,
+			LapiRouteHits, LapiPulledDecisionsAllowlisted, LapiLastPullTimestamp, LapiPushQueueDepth, LapiPushDroppedAlerts, LapiPushSimulatedAlerts, LapiPushStaleAlerts, LapiPushTruncatedContext, LapiPushDuration, LapiPushResponses,
 			BucketsCurrentCount,
 			CacheMetrics, RegexpCacheMetrics, NodesWlHitsOk, NodesWlHits)
 	case MetricsLevelFull:
 		prometheus.MustRegister(GlobalParserHits, GlobalParserHitsOk, GlobalParserHitsKo,
 			NodesHits, NodesHitsOk, NodesHitsKo,
 			GlobalCsInfo, GlobalParsingHistogram, GlobalPourHistogram,
-			LapiRouteHits, LapiMachineHits, LapiBouncerHits, LapiNilDecisions, LapiNonNilDecisions, LapiResponseTime,
+			LapiRouteHits, LapiMachineHits, LapiBouncerHits, LapiNilDecisions, LapiNonNilDecisions, LapiResponseTime, LapiPulledDecisionsAllowlisted, LapiLastPullTimestamp,
+			LapiPushQueueDepth, LapiPushDroppedAlerts, LapiPushSimulatedAlerts, LapiPushStaleAlerts, LapiPushTruncatedContext, LapiPushDuration, LapiPushResponses,
 			BucketsPour, BucketsUnderflow, BucketsCanceled, BucketsInstantiation, BucketsOverflow, BucketsCurrentCount,
 			GlobalActiveDecisions, GlobalAlerts, NodesWlHitsOk, NodesWlHits,
 			CacheMetrics, RegexpCacheMetrics)
@@ -27,6 +27,9 @@ type Decision struct {
 	// Read Only: true
 	ID int64 `json:"id,omitempty"`
 
+	// additional information about the decision, as received from CAPI
+	Metadata map[string]string `json:"metadata,omitempty"`
+
 	// the origin of the decision : cscli, crowdsec
 	// Required: true
 	Origin *string `json:"origin"`
@@ -28,6 +28,12 @@ type MetricsBouncerInfo struct {
 	// bouncer type (firewall, php ...)
 	Name string `json:"name,omitempty"`
 
+	// name of the operating system
+	OsName string `json:"os_name,omitempty"`
+
+	// version of the operating system
+	OsVersion string `json:"os_version,omitempty"`
+
 	// software version
 	Version string `json:"version,omitempty"`
 }
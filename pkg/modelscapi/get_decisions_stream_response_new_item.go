@@ -166,6 +166,9 @@ type GetDecisionsStreamResponseNewItemDecisionsItems0 struct {
 	// Required: true
 	Duration *string `json:"duration"`
 
+	// additional information about the decision, ie. scenario version or threat type
+	Metadata map[string]string `json:"metadata,omitempty"`
+
 	// the value of the decision scope : an IP, a range, a username, etc
 	// Required: true
 	Value *string `json:"value"`